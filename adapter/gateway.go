@@ -0,0 +1,133 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// MemoryGateway is a payment gateway that keeps the payments in memory, the
+// payer approves every payment as soon as it is created. It is meant for
+// tests and local development.
+type MemoryGateway struct {
+	mu       sync.Mutex
+	prefix   string
+	payments map[string]model.Payment
+	refunded map[string]model.Money
+	keys     map[string]string
+	refunds  map[string]model.Refund
+	last     int
+}
+
+func NewMemoryGateway(prefix string) *MemoryGateway {
+	return &MemoryGateway{
+		prefix:   prefix,
+		payments: make(map[string]model.Payment),
+		refunded: make(map[string]model.Money),
+		keys:     make(map[string]string),
+		refunds:  make(map[string]model.Refund),
+	}
+}
+
+func (mg *MemoryGateway) next() string {
+	mg.last++
+	return fmt.Sprintf("%s-%d", mg.prefix, mg.last)
+}
+
+func (mg *MemoryGateway) payment(id string) (model.Payment, error) {
+	p, ok := mg.payments[id]
+	if !ok {
+		return model.Payment{}, apperr.New(apperr.ErrNotFound, fmt.Sprintf("payment %s not found", id))
+	}
+	return p, nil
+}
+
+func (mg *MemoryGateway) CreateIntent(c context.Context, amount model.Money, key string) (string, error) {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	if id, ok := mg.keys[key]; ok {
+		return id, nil
+	}
+	id := mg.next()
+	mg.payments[id] = model.Payment{ID: id, Status: model.PaymentApproved, Amount: amount}
+	mg.keys[key] = id
+	return id, nil
+}
+
+func (mg *MemoryGateway) Capture(c context.Context, id string) (model.Capture, error) {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	p, err := mg.payment(id)
+	if err != nil {
+		return model.Capture{}, err
+	}
+	if p.Status == model.PaymentCompleted {
+		return model.Capture{ID: p.CaptureID, Status: string(p.Status), Amount: p.Amount}, nil
+	}
+	if p.Status != model.PaymentApproved {
+		return model.Capture{}, apperr.New(apperr.ErrPaymentDeclined, fmt.Sprintf("payment %s is %s", id, p.Status))
+	}
+	p.Status, p.CaptureID = model.PaymentCompleted, mg.next()
+	mg.payments[id] = p
+	return model.Capture{ID: p.CaptureID, Status: string(p.Status), Amount: p.Amount}, nil
+}
+
+func (mg *MemoryGateway) Void(c context.Context, id string) error {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	p, err := mg.payment(id)
+	if err != nil {
+		return err
+	}
+	if p.Status == model.PaymentCompleted {
+		return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("payment %s is already captured", id))
+	}
+	p.Status = model.PaymentVoided
+	mg.payments[id] = p
+	return nil
+}
+
+func (mg *MemoryGateway) Refund(c context.Context, cID string, amount model.Money, note, key string) (model.Refund, error) {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	if rf, ok := mg.refunds[key]; ok {
+		return rf, nil
+	}
+	for _, p := range mg.payments {
+		if p.CaptureID != cID {
+			continue
+		}
+		r := mg.refunded[cID]
+		if r.Amount+amount.Amount > p.Amount.Amount {
+			return model.Refund{}, apperr.New(apperr.ErrPaymentDeclined, fmt.Sprintf("capture %s has %s left to refund", cID, model.NewMoney(p.Amount.Amount-r.Amount, p.Amount.Currency)))
+		}
+		mg.refunded[cID] = model.NewMoney(r.Amount+amount.Amount, p.Amount.Currency)
+		id := mg.next()
+		mg.refunds[key] = model.Refund{ProviderID: &id, Status: string(model.PaymentCompleted), Amount: amount}
+		return mg.refunds[key], nil
+	}
+	return model.Refund{}, apperr.New(apperr.ErrNotFound, fmt.Sprintf("capture %s not found", cID))
+}
+
+func (mg *MemoryGateway) Status(c context.Context, id string) (model.Payment, error) {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	return mg.payment(id)
+}
+
+// SetStatus changes the status of the payment id as if the payer or the
+// provider did it.
+func (mg *MemoryGateway) SetStatus(id string, s model.PaymentStatus) error {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	p, err := mg.payment(id)
+	if err != nil {
+		return err
+	}
+	p.Status = s
+	mg.payments[id] = p
+	return nil
+}
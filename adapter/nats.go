@@ -0,0 +1,45 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/nats-io/nats.go"
+)
+
+// NATSBus publishes the messages to NATS JetStream, the ID of a message is
+// sent as the Nats-Msg-Id header so the stream discards the duplicates. The
+// stream that captures the subjects is created by the operators.
+type NATSBus struct {
+	nc *nats.Conn
+	js nats.JetStreamContext
+}
+
+// NewNATSBus connects to the NATS servers of url, a comma separated list, as
+// the client name.
+func NewNATSBus(url, name string) (*NATSBus, error) {
+	nc, err := nats.Connect(url, nats.Name(name), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("nats.Connect: %w", err)
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("nc.JetStream: %w", err)
+	}
+	return &NATSBus{nc: nc, js: js}, nil
+}
+
+// Publish returns once the stream stored m.
+func (nb *NATSBus) Publish(c context.Context, m BusMessage) error {
+	if _, err := nb.js.Publish(m.Subject, m.Data, nats.MsgId(m.ID), nats.Context(c)); err != nil {
+		return fmt.Errorf("js.Publish: %w", apperr.Wrap(apperr.ErrUnavailable, err))
+	}
+	return nil
+}
+
+// Close sends the pending messages and closes the connection.
+func (nb *NATSBus) Close() error {
+	return nb.nc.Drain()
+}
@@ -2,10 +2,14 @@ package adapter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
 	"github.com/plutov/paypal/v4"
 )
 
@@ -38,23 +42,146 @@ func NewPaypalSerive(cltID, secret, api, sURL, bName string) (paypalService, err
 	return ps, nil
 }
 
-func (ps paypalService) CreateOrder(ctx context.Context, t float64) (string, error) {
+// paypalError classifies the errors returned by the PayPal API.
+func paypalError(err error) error {
+	var er *paypal.ErrorResponse
+	if !errors.As(err, &er) || er.Response == nil {
+		return apperr.Wrap(apperr.ErrUnavailable, err)
+	}
+	switch c := er.Response.StatusCode; {
+	case c == http.StatusNotFound:
+		return apperr.Wrap(apperr.ErrNotFound, err)
+	case c == http.StatusUnprocessableEntity:
+		return apperr.Wrap(apperr.ErrPaymentDeclined, err)
+	case c >= http.StatusInternalServerError, c == http.StatusTooManyRequests:
+		return apperr.Wrap(apperr.ErrUnavailable, err)
+	}
+	return err
+}
+
+// CreateIntent creates a PayPal order for the payer to approve, the key is
+// sent as the PayPal-Request-Id so PayPal returns the same order for it.
+func (ps paypalService) CreateIntent(ctx context.Context, t model.Money, key string) (string, error) {
+	cur := t.Currency
+	if cur == "" {
+		cur = model.MXN
+	}
 	pur := paypal.PurchaseUnitRequest{
 		ReferenceID: "ref-id",
-		Amount:      &paypal.PurchaseUnitAmount{Currency: "MXN", Value: fmt.Sprintf("%.2f", t)},
+		Amount:      &paypal.PurchaseUnitAmount{Currency: cur, Value: t.String()},
 	}
-	po, err := ps.c.CreateOrder(ctx, paypal.OrderIntentCapture, []paypal.PurchaseUnitRequest{pur}, nil, &ps.appCtx)
+	po, err := ps.c.CreateOrderWithPaypalRequestID(ctx, paypal.OrderIntentCapture, []paypal.PurchaseUnitRequest{pur}, nil, &ps.appCtx, key)
 	if err != nil {
-		return "", fmt.Errorf("c.CreateOrder: %w", err)
+		return "", fmt.Errorf("c.CreateOrder: %w", paypalError(err))
 	}
 	return po.ID, nil
 }
 
-func (ps paypalService) CaptureOrder(ctx context.Context, id string) (string, error) {
+// paypalMoney parses an amount returned by the PayPal API.
+func paypalMoney(value, currency string) (model.Money, error) {
+	m, err := model.ParseMoney(value, currency)
+	if err != nil {
+		return model.Money{}, fmt.Errorf("parse amount: %w", err)
+	}
+	return m, nil
+}
+
+// firstCapture returns the first capture of the purchase units, the orders
+// are created with a single purchase unit.
+func firstCapture(pms []*paypal.CapturedPayments) (paypal.CaptureAmount, bool) {
+	for _, pm := range pms {
+		if pm != nil && len(pm.Captures) > 0 {
+			return pm.Captures[0], true
+		}
+	}
+	return paypal.CaptureAmount{}, false
+}
+
+// Capture captures the PayPal order id approved by the payer, the order id is
+// the PayPal-Request-Id so a retry returns the same capture.
+func (ps paypalService) Capture(ctx context.Context, id string) (model.Capture, error) {
 	log.Println(id)
-	r, err := ps.c.CaptureOrder(ctx, id, paypal.CaptureOrderRequest{})
+	r, err := ps.c.CaptureOrderWithPaypalRequestId(ctx, id, paypal.CaptureOrderRequest{}, "capture-"+id, nil)
 	if err != nil {
-		return "", fmt.Errorf("c.CaptureOrder: %w", err)
+		return model.Capture{}, fmt.Errorf("c.CaptureOrder: %w", paypalError(err))
+	}
+	cp := model.Capture{Status: r.Status}
+	pms := make([]*paypal.CapturedPayments, len(r.PurchaseUnits))
+	for i := range r.PurchaseUnits {
+		pms[i] = r.PurchaseUnits[i].Payments
+	}
+	if ca, ok := firstCapture(pms); ok {
+		cp.ID = ca.ID
+		if ca.Amount != nil {
+			if cp.Amount, err = paypalMoney(ca.Amount.Value, ca.Amount.Currency); err != nil {
+				return model.Capture{}, err
+			}
+		}
+	}
+	return cp, nil
+}
+
+// Status returns the PayPal order id, PayPal statuses that are not in the
+// model are reported as PaymentCreated.
+func (ps paypalService) Status(ctx context.Context, id string) (model.Payment, error) {
+	o, err := ps.c.GetOrder(ctx, id)
+	if err != nil {
+		return model.Payment{}, fmt.Errorf("c.GetOrder: %w", paypalError(err))
+	}
+	p := model.Payment{ID: o.ID, Status: model.PaymentCreated}
+	switch s := model.PaymentStatus(o.Status); s {
+	case model.PaymentApproved, model.PaymentCompleted, model.PaymentVoided:
+		p.Status = s
+	}
+	pms := make([]*paypal.CapturedPayments, len(o.PurchaseUnits))
+	for i, pu := range o.PurchaseUnits {
+		pms[i] = pu.Payments
+		if i == 0 && pu.Amount != nil {
+			if p.Amount, err = paypalMoney(pu.Amount.Value, pu.Amount.Currency); err != nil {
+				return model.Payment{}, err
+			}
+		}
+	}
+	if ca, ok := firstCapture(pms); ok {
+		p.CaptureID = ca.ID
+	}
+	return p, nil
+}
+
+// Void gives up the PayPal order id. The orders API can not void an order
+// created to be captured, it expires by itself when it is not captured, so
+// only a completed order is an error.
+func (ps paypalService) Void(ctx context.Context, id string) error {
+	p, err := ps.Status(ctx, id)
+	if err != nil {
+		return err
+	}
+	if p.Status == model.PaymentCompleted {
+		return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("paypal order %s is already captured", id))
+	}
+	return nil
+}
+
+// Refund returns amount of the capture cID to the payer, the key is sent as
+// the PayPal-Request-Id so PayPal makes the refund once.
+func (ps paypalService) Refund(ctx context.Context, cID string, amount model.Money, note, key string) (model.Refund, error) {
+	cur := amount.Currency
+	if cur == "" {
+		cur = model.MXN
+	}
+	req := paypal.RefundCaptureRequest{
+		Amount:      &paypal.Money{Currency: cur, Value: amount.String()},
+		NoteToPayer: note,
+	}
+	r, err := ps.c.RefundCaptureWithPaypalRequestId(ctx, cID, req, key)
+	if err != nil {
+		return model.Refund{}, fmt.Errorf("c.RefundCapture: %w", paypalError(err))
+	}
+	rf := model.Refund{ProviderID: &r.ID, Status: r.Status, Amount: amount}
+	if r.Amount != nil {
+		if rf.Amount, err = paypalMoney(r.Amount.Value, r.Amount.Currency); err != nil {
+			return model.Refund{}, err
+		}
 	}
-	return r.Status, nil
+	return rf, nil
 }
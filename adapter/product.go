@@ -0,0 +1,46 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	pfp "github.com/modular-project/protobuffers/information/product"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type productService struct {
+	c pfp.ProductServiceClient
+}
+
+// NewProductService connects to the product service running at addr.
+func NewProductService(addr string) (productService, error) {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return productService{}, fmt.Errorf("grpc.Dial: %w", err)
+	}
+	return productService{c: pfp.NewProductServiceClient(conn)}, nil
+}
+
+func (ps productService) Prices(ctx context.Context, ids []uint64) (map[uint64]model.Product, error) {
+	r, err := ps.c.GetInBatch(ctx, &pfp.RequestGetInBatch{Ids: ids})
+	if err != nil {
+		if c := status.Code(err); c == codes.Unavailable || c == codes.DeadlineExceeded {
+			err = apperr.Wrap(apperr.ErrUnavailable, err)
+		}
+		return nil, fmt.Errorf("c.GetInBatch: %w", err)
+	}
+	m := make(map[uint64]model.Product, len(r.Products))
+	for _, p := range r.Products {
+		m[p.Id] = model.Product{
+			ID:    p.Id,
+			Name:  p.Name,
+			Price: model.MoneyFromFloat(float64(p.Price), model.MXN),
+		}
+	}
+	return m, nil
+}
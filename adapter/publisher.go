@@ -0,0 +1,71 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/modular-project/orders-service/model"
+)
+
+// BusMessage is a message of a subject based broker such as NATS or Kafka.
+// ID is the deduplication ID, e.g. the Nats-Msg-Id header or the record key.
+type BusMessage struct {
+	Subject string
+	ID      string
+	Data    []byte
+}
+
+// Bus is the client of a message broker.
+type Bus interface {
+	Publish(c context.Context, m BusMessage) error
+}
+
+// BusPublisher publishes every event in the subject prefix.type, e.g.
+// orders.order.created.
+type BusPublisher struct {
+	bus    Bus
+	prefix string
+}
+
+func NewBusPublisher(bus Bus, prefix string) BusPublisher {
+	return BusPublisher{bus: bus, prefix: prefix}
+}
+
+func (bp BusPublisher) Publish(c context.Context, e model.OrderEvent) error {
+	m := BusMessage{Subject: bp.prefix + "." + e.Type, ID: e.EventID, Data: []byte(e.Payload)}
+	if err := bp.bus.Publish(c, m); err != nil {
+		return fmt.Errorf("bus.Publish %s: %w", m.Subject, err)
+	}
+	return nil
+}
+
+// LocalBus is a Bus kept in memory that discards the messages with a
+// repeated ID, it stands in for the broker in tests and local development.
+type LocalBus struct {
+	mu       sync.Mutex
+	seen     map[string]bool
+	messages []BusMessage
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{seen: make(map[string]bool)}
+}
+
+func (lb *LocalBus) Publish(c context.Context, m BusMessage) error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if lb.seen[m.ID] {
+		return nil
+	}
+	lb.seen[m.ID] = true
+	lb.messages = append(lb.messages, m)
+	return nil
+}
+
+// Messages returns the messages received, without duplicates.
+func (lb *LocalBus) Messages() []BusMessage {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return append([]BusMessage(nil), lb.messages...)
+}
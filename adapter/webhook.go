@@ -0,0 +1,92 @@
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"github.com/plutov/paypal/v4"
+)
+
+// paypalEvent is the body of a PayPal webhook, only the fields of the
+// resources used by the orders are decoded.
+type paypalEvent struct {
+	ID        string `json:"id"`
+	EventType string `json:"event_type"`
+	Resource  struct {
+		ID                string        `json:"id"`
+		Amount            *paypal.Money `json:"amount"`
+		SupplementaryData struct {
+			RelatedIDs struct {
+				OrderID string `json:"order_id"`
+			} `json:"related_ids"`
+		} `json:"supplementary_data"`
+		Links []paypal.Link `json:"links"`
+	} `json:"resource"`
+}
+
+type PaypalWebhook struct {
+	c  *paypal.Client
+	id string
+}
+
+// NewPaypalWebhook returns the verifier of the webhook wID of the PayPal app.
+func NewPaypalWebhook(cltID, secret, api, wID string) (PaypalWebhook, error) {
+	c, err := paypal.NewClient(cltID, secret, api)
+	if err != nil {
+		return PaypalWebhook{}, fmt.Errorf("paypal.NewClient: %w", err)
+	}
+	return PaypalWebhook{c: c, id: wID}, nil
+}
+
+// Verify asks PayPal if it signed the webhook r and returns its event.
+func (pw PaypalWebhook) Verify(r *http.Request) (model.PaymentEvent, error) {
+	vr, err := pw.c.VerifyWebhookSignature(r.Context(), r, pw.id)
+	if err != nil {
+		return model.PaymentEvent{}, fmt.Errorf("c.VerifyWebhookSignature: %w", paypalError(err))
+	}
+	if vr.VerificationStatus != "SUCCESS" {
+		return model.PaymentEvent{}, apperr.New(apperr.ErrPermissionDenied, fmt.Sprintf("webhook verification is %s", vr.VerificationStatus))
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return model.PaymentEvent{}, fmt.Errorf("read body: %w", err)
+	}
+	return paypalPaymentEvent(b)
+}
+
+// paypalPaymentEvent decodes the body b of a PayPal webhook.
+func paypalPaymentEvent(b []byte) (model.PaymentEvent, error) {
+	var pe paypalEvent
+	if err := json.Unmarshal(b, &pe); err != nil {
+		return model.PaymentEvent{}, fmt.Errorf("decode event: %w", apperr.Wrap(apperr.ErrInvalidArgument, err))
+	}
+	e := model.PaymentEvent{ID: pe.ID, Type: model.PaymentEventType(pe.EventType)}
+	res := pe.Resource
+	switch e.Type {
+	case model.PaymentEventApproved:
+		e.PayID = res.ID
+	case model.PaymentEventCaptured, model.PaymentEventDenied:
+		e.PayID, e.CaptureID = res.SupplementaryData.RelatedIDs.OrderID, res.ID
+	case model.PaymentEventRefunded:
+		e.RefundID = res.ID
+		// the refund links to its capture as "up".
+		for _, l := range res.Links {
+			if l.Rel == "up" {
+				e.CaptureID = path.Base(l.Href)
+			}
+		}
+	}
+	if res.Amount != nil {
+		m, err := paypalMoney(res.Amount.Value, res.Amount.Currency)
+		if err != nil {
+			return model.PaymentEvent{}, err
+		}
+		e.Amount = m
+	}
+	return e, nil
+}
@@ -0,0 +1,100 @@
+// Package apperr defines the kinds of errors returned by the service, they
+// are independent of the transport so storage and controller can return
+// them and the server maps them to its own codes.
+package apperr
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrInvalidArgument    = errors.New("invalid argument")
+	ErrFailedPrecondition = errors.New("failed precondition")
+	ErrConflict           = errors.New("conflict")
+	ErrPaymentDeclined    = errors.New("payment declined")
+	ErrUnavailable        = errors.New("unavailable")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrUnauthenticated    = errors.New("unauthenticated")
+)
+
+var kinds = []error{
+	ErrNotFound,
+	ErrInvalidArgument,
+	ErrFailedPrecondition,
+	ErrConflict,
+	ErrPaymentDeclined,
+	ErrUnavailable,
+	ErrPermissionDenied,
+	ErrUnauthenticated,
+}
+
+// FieldViolation describes why a field of a request is invalid.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Error is an error of one of the kinds above with its own message.
+type Error struct {
+	kind       error
+	msg        string
+	err        error
+	violations []FieldViolation
+}
+
+// New returns an error of the given kind.
+func New(kind error, msg string) *Error {
+	return &Error{kind: kind, msg: msg}
+}
+
+// Wrap classifies err with the given kind keeping it as the cause.
+func Wrap(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{kind: kind, msg: err.Error(), err: err}
+}
+
+// InvalidArgument returns an ErrInvalidArgument with the invalid fields.
+func InvalidArgument(vs ...FieldViolation) *Error {
+	msgs := make([]string, len(vs))
+	for i := range vs {
+		msgs[i] = vs[i].Field + ": " + vs[i].Description
+	}
+	return &Error{kind: ErrInvalidArgument, msg: strings.Join(msgs, ", "), violations: vs}
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Kind returns the kind of err or nil if it is not classified.
+func Kind(err error) error {
+	for _, k := range kinds {
+		if errors.Is(err, k) {
+			return k
+		}
+	}
+	return nil
+}
+
+// Violations returns the invalid fields reported by err.
+func Violations(err error) []FieldViolation {
+	for err != nil {
+		if e, ok := err.(*Error); ok && len(e.violations) > 0 {
+			return e.violations
+		}
+		err = errors.Unwrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKind(t *testing.T) {
+	cause := errors.New("connection refused")
+	tests := []struct {
+		name string
+		give error
+		want error
+	}{
+		{name: "new", give: New(ErrNotFound, "order not found"), want: ErrNotFound},
+		{name: "wrapped", give: fmt.Errorf("first order: %w", Wrap(ErrUnavailable, cause)), want: ErrUnavailable},
+		{name: "sentinel", give: fmt.Errorf("%w: 10", ErrConflict), want: ErrConflict},
+		{name: "invalid argument", give: InvalidArgument(FieldViolation{Field: "id", Description: "must not be empty"}), want: ErrInvalidArgument},
+		{name: "unclassified", give: cause, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Kind(tt.give); got != tt.want {
+				t.Errorf("Kind() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !errors.Is(Wrap(ErrUnavailable, cause), cause) {
+		t.Errorf("Wrap() lost the cause")
+	}
+}
+
+func TestViolations(t *testing.T) {
+	v := FieldViolation{Field: "search.range", Description: "must have two values"}
+	err := fmt.Errorf("handler: %w", Wrap(ErrInvalidArgument, InvalidArgument(v)))
+	got := Violations(err)
+	if len(got) != 1 || got[0] != v {
+		t.Errorf("Violations() = %v, want [%v]", got, v)
+	}
+	if got := Violations(New(ErrNotFound, "order not found")); got != nil {
+		t.Errorf("Violations() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,230 @@
+// Package auth verifies the JSON Web Tokens sent by the clients and returns
+// the actor of their requests, the keys to verify them are read from a
+// KeySource so they can be rotated or fetched from the identity provider.
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// Algorithms of the signatures accepted.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+var (
+	ErrInvalidToken = apperr.New(apperr.ErrUnauthenticated, "invalid token")
+	ErrExpiredToken = apperr.New(apperr.ErrUnauthenticated, "token expired")
+	ErrUnknownKey   = apperr.New(apperr.ErrUnauthenticated, "unknown signing key")
+)
+
+// Claims are the claims of the tokens read by the service. Subject is the ID
+// of the user for the customers and of the employee for the staff.
+type Claims struct {
+	Subject         string `json:"sub"`
+	Issuer          string `json:"iss,omitempty"`
+	Role            string `json:"role"`
+	EstablishmentID uint64 `json:"establishment_id,omitempty"`
+	ExpiresAt       int64  `json:"exp"`
+	NotBefore       int64  `json:"nbf,omitempty"`
+	IssuedAt        int64  `json:"iat,omitempty"`
+}
+
+// Actor returns who the claims identify, the staff other than the admins
+// must be bound to an establishment.
+func (cl Claims) Actor() (model.Actor, error) {
+	id, err := strconv.ParseUint(cl.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return model.Actor{}, fmt.Errorf("%w: invalid subject %q", ErrInvalidToken, cl.Subject)
+	}
+	a := model.Actor{Role: cl.Role, EstablishmentID: cl.EstablishmentID}
+	switch cl.Role {
+	case model.RoleCustomer:
+		a.UserID, a.EstablishmentID = id, 0
+	case model.RoleWaiter, model.RoleCook, model.RoleManager:
+		if cl.EstablishmentID == 0 {
+			return model.Actor{}, fmt.Errorf("%w: role %s without establishment", ErrInvalidToken, cl.Role)
+		}
+		a.EmployeeID = id
+	case model.RoleAdmin:
+		a.EmployeeID = id
+	default:
+		return model.Actor{}, fmt.Errorf("%w: unknown role %q", ErrInvalidToken, cl.Role)
+	}
+	return a, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// KeySource returns the keys that verify the signatures of the tokens.
+type KeySource interface {
+	// Key returns the key kid for the algorithm alg, a []byte for HS256 and
+	// an *rsa.PublicKey for RS256.
+	Key(kid, alg string) (interface{}, error)
+}
+
+// HMACKey is a shared secret that verifies the tokens signed with HS256.
+type HMACKey []byte
+
+func (k HMACKey) Key(_, alg string) (interface{}, error) {
+	if alg != HS256 {
+		return nil, ErrUnknownKey
+	}
+	return []byte(k), nil
+}
+
+// RSAKeys are the public keys that verify the tokens signed with RS256 by
+// their key ID, a single key is also used for the tokens without one.
+type RSAKeys map[string]*rsa.PublicKey
+
+func (ks RSAKeys) Key(kid, alg string) (interface{}, error) {
+	if alg != RS256 {
+		return nil, ErrUnknownKey
+	}
+	if k, ok := ks[kid]; ok {
+		return k, nil
+	}
+	if kid == "" && len(ks) == 1 {
+		for _, k := range ks {
+			return k, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// ParseRSAPublicKey parses a PEM encoded PKIX public key.
+func ParseRSAPublicKey(b []byte) (*rsa.PublicKey, error) {
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	k, err := x509.ParsePKIXPublicKey(blk.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKIXPublicKey: %w", err)
+	}
+	pk, ok := k.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key of type %T is not RSA", k)
+	}
+	return pk, nil
+}
+
+// Verifier checks the signature and the validity of the tokens, the times
+// are compared with a margin of leeway for the clock skew.
+type Verifier struct {
+	keys   KeySource
+	issuer string
+	leeway time.Duration
+	now    func() time.Time
+}
+
+// NewVerifier returns a Verifier of the tokens signed with the keys, the
+// issuer is checked when it is not empty.
+func NewVerifier(keys KeySource, issuer string, leeway time.Duration) Verifier {
+	return Verifier{keys: keys, issuer: issuer, leeway: leeway, now: time.Now}
+}
+
+// Verify returns the claims of a valid token.
+func (v Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %s", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature: %s", ErrInvalidToken, err)
+	}
+	key, err := v.keys.Key(h.Kid, h.Alg)
+	if err != nil {
+		return Claims{}, fmt.Errorf("keys.Key: %w", err)
+	}
+	if err := verifySignature(h.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return Claims{}, err
+	}
+	var cl Claims
+	if err := decodeSegment(parts[1], &cl); err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %s", ErrInvalidToken, err)
+	}
+	now := v.now()
+	switch {
+	case cl.ExpiresAt == 0:
+		return Claims{}, fmt.Errorf("%w: without expiration", ErrInvalidToken)
+	case now.After(time.Unix(cl.ExpiresAt, 0).Add(v.leeway)):
+		return Claims{}, ErrExpiredToken
+	case cl.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(cl.NotBefore, 0)):
+		return Claims{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case v.issuer != "" && cl.Issuer != v.issuer:
+		return Claims{}, fmt.Errorf("%w: issuer %q", ErrInvalidToken, cl.Issuer)
+	}
+	return cl, nil
+}
+
+// Authenticate returns the actor identified by a valid token.
+func (v Verifier) Authenticate(token string) (model.Actor, error) {
+	cl, err := v.Verify(token)
+	if err != nil {
+		return model.Actor{}, err
+	}
+	return cl.Actor()
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, key interface{}, signed string, sig []byte) error {
+	switch k := key.(type) {
+	case []byte:
+		if alg != HS256 {
+			break
+		}
+		if !hmac.Equal(sig, signHMAC(k, signed)) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+		return nil
+	case *rsa.PublicKey:
+		if alg != RS256 {
+			break
+		}
+		sum := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig); err != nil {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: algorithm %q", ErrInvalidToken, alg)
+}
+
+func signHMAC(key []byte, signed string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(signed))
+	return m.Sum(nil)
+}
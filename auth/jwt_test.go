@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func signRSA(t *testing.T, k *rsa.PrivateKey, kid string, cl Claims) string {
+	h, _ := json.Marshal(header{Alg: RS256, Kid: kid})
+	b, _ := json.Marshal(cl)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatalf("rsa.SignPKCS1v15() error = %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	valid := Claims{Subject: "7", Role: model.RoleCustomer, Issuer: "auth", ExpiresAt: now.Add(time.Hour).Unix()}
+	sign := func(key string, cl Claims) string {
+		tk, err := NewSigner(HMACKey(key), "").Sign(cl)
+		if err != nil {
+			t.Fatalf("Signer.Sign() error = %v", err)
+		}
+		return tk
+	}
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	expired, early, other := valid, valid, valid
+	expired.ExpiresAt = now.Add(-2 * time.Minute).Unix()
+	early.NotBefore = now.Add(2 * time.Minute).Unix()
+	other.Issuer = "other"
+	tampered := sign("secret", valid)
+	tampered = tampered[:len(tampered)-2] + "AA"
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"7","role":"admin","exp":1900000000}`)) + "."
+	tests := []struct {
+		name    string
+		keys    KeySource
+		token   string
+		wantErr error
+	}{
+		{name: "hmac", keys: HMACKey("secret"), token: sign("secret", valid)},
+		{name: "rsa", keys: RSAKeys{"k1": &rk.PublicKey}, token: signRSA(t, rk, "k1", valid)},
+		{name: "rsa without kid", keys: RSAKeys{"k1": &rk.PublicKey}, token: signRSA(t, rk, "", valid)},
+		{name: "rsa unknown kid", keys: RSAKeys{"k1": &rk.PublicKey}, token: signRSA(t, rk, "k2", valid), wantErr: ErrUnknownKey},
+		{name: "hmac with rsa keys", keys: RSAKeys{"k1": &rk.PublicKey}, token: sign("secret", valid), wantErr: ErrUnknownKey},
+		{name: "another secret", keys: HMACKey("secret"), token: sign("other", valid), wantErr: ErrInvalidToken},
+		{name: "tampered", keys: HMACKey("secret"), token: tampered, wantErr: ErrInvalidToken},
+		{name: "alg none", keys: HMACKey("secret"), token: none, wantErr: ErrUnknownKey},
+		{name: "malformed", keys: HMACKey("secret"), token: "a.b", wantErr: ErrInvalidToken},
+		{name: "expired within leeway", keys: HMACKey("secret"), token: sign("secret", Claims{Subject: "7", Role: model.RoleCustomer, Issuer: "auth", ExpiresAt: now.Add(-30 * time.Second).Unix()})},
+		{name: "expired", keys: HMACKey("secret"), token: sign("secret", expired), wantErr: ErrExpiredToken},
+		{name: "not valid yet", keys: HMACKey("secret"), token: sign("secret", early), wantErr: ErrInvalidToken},
+		{name: "without expiration", keys: HMACKey("secret"), token: sign("secret", Claims{Subject: "7", Role: model.RoleCustomer, Issuer: "auth"}), wantErr: ErrInvalidToken},
+		{name: "another issuer", keys: HMACKey("secret"), token: sign("secret", other), wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(tt.keys, "auth", time.Minute)
+			v.now = func() time.Time { return now }
+			got, err := v.Verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verifier.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				assert.ErrorIs(t, err, apperr.ErrUnauthenticated)
+				return
+			}
+			assert.Equal(t, "7", got.Subject)
+		})
+	}
+}
+
+func TestClaims_Actor(t *testing.T) {
+	tests := []struct {
+		name    string
+		give    Claims
+		want    model.Actor
+		wantErr bool
+	}{
+		{name: "customer", give: Claims{Subject: "7", Role: model.RoleCustomer, EstablishmentID: 3}, want: model.Actor{UserID: 7, Role: model.RoleCustomer}},
+		{name: "waiter", give: Claims{Subject: "2", Role: model.RoleWaiter, EstablishmentID: 3}, want: model.Actor{EmployeeID: 2, Role: model.RoleWaiter, EstablishmentID: 3}},
+		{name: "admin", give: Claims{Subject: "1", Role: model.RoleAdmin}, want: model.Actor{EmployeeID: 1, Role: model.RoleAdmin}},
+		{name: "staff without establishment", give: Claims{Subject: "2", Role: model.RoleCook}, wantErr: true},
+		{name: "unknown role", give: Claims{Subject: "2", Role: "owner"}, wantErr: true},
+		{name: "invalid subject", give: Claims{Subject: "user", Role: model.RoleCustomer}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.give.Actor()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Claims.Actor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRSAPublicKey(t *testing.T) {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rk.PublicKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey() error = %v", err)
+	}
+	got, err := ParseRSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParseRSAPublicKey() error = %v", err)
+	}
+	assert.True(t, rk.PublicKey.Equal(got))
+	if _, err := ParseRSAPublicKey([]byte("key")); err == nil {
+		t.Errorf("ParseRSAPublicKey() without PEM error = nil")
+	}
+}
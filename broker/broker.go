@@ -0,0 +1,138 @@
+// Package broker fans out kitchen events to the subscribers of a topic and
+// keeps the latest events of every topic so a subscriber can resume.
+package broker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// ErrSlowConsumer closes a subscription that did not keep up with its topic,
+// the subscriber is expected to subscribe again from its last cursor.
+var ErrSlowConsumer = apperr.New(apperr.ErrUnavailable, "subscriber is too slow")
+
+type topic struct {
+	events []model.KitchenEvent
+	// evicted is the cursor of the newest event no longer kept.
+	evicted uint64
+	subs    map[*Subscription]struct{}
+}
+
+type Broker struct {
+	mu     sync.Mutex
+	size   int
+	cursor uint64
+	topics map[string]*topic
+}
+
+// New returns a broker that keeps the last size events of every topic.
+// Cursors start at the current time so the ones of a previous process are
+// never replayed.
+func New(size int) *Broker {
+	if size < 1 {
+		size = 1
+	}
+	return &Broker{
+		size:   size,
+		cursor: uint64(time.Now().UnixNano()),
+		topics: make(map[string]*topic),
+	}
+}
+
+func (b *Broker) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{evicted: b.cursor, subs: make(map[*Subscription]struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Publish assigns the next cursor to e and sends it to the subscribers of the topic.
+func (b *Broker) Publish(name string, e model.KitchenEvent) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cursor++
+	e.Cursor = b.cursor
+	t := b.topic(name)
+	t.events = append(t.events, e)
+	if len(t.events) > b.size {
+		t.evicted = t.events[0].Cursor
+		t.events = append(t.events[:0], t.events[1:]...)
+	}
+	for s := range t.subs {
+		select {
+		case s.c <- e:
+		default:
+			delete(t.subs, s)
+			s.close(ErrSlowConsumer)
+		}
+	}
+	return e.Cursor
+}
+
+// Subscribe starts a subscription to the topic. When the events after the
+// cursor are still kept they are replayed and replayed is true, otherwise the
+// subscriber only receives new events and must load the current state.
+func (b *Broker) Subscribe(name string, after uint64) (s *Subscription, replayed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(name)
+	s = &Subscription{
+		c:      make(chan model.KitchenEvent, b.size),
+		b:      b,
+		name:   name,
+		Cursor: b.cursor,
+	}
+	s.C = s.c
+	if after != 0 && after >= t.evicted && after <= b.cursor {
+		replayed = true
+		for _, e := range t.events {
+			if e.Cursor > after {
+				s.c <- e
+			}
+		}
+	}
+	t.subs[s] = struct{}{}
+	return s, replayed
+}
+
+type Subscription struct {
+	// C receives the events of the topic, it is closed when the subscription ends.
+	C <-chan model.KitchenEvent
+	// Cursor is the cursor of the last event published before subscribing.
+	Cursor uint64
+
+	c    chan model.KitchenEvent
+	b    *Broker
+	name string
+	once sync.Once
+	err  error
+}
+
+func (s *Subscription) close(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.c)
+	})
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	if t, ok := s.b.topics[s.name]; ok {
+		delete(t.subs, s)
+	}
+	s.close(nil)
+}
+
+// Err returns why C was closed, it is nil if the subscription was closed by Close.
+func (s *Subscription) Err() error {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	return s.err
+}
@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func drain(s *Subscription) []uint64 {
+	var ps []uint64
+	for {
+		select {
+		case e, ok := <-s.C:
+			if !ok {
+				return ps
+			}
+			ps = append(ps, e.Product.ID)
+		default:
+			return ps
+		}
+	}
+}
+
+func publish(b *Broker, name string, ids ...uint64) []uint64 {
+	cs := make([]uint64, len(ids))
+	for i, id := range ids {
+		cs[i] = b.Publish(name, model.KitchenEvent{Kind: model.ProductAdded, Product: model.OrderProduct{ID: id}})
+	}
+	return cs
+}
+
+func TestBroker_Subscribe(t *testing.T) {
+	b := New(3)
+	cs := publish(b, "kitchen.1", 1, 2, 3, 4)
+	publish(b, "kitchen.2", 10)
+	tests := []struct {
+		name     string
+		after    uint64
+		replayed bool
+		want     []uint64
+	}{
+		{name: "no cursor", after: 0, replayed: false},
+		{name: "resume", after: cs[1], replayed: true, want: []uint64{3, 4}},
+		{name: "up to date", after: cs[3] + 1, replayed: true},
+		{name: "oldest kept", after: cs[0], replayed: true, want: []uint64{2, 3, 4}},
+		{name: "evicted", after: cs[0] - 1, replayed: false},
+		{name: "previous process", after: 10, replayed: false},
+		{name: "future cursor", after: cs[3] + 10, replayed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, replayed := b.Subscribe("kitchen.1", tt.after)
+			defer s.Close()
+			assert.Equal(t, tt.replayed, replayed)
+			assert.Equal(t, tt.want, drain(s))
+		})
+	}
+}
+
+func TestBroker_Publish(t *testing.T) {
+	b := New(2)
+	s, _ := b.Subscribe("kitchen.1", 0)
+	other, _ := b.Subscribe("kitchen.2", 0)
+	defer other.Close()
+	cs := publish(b, "kitchen.1", 1, 2)
+	assert.Less(t, s.Cursor, cs[0])
+	assert.Equal(t, []uint64{1, 2}, drain(s))
+	assert.Nil(t, drain(other))
+
+	publish(b, "kitchen.1", 3, 4, 5)
+	assert.Equal(t, []uint64{3, 4}, drain(s))
+	if _, ok := <-s.C; ok {
+		t.Fatalf("slow subscription was not closed")
+	}
+	assert.True(t, errors.Is(s.Err(), ErrSlowConsumer))
+
+	s.Close()
+	c, _ := b.Subscribe("kitchen.1", 0)
+	c.Close()
+	assert.Nil(t, c.Err())
+}
@@ -1,19 +1,35 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	// the time zones of the establishments are embedded, the image may not
+	// have them.
+	_ "time/tzdata"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	"github.com/modular-project/orders-service/adapter"
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/auth"
+	"github.com/modular-project/orders-service/broker"
 	"github.com/modular-project/orders-service/controller"
 	"github.com/modular-project/orders-service/http/handler"
 	"github.com/modular-project/orders-service/model"
 	"github.com/modular-project/orders-service/storage"
 	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health"
@@ -22,6 +38,124 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+const (
+	// kitchenEvents is the number of events kept per kitchen to resume its feed.
+	kitchenEvents = 256
+	// outboxInterval is how often the outbox is checked for new events.
+	outboxInterval = time.Second
+	outboxBatch    = 100
+	// reconcileInterval is how often the orders waiting for a payment are
+	// compared with the payment gateway.
+	reconcileInterval = 10 * time.Minute
+	// reconcileAge is how long the payer has to approve a payment before it
+	// is reconciled.
+	reconcileAge   = 15 * time.Minute
+	reconcileBatch = 100
+	// expireInterval is how often the unpaid delivery orders are expired.
+	expireInterval = time.Minute
+	expireBatch    = 100
+	// paymentTTL is how long a delivery order waits for its payment when
+	// ORDER_PAYMENT_TTL is not set.
+	paymentTTL = 30 * time.Minute
+	// idempotencyTTL is how long the response of a request sent with an
+	// idempotency key is kept.
+	idempotencyTTL   = 24 * time.Hour
+	idempotencyPurge = time.Hour
+	// tokenLeeway is the clock skew allowed when the tokens are verified.
+	tokenLeeway = time.Minute
+	// timeZone is the time zone of the establishments when ORDER_TIMEZONE is
+	// not set.
+	timeZone = "America/Mexico_City"
+)
+
+// transactor binds the storages to a transaction of the unit of work.
+type transactor struct {
+	uow storage.UnitOfWork
+}
+
+func (t transactor) WithTx(c context.Context, fn func(controller.OrderStorager, controller.OrderStatusStorager) error) error {
+	return t.uow.WithTx(c, func(tx storage.Tx) error {
+		return fn(tx.Orders(), tx.OrderStatus())
+	})
+}
+
+type memoryTransactor struct {
+	ms *storage.MemoryStorage
+}
+
+func (mt memoryTransactor) WithTx(c context.Context, fn func(controller.OrderStorager, controller.OrderStatusStorager) error) error {
+	return mt.ms.WithTx(c, func(tx *storage.MemoryStorage) error {
+		return fn(tx, tx)
+	})
+}
+
+type storages struct {
+	orders controller.OrderStorager
+	status controller.OrderStatusStorager
+	tx     controller.Transactor
+	outbox controller.OutboxStorager
+	keys   controller.IdempotencyStorager
+	// close releases the connections.
+	close func() error
+}
+
+// newStorages returns the storages of the driver set in ORDER_DB_TYPE,
+// PostgreSQL is used by default.
+func newStorages() storages {
+	if storage.DRIVER(os.Getenv("ORDER_DB_TYPE")) == storage.MEMORY {
+		log.Println("using in memory storage, the orders are lost at exit")
+		ms := storage.NewMemoryStorage()
+		return storages{orders: ms, status: ms, tx: memoryTransactor{ms: ms}, outbox: ms, keys: ms, close: func() error { return nil }}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db, err := storage.NewDB(ctx, newDBConn())
+	if err != nil {
+		log.Fatalf("fatal at start db: %s", err)
+	}
+	if err := db.Migrate(&model.Order{}, &model.OrderProduct{}, &model.OrderEvent{}, &model.OrderAudit{}, &model.Refund{}, &model.PaymentEvent{}, &model.IdempotencyKey{}); err != nil {
+		log.Fatalf("fatal at migrate db: %s", err)
+	}
+	if err := db.MigrateSearch(); err != nil {
+		log.Fatalf("fatal at migrate search indexes: %s", err)
+	}
+	return storages{
+		orders: storage.NewOrderStorage(db),
+		status: storage.NewOrderStatusStorage(db),
+		tx:     transactor{uow: storage.NewUnitOfWork(db)},
+		outbox: storage.NewOutboxStorage(db),
+		keys:   storage.NewIdempotencyStorage(db),
+		close:  db.Close,
+	}
+}
+
+// newEventPublisher returns the publisher of the bus set in ORDER_EVENT_BUS
+// and the function that closes it. NATS publishes to the JetStream of the
+// servers in ORDER_NATS_URL and LOCAL keeps the events in memory, the server
+// does not start without a bus so the events are never marked as published
+// without being sent.
+func newEventPublisher() (controller.EventPublisher, func() error) {
+	switch bus := os.Getenv("ORDER_EVENT_BUS"); bus {
+	case "NATS":
+		env := "ORDER_NATS_URL"
+		url, f := os.LookupEnv(env)
+		if !f {
+			log.Fatalf("environment variable (%s) not found", env)
+		}
+		nb, err := adapter.NewNATSBus(url, "orders-service")
+		if err != nil {
+			log.Fatalf("fatal at connect to the event bus: %s", err)
+		}
+		return adapter.NewBusPublisher(nb, "orders"), nb.Close
+	case "LOCAL":
+		log.Println("using in memory event bus, the events are not sent to other services")
+		return adapter.NewBusPublisher(adapter.NewLocalBus(), "orders"), func() error { return nil }
+	default:
+		log.Fatalf("environment variable (ORDER_EVENT_BUS) must be NATS or LOCAL, got %q", bus)
+	}
+	return nil, nil
+}
+
 func newDBConn() storage.DBConnection {
 	env := "ORDER_DB_HOST"
 	host, f := os.LookupEnv(env)
@@ -55,10 +189,27 @@ func newDBConn() storage.DBConnection {
 		Host:     host,
 		Port:     port,
 		NameDB:   name,
+
+		MaxOpenConns:    20,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
 	}
 }
 
-func newPaypalService() controller.PaypalServicer {
+// newPaymentGateways registers the gateway of every payment method paid
+// online, ORDER_PAYMENT_GATEWAY=MEMORY replaces PayPal with a gateway that
+// approves every payment for local development.
+func newPaymentGateways() *controller.PaymentGateways {
+	pg := controller.NewPaymentGateways()
+	if os.Getenv("ORDER_PAYMENT_GATEWAY") == "MEMORY" {
+		pg.Register(model.PAYPAL, adapter.NewMemoryGateway("PAYPAL"))
+		return pg
+	}
+	pg.Register(model.PAYPAL, newPaypalService())
+	return pg
+}
+
+func newPaypalService() controller.PaymentGateway {
 	env := "PP_CLTID"
 	ctlID, f := os.LookupEnv(env)
 	if !f {
@@ -91,32 +242,335 @@ func newPaypalService() controller.PaypalServicer {
 	return ps
 }
 
+// newWebhookServer returns the HTTP server of the PayPal webhooks at
+// ORDER_WEBHOOK_PORT, it is nil when the port is not set.
+func newWebhookServer(oss controller.OrderStatusService) *http.Server {
+	port, f := os.LookupEnv("ORDER_WEBHOOK_PORT")
+	if !f {
+		return nil
+	}
+	env := "PP_CLTID"
+	ctlID, f := os.LookupEnv(env)
+	if !f {
+		log.Fatalf("environment variable (%s) not found", env)
+	}
+	env = "PP_SECRET"
+	secret, f := os.LookupEnv(env)
+	if !f {
+		log.Fatalf("environment variable (%s) not found", env)
+	}
+	env = "PP_API"
+	api, f := os.LookupEnv(env)
+	if !f {
+		log.Fatalf("environment variable (%s) not found", env)
+	}
+	env = "PP_WEBHOOK_ID"
+	wID, f := os.LookupEnv(env)
+	if !f {
+		log.Fatalf("environment variable (%s) not found", env)
+	}
+	wv, err := adapter.NewPaypalWebhook(ctlID, secret, api, wID)
+	if err != nil {
+		log.Fatalf("fatal at started paypal webhook: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/webhooks/paypal", handler.NewWebhookUC(oss, wv))
+	return &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+}
+
+// newVerifier returns the verifier of the tokens signed with the secret in
+// ORDER_JWT_SECRET or with the RSA key in the PEM file ORDER_JWT_PUBLIC_KEY,
+// ORDER_JWT_ISSUER is checked when it is set.
+func newVerifier() auth.Verifier {
+	iss := os.Getenv("ORDER_JWT_ISSUER")
+	if secret, f := os.LookupEnv("ORDER_JWT_SECRET"); f {
+		return auth.NewVerifier(auth.HMACKey(secret), iss, tokenLeeway)
+	}
+	env := "ORDER_JWT_PUBLIC_KEY"
+	path, f := os.LookupEnv(env)
+	if !f {
+		log.Fatalf("environment variable (ORDER_JWT_SECRET or %s) not found", env)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("fatal at read jwt public key: %s", err)
+	}
+	k, err := auth.ParseRSAPublicKey(b)
+	if err != nil {
+		log.Fatalf("fatal at parse jwt public key: %s", err)
+	}
+	return auth.NewVerifier(auth.RSAKeys{os.Getenv("ORDER_JWT_KEY_ID"): k}, iss, tokenLeeway)
+}
+
+// signToken writes to the standard output a token with the claims cl, a JSON
+// object, signed with ORDER_JWT_SECRET to call a local server.
+func signToken(cl string) error {
+	secret, f := os.LookupEnv("ORDER_JWT_SECRET")
+	if !f {
+		return errors.New("environment variable (ORDER_JWT_SECRET) not found")
+	}
+	var claims auth.Claims
+	if err := json.Unmarshal([]byte(cl), &claims); err != nil {
+		return fmt.Errorf("unmarshal claims: %w", err)
+	}
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	}
+	if claims.Issuer == "" {
+		claims.Issuer = os.Getenv("ORDER_JWT_ISSUER")
+	}
+	t, err := auth.NewSigner(auth.HMACKey(secret), "").Sign(claims)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+	fmt.Println(t)
+	return nil
+}
+
+// newPageTokens returns the signer of the page tokens with the key in
+// ORDER_PAGE_SECRET, without it a random key is used and the tokens are only
+// valid until the server stops.
+func newPageTokens() controller.PageTokens {
+	if k, f := os.LookupEnv("ORDER_PAGE_SECRET"); f {
+		return controller.NewPageTokens([]byte(k))
+	}
+	log.Println("ORDER_PAGE_SECRET not found, the page tokens expire at exit")
+	k := make([]byte, 32)
+	if _, err := rand.Read(k); err != nil {
+		log.Fatalf("fatal at generate page key: %s", err)
+	}
+	return controller.NewPageTokens(k)
+}
+
+// newExpiryPolicy returns how long the delivery orders wait for their payment,
+// ORDER_PAYMENT_TTL is the TTL of every establishment and
+// ORDER_PAYMENT_TTL_ESTABLISHMENTS overrides it for some, like "1=15m,4=2h".
+func newExpiryPolicy() controller.ExpiryPolicy {
+	ttl := paymentTTL
+	if v, f := os.LookupEnv("ORDER_PAYMENT_TTL"); f {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("environment variable (ORDER_PAYMENT_TTL) is invalid: %s", err)
+		}
+		ttl = d
+	}
+	ep, err := controller.ParseExpiryPolicy(ttl, os.Getenv("ORDER_PAYMENT_TTL_ESTABLISHMENTS"))
+	if err != nil {
+		log.Fatalf("fatal at parse payment ttl: %s", err)
+	}
+	return ep
+}
+
+// newCalendar returns the business days of the establishments, ORDER_TIMEZONE
+// and ORDER_DAY_CUTOFF are the time zone and the time the days start of every
+// establishment, ORDER_TIMEZONE_ESTABLISHMENTS and
+// ORDER_DAY_CUTOFF_ESTABLISHMENTS override them for some, like
+// "1=America/Tijuana,4=America/Cancun" and "1=4h".
+func newCalendar() controller.Calendar {
+	zone := timeZone
+	if v, f := os.LookupEnv("ORDER_TIMEZONE"); f {
+		zone = v
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		log.Fatalf("environment variable (ORDER_TIMEZONE) is invalid: %s", err)
+	}
+	def := controller.BusinessDay{Location: loc}
+	if v, f := os.LookupEnv("ORDER_DAY_CUTOFF"); f {
+		if def.Cutoff, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("environment variable (ORDER_DAY_CUTOFF) is invalid: %s", err)
+		}
+	}
+	cal, err := controller.ParseCalendar(def, os.Getenv("ORDER_TIMEZONE_ESTABLISHMENTS"), os.Getenv("ORDER_DAY_CUTOFF_ESTABLISHMENTS"))
+	if err != nil {
+		log.Fatalf("fatal at parse calendar: %s", err)
+	}
+	return cal
+}
+
+func newProductService() controller.ProductPricer {
+	env := "PRODUCT_HOST"
+	host, f := os.LookupEnv(env)
+	if !f {
+		log.Fatalf("environment variable (%s) not found", env)
+	}
+	pp, err := adapter.NewProductService(host)
+	if err != nil {
+		log.Fatalf("fatal at started product service: %s", err)
+	}
+	return pp
+}
+
 func Recovery(i interface{}) error {
 	return status.Errorf(codes.Unknown, "panic triggered: %v", i)
 }
 
-func startGRPC() *grpc.Server {
+// contextError returns the gRPC status of a request that was cancelled or
+// exceeded its deadline, any other error is returned as is.
+func contextError(c context.Context, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(c.Err(), context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled) || errors.Is(c.Err(), context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return err
+}
+
+func ContextUnaryInterceptor(c context.Context, req interface{}, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+	resp, err := h(c, req)
+	if err != nil {
+		return resp, contextError(c, err)
+	}
+	return resp, nil
+}
+
+func ContextStreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+	if err := h(srv, ss); err != nil {
+		return contextError(ss.Context(), err)
+	}
+	return nil
+}
+
+var errorCodes = map[error]codes.Code{
+	apperr.ErrNotFound:           codes.NotFound,
+	apperr.ErrInvalidArgument:    codes.InvalidArgument,
+	apperr.ErrFailedPrecondition: codes.FailedPrecondition,
+	apperr.ErrConflict:           codes.Aborted,
+	apperr.ErrPaymentDeclined:    codes.FailedPrecondition,
+	apperr.ErrUnavailable:        codes.Unavailable,
+	apperr.ErrPermissionDenied:   codes.PermissionDenied,
+	apperr.ErrUnauthenticated:    codes.Unauthenticated,
+}
+
+// grpcError returns the gRPC status of the errors defined in apperr, the
+// invalid fields are sent as BadRequest details.
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	k := apperr.Kind(err)
+	c, ok := errorCodes[k]
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	st := status.New(c, err.Error())
+	if vs := apperr.Violations(err); len(vs) > 0 {
+		br := &errdetails.BadRequest{FieldViolations: make([]*errdetails.BadRequest_FieldViolation, len(vs))}
+		for i := range vs {
+			br.FieldViolations[i] = &errdetails.BadRequest_FieldViolation{Field: vs[i].Field, Description: vs[i].Description}
+		}
+		if d, err := st.WithDetails(br); err == nil {
+			st = d
+		}
+	}
+	if k == apperr.ErrPaymentDeclined {
+		if d, err := st.WithDetails(&errdetails.ErrorInfo{Reason: "PAYMENT_DECLINED", Domain: "orders"}); err == nil {
+			st = d
+		}
+	}
+	return st.Err()
+}
+
+func ErrorUnaryInterceptor(c context.Context, req interface{}, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+	resp, err := h(c, req)
+	if err != nil {
+		return resp, grpcError(err)
+	}
+	return resp, nil
+}
+
+func ErrorStreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+	if err := h(srv, ss); err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func startGRPC(au handler.Authenticator, sc handler.Scoper, is controller.IdempotencyService) *grpc.Server {
 	opts := []grpc_recovery.Option{
 		grpc_recovery.WithRecoveryHandler(Recovery),
 	}
 	server := grpc.NewServer(
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_recovery.UnaryServerInterceptor(opts...),
+			ErrorUnaryInterceptor,
+			ContextUnaryInterceptor,
+			handler.AuthUnaryInterceptor(au, sc),
+			handler.ValidationUnaryInterceptor,
+			handler.IdempotencyUnaryInterceptor(is),
 		)),
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-			grpc_middleware.ChainStreamServer(),
+			grpc_recovery.StreamServerInterceptor(opts...),
+			ErrorStreamInterceptor,
+			ContextStreamInterceptor,
+			handler.AuthStreamInterceptor(au, sc),
+			handler.ValidationStreamInterceptor,
 		)),
 	)
 	return server
 }
 
+// reconcileOnce reconciles the payments once and writes the report to the
+// standard output.
+func reconcileOnce(rc controller.PaymentReconciler) error {
+	r, err := rc.Reconcile(context.Background())
+	if err != nil {
+		return fmt.Errorf("rc.Reconcile: %w", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	return nil
+}
+
 func main() {
-	if err := storage.NewDB(newDBConn()); err != nil {
-		log.Fatalf("fatal at start db: %s", err)
+	once := flag.Bool("reconcile", false, "reconcile the payments once, print the report and exit")
+	token := flag.String("token", "", "print a token with the JSON claims signed with ORDER_JWT_SECRET and exit")
+	flag.Parse()
+	if *token != "" {
+		if err := signToken(*token); err != nil {
+			log.Fatalf("failed to sign token: %s", err)
+		}
+		return
+	}
+	str := newStorages()
+	kf := controller.NewKitchenFeed(str.orders, broker.New(kitchenEvents))
+	oss := controller.NewOrderStatusService(str.status, newPaymentGateways(), str.tx, kf)
+	rc := controller.NewPaymentReconciler(oss, reconcileInterval, reconcileAge, reconcileBatch)
+	if *once {
+		err := reconcileOnce(rc)
+		if cerr := str.close(); cerr != nil {
+			log.Printf("failed to close db: %s", cerr)
+		}
+		if err != nil {
+			log.Fatalf("failed to reconcile payments: %s", err)
+		}
+		return
+	}
+	ose := controller.NewOrderService(str.orders, str.tx, newProductService(), kf, newPageTokens(), newCalendar())
+	ctx, cancel := context.WithCancel(context.Background())
+	pub, closePub := newEventPublisher()
+	relay := controller.NewOutboxRelay(str.outbox, pub, outboxInterval, outboxBatch)
+	expirer := controller.NewOrderExpirer(oss, newExpiryPolicy(), expireInterval, expireBatch)
+	is := controller.NewIdempotencyService(str.keys, idempotencyTTL, idempotencyPurge)
+	workers := map[string]func(context.Context) error{
+		"outbox relay": relay.Run, "payment reconciler": rc.Run, "order expirer": expirer.Run, "idempotency purger": is.Run,
+	}
+	done := make(chan struct{}, len(workers))
+	for name, run := range workers {
+		go func(name string, run func(context.Context) error) {
+			defer func() { done <- struct{}{} }()
+			if err := run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("%s stopped: %s", name, err)
+			}
+		}(name, run)
 	}
-	storage.Migrate(&model.Order{}, &model.OrderProduct{})
-	ose := controller.NewOrderService(storage.NewOrderStorage())
-	oss := controller.NewOrderStatusService(storage.NewOrderStatusStorage(), newPaypalService())
 	env := "ORDER_PORT"
 	port, f := os.LookupEnv(env)
 	if !f {
@@ -126,20 +580,64 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
+	if err := handler.RegisterFiles(); err != nil {
+		log.Fatalf("fatal at register proto files: %s", err)
+	}
 	ouc := handler.NewOrderUC(ose)
 	osuc := handler.NewOrderStatusUC(oss)
-	srv := startGRPC()
+	srv := startGRPC(newVerifier(), ose, is)
 	healthServer := health.NewServer()
 	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
 	pf.RegisterOrderServiceServer(srv, ouc)
 	healthServer.SetServingStatus(pf.OrderService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
 	pf.RegisterOrderStatusServiceServer(srv, osuc)
 	healthServer.SetServingStatus(pf.OrderStatusService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	srv.RegisterService(&handler.KitchenService_ServiceDesc, handler.NewKitchenUC(kf))
+	healthServer.SetServingStatus(handler.KitchenService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	srv.RegisterService(&handler.WaiterService_ServiceDesc, handler.NewKitchenUC(kf))
+	healthServer.SetServingStatus(handler.WaiterService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	srv.RegisterService(&handler.OrderHistoryService_ServiceDesc, handler.NewHistoryUC(ose))
+	healthServer.SetServingStatus(handler.OrderHistoryService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	srv.RegisterService(&handler.CancellationService_ServiceDesc, handler.NewCancellationUC(oss))
+	healthServer.SetServingStatus(handler.CancellationService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	srv.RegisterService(&handler.RefundService_ServiceDesc, handler.NewRefundUC(oss))
+	healthServer.SetServingStatus(handler.RefundService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
 	reflection.Register(srv)
 	healthpb.RegisterHealthServer(srv, healthServer)
+	ws := newWebhookServer(oss)
+	if ws != nil {
+		go func() {
+			log.Printf("Webhook server started at %s", ws.Addr)
+			if err := ws.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("failed to serve webhooks at %s, got error: %s", ws.Addr, err)
+			}
+		}()
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("shutting down order server")
+		if ws != nil {
+			if err := ws.Shutdown(context.Background()); err != nil {
+				log.Printf("failed to shut down webhook server: %s", err)
+			}
+		}
+		srv.GracefulStop()
+	}()
 	log.Printf("Order server started at :%s", port)
 	err = srv.Serve(lis)
 	if err != nil {
 		log.Fatalf("failed to server at :%s, got error: %s", port, err)
 	}
+	cancel()
+	for range workers {
+		<-done
+	}
+	if err := closePub(); err != nil {
+		log.Printf("failed to close event bus: %s", err)
+	}
+	if err := str.close(); err != nil {
+		log.Printf("failed to close db: %s", err)
+	}
 }
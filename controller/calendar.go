@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modular-project/orders-service/model"
+)
+
+// BusinessDay is the time zone of an establishment and the local time its
+// days start, the orders taken after midnight and before Cutoff belong to
+// the previous day.
+type BusinessDay struct {
+	Location *time.Location
+	Cutoff   time.Duration
+}
+
+func (bd BusinessDay) equal(o BusinessDay) bool {
+	return bd.Location.String() == o.Location.String() && bd.Cutoff == o.Cutoff
+}
+
+// Parse returns the time of s in UTC, s is an RFC 3339 timestamp or a local
+// date that starts at the cutoff of its day.
+func (bd BusinessDay) Parse(s string) (time.Time, error) {
+	return bd.parse(s, 0)
+}
+
+// ParseEnd returns the end of a range to s in UTC, s is an RFC 3339
+// timestamp or a local date that is included, its day ends at the cutoff of
+// the next one.
+func (bd BusinessDay) ParseEnd(s string) (time.Time, error) {
+	return bd.parse(s, 1)
+}
+
+// parse returns the start of the day days after the date s.
+func (bd BusinessDay) parse(s string, days int) (time.Time, error) {
+	d, date, err := model.ParseDate(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !date {
+		return d.UTC(), nil
+	}
+	// the cutoff is added to the wall clock so it is kept on the days the
+	// clocks change.
+	h, m := int(bd.Cutoff/time.Hour), int(bd.Cutoff%time.Hour/time.Minute)
+	return time.Date(d.Year(), d.Month(), d.Day()+days, h, m, 0, 0, bd.Location).UTC(), nil
+}
+
+// Calendar is the business day of the establishments, Establishments
+// overrides Default for some establishments.
+type Calendar struct {
+	Default        BusinessDay
+	Establishments map[uint64]BusinessDay
+}
+
+// establishmentValues splits a list like "1=a,4=b" by establishment.
+func establishmentValues(list string) (map[uint64]string, error) {
+	vs := make(map[uint64]string)
+	for _, kv := range strings.Split(list, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%q must be establishment=value", kv)
+		}
+		eID, err := strconv.ParseUint(kv[:i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("establishment of %q: %w", kv, err)
+		}
+		vs[eID] = kv[i+1:]
+	}
+	return vs, nil
+}
+
+func checkCutoff(c time.Duration) error {
+	if c < 0 || c >= 24*time.Hour {
+		return fmt.Errorf("cutoff %s must be between 0 and 24h", c)
+	}
+	return nil
+}
+
+// ParseCalendar returns the calendar with the business day def and the time
+// zones and cutoffs of the establishments written as lists like
+// "1=America/Tijuana,4=America/Cancun" and "1=4h".
+func ParseCalendar(def BusinessDay, zones, cutoffs string) (Calendar, error) {
+	if err := checkCutoff(def.Cutoff); err != nil {
+		return Calendar{}, err
+	}
+	cal := Calendar{Default: def, Establishments: make(map[uint64]BusinessDay)}
+	zs, err := establishmentValues(zones)
+	if err != nil {
+		return Calendar{}, fmt.Errorf("time zones: %w", err)
+	}
+	for eID, z := range zs {
+		loc, err := time.LoadLocation(z)
+		if err != nil {
+			return Calendar{}, fmt.Errorf("time zone of %d: %w", eID, err)
+		}
+		cal.Establishments[eID] = BusinessDay{Location: loc, Cutoff: def.Cutoff}
+	}
+	cs, err := establishmentValues(cutoffs)
+	if err != nil {
+		return Calendar{}, fmt.Errorf("cutoffs: %w", err)
+	}
+	for eID, c := range cs {
+		d, err := time.ParseDuration(c)
+		if err != nil {
+			return Calendar{}, fmt.Errorf("cutoff of %d: %w", eID, err)
+		}
+		if err := checkCutoff(d); err != nil {
+			return Calendar{}, fmt.Errorf("cutoff of %d: %w", eID, err)
+		}
+		bd := cal.Day(eID)
+		bd.Cutoff = d
+		cal.Establishments[eID] = bd
+	}
+	return cal, nil
+}
+
+// Day returns the business day of the establishment eID.
+func (cal Calendar) Day(eID uint64) BusinessDay {
+	if bd, ok := cal.Establishments[eID]; ok {
+		return bd
+	}
+	return cal.Default
+}
+
+// dayGroup are the establishments that share a business day.
+type dayGroup struct {
+	day  BusinessDay
+	ests []uint64
+}
+
+func (cal Calendar) groups(ests []uint64) []dayGroup {
+	var gs []dayGroup
+	add := func(eID uint64, bd BusinessDay) {
+		for i := range gs {
+			if gs[i].day.equal(bd) {
+				gs[i].ests = append(gs[i].ests, eID)
+				return
+			}
+		}
+		gs = append(gs, dayGroup{day: bd, ests: []uint64{eID}})
+	}
+	for _, eID := range ests {
+		add(eID, cal.Day(eID))
+	}
+	return gs
+}
+
+// Ranges returns the ranges of the orders of the establishments ests created
+// from the start to the end, every establishment for nil ests. An end date is
+// included and an end timestamp excluded. Each group of establishments with
+// the same business day gets its own range.
+func (cal Calendar) Ranges(ests []uint64, start, end string) ([]model.DayRange, error) {
+	var gs []dayGroup
+	var except []uint64
+	if len(ests) == 0 {
+		ests = nil
+		// the establishments with the default day are in the last range,
+		// as every establishment not configured.
+		var own []uint64
+		for eID, bd := range cal.Establishments {
+			if !bd.equal(cal.Default) {
+				own = append(own, eID)
+			}
+		}
+		sort.Slice(own, func(i, j int) bool { return own[i] < own[j] })
+		gs, except = cal.groups(own), own
+		gs = append(gs, dayGroup{day: cal.Default})
+	} else {
+		gs = cal.groups(ests)
+	}
+	rs := make([]model.DayRange, len(gs))
+	same := true
+	for i, g := range gs {
+		from, err := g.day.Parse(start)
+		if err != nil {
+			return nil, err
+		}
+		to, err := g.day.ParseEnd(end)
+		if err != nil {
+			return nil, err
+		}
+		rs[i] = model.DayRange{Ests: g.ests, From: from, To: to}
+		if g.ests == nil {
+			rs[i].Except = except
+		}
+		same = same && from.Equal(rs[0].From) && to.Equal(rs[0].To)
+	}
+	if same {
+		// the timestamps are the same for every establishment.
+		return []model.DayRange{{Ests: ests, From: rs[0].From, To: rs[0].To}}, nil
+	}
+	return rs, nil
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func location(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("time.LoadLocation(%s) error = %v", name, err)
+	}
+	return loc
+}
+
+func TestParseCalendar(t *testing.T) {
+	mx, tj := location(t, "America/Mexico_City"), location(t, "America/Tijuana")
+	def := BusinessDay{Location: mx, Cutoff: 4 * time.Hour}
+	tests := []struct {
+		name    string
+		zones   string
+		cutoffs string
+		want    Calendar
+		wantErr bool
+	}{
+		{name: "default only", want: Calendar{Default: def, Establishments: map[uint64]BusinessDay{}}},
+		{
+			name: "establishments", zones: "1=America/Tijuana, 2=America/Tijuana", cutoffs: "2=0s,3=6h30m",
+			want: Calendar{Default: def, Establishments: map[uint64]BusinessDay{
+				1: {Location: tj, Cutoff: 4 * time.Hour},
+				2: {Location: tj},
+				3: {Location: mx, Cutoff: 6*time.Hour + 30*time.Minute},
+			}},
+		},
+		{name: "unknown zone", zones: "1=Mars/Olympus", wantErr: true},
+		{name: "without zone", zones: "1", wantErr: true},
+		{name: "invalid cutoff", cutoffs: "1=late", wantErr: true},
+		{name: "cutoff of another day", cutoffs: "1=24h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCalendar(def, tt.zones, tt.cutoffs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCalendar() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBusinessDay_Parse(t *testing.T) {
+	chicago := location(t, "America/Chicago")
+	tests := []struct {
+		name    string
+		day     BusinessDay
+		give    string
+		want    time.Time
+		wantErr error
+	}{
+		{name: "date", day: BusinessDay{Location: chicago}, give: "2022-01-10", want: time.Date(2022, 1, 10, 6, 0, 0, 0, time.UTC)},
+		{name: "date with cutoff", day: BusinessDay{Location: chicago, Cutoff: 4 * time.Hour}, give: "2022-03-12", want: time.Date(2022, 3, 12, 10, 0, 0, 0, time.UTC)},
+		{name: "date when the clocks change", day: BusinessDay{Location: chicago, Cutoff: 4 * time.Hour}, give: "2022-03-13", want: time.Date(2022, 3, 13, 9, 0, 0, 0, time.UTC)},
+		{name: "timestamp", day: BusinessDay{Location: chicago, Cutoff: 4 * time.Hour}, give: "2022-03-13T01:30:00-06:00", want: time.Date(2022, 3, 13, 7, 30, 0, 0, time.UTC)},
+		{name: "invalid", day: BusinessDay{Location: chicago}, give: "13/03/2022", wantErr: apperr.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.day.Parse(tt.give)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BusinessDay.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBusinessDay_ParseEnd(t *testing.T) {
+	chicago := location(t, "America/Chicago")
+	tests := []struct {
+		name    string
+		day     BusinessDay
+		give    string
+		want    time.Time
+		wantErr error
+	}{
+		{name: "date", day: BusinessDay{Location: chicago}, give: "2022-01-10", want: time.Date(2022, 1, 11, 6, 0, 0, 0, time.UTC)},
+		{name: "date with cutoff", day: BusinessDay{Location: chicago, Cutoff: 4 * time.Hour}, give: "2022-03-12", want: time.Date(2022, 3, 13, 9, 0, 0, 0, time.UTC)},
+		{name: "end of the month", day: BusinessDay{Location: chicago}, give: "2022-01-31", want: time.Date(2022, 2, 1, 6, 0, 0, 0, time.UTC)},
+		{name: "timestamp", day: BusinessDay{Location: chicago, Cutoff: 4 * time.Hour}, give: "2022-03-13T01:30:00-06:00", want: time.Date(2022, 3, 13, 7, 30, 0, 0, time.UTC)},
+		{name: "invalid", day: BusinessDay{Location: chicago}, give: "13/03/2022", wantErr: apperr.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.day.ParseEnd(tt.give)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BusinessDay.ParseEnd() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCalendar_Ranges(t *testing.T) {
+	mx, tj := location(t, "America/Mexico_City"), location(t, "America/Tijuana")
+	cal := Calendar{Default: BusinessDay{Location: mx}, Establishments: map[uint64]BusinessDay{
+		1: {Location: tj},
+		2: {Location: mx},
+		3: {Location: tj},
+	}}
+	// Mexico City is UTC-6 and Tijuana UTC-8 in January.
+	day := func(d, h int) time.Time { return time.Date(2022, 1, d, h, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name       string
+		ests       []uint64
+		start, end string
+		want       []model.DayRange
+	}{
+		{
+			name: "one establishment", ests: []uint64{1}, start: "2022-01-10", end: "2022-01-10",
+			want: []model.DayRange{{Ests: []uint64{1}, From: day(10, 8), To: day(11, 8)}},
+		},
+		{
+			name: "establishments of a zone", ests: []uint64{2, 4}, start: "2022-01-10", end: "2022-01-10",
+			want: []model.DayRange{{Ests: []uint64{2, 4}, From: day(10, 6), To: day(11, 6)}},
+		},
+		{
+			name: "establishments of several zones", ests: []uint64{1, 2, 3}, start: "2022-01-10", end: "2022-01-10",
+			want: []model.DayRange{
+				{Ests: []uint64{1, 3}, From: day(10, 8), To: day(11, 8)},
+				{Ests: []uint64{2}, From: day(10, 6), To: day(11, 6)},
+			},
+		},
+		{
+			name: "every establishment", start: "2022-01-10", end: "2022-01-10",
+			want: []model.DayRange{
+				{Ests: []uint64{1, 3}, From: day(10, 8), To: day(11, 8)},
+				{Except: []uint64{1, 3}, From: day(10, 6), To: day(11, 6)},
+			},
+		},
+		{
+			name: "timestamps", ests: []uint64{1, 2}, start: "2022-01-10T00:00:00Z", end: "2022-01-11T00:00:00Z",
+			want: []model.DayRange{{Ests: []uint64{1, 2}, From: day(10, 0), To: day(11, 0)}},
+		},
+		{
+			name: "timestamps of every establishment", start: "2022-01-10T00:00:00Z", end: "2022-01-11T00:00:00Z",
+			want: []model.DayRange{{From: day(10, 0), To: day(11, 0)}},
+		},
+		{
+			name: "several days", ests: []uint64{1}, start: "2022-01-10", end: "2022-01-12",
+			want: []model.DayRange{{Ests: []uint64{1}, From: day(10, 8), To: day(13, 8)}},
+		},
+		{
+			name: "date and timestamp", ests: []uint64{1, 2}, start: "2022-01-10", end: "2022-01-11T00:00:00Z",
+			want: []model.DayRange{
+				{Ests: []uint64{1}, From: day(10, 8), To: day(11, 0)},
+				{Ests: []uint64{2}, From: day(10, 6), To: day(11, 0)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cal.Ranges(tt.ests, tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("Calendar.Ranges() error = %v", err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// ExpiryPolicy is how long a delivery order can wait for its payment,
+// Establishments overrides Default for some establishments. The orders not
+// assigned to an establishment yet wait for Default.
+type ExpiryPolicy struct {
+	Default        time.Duration
+	Establishments map[uint64]time.Duration
+}
+
+// ParseExpiryPolicy returns the policy with the TTL def and the TTL of the
+// establishments written as a list like "1=15m,4=2h".
+func ParseExpiryPolicy(def time.Duration, establishments string) (ExpiryPolicy, error) {
+	ep := ExpiryPolicy{Default: def, Establishments: make(map[uint64]time.Duration)}
+	if def <= 0 {
+		return ExpiryPolicy{}, fmt.Errorf("default ttl %s must be positive", def)
+	}
+	vs, err := establishmentValues(establishments)
+	if err != nil {
+		return ExpiryPolicy{}, fmt.Errorf("ttls: %w", err)
+	}
+	for eID, v := range vs {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return ExpiryPolicy{}, fmt.Errorf("ttl of %d: %w", eID, err)
+		}
+		if ttl <= 0 {
+			return ExpiryPolicy{}, fmt.Errorf("ttl of %d must be positive", eID)
+		}
+		ep.Establishments[eID] = ttl
+	}
+	return ep, nil
+}
+
+// TTL returns how long the orders of the establishment eID can wait for
+// their payment.
+func (ep ExpiryPolicy) TTL(eID uint64) time.Duration {
+	if ttl, ok := ep.Establishments[eID]; ok {
+		return ttl
+	}
+	return ep.Default
+}
+
+// shortest returns the shortest TTL of every establishment.
+func (ep ExpiryPolicy) shortest() time.Duration {
+	min := ep.Default
+	for _, ttl := range ep.Establishments {
+		if ttl < min {
+			min = ttl
+		}
+	}
+	return min
+}
+
+// OrderExpirer moves the delivery orders that were not paid in time to
+// Expired and voids their pending payment.
+type OrderExpirer struct {
+	oss      OrderStatusService
+	policy   ExpiryPolicy
+	interval time.Duration
+	batch    int
+}
+
+func NewOrderExpirer(oss OrderStatusService, policy ExpiryPolicy, interval time.Duration, batch int) OrderExpirer {
+	return OrderExpirer{oss: oss, policy: policy, interval: interval, batch: batch}
+}
+
+// Expire expires once the delivery orders that waited for their payment
+// longer than the TTL of their establishment, it returns how many expired.
+// An order that can not expire is logged and skipped.
+func (oe OrderExpirer) Expire(c context.Context) (int, error) {
+	now := time.Now()
+	before := now.Add(-oe.policy.shortest())
+	var after uint64
+	n := 0
+	for {
+		os, err := oe.oss.ost.UnpaidOrders(c, before, after, oe.batch)
+		if err != nil {
+			return n, fmt.Errorf("ost.UnpaidOrders: %w", err)
+		}
+		for _, o := range os {
+			if err := c.Err(); err != nil {
+				return n, err
+			}
+			after = o.ID
+			if o.TypeID != model.Delivery || now.Sub(o.CreatedAt) < oe.policy.TTL(o.EstablishmentID) {
+				continue
+			}
+			ok, err := oe.oss.expireUnpaid(c, o.ID)
+			if err != nil {
+				log.Printf("order expirer: order %d: %s", o.ID, err)
+				continue
+			}
+			if ok {
+				n++
+			}
+		}
+		if len(os) < oe.batch {
+			return n, nil
+		}
+	}
+}
+
+// Run calls Expire every interval until c is done.
+func (oe OrderExpirer) Run(c context.Context) error {
+	t := time.NewTicker(oe.interval)
+	defer t.Stop()
+	for {
+		if _, err := oe.Expire(c); err != nil && c.Err() == nil {
+			log.Printf("order expirer: %s", err)
+		}
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// expireUnpaid voids the payment of the order oID and expires it, it reports
+// false when the order is no longer waiting for the payment. The payment is
+// voided outside of any transaction.
+func (oss OrderStatusService) expireUnpaid(c context.Context, oID uint64) (bool, error) {
+	o, err := oss.ost.Order(c, oID)
+	if err != nil {
+		return false, fmt.Errorf("ost.Order: %w", err)
+	}
+	if o.StatusID != model.AwaitingPayment {
+		return false, nil
+	}
+	var pID string
+	if o.PayID != nil {
+		pID = *o.PayID
+		g, err := oss.gateway(o)
+		if err != nil {
+			return false, err
+		}
+		if err := g.Void(c, pID); err != nil && !errors.Is(err, apperr.ErrNotFound) {
+			return false, fmt.Errorf("g.Void: %w", err)
+		}
+	}
+	return oss.expirePayment(c, oID, pID)
+}
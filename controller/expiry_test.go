@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpiryPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		give    string
+		want    ExpiryPolicy
+		wantErr bool
+	}{
+		{name: "default only", want: ExpiryPolicy{Default: time.Hour, Establishments: map[uint64]time.Duration{}}},
+		{
+			name: "establishments",
+			give: "1=15m, 4=2h,",
+			want: ExpiryPolicy{Default: time.Hour, Establishments: map[uint64]time.Duration{1: 15 * time.Minute, 4: 2 * time.Hour}},
+		},
+		{name: "without ttl", give: "1", wantErr: true},
+		{name: "invalid establishment", give: "a=15m", wantErr: true},
+		{name: "invalid ttl", give: "1=soon", wantErr: true},
+		{name: "negative ttl", give: "1=-15m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExpiryPolicy(time.Hour, tt.give)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExpiryPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOrderExpirer_Expire(t *testing.T) {
+	payID := "PAY-1"
+	now := time.Now()
+	ost := &fakeStatusStorage{orders: map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1, CreatedAt: now.Add(-2 * time.Hour)}, TypeID: model.Delivery, StatusID: model.AwaitingPayment, PayID: &payID},
+		2: {Model: model.Model{ID: 2, CreatedAt: now.Add(-2 * time.Hour)}, TypeID: model.Delivery, StatusID: model.AwaitingPayment},
+		3: {Model: model.Model{ID: 3, CreatedAt: now.Add(-10 * time.Minute)}, TypeID: model.Delivery, StatusID: model.AwaitingPayment},
+		4: {Model: model.Model{ID: 4, CreatedAt: now.Add(-10 * time.Minute)}, TypeID: model.Delivery, EstablishmentID: 2, StatusID: model.AwaitingPayment},
+		5: {Model: model.Model{ID: 5, CreatedAt: now.Add(-2 * time.Hour)}, TypeID: model.Delivery, EstablishmentID: 3, StatusID: model.AwaitingPayment},
+		6: {Model: model.Model{ID: 6, CreatedAt: now.Add(-2 * time.Hour)}, TypeID: model.Delivery, StatusID: model.Paid},
+	}}
+	ps := &fakeGateway{}
+	oss := NewOrderStatusService(ost, paypalGateways(ps), fakeTx{os: &fakeOrderStorage{}, ost: ost}, &fakeNotifier{})
+	policy := ExpiryPolicy{Default: time.Hour, Establishments: map[uint64]time.Duration{2: 5 * time.Minute, 3: 3 * time.Hour}}
+	got, err := NewOrderExpirer(oss, policy, time.Minute, 2).Expire(context.Background())
+	if err != nil {
+		t.Fatalf("OrderExpirer.Expire() error = %v", err)
+	}
+	assert.Equal(t, 3, got)
+	assert.Equal(t, []uint64{1, 2, 4}, ost.expired)
+	assert.Equal(t, []string{"PAY-1"}, ps.voided)
+}
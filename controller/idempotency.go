@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// maxIdempotencyKey is the longest idempotency key accepted.
+const maxIdempotencyKey = 255
+
+// completeAttempts is how many times the response of a request is stored
+// before its key is released.
+const completeAttempts = 3
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with
+	// another request.
+	ErrIdempotencyKeyReused = apperr.New(apperr.ErrInvalidArgument, "idempotency key reused with another request")
+	// ErrIdempotencyInProgress is returned when a key is sent again before
+	// its first request finished.
+	ErrIdempotencyInProgress = apperr.New(apperr.ErrConflict, "request with the same idempotency key in progress")
+)
+
+type IdempotencyStorager interface {
+	Reserve(c context.Context, k *model.IdempotencyKey) (model.IdempotencyKey, bool, error)
+	Complete(c context.Context, key, scope string, resp []byte) error
+	Release(c context.Context, key, scope string) error
+	Purge(c context.Context, before time.Time) (int64, error)
+}
+
+// IdempotencyService runs once the requests sent with the same idempotency
+// key, the keys are kept for ttl and the expired ones are deleted every
+// interval.
+type IdempotencyService struct {
+	str      IdempotencyStorager
+	ttl      time.Duration
+	interval time.Duration
+}
+
+func NewIdempotencyService(str IdempotencyStorager, ttl, interval time.Duration) IdempotencyService {
+	return IdempotencyService{str: str, ttl: ttl, interval: interval}
+}
+
+// actorScope returns scope for the actor of c, the same key sent by two users
+// or employees belongs to different requests.
+func actorScope(c context.Context, scope string) string {
+	a := model.ActorFromContext(c)
+	return fmt.Sprintf("%s:user=%d:employee=%d", scope, a.UserID, a.EmployeeID)
+}
+
+// Do runs fn for the first request req sent with key in scope by the actor of
+// c and returns its response to the repeated ones. A failed request is not
+// stored so it can be retried with the same key, neither is a response that
+// fails to be stored.
+func (is IdempotencyService) Do(c context.Context, scope, key string, req []byte, fn func() ([]byte, error)) ([]byte, error) {
+	if key == "" || len(key) > maxIdempotencyKey {
+		return nil, apperr.InvalidArgument(apperr.FieldViolation{
+			Field: "idempotency-key", Description: fmt.Sprintf("must have between 1 and %d characters", maxIdempotencyKey),
+		})
+	}
+	scope = actorScope(c, scope)
+	sum := sha256.Sum256(req)
+	k := model.IdempotencyKey{Key: key, Scope: scope, Hash: hex.EncodeToString(sum[:]), ExpiresAt: time.Now().Add(is.ttl)}
+	got, created, err := is.str.Reserve(c, &k)
+	if err != nil {
+		return nil, fmt.Errorf("str.Reserve: %w", err)
+	}
+	if !created {
+		switch {
+		case got.Hash != k.Hash:
+			return nil, ErrIdempotencyKeyReused
+		case got.CompletedAt == nil:
+			return nil, ErrIdempotencyInProgress
+		}
+		return got.Response, nil
+	}
+	resp, err := fn()
+	if err != nil {
+		// the request may have been cancelled, the key is released anyway.
+		if rerr := is.str.Release(context.Background(), key, scope); rerr != nil {
+			log.Printf("idempotency: str.Release %s: %s", key, rerr)
+		}
+		return nil, err
+	}
+	is.complete(key, scope, resp)
+	return resp, nil
+}
+
+// complete stores the response of the request sent with key in scope, the key
+// is released when it can not be stored so a retry runs the request again
+// instead of waiting for the key to expire.
+func (is IdempotencyService) complete(key, scope string, resp []byte) {
+	// the request may have been cancelled after it finished.
+	c := context.Background()
+	var err error
+	for i := 0; i < completeAttempts; i++ {
+		if err = is.str.Complete(c, key, scope, resp); err == nil {
+			return
+		}
+	}
+	log.Printf("idempotency: str.Complete %s: %s", key, err)
+	if err := is.str.Release(c, key, scope); err != nil {
+		log.Printf("idempotency: str.Release %s: %s", key, err)
+	}
+}
+
+// Run deletes the expired keys every interval until c is done.
+func (is IdempotencyService) Run(c context.Context) error {
+	t := time.NewTicker(is.interval)
+	defer t.Stop()
+	for {
+		if _, err := is.str.Purge(c, time.Now()); err != nil && c.Err() == nil {
+			log.Printf("idempotency: str.Purge: %s", err)
+		}
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-t.C:
+		}
+	}
+}
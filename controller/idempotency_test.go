@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeIdempotencyStorage struct {
+	IdempotencyStorager
+	// keys are stored by scope and key.
+	keys map[string]model.IdempotencyKey
+	// failComplete is how many times Complete fails.
+	failComplete int
+}
+
+func (f *fakeIdempotencyStorage) Reserve(c context.Context, k *model.IdempotencyKey) (model.IdempotencyKey, bool, error) {
+	if got, ok := f.keys[k.Scope+" "+k.Key]; ok {
+		return got, false, nil
+	}
+	f.keys[k.Scope+" "+k.Key] = *k
+	return *k, true, nil
+}
+
+func (f *fakeIdempotencyStorage) Complete(c context.Context, key, scope string, resp []byte) error {
+	if f.failComplete > 0 {
+		f.failComplete--
+		return apperr.New(apperr.ErrUnavailable, "database is down")
+	}
+	k := f.keys[scope+" "+key]
+	now := time.Now()
+	k.Response, k.CompletedAt = resp, &now
+	f.keys[scope+" "+key] = k
+	return nil
+}
+
+func (f *fakeIdempotencyStorage) Release(c context.Context, key, scope string) error {
+	delete(f.keys, scope+" "+key)
+	return nil
+}
+
+func TestIdempotencyService_Do(t *testing.T) {
+	errFailed := errors.New("failed")
+	customer := model.Actor{UserID: 7, Role: model.RoleCustomer}
+	scope := "create:user=7:employee=0"
+	tests := []struct {
+		name         string
+		actor        model.Actor
+		key          string
+		req          string
+		fail         bool
+		failComplete int
+		want         string
+		wantErr      error
+		wantRuns     int
+		wantStored   bool
+	}{
+		{name: "first request", actor: customer, key: "K-2", req: "order", want: "response order", wantRuns: 1, wantStored: true},
+		{name: "repeated request", actor: customer, key: "K-1", req: "order", want: "stored", wantStored: true},
+		{name: "another request", actor: customer, key: "K-1", req: "other order", wantErr: ErrIdempotencyKeyReused},
+		{name: "in progress", actor: customer, key: "K-0", req: "order", wantErr: ErrIdempotencyInProgress},
+		{name: "failed request", actor: customer, key: "K-2", req: "order", fail: true, wantErr: errFailed, wantRuns: 1},
+		{name: "without key", actor: customer, req: "order", wantErr: apperr.ErrInvalidArgument},
+		{
+			name: "key of another user", actor: model.Actor{UserID: 8, Role: model.RoleCustomer}, key: "K-1", req: "order",
+			want: "response order", wantRuns: 1, wantStored: true,
+		}, {
+			name: "stored on a retry", actor: customer, key: "K-2", req: "order", failComplete: completeAttempts - 1,
+			want: "response order", wantRuns: 1, wantStored: true,
+		}, {
+			name: "response not stored", actor: customer, key: "K-2", req: "order", failComplete: completeAttempts,
+			want: "response order", wantRuns: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			str := &fakeIdempotencyStorage{keys: make(map[string]model.IdempotencyKey)}
+			is := NewIdempotencyService(str, time.Hour, time.Hour)
+			c := model.ContextWithActor(context.Background(), customer)
+			// K-1 completed and K-0 still running, both for "order" of the customer.
+			if _, err := is.Do(c, "create", "K-1", []byte("order"), func() ([]byte, error) { return []byte("stored"), nil }); err != nil {
+				t.Fatalf("IdempotencyService.Do() error = %v", err)
+			}
+			k := str.keys[scope+" K-1"]
+			k.Key, k.CompletedAt = "K-0", nil
+			str.keys[scope+" K-0"] = k
+			str.failComplete = tt.failComplete
+			runs := 0
+			c = model.ContextWithActor(context.Background(), tt.actor)
+			got, err := is.Do(c, "create", tt.key, []byte(tt.req), func() ([]byte, error) {
+				runs++
+				if tt.fail {
+					return nil, errFailed
+				}
+				return []byte("response " + tt.req), nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IdempotencyService.Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantRuns, runs)
+			if err != nil {
+				if tt.fail {
+					assert.NotContains(t, str.keys, scope+" "+tt.key)
+				}
+				return
+			}
+			assert.Equal(t, tt.want, string(got))
+			k, ok := str.keys[actorScope(c, "create")+" "+tt.key]
+			// a response that is not stored releases the key.
+			assert.Equal(t, tt.wantStored, ok)
+			if ok {
+				assert.NotNil(t, k.CompletedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/modular-project/orders-service/broker"
+	"github.com/modular-project/orders-service/model"
+)
+
+type KitchenStorager interface {
+	Kitchen(c context.Context, eID, last uint64) ([]model.OrderProduct, error)
+	WaiterPending(c context.Context, wID uint64) ([]model.Order, error)
+	KitchenProducts(c context.Context, ids []uint64) ([]model.KitchenProduct, error)
+}
+
+// KitchenNotifier is told about the order products that changed, once the
+// change is stored.
+type KitchenNotifier interface {
+	Notify(c context.Context, k model.KitchenEventKind, ids ...uint64)
+}
+
+// KitchenFeed publishes the changes of the order products to the kitchen of
+// their establishment and, once they are ready, to the waiter of their table.
+type KitchenFeed struct {
+	str KitchenStorager
+	b   *broker.Broker
+}
+
+func NewKitchenFeed(str KitchenStorager, b *broker.Broker) KitchenFeed {
+	return KitchenFeed{str: str, b: b}
+}
+
+func kitchenTopic(eID uint64) string {
+	return fmt.Sprintf("kitchen.%d", eID)
+}
+
+func waiterTopic(wID uint64) string {
+	return fmt.Sprintf("waiter.%d", wID)
+}
+
+// Notify publishes the products of orders shown in the kitchen and every
+// cancelled product so it is removed from the screens, a failure is only
+// logged because the change is already stored and a reconnecting client
+// loads it anyway.
+func (kf KitchenFeed) Notify(c context.Context, k model.KitchenEventKind, ids ...uint64) {
+	if len(ids) == 0 {
+		return
+	}
+	kps, err := kf.str.KitchenProducts(c, ids)
+	if err != nil {
+		log.Printf("kitchen feed: str.KitchenProducts: %s", err)
+		return
+	}
+	for _, kp := range kps {
+		if !kp.StatusID.InKitchen() && k != model.ProductCancelled {
+			continue
+		}
+		e := model.KitchenEvent{Kind: k, TableID: kp.TableID, Product: kp.OrderProduct}
+		kf.b.Publish(kitchenTopic(kp.EstablishmentID), e)
+		if kp.EmployeeID != 0 && kp.IsReady {
+			kf.b.Publish(waiterTopic(kp.EmployeeID), e)
+		}
+	}
+}
+
+// Watch sends to send the products of the kitchen of the establishment eID
+// until c is done or send fails. When the events after the cursor can not be
+// replayed the products pending in the kitchen are sent first as ProductAdded,
+// only the last of them has the cursor to resume from.
+func (kf KitchenFeed) Watch(c context.Context, eID, after uint64, send func(model.KitchenEvent) error) error {
+	return kf.watch(c, kitchenTopic(eID), after, send, func() ([]model.KitchenEvent, error) {
+		ps, err := kf.str.Kitchen(c, eID, 0)
+		if err != nil {
+			return nil, fmt.Errorf("str.Kitchen: %w", err)
+		}
+		es := make([]model.KitchenEvent, len(ps))
+		for i := range ps {
+			es[i] = model.KitchenEvent{Kind: model.ProductAdded, Product: ps[i]}
+		}
+		return es, nil
+	})
+}
+
+// WatchWaiter sends to send the products of the tables of the waiter wID
+// when they are ready and when they are delivered, it resumes like Watch
+// starting with the products ready to deliver.
+func (kf KitchenFeed) WatchWaiter(c context.Context, wID, after uint64, send func(model.KitchenEvent) error) error {
+	return kf.watch(c, waiterTopic(wID), after, send, func() ([]model.KitchenEvent, error) {
+		os, err := kf.str.WaiterPending(c, wID)
+		if err != nil {
+			return nil, fmt.Errorf("str.WaiterPending: %w", err)
+		}
+		var es []model.KitchenEvent
+		for _, o := range os {
+			for _, p := range o.OrderProducts {
+				es = append(es, model.KitchenEvent{Kind: model.ProductAdded, TableID: o.TableID, Product: p})
+			}
+		}
+		return es, nil
+	})
+}
+
+func (kf KitchenFeed) watch(c context.Context, topic string, after uint64, send func(model.KitchenEvent) error, snapshot func() ([]model.KitchenEvent, error)) error {
+	sub, replayed := kf.b.Subscribe(topic, after)
+	defer sub.Close()
+	if !replayed {
+		es, err := snapshot()
+		if err != nil {
+			return err
+		}
+		for i := range es {
+			if i == len(es)-1 {
+				es[i].Cursor = sub.Cursor
+			}
+			if err := send(es[i]); err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+		}
+	}
+	for {
+		select {
+		case <-c.Done():
+			return c.Err()
+		case e, ok := <-sub.C:
+			if !ok {
+				return fmt.Errorf("%s: %w", topic, sub.Err())
+			}
+			if err := send(e); err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+		}
+	}
+}
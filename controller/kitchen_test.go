@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/broker"
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKitchenStorage struct {
+	pending  []model.OrderProduct
+	tables   []model.Order
+	products map[uint64]model.KitchenProduct
+}
+
+func (f *fakeKitchenStorage) WaiterPending(c context.Context, wID uint64) ([]model.Order, error) {
+	return f.tables, nil
+}
+
+func (f *fakeKitchenStorage) Kitchen(c context.Context, eID, last uint64) ([]model.OrderProduct, error) {
+	return f.pending, nil
+}
+
+func (f *fakeKitchenStorage) KitchenProducts(c context.Context, ids []uint64) ([]model.KitchenProduct, error) {
+	var kps []model.KitchenProduct
+	for _, id := range ids {
+		if kp, ok := f.products[id]; ok {
+			kps = append(kps, kp)
+		}
+	}
+	return kps, nil
+}
+
+var errStop = errors.New("stop")
+
+type watchFunc func(c context.Context, id, after uint64, send func(model.KitchenEvent) error) error
+
+// watch collects the events sent by fn until n are received.
+func watch(fn watchFunc, id, after uint64, n int) ([]model.KitchenEvent, error) {
+	var es []model.KitchenEvent
+	c, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := fn(c, id, after, func(e model.KitchenEvent) error {
+		es = append(es, e)
+		if len(es) == n {
+			return errStop
+		}
+		return nil
+	})
+	return es, err
+}
+
+func TestKitchenFeed_Watch(t *testing.T) {
+	str := &fakeKitchenStorage{
+		pending: []model.OrderProduct{{ID: 1}, {ID: 2}},
+		products: map[uint64]model.KitchenProduct{
+			1: {OrderProduct: model.OrderProduct{ID: 1, IsReady: true}, EstablishmentID: 1, StatusID: model.InPreparation},
+			3: {OrderProduct: model.OrderProduct{ID: 3}, EstablishmentID: 1, StatusID: model.InPreparation},
+			4: {OrderProduct: model.OrderProduct{ID: 4}, EstablishmentID: 1, StatusID: model.AwaitingPayment},
+			5: {OrderProduct: model.OrderProduct{ID: 5}, EstablishmentID: 2, StatusID: model.InPreparation},
+			6: {OrderProduct: model.OrderProduct{ID: 6, IsCancelled: true}, EstablishmentID: 1, StatusID: model.Cancelled},
+		},
+	}
+	kf := NewKitchenFeed(str, broker.New(10))
+
+	es, err := watch(kf.Watch, 1, 0, 2)
+	assert.True(t, errors.Is(err, errStop))
+	assert.Equal(t, model.ProductAdded, es[1].Kind)
+	assert.Zero(t, es[0].Cursor)
+	cursor := es[1].Cursor
+	assert.NotZero(t, cursor)
+
+	kf.Notify(context.Background(), model.ProductAdded, 3, 4, 5)
+	kf.Notify(context.Background(), model.ProductUpdated, 1)
+	es, err = watch(kf.Watch, 1, cursor, 2)
+	assert.True(t, errors.Is(err, errStop))
+	if assert.Len(t, es, 2) {
+		assert.Equal(t, uint64(3), es[0].Product.ID)
+		assert.Equal(t, model.ProductUpdated, es[1].Kind)
+		assert.True(t, es[1].Product.IsReady)
+		assert.Greater(t, es[1].Cursor, es[0].Cursor)
+	}
+
+	cursor = es[1].Cursor
+	es, err = watch(kf.Watch, 1, cursor, 1)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Empty(t, es)
+
+	kf.Notify(context.Background(), model.ProductCancelled, 6)
+	es, err = watch(kf.Watch, 1, cursor, 1)
+	assert.True(t, errors.Is(err, errStop))
+	if assert.Len(t, es, 1) {
+		assert.Equal(t, model.ProductCancelled, es[0].Kind)
+		assert.Equal(t, uint64(6), es[0].Product.ID)
+	}
+}
+
+func TestKitchenFeed_WatchWaiter(t *testing.T) {
+	str := &fakeKitchenStorage{
+		tables: []model.Order{{TableID: 4, OrderProducts: []model.OrderProduct{{ID: 1, IsReady: true}}}},
+		products: map[uint64]model.KitchenProduct{
+			2: {OrderProduct: model.OrderProduct{ID: 2}, EmployeeID: 7, TableID: 4, StatusID: model.InPreparation},
+			3: {OrderProduct: model.OrderProduct{ID: 3, IsReady: true}, EmployeeID: 7, TableID: 5, StatusID: model.InPreparation},
+			4: {OrderProduct: model.OrderProduct{ID: 4, IsReady: true}, EmployeeID: 8, TableID: 1, StatusID: model.InPreparation},
+			1: {OrderProduct: model.OrderProduct{ID: 1, IsReady: true, IsDelivered: true}, EmployeeID: 7, TableID: 4, StatusID: model.InPreparation},
+		},
+	}
+	kf := NewKitchenFeed(str, broker.New(10))
+
+	es, err := watch(kf.WatchWaiter, 7, 0, 1)
+	assert.True(t, errors.Is(err, errStop))
+	if assert.Len(t, es, 1) {
+		assert.Equal(t, uint64(4), es[0].TableID)
+		assert.NotZero(t, es[0].Cursor)
+	}
+
+	kf.Notify(context.Background(), model.ProductAdded, 2)
+	kf.Notify(context.Background(), model.ProductUpdated, 3, 4)
+	kf.Notify(context.Background(), model.ProductUpdated, 1)
+	es, err = watch(kf.WatchWaiter, 7, es[0].Cursor, 2)
+	assert.True(t, errors.Is(err, errStop))
+	if assert.Len(t, es, 2) {
+		assert.Equal(t, uint64(3), es[0].Product.ID)
+		assert.Equal(t, uint64(5), es[0].TableID)
+		assert.True(t, es[1].Product.IsDelivered)
+	}
+}
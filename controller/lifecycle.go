@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// ErrInvalidTransition is wrapped by every TransitionError.
+var ErrInvalidTransition = apperr.New(apperr.ErrFailedPrecondition, "invalid order status transition")
+
+// transitions is the order lifecycle, it maps every status to the statuses
+// it can move to. Cancelled, Refunded and Expired are final, a partially
+// refunded order stays in PartiallyRefunded until the rest is refunded. Ready
+// and delivered orders go back to InPreparation when products are added.
+var transitions = map[model.Status][]model.Status{
+	model.Draft:             {model.AwaitingPayment, model.InPreparation, model.Cancelled},
+	model.AwaitingPayment:   {model.Paid, model.Cancelled, model.Expired},
+	model.Paid:              {model.InPreparation, model.Closed, model.Refunded, model.PartiallyRefunded},
+	model.InPreparation:     {model.Ready, model.Closed, model.Cancelled},
+	model.Ready:             {model.InPreparation, model.OutForDelivery, model.Delivered, model.Closed},
+	model.OutForDelivery:    {model.Delivered},
+	model.Delivered:         {model.InPreparation, model.Closed},
+	model.Closed:            {model.Refunded, model.PartiallyRefunded},
+	model.Cancelled:         nil,
+	model.Refunded:          nil,
+	model.PartiallyRefunded: {model.Refunded},
+	model.Expired:           nil,
+}
+
+// TransitionError is returned when an order can not move From one status To another.
+type TransitionError struct {
+	From model.Status
+	To   model.Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("order can not change from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// CanTransition reports whether an order in status from can move to status to.
+func CanTransition(from, to model.Status) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+func checkTransition(from, to model.Status) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+	return nil
+}
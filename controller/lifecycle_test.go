@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/modular-project/orders-service/model"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    model.Status
+		to      model.Status
+		wantErr bool
+	}{
+		{name: "pay awaiting order", from: model.AwaitingPayment, to: model.Paid},
+		{name: "close local order", from: model.InPreparation, to: model.Closed},
+		{name: "refund closed order", from: model.Closed, to: model.Refunded},
+		{name: "refund the rest", from: model.PartiallyRefunded, to: model.Refunded},
+		{name: "expire awaiting order", from: model.AwaitingPayment, to: model.Expired},
+		{name: "add products to ready order", from: model.Ready, to: model.InPreparation},
+		{name: "pay twice", from: model.Paid, to: model.Paid, wantErr: true},
+		{name: "complete cancelled order", from: model.Cancelled, to: model.Closed, wantErr: true},
+		{name: "refund unpaid order", from: model.AwaitingPayment, to: model.Refunded, wantErr: true},
+		{name: "pay expired order", from: model.Expired, to: model.Paid, wantErr: true},
+		{name: "unknown status", from: 0, to: model.Paid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTransition(tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkTransition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("checkTransition() error = %v, want ErrInvalidTransition", err)
+			}
+		})
+	}
+}
@@ -1,145 +1,283 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/modular-project/orders-service/apperr"
 	"github.com/modular-project/orders-service/model"
 )
 
 type OrderStorager interface {
-	Kitchen(kID, last uint64) ([]model.OrderProduct, error)
-	Search(*model.SearchOrder) ([]model.Order, error)
-	Waiter(uint64) ([]model.Order, error)
-	WaiterPending(uint64) ([]model.Order, error)
-	Create(*model.Order) error
-	Products(uint64) ([]model.OrderProduct, error)
-	AddProducts(uint64, float64, []model.OrderProduct) error
-	PayPaypal(string) error
-	Pay(uint64, float64) error
-	SetPayment(uint64, float64, string) error
-	User(uID uint64, limit, offset int) ([]model.Order, error)
-	GetTipsFromEmployee(eID uint64, start, end string) (float32, error)
+	Kitchen(c context.Context, kID, last uint64) ([]model.OrderProduct, error)
+	KitchenProducts(c context.Context, ids []uint64) ([]model.KitchenProduct, error)
+	Owners(c context.Context, ids []uint64) ([]model.Order, error)
+	PaymentOwners(c context.Context, pIDs []string) ([]model.Order, error)
+	Search(context.Context, *model.SearchOrder) (model.OrderPage, error)
+	Waiter(context.Context, uint64) ([]model.Order, error)
+	WaiterPending(context.Context, uint64) ([]model.Order, error)
+	Create(context.Context, *model.Order) error
+	Products(context.Context, uint64) ([]model.OrderProduct, error)
+	AddProducts(context.Context, uint64, model.Money, []model.OrderProduct) error
+	User(c context.Context, uID uint64, s model.Search) (model.OrderPage, error)
+	GetTipsFromEmployee(c context.Context, eID uint64, days []model.DayRange) (model.Money, error)
+	History(c context.Context, oID uint64) ([]model.OrderAudit, error)
 }
 
 type OrderService struct {
 	str OrderStorager
+	tx  Transactor
+	pp  ProductPricer
+	kn  KitchenNotifier
+	pt  PageTokens
+	cal Calendar
 }
 
-func NewOrderService(str OrderStorager) OrderService {
-	return OrderService{str: str}
+func NewOrderService(str OrderStorager, tx Transactor, pp ProductPricer, kn KitchenNotifier, pt PageTokens, cal Calendar) OrderService {
+	return OrderService{str: str, tx: tx, pp: pp, kn: kn, pt: pt, cal: cal}
 }
 
-func (os OrderService) Products(oID uint64) ([]model.OrderProduct, error) {
+func (os OrderService) Products(c context.Context, oID uint64) ([]model.OrderProduct, error) {
 	if oID == 0 {
-		return nil, fmt.Errorf("order not found")
+		return nil, apperr.New(apperr.ErrNotFound, "order not found")
 	}
-	ps, err := os.str.Products(oID)
+	ps, err := os.str.Products(c, oID)
 	if err != nil {
 		return nil, fmt.Errorf("products by order: %w", err)
 	}
 	return ps, nil
 }
 
-func (os OrderService) Create(o *model.Order) ([]uint64, error) {
-	if err := os.str.Create(o); err != nil {
+// History returns the audit trail of the order.
+func (os OrderService) History(c context.Context, oID uint64) ([]model.OrderAudit, error) {
+	if oID == 0 {
+		return nil, apperr.New(apperr.ErrNotFound, "order not found")
+	}
+	as, err := os.str.History(c, oID)
+	if err != nil {
+		return nil, fmt.Errorf("str.History: %w", err)
+	}
+	return as, nil
+}
+
+// Owners returns the user, employee and establishment of the orders ids.
+func (os OrderService) Owners(c context.Context, ids []uint64) ([]model.Order, error) {
+	o, err := os.str.Owners(c, ids)
+	if err != nil {
+		return nil, fmt.Errorf("str.Owners: %w", err)
+	}
+	return o, nil
+}
+
+// PaymentOwners returns the user, employee and establishment of the orders
+// paid with the payments pIDs.
+func (os OrderService) PaymentOwners(c context.Context, pIDs []string) ([]model.Order, error) {
+	o, err := os.str.PaymentOwners(c, pIDs)
+	if err != nil {
+		return nil, fmt.Errorf("str.PaymentOwners: %w", err)
+	}
+	return o, nil
+}
+
+// ProductOwners returns the products ids with the establishment and employee
+// of their orders.
+func (os OrderService) ProductOwners(c context.Context, ids []uint64) ([]model.KitchenProduct, error) {
+	kps, err := os.str.KitchenProducts(c, ids)
+	if err != nil {
+		return nil, fmt.Errorf("str.KitchenProducts: %w", err)
+	}
+	return kps, nil
+}
+
+func (os OrderService) Create(c context.Context, o *model.Order) ([]uint64, error) {
+	if o == nil {
+		return nil, apperr.New(apperr.ErrInvalidArgument, "nil order")
+	}
+	total, err := priceProducts(c, os.pp, o.OrderProducts)
+	if err != nil {
+		return nil, fmt.Errorf("priceProducts: %w", err)
+	}
+	if err := checkTotal(o.Total, total); err != nil {
+		return nil, err
+	}
+	o.Total = total
+	if err := os.str.Create(c, o); err != nil {
 		return nil, fmt.Errorf("create order: %w", err)
 	}
 	ids := make([]uint64, len(o.OrderProducts))
 	for i := range o.OrderProducts {
 		ids[i] = o.OrderProducts[i].ID
 	}
+	os.kn.Notify(c, model.ProductAdded, ids...)
 	return ids, nil
 }
 
-func (os OrderService) GetTipsFromEmployee(eID uint64, start, end string) (float32, error) {
-	tips, err := os.str.GetTipsFromEmployee(eID, start, end)
+// GetTipsFromEmployee returns the tips of the employee eID between the start
+// and the end, the dates are the business days of the establishments.
+func (os OrderService) GetTipsFromEmployee(c context.Context, eID uint64, start, end string) (model.Money, error) {
+	days, err := os.cal.Ranges(nil, start, end)
+	if err != nil {
+		return model.Money{}, err
+	}
+	tips, err := os.str.GetTipsFromEmployee(c, eID, days)
 	if err != nil {
-		return 0, fmt.Errorf("controller GetTipsFromEmployee: %w", err)
+		return model.Money{}, fmt.Errorf("controller GetTipsFromEmployee: %w", err)
 	}
 	return tips, nil
 }
 
-func (os OrderService) AddProducts(oID uint64, total float64, ps []model.OrderProduct) ([]uint64, error) {
+// checkAddProducts returns an error when the order o does not accept new
+// products, the orders paid online keep the total they were paid for.
+func checkAddProducts(o model.Order) error {
+	switch o.StatusID {
+	case model.Draft, model.InPreparation:
+	case model.Ready, model.Delivered:
+		if err := checkTransition(o.StatusID, model.InPreparation); err != nil {
+			return err
+		}
+	default:
+		return &TransitionError{From: o.StatusID, To: model.InPreparation}
+	}
+	if o.PayID != nil {
+		return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("order %d is paid online", o.ID))
+	}
+	return nil
+}
+
+// AddProducts adds the products ps to the order oID, a ready or delivered
+// order goes back to InPreparation.
+func (os OrderService) AddProducts(c context.Context, oID uint64, total model.Money, ps []model.OrderProduct) ([]uint64, error) {
 	if oID == 0 {
-		return nil, fmt.Errorf("order not found")
+		return nil, apperr.New(apperr.ErrNotFound, "order not found")
 	}
 	if ps == nil {
-		return nil, fmt.Errorf("products are nil")
+		return nil, apperr.InvalidArgument(apperr.FieldViolation{Field: "products", Description: "must not be empty"})
+	}
+	st, err := priceProducts(c, os.pp, ps)
+	if err != nil {
+		return nil, fmt.Errorf("priceProducts: %w", err)
+	}
+	if err := checkTotal(total, st); err != nil {
+		return nil, err
 	}
-	if err := os.str.AddProducts(oID, total, ps); err != nil {
-		return nil, fmt.Errorf("create order products: %w", err)
+	err = os.tx.WithTx(c, func(str OrderStorager, ost OrderStatusStorager) error {
+		o, err := ost.Order(c, oID)
+		if err != nil {
+			return fmt.Errorf("ost.Order: %w", err)
+		}
+		if err := checkAddProducts(o); err != nil {
+			return err
+		}
+		if err := str.AddProducts(c, oID, st, ps); err != nil {
+			return fmt.Errorf("create order products: %w", err)
+		}
+		if o.StatusID == model.Ready || o.StatusID == model.Delivered {
+			if err := ost.SetStatus(c, oID, o.StatusID, model.InPreparation); err != nil {
+				return fmt.Errorf("ost.SetStatus: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	ids := make([]uint64, len(ps))
 	for i := range ps {
 		ids[i] = ps[i].ID
 	}
+	os.kn.Notify(c, model.ProductAdded, ids...)
 	return ids, nil
 }
 
-func (os OrderService) Kitchen(kID, last uint64) ([]model.OrderProduct, error) {
+func (os OrderService) Kitchen(c context.Context, kID, last uint64) ([]model.OrderProduct, error) {
 	if kID == 0 {
-		return nil, fmt.Errorf("kitchen not found")
+		return nil, apperr.New(apperr.ErrNotFound, "kitchen not found")
 	}
-	ps, err := os.str.Kitchen(kID, last)
+	ps, err := os.str.Kitchen(c, kID, last)
 	if err != nil {
 		return nil, fmt.Errorf("get by kitchen: %w", err)
 	}
 	return ps, nil
 }
 
-func (os OrderService) Waiter(wID uint64) ([]model.Order, error) {
+func (os OrderService) Waiter(c context.Context, wID uint64) ([]model.Order, error) {
 	if wID == 0 {
-		return nil, fmt.Errorf("user not found")
+		return nil, apperr.New(apperr.ErrNotFound, "user not found")
 	}
-	orders, err := os.str.Waiter(wID)
+	orders, err := os.str.Waiter(c, wID)
 	if err != nil {
 		return nil, fmt.Errorf("get by waiter: %w", err)
 	}
 	return orders, nil
 }
 
-func (os OrderService) WaiterPending(wID uint64) ([]model.Order, error) {
+func (os OrderService) WaiterPending(c context.Context, wID uint64) ([]model.Order, error) {
 	if wID == 0 {
-		return nil, fmt.Errorf("user not found")
+		return nil, apperr.New(apperr.ErrNotFound, "user not found")
 	}
-	orders, err := os.str.WaiterPending(wID)
+	orders, err := os.str.WaiterPending(c, wID)
 	if err != nil {
 		return nil, fmt.Errorf("get by waiter: %w", err)
 	}
 	return orders, nil
 }
 
-func (os OrderService) Search(s *model.SearchOrder) ([]model.Order, error) {
-	orders, err := os.str.Search(s)
+// search returns the page of s that follows token and the token of the next
+// one, read by find.
+func (os OrderService) search(s *model.SearchOrder, token string, find func() (model.OrderPage, error)) (model.OrderPage, error) {
+	after, err := os.pt.Decode(*s, token)
 	if err != nil {
-		return nil, fmt.Errorf("get by user: %w", err)
+		return model.OrderPage{}, err
 	}
-	return orders, nil
+	s.After = after
+	p, err := find()
+	if err != nil {
+		return model.OrderPage{}, err
+	}
+	if p.Next != nil {
+		if p.NextToken, err = os.pt.Encode(*s, *p.Next); err != nil {
+			return model.OrderPage{}, fmt.Errorf("pt.Encode: %w", err)
+		}
+	}
+	return p, nil
 }
 
-func (os OrderService) User(uID uint64, s model.SearchOrder) ([]model.Order, error) {
-	if uID == 0 {
-		return nil, fmt.Errorf("user not found")
-	}
-	// s.Types = []model.Type{model.Delivery}
-	// s.Users = []uint64{uID}
-	// s.Ests = nil
-	orders, err := os.str.User(uID, s.Limit, s.Offset)
-	if err != nil {
-		return nil, fmt.Errorf("get by user: %w", err)
+func (os OrderService) Search(c context.Context, s *model.SearchOrder, token string) (model.OrderPage, error) {
+	if s.Start != "" && s.End != "" {
+		days, err := os.cal.Ranges(s.Ests, s.Start, s.End)
+		if err != nil {
+			return model.OrderPage{}, err
+		}
+		s.Days = days
 	}
-	return orders, nil
+	return os.search(s, token, func() (model.OrderPage, error) {
+		p, err := os.str.Search(c, s)
+		if err != nil {
+			return model.OrderPage{}, fmt.Errorf("get by user: %w", err)
+		}
+		return p, nil
+	})
 }
 
-func (os OrderService) Establishment(uID uint64, s model.SearchOrder) ([]model.Order, error) {
+func (os OrderService) User(c context.Context, uID uint64, s model.SearchOrder, token string) (model.OrderPage, error) {
 	if uID == 0 {
-		return nil, fmt.Errorf("user not found")
+		return model.OrderPage{}, apperr.New(apperr.ErrNotFound, "user not found")
 	}
-	s.Users = nil
-	s.Ests = []uint64{uID}
-	orders, err := os.str.Search(&s)
-	if err != nil {
-		return nil, fmt.Errorf("get by user: %w", err)
+	s.Users = []uint64{uID}
+	return os.search(&s, token, func() (model.OrderPage, error) {
+		p, err := os.str.User(c, uID, s.Search)
+		if err != nil {
+			return model.OrderPage{}, fmt.Errorf("get by user: %w", err)
+		}
+		return p, nil
+	})
+}
+
+func (os OrderService) Establishment(c context.Context, eID uint64, s model.SearchOrder, token string) (model.OrderPage, error) {
+	if eID == 0 {
+		return model.OrderPage{}, apperr.New(apperr.ErrNotFound, "establishment not found")
 	}
-	return orders, nil
+	s.Users = nil
+	s.Ests = []uint64{eID}
+	return os.Search(c, &s, token)
 }
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOrderStorage struct {
+	OrderStorager
+	added    model.Money
+	products []model.KitchenProduct
+}
+
+func (f *fakeOrderStorage) Products(c context.Context, oID uint64) ([]model.OrderProduct, error) {
+	var ps []model.OrderProduct
+	for _, kp := range f.products {
+		if kp.OrderID == oID {
+			ps = append(ps, kp.OrderProduct)
+		}
+	}
+	return ps, nil
+}
+
+func (f *fakeOrderStorage) KitchenProducts(c context.Context, ids []uint64) ([]model.KitchenProduct, error) {
+	var kps []model.KitchenProduct
+	for _, kp := range f.products {
+		for _, id := range ids {
+			if kp.ID == id {
+				kps = append(kps, kp)
+			}
+		}
+	}
+	return kps, nil
+}
+
+func (f *fakeOrderStorage) Create(c context.Context, o *model.Order) error {
+	o.ID = 1
+	for i := range o.OrderProducts {
+		o.OrderProducts[i].ID = uint64(i + 1)
+	}
+	return nil
+}
+
+func (f *fakeOrderStorage) AddProducts(c context.Context, oID uint64, total model.Money, ps []model.OrderProduct) error {
+	f.added = total
+	return nil
+}
+
+// fakePricer is a catalog with the given products.
+type fakePricer map[uint64]model.Product
+
+func newFakePricer(ps ...model.Product) fakePricer {
+	fp := make(fakePricer, len(ps))
+	for _, p := range ps {
+		fp[p.ID] = p
+	}
+	return fp
+}
+
+func (fp fakePricer) Prices(c context.Context, ids []uint64) (map[uint64]model.Product, error) {
+	m := make(map[uint64]model.Product, len(ids))
+	for _, id := range ids {
+		if p, ok := fp[id]; ok {
+			m[id] = p
+		}
+	}
+	return m, nil
+}
+
+type fakeNotifier struct {
+	kinds []model.KitchenEventKind
+	ids   []uint64
+}
+
+func (f *fakeNotifier) Notify(c context.Context, k model.KitchenEventKind, ids ...uint64) {
+	f.kinds = append(f.kinds, k)
+	f.ids = append(f.ids, ids...)
+}
+
+func TestOrderService_Create(t *testing.T) {
+	pp := newFakePricer(
+		model.Product{ID: 1, Name: "Taco", Price: model.NewMoney(2550, model.MXN)},
+		model.Product{ID: 2, Name: "Agua", Price: model.NewMoney(1999, model.MXN)},
+	)
+	tests := []struct {
+		name      string
+		give      model.Order
+		wantTotal model.Money
+		wantErr   error
+	}{
+		{
+			name: "client total disagrees",
+			give: model.Order{Total: model.NewMoney(11648, model.MXN), OrderProducts: []model.OrderProduct{
+				{ProductID: 1, Quantity: 2},
+				{ProductID: 2, Quantity: 2},
+			}},
+			wantErr: ErrTotalMismatch,
+		}, {
+			name: "client total matches",
+			give: model.Order{Total: model.NewMoney(9098, model.MXN), OrderProducts: []model.OrderProduct{
+				{ProductID: 1, Quantity: 2},
+				{ProductID: 2, Quantity: 2},
+			}},
+			wantTotal: model.NewMoney(9098, model.MXN),
+		}, {
+			name: "unknown product",
+			give: model.Order{Total: model.NewMoney(1000, model.MXN), OrderProducts: []model.OrderProduct{
+				{ProductID: 3, Quantity: 1},
+			}},
+			wantErr: ErrUnknownProduct,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kn := &fakeNotifier{}
+			os := NewOrderService(&fakeOrderStorage{}, fakeTx{}, pp, kn, NewPageTokens([]byte("key")), Calendar{Default: BusinessDay{Location: time.UTC}})
+			_, err := os.Create(context.Background(), &tt.give)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderService.Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				assert.Equal(t, tt.wantTotal, tt.give.Total)
+				pt, err := model.ProductsTotal(tt.give.OrderProducts)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantTotal, pt)
+				assert.Equal(t, "Taco", tt.give.OrderProducts[0].Name)
+				assert.Equal(t, model.NewMoney(2550, model.MXN), tt.give.OrderProducts[0].UnitPrice)
+				assert.Equal(t, model.NewMoney(5100, model.MXN), tt.give.OrderProducts[0].Subtotal)
+				assert.Equal(t, []uint64{1, 2}, kn.ids)
+			} else {
+				assert.Nil(t, kn.ids)
+			}
+		})
+	}
+}
+
+func TestOrderService_AddProducts(t *testing.T) {
+	pp := newFakePricer(model.Product{ID: 1, Name: "Taco", Price: model.NewMoney(2550, model.MXN)})
+	payID := "PAY-5"
+	orders := map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1}, TypeID: model.Local, StatusID: model.InPreparation},
+		2: {Model: model.Model{ID: 2}, TypeID: model.Local, StatusID: model.Ready},
+		3: {Model: model.Model{ID: 3}, TypeID: model.Local, StatusID: model.Closed},
+		4: {Model: model.Model{ID: 4}, TypeID: model.Delivery, StatusID: model.AwaitingPayment},
+		5: {Model: model.Model{ID: 5}, TypeID: model.Delivery, StatusID: model.InPreparation, PayID: &payID},
+	}
+	tests := []struct {
+		name      string
+		oID       uint64
+		total     model.Money
+		wantErr   error
+		wantMoved []model.Status
+	}{
+		{name: "ok", oID: 1, total: model.NewMoney(5100, model.MXN)},
+		{name: "ready order", oID: 2, total: model.NewMoney(5100, model.MXN), wantMoved: []model.Status{model.InPreparation}},
+		{name: "total mismatch", oID: 1, total: model.NewMoney(100, model.MXN), wantErr: ErrTotalMismatch},
+		{name: "closed order", oID: 3, total: model.NewMoney(5100, model.MXN), wantErr: ErrInvalidTransition},
+		{name: "awaiting payment", oID: 4, total: model.NewMoney(5100, model.MXN), wantErr: ErrInvalidTransition},
+		{name: "paid online", oID: 5, total: model.NewMoney(5100, model.MXN), wantErr: apperr.ErrFailedPrecondition},
+		{name: "unknown order", oID: 9, total: model.NewMoney(5100, model.MXN), wantErr: apperr.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			str := &fakeOrderStorage{}
+			ost := &fakeStatusStorage{orders: orders}
+			os := NewOrderService(str, fakeTx{os: str, ost: ost}, pp, &fakeNotifier{}, NewPageTokens([]byte("key")), Calendar{Default: BusinessDay{Location: time.UTC}})
+			_, err := os.AddProducts(context.Background(), tt.oID, tt.total, []model.OrderProduct{{ProductID: 1, Quantity: 2}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderService.AddProducts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				assert.True(t, str.added.IsZero())
+				return
+			}
+			assert.Equal(t, tt.total, str.added)
+			assert.Equal(t, tt.wantMoved, ost.moved)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/modular-project/orders-service/model"
+)
+
+// EventPublisher delivers the order events to the other services. Publish
+// may be called more than once with the same event.
+type EventPublisher interface {
+	Publish(c context.Context, e model.OrderEvent) error
+}
+
+type OutboxStorager interface {
+	Dispatch(c context.Context, limit int, publish func(model.OrderEvent) error) (int, error)
+}
+
+// OutboxRelay publishes the events stored in the outbox.
+type OutboxRelay struct {
+	str      OutboxStorager
+	pub      EventPublisher
+	interval time.Duration
+	batch    int
+}
+
+func NewOutboxRelay(str OutboxStorager, pub EventPublisher, interval time.Duration, batch int) OutboxRelay {
+	return OutboxRelay{str: str, pub: pub, interval: interval, batch: batch}
+}
+
+// Relay publishes the pending events until there are none left or one fails,
+// it returns the number of published events.
+func (or OutboxRelay) Relay(c context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := or.str.Dispatch(c, or.batch, func(e model.OrderEvent) error {
+			return or.pub.Publish(c, e)
+		})
+		total += n
+		if err != nil || n < or.batch {
+			return total, err
+		}
+	}
+}
+
+// Run calls Relay every interval until c is done.
+func (or OutboxRelay) Run(c context.Context) error {
+	t := time.NewTicker(or.interval)
+	defer t.Stop()
+	for {
+		if _, err := or.Relay(c); err != nil && c.Err() == nil {
+			log.Printf("outbox relay: %s", err)
+		}
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOutbox struct {
+	events    []model.OrderEvent
+	published int
+}
+
+func (f *fakeOutbox) Dispatch(c context.Context, limit int, publish func(model.OrderEvent) error) (int, error) {
+	n := 0
+	for f.published < len(f.events) && n < limit {
+		if err := publish(f.events[f.published]); err != nil {
+			return n, err
+		}
+		f.published++
+		n++
+	}
+	return n, nil
+}
+
+type fakePublisher struct {
+	ids  []string
+	fail map[string]error
+}
+
+func (f *fakePublisher) Publish(c context.Context, e model.OrderEvent) error {
+	if err := f.fail[e.EventID]; err != nil {
+		delete(f.fail, e.EventID)
+		return err
+	}
+	f.ids = append(f.ids, e.EventID)
+	return nil
+}
+
+func TestOutboxRelay_Relay(t *testing.T) {
+	errDown := errors.New("bus is down")
+	str := &fakeOutbox{events: []model.OrderEvent{{EventID: "a"}, {EventID: "b"}, {EventID: "c"}, {EventID: "d"}, {EventID: "e"}}}
+	pub := &fakePublisher{fail: map[string]error{"d": errDown}}
+	or := NewOutboxRelay(str, pub, 0, 2)
+
+	n, err := or.Relay(context.Background())
+	assert.True(t, errors.Is(err, errDown))
+	assert.Equal(t, 3, n)
+	n, err = or.Relay(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, pub.ids)
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// ErrInvalidPageToken is returned when a page token was not issued for the
+// same search.
+var ErrInvalidPageToken = apperr.InvalidArgument(apperr.FieldViolation{
+	Field: "search.page_token", Description: "must be the token of the previous page of the same search",
+})
+
+// pageToken is the content of a page token, Search is a digest of the
+// search so the token is only valid to read the pages of the same one.
+type pageToken struct {
+	Cursor model.Cursor `json:"c"`
+	Search string       `json:"s"`
+}
+
+// PageTokens signs the cursors of the searches, the tokens are opaque to the
+// clients and can't be altered to read out of the search.
+type PageTokens struct {
+	key []byte
+}
+
+func NewPageTokens(key []byte) PageTokens {
+	return PageTokens{key: key}
+}
+
+// searchDigest returns the digest of the filters and sorts of s.
+func searchDigest(s model.SearchOrder) (string, error) {
+	s.Limit, s.Offset = 0, 0
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("marshal search: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func (pt PageTokens) sign(b []byte) []byte {
+	m := hmac.New(sha256.New, pt.key)
+	m.Write(b)
+	return m.Sum(nil)
+}
+
+// Encode returns the token of the page of s that follows c.
+func (pt PageTokens) Encode(s model.SearchOrder, c model.Cursor) (string, error) {
+	d, err := searchDigest(s)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(pageToken{Cursor: c, Search: d})
+	if err != nil {
+		return "", fmt.Errorf("marshal page token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b) + "." + base64.RawURLEncoding.EncodeToString(pt.sign(b)), nil
+}
+
+// Decode returns the cursor of a token issued by Encode for s, nil for an
+// empty token.
+func (pt PageTokens) Decode(s model.SearchOrder, token string) (*model.Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidPageToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, pt.sign(b)) {
+		return nil, ErrInvalidPageToken
+	}
+	var t pageToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	d, err := searchDigest(s)
+	if err != nil {
+		return nil, err
+	}
+	if t.Search != d || len(t.Cursor.Keys) != len(s.Sorts()) {
+		return nil, ErrInvalidPageToken
+	}
+	return &t.Cursor, nil
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageTokens(t *testing.T) {
+	pt := NewPageTokens([]byte("key"))
+	s := model.SearchOrder{
+		Search: model.Search{Limit: 10, OrderBys: []model.OrderBy{{By: model.PRICE, Sort: model.DES}}},
+		Filter: model.Filter{Status: []model.Status{model.Paid}},
+	}
+	c := model.Cursor{Keys: []int64{2550}, ID: 7}
+	token, err := pt.Encode(s, c)
+	if err != nil {
+		t.Fatalf("PageTokens.Encode() error = %v", err)
+	}
+	other := s
+	other.Status = []model.Status{model.Cancelled}
+	unsorted := s
+	unsorted.OrderBys = nil
+	tests := []struct {
+		name    string
+		s       model.SearchOrder
+		token   string
+		want    *model.Cursor
+		wantErr error
+	}{
+		{name: "empty", s: s},
+		{name: "same search", s: s, token: token, want: &c},
+		{name: "another limit", s: model.SearchOrder{Search: model.Search{Limit: 5, OrderBys: s.OrderBys}, Filter: s.Filter}, token: token, want: &c},
+		{name: "another search", s: other, token: token, wantErr: ErrInvalidPageToken},
+		{name: "another sort", s: unsorted, token: token, wantErr: ErrInvalidPageToken},
+		{name: "tampered", s: s, token: "e30" + token[3:], wantErr: ErrInvalidPageToken},
+		{name: "another key", s: s, token: func() string { tk, _ := NewPageTokens([]byte("other")).Encode(s, c); return tk }(), wantErr: ErrInvalidPageToken},
+		{name: "malformed", s: s, token: "abc", wantErr: ErrInvalidPageToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pt.Decode(tt.s, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PageTokens.Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// PaymentGateway collects the online payments of a payment method. The calls
+// that move money are idempotent so they can be retried after a failure.
+type PaymentGateway interface {
+	// CreateIntent creates a payment of amount for the payer to approve and
+	// returns its ID, the same key returns the same payment.
+	CreateIntent(c context.Context, amount model.Money, key string) (string, error)
+	// Capture collects the payment pID, a payment captured before returns
+	// the same capture.
+	Capture(c context.Context, pID string) (model.Capture, error)
+	// Void cancels a payment that was not captured.
+	Void(c context.Context, pID string) error
+	// Refund returns amount of the capture cID, the same key returns the
+	// same refund.
+	Refund(c context.Context, cID string, amount model.Money, note, key string) (model.Refund, error)
+	Status(c context.Context, pID string) (model.Payment, error)
+}
+
+// ErrUnsupportedPayment is returned for a payment method without a gateway.
+var ErrUnsupportedPayment = apperr.New(apperr.ErrInvalidArgument, "unsupported payment method")
+
+// PaymentGateways is the registry of the gateway of every payment method
+// paid online.
+type PaymentGateways struct {
+	gs map[model.PaymentMethod]PaymentGateway
+}
+
+func NewPaymentGateways() *PaymentGateways {
+	return &PaymentGateways{gs: make(map[model.PaymentMethod]PaymentGateway)}
+}
+
+// Register makes g the gateway of the payment method pm.
+func (pg *PaymentGateways) Register(pm model.PaymentMethod, g PaymentGateway) {
+	pg.gs[pm] = g
+}
+
+func (pg *PaymentGateways) Gateway(pm model.PaymentMethod) (PaymentGateway, error) {
+	g, ok := pg.gs[pm]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedPayment, pm)
+	}
+	return g, nil
+}
+
+// PaymentEvent applies a notification of the payment gateway with the same
+// transitions as CapturePayment and Refund. Every event is applied once, the
+// events of unknown payments or of orders that already moved on are recorded
+// without changing the order. An approved payment is captured before the
+// event is recorded, so the gateway sends the event again when it fails. A
+// denied capture removes the payment from its order to be paid again, and a
+// capture of another amount than the total leaves the order waiting.
+func (oss OrderStatusService) PaymentEvent(c context.Context, e model.PaymentEvent) error {
+	if e.ID == "" {
+		return apperr.InvalidArgument(apperr.FieldViolation{Field: "id", Description: "must not be empty"})
+	}
+	if e.Type == model.PaymentEventApproved {
+		_, err := oss.capture(c, e.PayID)
+		switch {
+		case errors.Is(err, apperr.ErrNotFound):
+			log.Printf("payment event %s %s: %s", e.ID, e.Type, err)
+		case err != nil && !errors.Is(err, apperr.ErrPaymentDeclined) && !errors.Is(err, ErrInvalidTransition):
+			return err
+		}
+	}
+	var k model.KitchenEventKind
+	var ids []uint64
+	err := oss.tx.WithTx(c, func(os OrderStorager, ost OrderStatusStorager) error {
+		if err := ost.AddPaymentEvent(c, &e); err != nil {
+			if errors.Is(err, model.ErrPaymentEventProcessed) {
+				return nil
+			}
+			return fmt.Errorf("ost.AddPaymentEvent: %w", err)
+		}
+		var err error
+		k, ids, err = oss.applyPaymentEvent(c, os, ost, e)
+		if errors.Is(err, apperr.ErrNotFound) {
+			log.Printf("payment event %s %s: %s", e.ID, e.Type, err)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	oss.kn.Notify(c, k, ids...)
+	return nil
+}
+
+// applyPaymentEvent applies e inside the transaction that records it, the
+// approved payments are already captured. It returns the products of the
+// order to add to or remove from the kitchen once the event is stored.
+func (oss OrderStatusService) applyPaymentEvent(c context.Context, os OrderStorager, ost OrderStatusStorager, e model.PaymentEvent) (model.KitchenEventKind, []uint64, error) {
+	switch e.Type {
+	case model.PaymentEventCaptured:
+		o, err := ost.PaymentOrder(c, e.PayID)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ost.PaymentOrder: %w", err)
+		}
+		if !CanTransition(o.StatusID, model.Paid) {
+			if o.StatusID != model.Paid {
+				log.Printf("payment event %s: capture %s of order %d in status %s", e.ID, e.CaptureID, o.ID, o.StatusID)
+			}
+			return 0, nil, nil
+		}
+		if !e.Amount.IsZero() {
+			if err := checkCapture(o, e.Amount); err != nil {
+				// the order keeps waiting and the reconciler reports the mismatch.
+				log.Printf("payment event %s: capture %s: %s", e.ID, e.CaptureID, err)
+				return 0, nil, nil
+			}
+		}
+		cp := model.Capture{ID: e.CaptureID, Status: string(model.PaymentCompleted), Amount: e.Amount}
+		if err := ost.PayDelivey(c, e.PayID, cp, o.StatusID, model.Paid); err != nil {
+			return 0, nil, fmt.Errorf("ost.PayDelivery: %w", err)
+		}
+		ids, err := orderProducts(c, os, o.ID)
+		return model.ProductAdded, ids, err
+	case model.PaymentEventDenied:
+		o, err := ost.PaymentOrder(c, e.PayID)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ost.PaymentOrder: %w", err)
+		}
+		if o.StatusID != model.AwaitingPayment {
+			log.Printf("payment event %s: denied capture of payment %s of order %d in status %s", e.ID, e.PayID, o.ID, o.StatusID)
+			return 0, nil, nil
+		}
+		// the customer pays again with a new payment.
+		if err := ost.DenyPayment(c, o.ID, e.PayID); err != nil {
+			return 0, nil, fmt.Errorf("ost.DenyPayment: %w", err)
+		}
+		return 0, nil, nil
+	case model.PaymentEventRefunded:
+		o, err := ost.CaptureOrder(c, e.CaptureID)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ost.CaptureOrder: %w", err)
+		}
+		ok, err := ost.HasRefund(c, e.RefundID)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ost.HasRefund: %w", err)
+		}
+		if ok {
+			return 0, nil, nil
+		}
+		to := refundStatus(o, e.Amount)
+		if !e.Amount.SameCurrency(o.Captured) || o.Refunded.Amount+e.Amount.Amount > o.Captured.Amount ||
+			(o.StatusID != to && !CanTransition(o.StatusID, to)) {
+			log.Printf("payment event %s: refund %s of %s of order %d in status %s", e.ID, e.RefundID, e.Amount, o.ID, o.StatusID)
+			return 0, nil, nil
+		}
+		rf := model.Refund{OrderID: o.ID, Amount: e.Amount, Reason: "refunded in the payment gateway"}
+		// a pending refund of the same amount is the one the event is about.
+		p, err := ost.PendingRefund(c, o.ID)
+		if err != nil && !errors.Is(err, apperr.ErrNotFound) {
+			return 0, nil, fmt.Errorf("ost.PendingRefund: %w", err)
+		}
+		if err == nil && p.Amount.Amount == e.Amount.Amount {
+			rf = p
+		}
+		rf.ProviderID, rf.Status = &e.RefundID, string(model.PaymentCompleted)
+		if err := ost.Refund(c, &rf, o.StatusID, to); err != nil {
+			return 0, nil, fmt.Errorf("ost.Refund: %w", err)
+		}
+		ids, err := leftKitchen(c, os, o, to)
+		return model.ProductCancelled, ids, err
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+var (
+	// ErrTotalMismatch is returned when the total sent by the client differs
+	// from the one calculated with the catalog prices.
+	ErrTotalMismatch  = apperr.New(apperr.ErrInvalidArgument, "order total does not match the products price")
+	ErrUnknownProduct = apperr.New(apperr.ErrInvalidArgument, "product not found")
+)
+
+// ProductPricer resolves the current price of the products of the catalog.
+type ProductPricer interface {
+	Prices(context.Context, []uint64) (map[uint64]model.Product, error)
+}
+
+// priceProducts stores in every product the name and price of the catalog
+// at this moment, and returns the total of the products.
+func priceProducts(c context.Context, pp ProductPricer, ps []model.OrderProduct) (model.Money, error) {
+	total := model.NewMoney(0, model.MXN)
+	if len(ps) == 0 {
+		return total, nil
+	}
+	ids := make([]uint64, 0, len(ps))
+	for i := range ps {
+		ids = append(ids, ps[i].ProductID)
+	}
+	prices, err := pp.Prices(c, ids)
+	if err != nil {
+		return model.Money{}, fmt.Errorf("pp.Prices: %w", err)
+	}
+	for i := range ps {
+		p, ok := prices[ps[i].ProductID]
+		if !ok {
+			return model.Money{}, fmt.Errorf("%w: %d", ErrUnknownProduct, ps[i].ProductID)
+		}
+		ps[i].Name = p.Name
+		ps[i].UnitPrice = p.Price
+		ps[i].Subtotal = p.Price.Mul(int64(ps[i].Quantity))
+		if total, err = total.Add(ps[i].Subtotal); err != nil {
+			return model.Money{}, fmt.Errorf("product %d: %w", ps[i].ProductID, err)
+		}
+	}
+	return total, nil
+}
+
+// checkTotal compares the total sent by the client against the calculated one.
+func checkTotal(client, server model.Money) error {
+	if !client.SameCurrency(server) || client.Amount != server.Amount {
+		return fmt.Errorf("%w: got %s, want %s", ErrTotalMismatch, client, server)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// PaymentReconciler brings the orders waiting for an online payment in line
+// with their payment gateway, the orders that got a payment less than age ago
+// are left alone because the payer may still be approving it.
+type PaymentReconciler struct {
+	oss      OrderStatusService
+	interval time.Duration
+	age      time.Duration
+	batch    int
+}
+
+func NewPaymentReconciler(oss OrderStatusService, interval, age time.Duration, batch int) PaymentReconciler {
+	return PaymentReconciler{oss: oss, interval: interval, age: age, batch: batch}
+}
+
+// Reconcile checks once every order in AwaitingPayment with a payment, the
+// orders that differ from their gateway are fixed when possible and reported.
+func (pr PaymentReconciler) Reconcile(c context.Context) (model.ReconcileReport, error) {
+	r := model.ReconcileReport{Discrepancies: []model.Discrepancy{}}
+	before := time.Now().Add(-pr.age)
+	var after uint64
+	for {
+		os, err := pr.oss.ost.UnpaidOrders(c, before, after, pr.batch)
+		if err != nil {
+			return r, fmt.Errorf("ost.UnpaidOrders: %w", err)
+		}
+		for _, o := range os {
+			if err := c.Err(); err != nil {
+				return r, err
+			}
+			after = o.ID
+			if o.PayID == nil {
+				continue
+			}
+			r.Checked++
+			if d, ok := pr.oss.reconcile(c, o.ID); ok {
+				r.Discrepancies = append(r.Discrepancies, d)
+			}
+		}
+		if len(os) < pr.batch {
+			return r, nil
+		}
+	}
+}
+
+// Run calls Reconcile every interval until c is done and logs the
+// discrepancies.
+func (pr PaymentReconciler) Run(c context.Context) error {
+	t := time.NewTicker(pr.interval)
+	defer t.Stop()
+	for {
+		r, err := pr.Reconcile(c)
+		if err != nil && c.Err() == nil {
+			log.Printf("payment reconciler: %s", err)
+		}
+		for _, d := range r.Discrepancies {
+			log.Printf("payment reconciler: order %d with payment %s: %s %s", d.OrderID, d.PayID, d.Kind, d.Detail)
+		}
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// reconcile compares the order oID with its payment gateway, it reports false
+// when they agree or the order is no longer waiting for the payment. The
+// gateway is called outside of any transaction.
+func (oss OrderStatusService) reconcile(c context.Context, oID uint64) (model.Discrepancy, bool) {
+	d := model.Discrepancy{OrderID: oID}
+	if err := oss.checkPayment(c, &d); err != nil {
+		d.Kind, d.Detail = model.DiscrepancyFailed, err.Error()
+	}
+	return d, d.Kind != ""
+}
+
+// checkPayment fills d with the difference between the order d.OrderID and
+// its payment and fixes it when possible.
+func (oss OrderStatusService) checkPayment(c context.Context, d *model.Discrepancy) error {
+	o, err := oss.ost.Order(c, d.OrderID)
+	if err != nil {
+		return fmt.Errorf("ost.Order: %w", err)
+	}
+	if o.StatusID != model.AwaitingPayment || o.PayID == nil {
+		return nil
+	}
+	d.PayID = *o.PayID
+	g, err := oss.gateway(o)
+	if err != nil {
+		return err
+	}
+	p, err := g.Status(c, *o.PayID)
+	if errors.Is(err, apperr.ErrNotFound) {
+		d.Kind = model.DiscrepancyMissing
+		_, err := oss.expirePayment(c, o.ID, *o.PayID)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("g.Status: %w", err)
+	}
+	d.Payment = p.Status
+	switch p.Status {
+	case model.PaymentApproved, model.PaymentCompleted:
+		if !p.Amount.IsZero() && (p.Amount.Amount != o.Total.Amount || !p.Amount.SameCurrency(o.Total)) {
+			d.Kind, d.Detail = model.DiscrepancyAmount, fmt.Sprintf("payment of %s for a total of %s", p.Amount, o.Total)
+			return nil
+		}
+		if p.Status == model.PaymentApproved {
+			d.Kind = model.DiscrepancyApproved
+			_, err := oss.capture(c, *o.PayID)
+			return err
+		}
+		d.Kind = model.DiscrepancyCaptured
+		cp := model.Capture{ID: p.CaptureID, Status: string(p.Status), Amount: p.Amount}
+		if cp.Amount.IsZero() {
+			cp.Amount = o.Total
+		}
+		return oss.paid(c, *o.PayID, cp)
+	case model.PaymentVoided:
+		d.Kind = model.DiscrepancyVoided
+		_, err := oss.expirePayment(c, o.ID, *o.PayID)
+		return err
+	}
+	return nil
+}
+
+// expirePayment moves the order oID that can no longer be paid with the
+// payment pID to Expired, it reports false when the order is no longer
+// waiting for that payment. The products of an expired order are removed
+// from the screens that may still show them.
+func (oss OrderStatusService) expirePayment(c context.Context, oID uint64, pID string) (bool, error) {
+	expired := false
+	var ids []uint64
+	err := oss.tx.WithTx(c, func(os OrderStorager, ost OrderStatusStorager) error {
+		o, err := ost.Order(c, oID)
+		if err != nil {
+			return fmt.Errorf("ost.Order: %w", err)
+		}
+		if o.StatusID != model.AwaitingPayment || (o.PayID != nil && *o.PayID != pID) {
+			return nil
+		}
+		if err := ost.ExpireOrder(c, o.ID, o.StatusID); err != nil {
+			return fmt.Errorf("ost.ExpireOrder: %w", err)
+		}
+		expired = true
+		ids, err = orderProducts(c, os, o.ID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	oss.kn.Notify(c, model.ProductCancelled, ids...)
+	return expired, nil
+}
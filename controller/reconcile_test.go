@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentReconciler_Reconcile(t *testing.T) {
+	payID := func(s string) *string { return &s }
+	total := model.NewMoney(10000, model.MXN)
+	ost := &fakeStatusStorage{orders: map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1}, StatusID: model.AwaitingPayment, PayID: payID("PAY-1"), Total: total},
+		2: {Model: model.Model{ID: 2}, StatusID: model.AwaitingPayment, PayID: payID("PAY-2"), Total: total},
+		3: {Model: model.Model{ID: 3}, StatusID: model.AwaitingPayment, PayID: payID("PAY-3"), Total: total},
+		4: {Model: model.Model{ID: 4}, StatusID: model.AwaitingPayment, PayID: payID("PAY-4"), Total: total},
+		5: {Model: model.Model{ID: 5}, StatusID: model.AwaitingPayment, PayID: payID("PAY-5"), Total: total},
+		6: {Model: model.Model{ID: 6}, StatusID: model.AwaitingPayment, PayID: payID("PAY-6"), Total: total},
+		7: {Model: model.Model{ID: 7}, StatusID: model.Paid, PayID: payID("PAY-7"), Total: total},
+		8: {Model: model.Model{ID: 8}, StatusID: model.AwaitingPayment, Total: total},
+	}}
+	ps := &fakeGateway{payments: map[string]model.Payment{
+		"PAY-1": {ID: "PAY-1", Status: model.PaymentCompleted, Amount: total, CaptureID: "CAP-1"},
+		"PAY-2": {ID: "PAY-2", Status: model.PaymentApproved, Amount: total},
+		"PAY-3": {ID: "PAY-3", Status: model.PaymentCreated, Amount: total},
+		"PAY-5": {ID: "PAY-5", Status: model.PaymentVoided, Amount: total},
+		"PAY-6": {ID: "PAY-6", Status: model.PaymentCompleted, Amount: model.NewMoney(9000, model.MXN), CaptureID: "CAP-6"},
+		"PAY-7": {ID: "PAY-7", Status: model.PaymentCompleted, Amount: total, CaptureID: "CAP-7"},
+	}, amount: total}
+	oss := NewOrderStatusService(ost, paypalGateways(ps), fakeTx{os: &fakeOrderStorage{}, ost: ost}, &fakeNotifier{})
+	got, err := NewPaymentReconciler(oss, time.Minute, time.Minute, 2).Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("PaymentReconciler.Reconcile() error = %v", err)
+	}
+	want := model.ReconcileReport{Checked: 6, Discrepancies: []model.Discrepancy{
+		{OrderID: 1, PayID: "PAY-1", Kind: model.DiscrepancyCaptured, Payment: model.PaymentCompleted},
+		{OrderID: 2, PayID: "PAY-2", Kind: model.DiscrepancyApproved, Payment: model.PaymentApproved},
+		{OrderID: 4, PayID: "PAY-4", Kind: model.DiscrepancyMissing},
+		{OrderID: 5, PayID: "PAY-5", Kind: model.DiscrepancyVoided, Payment: model.PaymentVoided},
+		{OrderID: 6, PayID: "PAY-6", Kind: model.DiscrepancyAmount, Payment: model.PaymentCompleted, Detail: "payment of 90.00 for a total of 100.00"},
+	}}
+	assert.Equal(t, want, got)
+	assert.Equal(t, 1, ps.captured)
+	assert.Equal(t, model.Paid, ost.paidTo)
+	assert.Equal(t, []uint64{4, 5}, ost.expired)
+}
@@ -2,96 +2,681 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/modular-project/orders-service/apperr"
 	"github.com/modular-project/orders-service/model"
 )
 
-type PaypalServicer interface {
-	CreateOrder(context.Context, float64) (string, error)
-	CaptureOrder(context.Context, string) (string, error)
-}
-
 type OrderStatusStorager interface {
-	TotalPrice(oID, uID uint64) (float64, error)
-	SetPaymentDelivery(oID, eID uint64, pID, aID string) error
-	PayLocal(oID, eID uint64, tip float32) error
-	PayDelivey(string) error
-	CompleteProduct(uint64) error
-	DeliverProduct([]uint64) error
-	CancelOrders([]uint64, uint64) error
+	Status(c context.Context, oID uint64) (model.Status, error)
+	PaymentOrder(c context.Context, pID string) (model.Order, error)
+	CaptureOrder(c context.Context, cID string) (model.Order, error)
+	UnpaidOrders(c context.Context, before time.Time, after uint64, limit int) ([]model.Order, error)
+	SetPaymentRequest(c context.Context, oID uint64, key string) error
+	SetPaymentDelivery(c context.Context, oID, eID uint64, pID, aID string, pm model.PaymentMethod) error
+	DenyPayment(c context.Context, oID uint64, pID string) error
+	PayLocal(c context.Context, oID, eID uint64, tip model.TipRate, from, to model.Status) error
+	PayDelivey(c context.Context, pID string, cp model.Capture, from, to model.Status) error
+	CompleteProduct(context.Context, uint64) error
+	DeliverProduct(context.Context, []uint64) error
+	Order(c context.Context, oID uint64) (model.Order, error)
+	CancelOrder(c context.Context, oID uint64, from model.Status, cn model.Cancellation) error
+	ExpireOrder(c context.Context, oID uint64, from model.Status) error
+	SetStatus(c context.Context, oID uint64, from, to model.Status) error
+	CancelProducts(c context.Context, oID uint64, ids []uint64, cn model.Cancellation) error
+	AddRefund(c context.Context, rf *model.Refund) error
+	PendingRefund(c context.Context, oID uint64) (model.Refund, error)
+	FailRefund(c context.Context, rID uint64) error
+	Refund(c context.Context, rf *model.Refund, from, to model.Status) error
+	HasRefund(c context.Context, prID string) (bool, error)
+	AddPaymentEvent(c context.Context, e *model.PaymentEvent) error
 }
 
 type OrderStatusService struct {
 	ost OrderStatusStorager
-	ps  PaypalServicer
+	pg  *PaymentGateways
+	tx  Transactor
+	kn  KitchenNotifier
+}
+
+func NewOrderStatusService(ost OrderStatusStorager, pg *PaymentGateways, tx Transactor, kn KitchenNotifier) OrderStatusService {
+	return OrderStatusService{ost: ost, pg: pg, tx: tx, kn: kn}
+}
+
+// gateway returns the gateway that collected the payment of o, the orders
+// paid before the payment method was stored were paid with PayPal.
+func (oss OrderStatusService) gateway(o model.Order) (PaymentGateway, error) {
+	pm := o.PaymentMethod
+	if pm == 0 {
+		pm = model.PAYPAL
+	}
+	return oss.pg.Gateway(pm)
 }
 
-func NewOrderStatusService(ost OrderStatusStorager, ps PaypalServicer) OrderStatusService {
-	return OrderStatusService{ost: ost, ps: ps}
+// CancelOrders cancels the orders ids of the user uID that are not paid yet,
+// none is cancelled when one of them can not be.
+func (oss OrderStatusService) CancelOrders(c context.Context, ids []uint64, uID uint64) error {
+	if len(ids) == 0 {
+		return apperr.InvalidArgument(apperr.FieldViolation{Field: "ids", Description: "must not be empty"})
+	}
+	cn := model.Cancellation{Reason: model.CustomerRequest}
+	return oss.tx.WithTx(c, func(_ OrderStorager, ost OrderStatusStorager) error {
+		for _, id := range ids {
+			o, err := ost.Order(c, id)
+			if err != nil {
+				return fmt.Errorf("ost.Order: %w", err)
+			}
+			if o.UserID != uID {
+				return apperr.New(apperr.ErrNotFound, fmt.Sprintf("order %d not found", id))
+			}
+			if o.StatusID != model.Draft && o.StatusID != model.AwaitingPayment {
+				return &TransitionError{From: o.StatusID, To: model.Cancelled}
+			}
+			if err := ost.CancelOrder(c, id, o.StatusID, cn); err != nil {
+				return fmt.Errorf("ost.CancelOrder: %w", err)
+			}
+		}
+		return nil
+	})
 }
 
-func (oss OrderStatusService) CancelOrders(ids []uint64, uID uint64) error {
-	if err := oss.ost.CancelOrders(ids, uID); err != nil {
-		return fmt.Errorf("controller CancelOrders: %w", err)
+// overridable are the statuses of local orders only a manager can cancel.
+var overridable = []model.Status{model.Ready, model.OutForDelivery, model.Delivered}
+
+// checkCancel returns an error when staff can not cancel the order o. Local
+// orders are cancelled as the lifecycle allows, delivery orders only before
+// they are paid and with override because the customer placed them.
+func checkCancel(o model.Order, override bool) error {
+	if o.TypeID == model.Delivery {
+		if override && (o.StatusID == model.Draft || o.StatusID == model.AwaitingPayment) {
+			return nil
+		}
+		return &TransitionError{From: o.StatusID, To: model.Cancelled}
+	}
+	if CanTransition(o.StatusID, model.Cancelled) {
+		return nil
+	}
+	if override {
+		for _, s := range overridable {
+			if o.StatusID == s {
+				return nil
+			}
+		}
+	}
+	return &TransitionError{From: o.StatusID, To: model.Cancelled}
+}
+
+func validateCancel(c context.Context, r model.CancelRequest) error {
+	var vs []apperr.FieldViolation
+	if len(r.OrderIDs) == 0 && len(r.ProductIDs) == 0 {
+		vs = append(vs, apperr.FieldViolation{Field: "ids", Description: "must not be empty"})
+	}
+	if !r.Reason.IsValid() {
+		vs = append(vs, apperr.FieldViolation{Field: "reason", Description: "must be a valid reason"})
+	}
+	if vs != nil {
+		return apperr.InvalidArgument(vs...)
+	}
+	a := model.ActorFromContext(c)
+	if a.EmployeeID == 0 {
+		return apperr.New(apperr.ErrPermissionDenied, "only staff can cancel orders")
+	}
+	if r.Override && !a.IsManager() {
+		return apperr.New(apperr.ErrPermissionDenied, "only a manager can override a cancellation")
 	}
 	return nil
 }
 
-func (oss OrderStatusService) DeliverProduct(ids []uint64) error {
-	if err := oss.ost.DeliverProduct(ids); err != nil {
-		return fmt.Errorf("ost.DeliverProduct: %w", err)
+// Cancel cancels whole orders and single products for the staff, the total of
+// an order is recalculated without its cancelled products. The products of an
+// order with a payment are not cancelled because the payment is for its
+// total. Nothing is cancelled when any order or product can not be.
+func (oss OrderStatusService) Cancel(c context.Context, r model.CancelRequest) (model.CancelResult, error) {
+	if err := validateCancel(c, r); err != nil {
+		return model.CancelResult{}, err
+	}
+	res := model.CancelResult{}
+	var voided []uint64
+	err := oss.tx.WithTx(c, func(os OrderStorager, ost OrderStatusStorager) error {
+		for _, id := range r.OrderIDs {
+			o, err := ost.Order(c, id)
+			if err != nil {
+				return fmt.Errorf("ost.Order: %w", err)
+			}
+			if err := checkCancel(o, r.Override); err != nil {
+				return err
+			}
+			ids, err := orderProducts(c, os, id)
+			if err != nil {
+				return err
+			}
+			if err := ost.CancelOrder(c, id, o.StatusID, r.Cancellation); err != nil {
+				return fmt.Errorf("ost.CancelOrder: %w", err)
+			}
+			voided = append(voided, ids...)
+			res.OrderIDs = append(res.OrderIDs, id)
+		}
+		if len(r.ProductIDs) == 0 {
+			return nil
+		}
+		kps, err := os.KitchenProducts(c, r.ProductIDs)
+		if err != nil {
+			return fmt.Errorf("os.KitchenProducts: %w", err)
+		}
+		if len(kps) != len(r.ProductIDs) {
+			return apperr.New(apperr.ErrNotFound, "order product not found")
+		}
+		var oIDs []uint64
+		byOrder := make(map[uint64][]uint64)
+		for _, kp := range kps {
+			switch {
+			case kp.IsCancelled:
+				return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("order product %d is already cancelled", kp.ID))
+			case (kp.IsReady || kp.IsDelivered) && !r.Override:
+				return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("order product %d is already prepared", kp.ID))
+			}
+			if _, ok := byOrder[kp.OrderID]; !ok {
+				oIDs = append(oIDs, kp.OrderID)
+			}
+			byOrder[kp.OrderID] = append(byOrder[kp.OrderID], kp.ID)
+		}
+		for _, oID := range oIDs {
+			o, err := ost.Order(c, oID)
+			if err != nil {
+				return fmt.Errorf("ost.Order: %w", err)
+			}
+			if err := checkCancel(o, r.Override); err != nil {
+				return err
+			}
+			if o.PayID != nil {
+				return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("order %d has a payment for its total", o.ID))
+			}
+			if err := ost.CancelProducts(c, oID, byOrder[oID], r.Cancellation); err != nil {
+				return fmt.Errorf("ost.CancelProducts: %w", err)
+			}
+			voided = append(voided, byOrder[oID]...)
+			res.ProductIDs = append(res.ProductIDs, byOrder[oID]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return model.CancelResult{}, err
+	}
+	oss.kn.Notify(c, model.ProductCancelled, voided...)
+	return res, nil
+}
+
+// orderProducts returns the products of the order oID that are not cancelled.
+func orderProducts(c context.Context, os OrderStorager, oID uint64) ([]uint64, error) {
+	ps, err := os.Products(c, oID)
+	if err != nil {
+		return nil, fmt.Errorf("os.Products: %w", err)
+	}
+	var ids []uint64
+	for _, p := range ps {
+		if !p.IsCancelled {
+			ids = append(ids, p.ID)
+		}
+	}
+	return ids, nil
+}
+
+// leftKitchen returns the products of the order o to remove from the kitchen
+// when it moves to the status to.
+func leftKitchen(c context.Context, os OrderStorager, o model.Order, to model.Status) ([]uint64, error) {
+	if !o.StatusID.InKitchen() || to.InKitchen() {
+		return nil, nil
+	}
+	return orderProducts(c, os, o.ID)
+}
+
+// settle moves the order o to the status to once every product not cancelled
+// is done, a paid order starts its preparation first. An order that can not
+// move to the status is left as it is.
+func settle(c context.Context, os OrderStorager, ost OrderStatusStorager, o model.Order, to model.Status, done func(model.OrderProduct) bool) error {
+	ps, err := os.Products(c, o.ID)
+	if err != nil {
+		return fmt.Errorf("os.Products: %w", err)
+	}
+	for _, p := range ps {
+		if !p.IsCancelled && !done(p) {
+			return nil
+		}
+	}
+	from := o.StatusID
+	if from == model.Paid && CanTransition(model.InPreparation, to) {
+		if err := ost.SetStatus(c, o.ID, from, model.InPreparation); err != nil {
+			return fmt.Errorf("ost.SetStatus: %w", err)
+		}
+		from = model.InPreparation
+	}
+	if !CanTransition(from, to) {
+		return nil
+	}
+	if err := ost.SetStatus(c, o.ID, from, to); err != nil {
+		return fmt.Errorf("ost.SetStatus: %w", err)
 	}
 	return nil
 }
 
+// DeliverProduct marks the products ids as delivered, their orders are
+// Delivered once every product is.
+func (oss OrderStatusService) DeliverProduct(c context.Context, ids []uint64) error {
+	err := oss.tx.WithTx(c, func(os OrderStorager, ost OrderStatusStorager) error {
+		kps, err := os.KitchenProducts(c, ids)
+		if err != nil {
+			return fmt.Errorf("os.KitchenProducts: %w", err)
+		}
+		// the orders are locked in the same order by every request.
+		var oIDs []uint64
+		seen := make(map[uint64]bool)
+		for _, kp := range kps {
+			if !seen[kp.OrderID] {
+				seen[kp.OrderID] = true
+				oIDs = append(oIDs, kp.OrderID)
+			}
+		}
+		sort.Slice(oIDs, func(i, j int) bool { return oIDs[i] < oIDs[j] })
+		orders := make([]model.Order, len(oIDs))
+		for i, oID := range oIDs {
+			if orders[i], err = ost.Order(c, oID); err != nil {
+				return fmt.Errorf("ost.Order: %w", err)
+			}
+		}
+		if err := ost.DeliverProduct(c, ids); err != nil {
+			return fmt.Errorf("ost.DeliverProduct: %w", err)
+		}
+		for _, o := range orders {
+			if err := settle(c, os, ost, o, model.Delivered, func(p model.OrderProduct) bool { return p.IsDelivered }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	oss.kn.Notify(c, model.ProductUpdated, ids...)
+	return nil
+}
+
+// PayDelivery creates the online payment of the delivery order oID of the
+// user uID and returns its ID. The gateway is called outside of any
+// transaction, the key of the payment is stored before so a retry gets the
+// same payment, and the payment of the order is reused while it is open for
+// its total.
 func (oss OrderStatusService) PayDelivery(c context.Context, oID uint64, uID uint64, eID uint64, aID string, pm model.PaymentMethod) (string, error) {
-	if pm != model.PAYPAL {
-		return "", fmt.Errorf("payment method must be paypal")
+	g, err := oss.pg.Gateway(pm)
+	if err != nil {
+		return "", apperr.InvalidArgument(apperr.FieldViolation{Field: "payment", Description: err.Error()})
 	}
-	tp, err := oss.ost.TotalPrice(oID, uID)
+	var o model.Order
+	err = oss.tx.WithTx(c, func(_ OrderStorager, ost OrderStatusStorager) error {
+		var err error
+		if o, err = ost.Order(c, oID); err != nil {
+			return fmt.Errorf("ost.Order: %w", err)
+		}
+		if o.UserID != uID {
+			return apperr.New(apperr.ErrNotFound, fmt.Sprintf("order %d not found", oID))
+		}
+		if err := checkTransition(o.StatusID, model.Paid); err != nil {
+			return err
+		}
+		if o.PayRequestID != nil {
+			return nil
+		}
+		key, err := model.NewUUID()
+		if err != nil {
+			return err
+		}
+		if err := ost.SetPaymentRequest(c, oID, key); err != nil {
+			return fmt.Errorf("ost.SetPaymentRequest: %w", err)
+		}
+		o.PayRequestID = &key
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("ost.TotalPrice: %w", err)
+		return "", err
 	}
-	pID, err := oss.ps.CreateOrder(c, tp)
+	pID, err := openPayment(c, g, o, pm)
 	if err != nil {
-		return "", fmt.Errorf("ps.CreateOrder: %w", err)
+		return "", err
 	}
-	if err := oss.ost.SetPaymentDelivery(oID, eID, pID, aID); err != nil {
-		return "", fmt.Errorf("ost.PayDelivery: %w", err)
+	err = oss.tx.WithTx(c, func(_ OrderStorager, ost OrderStatusStorager) error {
+		st, err := ost.Status(c, oID)
+		if err != nil {
+			return fmt.Errorf("ost.Status: %w", err)
+		}
+		if err := checkTransition(st, model.Paid); err != nil {
+			return err
+		}
+		if err := ost.SetPaymentDelivery(c, oID, eID, pID, aID, pm); err != nil {
+			return fmt.Errorf("ost.SetPaymentDelivery: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
 	return pID, nil
+}
 
+// openPayment returns the payment of the order o when it can still be paid
+// with pm for its total, otherwise it creates one with the key of the order.
+func openPayment(c context.Context, g PaymentGateway, o model.Order, pm model.PaymentMethod) (string, error) {
+	if o.PayID != nil && o.PaymentMethod == pm {
+		p, err := g.Status(c, *o.PayID)
+		if err != nil && !errors.Is(err, apperr.ErrNotFound) {
+			return "", fmt.Errorf("g.Status: %w", err)
+		}
+		open := p.Status == model.PaymentCreated || p.Status == model.PaymentApproved
+		if err == nil && open && p.Amount.Amount == o.Total.Amount && p.Amount.SameCurrency(o.Total) {
+			return *o.PayID, nil
+		}
+	}
+	pID, err := g.CreateIntent(c, o.Total, *o.PayRequestID)
+	if err != nil {
+		return "", fmt.Errorf("g.CreateIntent: %w", err)
+	}
+	return pID, nil
 }
 
-func (oss OrderStatusService) PayLocal(oID uint64, eID uint64, pm model.PaymentMethod, tip float32) error {
+func (oss OrderStatusService) PayLocal(c context.Context, oID uint64, eID uint64, pm model.PaymentMethod, tip model.TipRate) error {
 	if pm != model.CASH {
-		return fmt.Errorf("payment method must be cash")
+		return apperr.InvalidArgument(apperr.FieldViolation{Field: "payment", Description: "must be cash"})
 	}
-	if err := oss.ost.PayLocal(oID, eID, tip); err != nil {
-		return fmt.Errorf("ost.PayLocal: %w", err)
+	return oss.tx.WithTx(c, func(_ OrderStorager, ost OrderStatusStorager) error {
+		st, err := ost.Status(c, oID)
+		if err != nil {
+			return fmt.Errorf("ost.Status: %w", err)
+		}
+		if err := checkTransition(st, model.Closed); err != nil {
+			return err
+		}
+		if err := ost.PayLocal(c, oID, eID, tip, st, model.Closed); err != nil {
+			return fmt.Errorf("ost.PayLocal: %w", err)
+		}
+		return nil
+	})
+}
+
+// CompleteProduct marks the product opID as ready, its order is Ready once
+// every product is. Only the products not cancelled of an order in
+// preparation are completed, a paid order starts its preparation with its
+// first product.
+func (oss OrderStatusService) CompleteProduct(c context.Context, opID uint64) error {
+	err := oss.tx.WithTx(c, func(os OrderStorager, ost OrderStatusStorager) error {
+		kps, err := os.KitchenProducts(c, []uint64{opID})
+		if err != nil {
+			return fmt.Errorf("os.KitchenProducts: %w", err)
+		}
+		if len(kps) == 0 {
+			return apperr.New(apperr.ErrNotFound, "order product not found")
+		}
+		o, err := ost.Order(c, kps[0].OrderID)
+		if err != nil {
+			return fmt.Errorf("ost.Order: %w", err)
+		}
+		if kps[0].IsCancelled {
+			return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("order product %d is cancelled", opID))
+		}
+		switch o.StatusID {
+		case model.InPreparation:
+		case model.Paid:
+			if err := ost.SetStatus(c, o.ID, o.StatusID, model.InPreparation); err != nil {
+				return fmt.Errorf("ost.SetStatus: %w", err)
+			}
+			o.StatusID = model.InPreparation
+		default:
+			return &TransitionError{From: o.StatusID, To: model.Ready}
+		}
+		if err := ost.CompleteProduct(c, opID); err != nil {
+			return fmt.Errorf("ost.CompleteProduct: %w", err)
+		}
+		return settle(c, os, ost, o, model.Ready, func(p model.OrderProduct) bool { return p.IsReady })
+	})
+	if err != nil {
+		return err
 	}
+	oss.kn.Notify(c, model.ProductUpdated, opID)
 	return nil
 }
 
-func (oss OrderStatusService) CompleteProduct(opID uint64) error {
-	if err := oss.ost.CompleteProduct(opID); err != nil {
-		return fmt.Errorf("ost.CompleteProduct: %w", err)
+func (oss OrderStatusService) CapturePayment(c context.Context, pID string) (string, error) {
+	return oss.capture(c, pID)
+}
+
+// capture captures the payment pID and moves its order to Paid, it returns
+// the status of the capture. The gateway is called outside of any
+// transaction, a capture that fails to be stored is stored by a retry, the
+// event of the gateway or the reconciler because capturing is idempotent.
+func (oss OrderStatusService) capture(c context.Context, pID string) (string, error) {
+	o, err := oss.ost.PaymentOrder(c, pID)
+	if err != nil {
+		return "", fmt.Errorf("ost.PaymentOrder: %w", err)
+	}
+	if err := checkTransition(o.StatusID, model.Paid); err != nil {
+		return "", err
+	}
+	g, err := oss.gateway(o)
+	if err != nil {
+		return "", err
+	}
+	cp, err := g.Capture(c, pID)
+	if err != nil {
+		return "", fmt.Errorf("g.Capture: %w", err)
+	}
+	if !strings.EqualFold(cp.Status, string(model.PaymentCompleted)) {
+		return cp.Status, apperr.New(apperr.ErrPaymentDeclined, fmt.Sprintf("payment status is %s", cp.Status))
+	}
+	return cp.Status, oss.paid(c, pID, cp)
+}
+
+// checkCapture fails when the amount captured is not the total of the order
+// o, the order keeps waiting for its payment and the reconciler reports it.
+func checkCapture(o model.Order, amount model.Money) error {
+	if amount.Amount != o.Total.Amount || !amount.SameCurrency(o.Total) {
+		return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("capture of %s for a total of %s of order %d", amount, o.Total, o.ID))
 	}
 	return nil
 }
 
-func (oss OrderStatusService) CapturePayment(c context.Context, pID string) (string, error) {
-	s, err := oss.ps.CaptureOrder(c, pID)
+// paid stores the capture cp of the payment pID and moves its order to Paid,
+// an order paid meanwhile with the same payment is left as it is. The
+// products of the order are sent to the kitchen once it is paid, an order
+// with another total than the capture is left in AwaitingPayment.
+func (oss OrderStatusService) paid(c context.Context, pID string, cp model.Capture) error {
+	var ids []uint64
+	err := oss.tx.WithTx(c, func(os OrderStorager, ost OrderStatusStorager) error {
+		o, err := ost.PaymentOrder(c, pID)
+		if err != nil {
+			return fmt.Errorf("ost.PaymentOrder: %w", err)
+		}
+		if o.StatusID == model.Paid {
+			return nil
+		}
+		if err := checkTransition(o.StatusID, model.Paid); err != nil {
+			return err
+		}
+		if err := checkCapture(o, cp.Amount); err != nil {
+			return err
+		}
+		if err := ost.PayDelivey(c, pID, cp, o.StatusID, model.Paid); err != nil {
+			return fmt.Errorf("ost.PayDelivery: %w", err)
+		}
+		ids, err = orderProducts(c, os, o.ID)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("ps.CaptureOrder: %w", err)
+		return err
 	}
-	if !strings.EqualFold(s, "COMPLETED") {
-		return s, fmt.Errorf("payment status is not completed")
+	oss.kn.Notify(c, model.ProductAdded, ids...)
+	return nil
+}
+
+// refundStatus returns the status of the order o after refunding amount.
+func refundStatus(o model.Order, amount model.Money) model.Status {
+	if o.Refunded.Amount+amount.Amount >= o.Captured.Amount {
+		return model.Refunded
 	}
-	if err := oss.ost.PayDelivey(pID); err != nil {
-		return s, fmt.Errorf("ost.PayDelivery: %w", err)
+	return model.PartiallyRefunded
+}
+
+func validateRefund(c context.Context, r model.RefundRequest) error {
+	var vs []apperr.FieldViolation
+	if r.OrderID == 0 {
+		vs = append(vs, apperr.FieldViolation{Field: "order_id", Description: "must not be empty"})
+	}
+	if r.Amount.Amount < 0 {
+		vs = append(vs, apperr.FieldViolation{Field: "amount", Description: "must not be negative"})
+	}
+	if strings.TrimSpace(r.Reason) == "" {
+		vs = append(vs, apperr.FieldViolation{Field: "reason", Description: "must not be empty"})
+	}
+	if vs != nil {
+		return apperr.InvalidArgument(vs...)
+	}
+	if !model.ActorFromContext(c).IsManager() {
+		return apperr.New(apperr.ErrPermissionDenied, "only a manager can refund orders")
+	}
+	return nil
+}
+
+// Refund returns money of a paid order to the customer, through the payment
+// provider when the order was paid online. The order is Refunded when all the
+// money captured is returned and PartiallyRefunded otherwise. An online
+// refund is stored as pending before the provider is called outside of any
+// transaction, a refund left pending by a failure is sent again with the
+// same key by the next request.
+func (oss OrderStatusService) Refund(c context.Context, r model.RefundRequest) (model.Refund, error) {
+	if err := validateRefund(c, r); err != nil {
+		return model.Refund{}, err
+	}
+	var rf model.Refund
+	var o model.Order
+	var removed []uint64
+	err := oss.tx.WithTx(c, func(os OrderStorager, ost OrderStatusStorager) error {
+		var err error
+		if o, err = ost.Order(c, r.OrderID); err != nil {
+			return fmt.Errorf("ost.Order: %w", err)
+		}
+		if o.Captured.IsZero() {
+			return &TransitionError{From: o.StatusID, To: model.Refunded}
+		}
+		left := model.NewMoney(o.Captured.Amount-o.Refunded.Amount, o.Captured.Currency)
+		if left.Amount <= 0 {
+			return apperr.New(apperr.ErrFailedPrecondition, fmt.Sprintf("order %d is already refunded", o.ID))
+		}
+		amount := r.Amount
+		if amount.IsZero() {
+			amount = left
+		}
+		if amount.Amount <= 0 {
+			return apperr.InvalidArgument(apperr.FieldViolation{Field: "amount", Description: "must be greater than zero"})
+		}
+		if !amount.SameCurrency(left) {
+			return apperr.InvalidArgument(apperr.FieldViolation{Field: "amount", Description: fmt.Sprintf("must be in %s", left.Currency)})
+		}
+		if amount.Amount > left.Amount {
+			return apperr.InvalidArgument(apperr.FieldViolation{Field: "amount", Description: fmt.Sprintf("must not exceed the %s left to refund", left)})
+		}
+		to := refundStatus(o, amount)
+		if o.StatusID != to {
+			if err := checkTransition(o.StatusID, to); err != nil {
+				return err
+			}
+		}
+		rf = model.Refund{OrderID: r.OrderID, Amount: amount, Reason: r.Reason, EmployeeID: model.ActorFromContext(c).EmployeeID}
+		if o.CaptureID == nil {
+			rf.Status = string(model.PaymentCompleted)
+			if err := ost.Refund(c, &rf, o.StatusID, to); err != nil {
+				return fmt.Errorf("ost.Refund: %w", err)
+			}
+			removed, err = leftKitchen(c, os, o, to)
+			return err
+		}
+		p, err := ost.PendingRefund(c, o.ID)
+		switch {
+		case err == nil && p.Amount.Amount != amount.Amount:
+			return apperr.New(apperr.ErrConflict, fmt.Sprintf("a refund of %s is pending", p.Amount))
+		case err == nil:
+			rf = p
+			return nil
+		case !errors.Is(err, apperr.ErrNotFound):
+			return fmt.Errorf("ost.PendingRefund: %w", err)
+		}
+		key, err := model.NewUUID()
+		if err != nil {
+			return err
+		}
+		rf.RequestID = &key
+		if err := ost.AddRefund(c, &rf); err != nil {
+			return fmt.Errorf("ost.AddRefund: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return model.Refund{}, err
+	}
+	if rf.Status != model.RefundPending {
+		oss.kn.Notify(c, model.ProductCancelled, removed...)
+		return rf, nil
+	}
+	return oss.sendRefund(c, o, rf)
+}
+
+// sendRefund sends the pending refund rf of the order o to the payment
+// provider and completes it, a refund declined by the provider is failed.
+func (oss OrderStatusService) sendRefund(c context.Context, o model.Order, rf model.Refund) (model.Refund, error) {
+	g, err := oss.gateway(o)
+	if err != nil {
+		return model.Refund{}, err
+	}
+	prf, err := g.Refund(c, *o.CaptureID, rf.Amount, rf.Reason, *rf.RequestID)
+	if errors.Is(err, apperr.ErrPaymentDeclined) {
+		if err := oss.ost.FailRefund(c, rf.ID); err != nil {
+			log.Printf("refund %d of order %d: ost.FailRefund: %s", rf.ID, o.ID, err)
+		}
+	}
+	if err != nil {
+		return model.Refund{}, fmt.Errorf("g.Refund: %w", err)
+	}
+	rf.ProviderID, rf.Status = prf.ProviderID, prf.Status
+	var removed []uint64
+	err = oss.tx.WithTx(c, func(os OrderStorager, ost OrderStatusStorager) error {
+		// the event of the provider may have completed the refund already.
+		ok, err := ost.HasRefund(c, *rf.ProviderID)
+		if err != nil {
+			return fmt.Errorf("ost.HasRefund: %w", err)
+		}
+		if ok {
+			return nil
+		}
+		o, err := ost.Order(c, rf.OrderID)
+		if err != nil {
+			return fmt.Errorf("ost.Order: %w", err)
+		}
+		to := refundStatus(o, rf.Amount)
+		if o.StatusID != to {
+			if err := checkTransition(o.StatusID, to); err != nil {
+				return err
+			}
+		}
+		if err := ost.Refund(c, &rf, o.StatusID, to); err != nil {
+			return fmt.Errorf("ost.Refund: %w", err)
+		}
+		removed, err = leftKitchen(c, os, o, to)
+		return err
+	})
+	if err != nil {
+		return model.Refund{}, err
 	}
-	return s, nil
+	oss.kn.Notify(c, model.ProductCancelled, removed...)
+	return rf, nil
 }
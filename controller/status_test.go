@@ -0,0 +1,863 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStatusStorage struct {
+	OrderStatusStorager
+	status    model.Status
+	paidTo    model.Status
+	orders    map[uint64]model.Order
+	cancelled []uint64
+	voided    []uint64
+	refunded  []model.Status
+	method    model.PaymentMethod
+	expired   []uint64
+	events    map[string]bool
+	providers []string
+	keys      []string
+	pending   map[uint64]model.Refund
+	added     []model.Refund
+	failed    []uint64
+	moved     []model.Status
+	denied    []string
+}
+
+func (f *fakeStatusStorage) SetStatus(c context.Context, oID uint64, from, to model.Status) error {
+	f.moved = append(f.moved, to)
+	return nil
+}
+
+func (f *fakeStatusStorage) CompleteProduct(c context.Context, opID uint64) error {
+	return nil
+}
+
+func (f *fakeStatusStorage) DeliverProduct(c context.Context, ids []uint64) error {
+	return nil
+}
+
+func (f *fakeStatusStorage) AddPaymentEvent(c context.Context, e *model.PaymentEvent) error {
+	if f.events[e.ID] {
+		return model.ErrPaymentEventProcessed
+	}
+	if f.events == nil {
+		f.events = make(map[string]bool)
+	}
+	f.events[e.ID] = true
+	return nil
+}
+
+func (f *fakeStatusStorage) CaptureOrder(c context.Context, cID string) (model.Order, error) {
+	for _, o := range f.orders {
+		if o.CaptureID != nil && *o.CaptureID == cID {
+			return o, nil
+		}
+	}
+	return model.Order{}, apperr.New(apperr.ErrNotFound, "order not found")
+}
+
+func (f *fakeStatusStorage) UnpaidOrders(c context.Context, before time.Time, after uint64, limit int) ([]model.Order, error) {
+	var os []model.Order
+	for id := after + 1; len(os) < limit && id <= uint64(len(f.orders)); id++ {
+		if o := f.orders[id]; o.StatusID == model.AwaitingPayment && o.CreatedAt.Before(before) {
+			os = append(os, o)
+		}
+	}
+	return os, nil
+}
+
+func (f *fakeStatusStorage) ExpireOrder(c context.Context, oID uint64, from model.Status) error {
+	f.expired = append(f.expired, oID)
+	return nil
+}
+
+func (f *fakeStatusStorage) HasRefund(c context.Context, prID string) (bool, error) {
+	for _, id := range f.providers {
+		if id == prID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeStatusStorage) AddRefund(c context.Context, rf *model.Refund) error {
+	rf.ID, rf.Status = uint64(len(f.added)+1), model.RefundPending
+	f.added = append(f.added, *rf)
+	return nil
+}
+
+func (f *fakeStatusStorage) PendingRefund(c context.Context, oID uint64) (model.Refund, error) {
+	rf, ok := f.pending[oID]
+	if !ok {
+		return model.Refund{}, apperr.New(apperr.ErrNotFound, "refund not found")
+	}
+	return rf, nil
+}
+
+func (f *fakeStatusStorage) FailRefund(c context.Context, rID uint64) error {
+	f.failed = append(f.failed, rID)
+	return nil
+}
+
+func (f *fakeStatusStorage) Refund(c context.Context, rf *model.Refund, from, to model.Status) error {
+	f.refunded = append(f.refunded, to)
+	return nil
+}
+
+func (f *fakeStatusStorage) Order(c context.Context, oID uint64) (model.Order, error) {
+	o, ok := f.orders[oID]
+	if !ok {
+		return model.Order{}, apperr.New(apperr.ErrNotFound, "order not found")
+	}
+	return o, nil
+}
+
+func (f *fakeStatusStorage) CancelOrder(c context.Context, oID uint64, from model.Status, cn model.Cancellation) error {
+	f.cancelled = append(f.cancelled, oID)
+	return nil
+}
+
+func (f *fakeStatusStorage) CancelProducts(c context.Context, oID uint64, ids []uint64, cn model.Cancellation) error {
+	f.voided = append(f.voided, ids...)
+	return nil
+}
+
+func (f *fakeStatusStorage) Status(c context.Context, oID uint64) (model.Status, error) {
+	return f.status, nil
+}
+
+func (f *fakeStatusStorage) PaymentOrder(c context.Context, pID string) (model.Order, error) {
+	for _, o := range f.orders {
+		if o.PayID != nil && *o.PayID == pID {
+			return o, nil
+		}
+	}
+	if f.status == 0 {
+		return model.Order{}, apperr.New(apperr.ErrNotFound, "order not found")
+	}
+	// the other payments are of an order of 50.00 MXN in status.
+	return model.Order{StatusID: f.status, Total: model.NewMoney(5000, model.MXN)}, nil
+}
+
+func (f *fakeStatusStorage) DenyPayment(c context.Context, oID uint64, pID string) error {
+	f.denied = append(f.denied, pID)
+	return nil
+}
+
+func (f *fakeStatusStorage) SetPaymentRequest(c context.Context, oID uint64, key string) error {
+	f.keys = append(f.keys, key)
+	return nil
+}
+
+func (f *fakeStatusStorage) SetPaymentDelivery(c context.Context, oID, eID uint64, pID, aID string, pm model.PaymentMethod) error {
+	f.method = pm
+	return nil
+}
+
+func (f *fakeStatusStorage) PayLocal(c context.Context, oID, eID uint64, tip model.TipRate, from, to model.Status) error {
+	f.paidTo = to
+	return nil
+}
+
+func (f *fakeStatusStorage) PayDelivey(c context.Context, pID string, cp model.Capture, from, to model.Status) error {
+	f.paidTo = to
+	return nil
+}
+
+type fakeGateway struct {
+	PaymentGateway
+	intents    []string
+	captured   int
+	refunded   []string
+	refundKeys []string
+	payments   map[string]model.Payment
+	voided     []string
+	// amount is the amount captured, 50.00 MXN when it is zero.
+	amount model.Money
+}
+
+func (f *fakeGateway) Void(c context.Context, pID string) error {
+	f.voided = append(f.voided, pID)
+	return nil
+}
+
+func (f *fakeGateway) Status(c context.Context, pID string) (model.Payment, error) {
+	p, ok := f.payments[pID]
+	if !ok {
+		return model.Payment{}, apperr.New(apperr.ErrNotFound, "payment not found")
+	}
+	return p, nil
+}
+
+func (f *fakeGateway) CreateIntent(c context.Context, amount model.Money, key string) (string, error) {
+	f.intents = append(f.intents, key)
+	return "PAY-1", nil
+}
+
+func (f *fakeGateway) Capture(c context.Context, id string) (model.Capture, error) {
+	f.captured++
+	amount := f.amount
+	if amount.IsZero() {
+		amount = model.NewMoney(5000, model.MXN)
+	}
+	return model.Capture{ID: "CAP-1", Status: "COMPLETED", Amount: amount}, nil
+}
+
+func (f *fakeGateway) Refund(c context.Context, cID string, amount model.Money, note, key string) (model.Refund, error) {
+	f.refunded = append(f.refunded, cID)
+	f.refundKeys = append(f.refundKeys, key)
+	if cID == "CAP-DECLINED" {
+		return model.Refund{}, apperr.New(apperr.ErrPaymentDeclined, "refund declined")
+	}
+	id := "REF-1"
+	return model.Refund{ProviderID: &id, Status: "COMPLETED", Amount: amount}, nil
+}
+
+func paypalGateways(g PaymentGateway) *PaymentGateways {
+	pg := NewPaymentGateways()
+	pg.Register(model.PAYPAL, g)
+	return pg
+}
+
+type fakeTx struct {
+	os  OrderStorager
+	ost OrderStatusStorager
+}
+
+func (f fakeTx) WithTx(c context.Context, fn func(OrderStorager, OrderStatusStorager) error) error {
+	return fn(f.os, f.ost)
+}
+
+func TestOrderStatusService_PayLocal(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  model.Status
+		wantErr error
+	}{
+		{name: "ok", status: model.InPreparation},
+		{name: "cancelled order", status: model.Cancelled, wantErr: ErrInvalidTransition},
+		{name: "paid twice", status: model.Closed, wantErr: ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{status: tt.status}
+			oss := NewOrderStatusService(ost, paypalGateways(&fakeGateway{}), fakeTx{os: &fakeOrderStorage{}, ost: ost}, &fakeNotifier{})
+			err := oss.PayLocal(context.Background(), 1, 1, model.CASH, 1000)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderStatusService.PayLocal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && ost.paidTo != model.Closed {
+				t.Errorf("OrderStatusService.PayLocal() status = %s, want %s", ost.paidTo, model.Closed)
+			}
+		})
+	}
+}
+
+func TestOrderStatusService_PayDelivery(t *testing.T) {
+	payID := func(s string) *string { return &s }
+	total := model.NewMoney(10000, model.MXN)
+	orders := map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1}, UserID: 7, StatusID: model.AwaitingPayment, Total: total},
+		2: {Model: model.Model{ID: 2}, UserID: 7, StatusID: model.Paid, Total: total},
+		3: {Model: model.Model{ID: 3}, UserID: 7, StatusID: model.AwaitingPayment, Total: total, PayRequestID: payID("KEY-3")},
+		4: {Model: model.Model{ID: 4}, UserID: 7, StatusID: model.AwaitingPayment, Total: total, PayID: payID("PAY-4"), PaymentMethod: model.PAYPAL},
+		5: {Model: model.Model{ID: 5}, UserID: 7, StatusID: model.AwaitingPayment, Total: total, PayID: payID("PAY-5"), PaymentMethod: model.PAYPAL},
+		6: {Model: model.Model{ID: 6}, UserID: 8, StatusID: model.AwaitingPayment, Total: total},
+	}
+	payments := map[string]model.Payment{
+		"PAY-4": {ID: "PAY-4", Status: model.PaymentCreated, Amount: total},
+		"PAY-5": {ID: "PAY-5", Status: model.PaymentVoided, Amount: total},
+	}
+	tests := []struct {
+		name        string
+		oID         uint64
+		pm          model.PaymentMethod
+		want        string
+		wantErr     error
+		wantKeys    int
+		wantIntents int
+	}{
+		{name: "paypal", oID: 1, pm: model.PAYPAL, want: "PAY-1", wantKeys: 1, wantIntents: 1},
+		{name: "method without gateway", oID: 1, pm: model.CASH, wantErr: apperr.ErrInvalidArgument},
+		{name: "already paid", oID: 2, pm: model.PAYPAL, wantErr: ErrInvalidTransition},
+		{name: "order of another user", oID: 6, pm: model.PAYPAL, wantErr: apperr.ErrNotFound},
+		{name: "retry after a failure", oID: 3, pm: model.PAYPAL, want: "PAY-1", wantIntents: 1},
+		{name: "open payment", oID: 4, pm: model.PAYPAL, want: "PAY-4", wantKeys: 1},
+		{name: "voided payment", oID: 5, pm: model.PAYPAL, want: "PAY-1", wantKeys: 1, wantIntents: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{status: orders[tt.oID].StatusID, orders: orders}
+			g := &fakeGateway{payments: payments}
+			oss := NewOrderStatusService(ost, paypalGateways(g), fakeTx{os: &fakeOrderStorage{}, ost: ost}, &fakeNotifier{})
+			got, err := oss.PayDelivery(context.Background(), tt.oID, 7, 1, "ADDR-1", tt.pm)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderStatusService.PayDelivery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.pm, ost.method)
+			assert.Len(t, ost.keys, tt.wantKeys)
+			if assert.Len(t, g.intents, tt.wantIntents) && tt.wantIntents > 0 {
+				// the intent is created with the key stored before.
+				key := orders[tt.oID].PayRequestID
+				if key == nil {
+					key = &ost.keys[0]
+				}
+				assert.Equal(t, *key, g.intents[0])
+			}
+		})
+	}
+}
+
+func TestOrderStatusService_CompleteProduct(t *testing.T) {
+	orders := map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1}, TypeID: model.Local, StatusID: model.InPreparation},
+		2: {Model: model.Model{ID: 2}, TypeID: model.Local, StatusID: model.InPreparation},
+		3: {Model: model.Model{ID: 3}, TypeID: model.Delivery, StatusID: model.Paid},
+		4: {Model: model.Model{ID: 4}, TypeID: model.Delivery, StatusID: model.Paid},
+		5: {Model: model.Model{ID: 5}, TypeID: model.Local, StatusID: model.Cancelled},
+		6: {Model: model.Model{ID: 6}, TypeID: model.Delivery, StatusID: model.AwaitingPayment},
+		7: {Model: model.Model{ID: 7}, TypeID: model.Delivery, StatusID: model.Expired},
+		8: {Model: model.Model{ID: 8}, TypeID: model.Local, StatusID: model.Ready},
+	}
+	// the products are stored as they are once completed.
+	products := []model.KitchenProduct{
+		{OrderProduct: model.OrderProduct{ID: 11, OrderID: 1, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 12, OrderID: 1}},
+		{OrderProduct: model.OrderProduct{ID: 21, OrderID: 2, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 22, OrderID: 2, IsCancelled: true}},
+		{OrderProduct: model.OrderProduct{ID: 31, OrderID: 3, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 41, OrderID: 4, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 42, OrderID: 4}},
+		{OrderProduct: model.OrderProduct{ID: 51, OrderID: 5, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 61, OrderID: 6, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 71, OrderID: 7, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 81, OrderID: 8, IsReady: true}},
+	}
+	tests := []struct {
+		name      string
+		give      uint64
+		wantErr   error
+		wantMoved []model.Status
+	}{
+		{name: "products left", give: 11},
+		{name: "last product", give: 21, wantMoved: []model.Status{model.Ready}},
+		{name: "paid order", give: 31, wantMoved: []model.Status{model.InPreparation, model.Ready}},
+		{name: "paid order with products left", give: 41, wantMoved: []model.Status{model.InPreparation}},
+		{name: "cancelled product", give: 22, wantErr: apperr.ErrFailedPrecondition},
+		{name: "cancelled order", give: 51, wantErr: ErrInvalidTransition},
+		{name: "order awaiting payment", give: 61, wantErr: ErrInvalidTransition},
+		{name: "expired order", give: 71, wantErr: ErrInvalidTransition},
+		{name: "ready order", give: 81, wantErr: ErrInvalidTransition},
+		{name: "unknown product", give: 99, wantErr: apperr.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{orders: orders}
+			kn := &fakeNotifier{}
+			oss := NewOrderStatusService(ost, paypalGateways(&fakeGateway{}), fakeTx{os: &fakeOrderStorage{products: products}, ost: ost}, kn)
+			err := oss.CompleteProduct(context.Background(), tt.give)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderStatusService.CompleteProduct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantMoved, ost.moved)
+			if err == nil {
+				assert.Equal(t, []uint64{tt.give}, kn.ids)
+			}
+		})
+	}
+}
+
+func TestOrderStatusService_DeliverProduct(t *testing.T) {
+	orders := map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1}, TypeID: model.Local, StatusID: model.Ready},
+		2: {Model: model.Model{ID: 2}, TypeID: model.Local, StatusID: model.Ready},
+	}
+	products := []model.KitchenProduct{
+		{OrderProduct: model.OrderProduct{ID: 11, OrderID: 1, IsReady: true, IsDelivered: true}},
+		{OrderProduct: model.OrderProduct{ID: 12, OrderID: 1, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 21, OrderID: 2, IsReady: true, IsDelivered: true}},
+		{OrderProduct: model.OrderProduct{ID: 22, OrderID: 2, IsReady: true, IsDelivered: true}},
+	}
+	tests := []struct {
+		name      string
+		give      []uint64
+		wantMoved []model.Status
+	}{
+		{name: "products left", give: []uint64{11}},
+		{name: "every product", give: []uint64{21, 22}, wantMoved: []model.Status{model.Delivered}},
+		{name: "both orders", give: []uint64{11, 21, 22}, wantMoved: []model.Status{model.Delivered}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{orders: orders}
+			oss := NewOrderStatusService(ost, paypalGateways(&fakeGateway{}), fakeTx{os: &fakeOrderStorage{products: products}, ost: ost}, &fakeNotifier{})
+			if err := oss.DeliverProduct(context.Background(), tt.give); err != nil {
+				t.Fatalf("OrderStatusService.DeliverProduct() error = %v", err)
+			}
+			assert.Equal(t, tt.wantMoved, ost.moved)
+		})
+	}
+}
+
+func TestOrderStatusService_CapturePayment(t *testing.T) {
+	products := []model.KitchenProduct{
+		{OrderProduct: model.OrderProduct{ID: 1}},
+		{OrderProduct: model.OrderProduct{ID: 2, IsCancelled: true}},
+	}
+	tests := []struct {
+		name         string
+		status       model.Status
+		amount       model.Money
+		wantErr      error
+		wantCharge   int
+		wantPaid     bool
+		wantNotified []uint64
+	}{
+		{name: "ok", status: model.AwaitingPayment, wantCharge: 1, wantPaid: true, wantNotified: []uint64{1}},
+		{name: "already paid", status: model.Paid, wantErr: ErrInvalidTransition},
+		{name: "cancelled order", status: model.Cancelled, wantErr: ErrInvalidTransition},
+		{name: "another amount", status: model.AwaitingPayment, amount: model.NewMoney(4000, model.MXN), wantErr: apperr.ErrFailedPrecondition, wantCharge: 1},
+		{name: "another currency", status: model.AwaitingPayment, amount: model.NewMoney(5000, "USD"), wantErr: apperr.ErrFailedPrecondition, wantCharge: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{status: tt.status}
+			ps := &fakeGateway{amount: tt.amount}
+			kn := &fakeNotifier{}
+			oss := NewOrderStatusService(ost, paypalGateways(ps), fakeTx{os: &fakeOrderStorage{products: products}, ost: ost}, kn)
+			_, err := oss.CapturePayment(context.Background(), "PAY-1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderStatusService.CapturePayment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantNotified, kn.ids)
+			assert.Equal(t, tt.wantPaid, ost.paidTo == model.Paid)
+			if ps.captured != tt.wantCharge {
+				t.Errorf("OrderStatusService.CapturePayment() captured %d times, want %d", ps.captured, tt.wantCharge)
+			}
+		})
+	}
+}
+
+func TestOrderStatusService_CancelOrders(t *testing.T) {
+	orders := map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1}, TypeID: model.Delivery, UserID: 7, StatusID: model.AwaitingPayment},
+		2: {Model: model.Model{ID: 2}, TypeID: model.Delivery, UserID: 7, StatusID: model.Paid},
+		3: {Model: model.Model{ID: 3}, TypeID: model.Delivery, UserID: 8, StatusID: model.AwaitingPayment},
+	}
+	tests := []struct {
+		name          string
+		give          []uint64
+		wantErr       error
+		wantCancelled []uint64
+	}{
+		{name: "ok", give: []uint64{1}, wantCancelled: []uint64{1}},
+		{name: "no ids", wantErr: apperr.ErrInvalidArgument},
+		{name: "paid order", give: []uint64{1, 2}, wantErr: ErrInvalidTransition},
+		{name: "order of another user", give: []uint64{3}, wantErr: apperr.ErrNotFound},
+		{name: "unknown order", give: []uint64{4}, wantErr: apperr.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{orders: orders}
+			oss := NewOrderStatusService(ost, paypalGateways(&fakeGateway{}), fakeTx{os: &fakeOrderStorage{}, ost: ost}, &fakeNotifier{})
+			err := oss.CancelOrders(context.Background(), tt.give, 7)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderStatusService.CancelOrders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				assert.Equal(t, tt.wantCancelled, ost.cancelled)
+			}
+		})
+	}
+}
+
+func TestOrderStatusService_Cancel(t *testing.T) {
+	payID := "PAY-5"
+	orders := map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1}, TypeID: model.Local, StatusID: model.InPreparation},
+		2: {Model: model.Model{ID: 2}, TypeID: model.Local, StatusID: model.Ready},
+		3: {Model: model.Model{ID: 3}, TypeID: model.Delivery, UserID: 7, StatusID: model.AwaitingPayment},
+		4: {Model: model.Model{ID: 4}, TypeID: model.Local, StatusID: model.Closed},
+		5: {Model: model.Model{ID: 5}, TypeID: model.Delivery, UserID: 7, StatusID: model.AwaitingPayment, PayID: &payID},
+	}
+	products := []model.KitchenProduct{
+		{OrderProduct: model.OrderProduct{ID: 31, OrderID: 3}},
+		{OrderProduct: model.OrderProduct{ID: 51, OrderID: 5}},
+		{OrderProduct: model.OrderProduct{ID: 11, OrderID: 1}},
+		{OrderProduct: model.OrderProduct{ID: 12, OrderID: 1, IsReady: true}},
+		{OrderProduct: model.OrderProduct{ID: 13, OrderID: 1, IsCancelled: true}},
+		{OrderProduct: model.OrderProduct{ID: 21, OrderID: 2}},
+	}
+	staff := model.Actor{EmployeeID: 1}
+	manager := model.Actor{EmployeeID: 2, Role: model.RoleManager}
+	tests := []struct {
+		name         string
+		actor        model.Actor
+		give         model.CancelRequest
+		wantErr      error
+		want         model.CancelResult
+		wantNotified []uint64
+	}{
+		{
+			name:  "order",
+			actor: staff,
+			give:  model.CancelRequest{OrderIDs: []uint64{1}, Cancellation: model.Cancellation{Reason: model.KitchenError}},
+			want:  model.CancelResult{OrderIDs: []uint64{1}}, wantNotified: []uint64{11, 12},
+		}, {
+			name:    "no ids",
+			actor:   staff,
+			give:    model.CancelRequest{Cancellation: model.Cancellation{Reason: model.KitchenError}},
+			wantErr: apperr.ErrInvalidArgument,
+		}, {
+			name:    "invalid reason",
+			actor:   staff,
+			give:    model.CancelRequest{OrderIDs: []uint64{1}},
+			wantErr: apperr.ErrInvalidArgument,
+		}, {
+			name:    "customer",
+			actor:   model.Actor{UserID: 7},
+			give:    model.CancelRequest{OrderIDs: []uint64{3}, Cancellation: model.Cancellation{Reason: model.CustomerRequest}},
+			wantErr: apperr.ErrPermissionDenied,
+		}, {
+			name:    "override without manager",
+			actor:   staff,
+			give:    model.CancelRequest{OrderIDs: []uint64{2}, Cancellation: model.Cancellation{Reason: model.KitchenError}, Override: true},
+			wantErr: apperr.ErrPermissionDenied,
+		}, {
+			name:    "ready order",
+			actor:   staff,
+			give:    model.CancelRequest{OrderIDs: []uint64{2}, Cancellation: model.Cancellation{Reason: model.KitchenError}},
+			wantErr: ErrInvalidTransition,
+		}, {
+			name:  "ready order with override",
+			actor: manager,
+			give:  model.CancelRequest{OrderIDs: []uint64{2}, Cancellation: model.Cancellation{Reason: model.KitchenError}, Override: true},
+			want:  model.CancelResult{OrderIDs: []uint64{2}}, wantNotified: []uint64{21},
+		}, {
+			name:    "delivery order",
+			actor:   staff,
+			give:    model.CancelRequest{OrderIDs: []uint64{3}, Cancellation: model.Cancellation{Reason: model.PaymentIssue}},
+			wantErr: ErrInvalidTransition,
+		}, {
+			name:  "delivery order with override",
+			actor: manager,
+			give:  model.CancelRequest{OrderIDs: []uint64{3}, Cancellation: model.Cancellation{Reason: model.PaymentIssue}, Override: true},
+			want:  model.CancelResult{OrderIDs: []uint64{3}}, wantNotified: []uint64{31},
+		}, {
+			name:    "closed order with override",
+			actor:   manager,
+			give:    model.CancelRequest{OrderIDs: []uint64{4}, Cancellation: model.Cancellation{Reason: model.OtherReason}, Override: true},
+			wantErr: ErrInvalidTransition,
+		}, {
+			name:  "product",
+			actor: staff,
+			give:  model.CancelRequest{ProductIDs: []uint64{11}, Cancellation: model.Cancellation{Reason: model.OutOfStock}},
+			want:  model.CancelResult{ProductIDs: []uint64{11}}, wantNotified: []uint64{11},
+		}, {
+			name:    "ready product",
+			actor:   staff,
+			give:    model.CancelRequest{ProductIDs: []uint64{11, 12}, Cancellation: model.Cancellation{Reason: model.OutOfStock}},
+			wantErr: apperr.ErrFailedPrecondition,
+		}, {
+			name:  "ready product with override",
+			actor: manager,
+			give:  model.CancelRequest{ProductIDs: []uint64{11, 12}, Cancellation: model.Cancellation{Reason: model.OutOfStock}, Override: true},
+			want:  model.CancelResult{ProductIDs: []uint64{11, 12}}, wantNotified: []uint64{11, 12},
+		}, {
+			name:    "cancelled product",
+			actor:   staff,
+			give:    model.CancelRequest{ProductIDs: []uint64{13}, Cancellation: model.Cancellation{Reason: model.OutOfStock}},
+			wantErr: apperr.ErrFailedPrecondition,
+		}, {
+			name:  "product of a delivery order with override",
+			actor: manager,
+			give:  model.CancelRequest{ProductIDs: []uint64{31}, Cancellation: model.Cancellation{Reason: model.OutOfStock}, Override: true},
+			want:  model.CancelResult{ProductIDs: []uint64{31}}, wantNotified: []uint64{31},
+		}, {
+			name:    "product of an order with a payment",
+			actor:   manager,
+			give:    model.CancelRequest{ProductIDs: []uint64{51}, Cancellation: model.Cancellation{Reason: model.OutOfStock}, Override: true},
+			wantErr: apperr.ErrFailedPrecondition,
+		}, {
+			name:    "unknown product",
+			actor:   staff,
+			give:    model.CancelRequest{ProductIDs: []uint64{99}, Cancellation: model.Cancellation{Reason: model.OutOfStock}},
+			wantErr: apperr.ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{orders: orders}
+			kn := &fakeNotifier{}
+			oss := NewOrderStatusService(ost, paypalGateways(&fakeGateway{}), fakeTx{os: &fakeOrderStorage{products: products}, ost: ost}, kn)
+			got, err := oss.Cancel(model.ContextWithActor(context.Background(), tt.actor), tt.give)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderStatusService.Cancel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantNotified, kn.ids)
+			if err == nil {
+				assert.Equal(t, tt.want.OrderIDs, ost.cancelled)
+				assert.Equal(t, tt.want.ProductIDs, ost.voided)
+			}
+		})
+	}
+}
+
+func TestOrderStatusService_Refund(t *testing.T) {
+	capID, declined, key := "CAP-2", "CAP-DECLINED", "KEY-5"
+	pending := map[uint64]model.Refund{
+		5: {ID: 9, OrderID: 5, Amount: model.NewMoney(2000, model.MXN), Reason: "late delivery", RequestID: &key, Status: model.RefundPending, EmployeeID: 2},
+	}
+	orders := map[uint64]model.Order{
+		1: {Model: model.Model{ID: 1}, StatusID: model.Closed, Captured: model.NewMoney(10000, model.MXN), Refunded: model.NewMoney(0, model.MXN)},
+		2: {Model: model.Model{ID: 2}, StatusID: model.Paid, CaptureID: &capID, Captured: model.NewMoney(5000, model.MXN), Refunded: model.NewMoney(0, model.MXN)},
+		3: {Model: model.Model{ID: 3}, StatusID: model.PartiallyRefunded, Captured: model.NewMoney(10000, model.MXN), Refunded: model.NewMoney(6000, model.MXN)},
+		4: {Model: model.Model{ID: 4}, StatusID: model.AwaitingPayment},
+		5: {Model: model.Model{ID: 5}, StatusID: model.Paid, CaptureID: &capID, Captured: model.NewMoney(5000, model.MXN), Refunded: model.NewMoney(0, model.MXN)},
+		6: {Model: model.Model{ID: 6}, StatusID: model.Paid, CaptureID: &declined, Captured: model.NewMoney(5000, model.MXN), Refunded: model.NewMoney(0, model.MXN)},
+		7: {Model: model.Model{ID: 7}, StatusID: model.Refunded, Captured: model.NewMoney(10000, model.MXN), Refunded: model.NewMoney(10000, model.MXN)},
+		8: {Model: model.Model{ID: 8}, StatusID: model.Refunded, CaptureID: &capID, Captured: model.NewMoney(5000, model.MXN), Refunded: model.NewMoney(5000, model.MXN)},
+	}
+	products := []model.KitchenProduct{
+		{OrderProduct: model.OrderProduct{ID: 11, OrderID: 1}},
+		{OrderProduct: model.OrderProduct{ID: 12, OrderID: 1, IsCancelled: true}},
+		{OrderProduct: model.OrderProduct{ID: 21, OrderID: 2}},
+		{OrderProduct: model.OrderProduct{ID: 31, OrderID: 3}},
+	}
+	manager := model.Actor{EmployeeID: 2, Role: model.RoleManager}
+	tests := []struct {
+		name         string
+		actor        model.Actor
+		give         model.RefundRequest
+		wantErr      error
+		wantAmount   model.Money
+		wantStatus   model.Status
+		wantProvider []string
+		wantKey      string
+		wantFailed   []uint64
+		wantRemoved  []uint64
+	}{
+		{
+			name:       "everything in cash",
+			actor:      manager,
+			give:       model.RefundRequest{OrderID: 1, Reason: "cold food"},
+			wantAmount: model.NewMoney(10000, model.MXN), wantStatus: model.Refunded, wantRemoved: []uint64{11},
+		}, {
+			name:       "part in cash",
+			actor:      manager,
+			give:       model.RefundRequest{OrderID: 1, Amount: model.NewMoney(3000, model.MXN), Reason: "cold food"},
+			wantAmount: model.NewMoney(3000, model.MXN), wantStatus: model.PartiallyRefunded, wantRemoved: []uint64{11},
+		}, {
+			name:       "paypal",
+			actor:      manager,
+			give:       model.RefundRequest{OrderID: 2, Reason: "late delivery"},
+			wantAmount: model.NewMoney(5000, model.MXN), wantStatus: model.Refunded, wantProvider: []string{"CAP-2"}, wantRemoved: []uint64{21},
+		}, {
+			name:       "pending refund",
+			actor:      manager,
+			give:       model.RefundRequest{OrderID: 5, Amount: model.NewMoney(2000, model.MXN), Reason: "late delivery"},
+			wantAmount: model.NewMoney(2000, model.MXN), wantStatus: model.PartiallyRefunded, wantProvider: []string{"CAP-2"}, wantKey: key,
+		}, {
+			name:    "another amount while pending",
+			actor:   manager,
+			give:    model.RefundRequest{OrderID: 5, Amount: model.NewMoney(1000, model.MXN), Reason: "late delivery"},
+			wantErr: apperr.ErrConflict,
+		}, {
+			name:         "declined",
+			actor:        manager,
+			give:         model.RefundRequest{OrderID: 6, Reason: "late delivery"},
+			wantErr:      apperr.ErrPaymentDeclined,
+			wantProvider: []string{"CAP-DECLINED"}, wantFailed: []uint64{1},
+		}, {
+			name:       "rest of partial refund",
+			actor:      manager,
+			give:       model.RefundRequest{OrderID: 3, Amount: model.NewMoney(4000, model.MXN), Reason: "cold food"},
+			wantAmount: model.NewMoney(4000, model.MXN), wantStatus: model.Refunded,
+		}, {
+			name:    "more than captured",
+			actor:   manager,
+			give:    model.RefundRequest{OrderID: 3, Amount: model.NewMoney(4001, model.MXN), Reason: "cold food"},
+			wantErr: apperr.ErrInvalidArgument,
+		}, {
+			name:    "another currency",
+			actor:   manager,
+			give:    model.RefundRequest{OrderID: 1, Amount: model.NewMoney(100, "USD"), Reason: "cold food"},
+			wantErr: apperr.ErrInvalidArgument,
+		}, {
+			name:    "refunded order in cash",
+			actor:   manager,
+			give:    model.RefundRequest{OrderID: 7, Reason: "cold food"},
+			wantErr: apperr.ErrFailedPrecondition,
+		}, {
+			name:    "refunded order in paypal",
+			actor:   manager,
+			give:    model.RefundRequest{OrderID: 8, Reason: "late delivery"},
+			wantErr: apperr.ErrFailedPrecondition,
+		}, {
+			name:    "unpaid order",
+			actor:   manager,
+			give:    model.RefundRequest{OrderID: 4, Reason: "cold food"},
+			wantErr: ErrInvalidTransition,
+		}, {
+			name:    "negative amount",
+			actor:   manager,
+			give:    model.RefundRequest{OrderID: 1, Amount: model.NewMoney(-100, model.MXN), Reason: "cold food"},
+			wantErr: apperr.ErrInvalidArgument,
+		}, {
+			name:    "no reason",
+			actor:   manager,
+			give:    model.RefundRequest{OrderID: 1},
+			wantErr: apperr.ErrInvalidArgument,
+		}, {
+			name:    "not a manager",
+			actor:   model.Actor{EmployeeID: 1},
+			give:    model.RefundRequest{OrderID: 1, Reason: "cold food"},
+			wantErr: apperr.ErrPermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{orders: orders, pending: pending}
+			ps := &fakeGateway{}
+			kn := &fakeNotifier{}
+			oss := NewOrderStatusService(ost, paypalGateways(ps), fakeTx{os: &fakeOrderStorage{products: products}, ost: ost}, kn)
+			got, err := oss.Refund(model.ContextWithActor(context.Background(), tt.actor), tt.give)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderStatusService.Refund() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantProvider, ps.refunded)
+			assert.Equal(t, tt.wantFailed, ost.failed)
+			// the products leave the kitchen once the order is refunded.
+			assert.Equal(t, tt.wantRemoved, kn.ids)
+			if tt.wantProvider != nil {
+				// the provider gets the key of the refund stored as pending.
+				if tt.wantKey == "" {
+					tt.wantKey = *ost.added[0].RequestID
+				}
+				assert.Equal(t, []string{tt.wantKey}, ps.refundKeys)
+			}
+			if err != nil {
+				assert.Empty(t, ost.refunded)
+				return
+			}
+			assert.Equal(t, tt.wantAmount, got.Amount)
+			assert.Equal(t, tt.give.OrderID, got.OrderID)
+			assert.Equal(t, tt.actor.EmployeeID, got.EmployeeID)
+			assert.Equal(t, []model.Status{tt.wantStatus}, ost.refunded)
+		})
+	}
+}
+
+func TestOrderStatusService_PaymentEvent(t *testing.T) {
+	capID := "CAP-2"
+	orders := map[uint64]model.Order{
+		2: {Model: model.Model{ID: 2}, StatusID: model.Paid, CaptureID: &capID, Captured: model.NewMoney(5000, model.MXN), Refunded: model.NewMoney(0, model.MXN)},
+	}
+	products := []model.KitchenProduct{
+		{OrderProduct: model.OrderProduct{ID: 1}},
+		{OrderProduct: model.OrderProduct{ID: 21, OrderID: 2}},
+	}
+	tests := []struct {
+		name         string
+		status       model.Status
+		processed    bool
+		providers    []string
+		give         model.PaymentEvent
+		wantErr      error
+		wantCharge   int
+		wantPaid     model.Status
+		wantRefunded []model.Status
+		wantNotified []uint64
+		wantDenied   []string
+	}{
+		{
+			name:       "approved",
+			status:     model.AwaitingPayment,
+			give:       model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventApproved, PayID: "PAY-1"},
+			wantCharge: 1, wantPaid: model.Paid, wantNotified: []uint64{1},
+		}, {
+			name:   "approved and captured by the customer",
+			status: model.Paid,
+			give:   model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventApproved, PayID: "PAY-1"},
+		}, {
+			name:     "captured",
+			status:   model.AwaitingPayment,
+			give:     model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventCaptured, PayID: "PAY-1", CaptureID: "CAP-1", Amount: model.NewMoney(5000, model.MXN)},
+			wantPaid: model.Paid, wantNotified: []uint64{1},
+		}, {
+			name:   "captured twice",
+			status: model.Paid,
+			give:   model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventCaptured, PayID: "PAY-1", CaptureID: "CAP-1", Amount: model.NewMoney(5000, model.MXN)},
+		}, {
+			name:   "captured another amount",
+			status: model.AwaitingPayment,
+			give:   model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventCaptured, PayID: "PAY-1", CaptureID: "CAP-1", Amount: model.NewMoney(4000, model.MXN)},
+		}, {
+			name:       "denied",
+			status:     model.AwaitingPayment,
+			give:       model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventDenied, PayID: "PAY-1", CaptureID: "CAP-1"},
+			wantDenied: []string{"PAY-1"},
+		}, {
+			name:   "denied after paid",
+			status: model.Paid,
+			give:   model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventDenied, PayID: "PAY-1", CaptureID: "CAP-1"},
+		}, {
+			name:         "refunded in the gateway",
+			give:         model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventRefunded, CaptureID: "CAP-2", RefundID: "REF-1", Amount: model.NewMoney(2000, model.MXN)},
+			wantRefunded: []model.Status{model.PartiallyRefunded}, wantNotified: []uint64{21},
+		}, {
+			name:      "refunded by a manager",
+			providers: []string{"REF-1"},
+			give:      model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventRefunded, CaptureID: "CAP-2", RefundID: "REF-1", Amount: model.NewMoney(5000, model.MXN)},
+		}, {
+			name: "refunded more than captured",
+			give: model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventRefunded, CaptureID: "CAP-2", RefundID: "REF-1", Amount: model.NewMoney(5001, model.MXN)},
+		}, {
+			name:      "already processed",
+			status:    model.AwaitingPayment,
+			processed: true,
+			give:      model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventCaptured, PayID: "PAY-1", CaptureID: "CAP-1", Amount: model.NewMoney(5000, model.MXN)},
+		}, {
+			name: "unknown payment",
+			give: model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventApproved, PayID: "PAY-9"},
+		}, {
+			name:    "without id",
+			status:  model.AwaitingPayment,
+			give:    model.PaymentEvent{Type: model.PaymentEventApproved, PayID: "PAY-1"},
+			wantErr: apperr.ErrInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ost := &fakeStatusStorage{status: tt.status, orders: orders, providers: tt.providers}
+			if tt.processed {
+				ost.events = map[string]bool{tt.give.ID: true}
+			}
+			ps := &fakeGateway{}
+			kn := &fakeNotifier{}
+			oss := NewOrderStatusService(ost, paypalGateways(ps), fakeTx{os: &fakeOrderStorage{products: products}, ost: ost}, kn)
+			err := oss.PaymentEvent(context.Background(), tt.give)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OrderStatusService.PaymentEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantCharge, ps.captured)
+			assert.Equal(t, tt.wantPaid, ost.paidTo)
+			assert.Equal(t, tt.wantRefunded, ost.refunded)
+			assert.Equal(t, tt.wantNotified, kn.ids)
+			assert.Equal(t, tt.wantDenied, ost.denied)
+		})
+	}
+}
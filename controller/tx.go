@@ -0,0 +1,9 @@
+package controller
+
+import "context"
+
+// Transactor runs fn inside a single transaction, the storages given to fn
+// are bound to it and every change is rolled back when fn returns an error.
+type Transactor interface {
+	WithTx(c context.Context, fn func(OrderStorager, OrderStatusStorager) error) error
+}
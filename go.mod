@@ -5,9 +5,12 @@ go 1.16
 require (
 	github.com/grpc-ecosystem/go-grpc-middleware v1.3.0
 	github.com/modular-project/protobuffers v0.0.0-20221015023521-5179e26c51fd
+	github.com/nats-io/nats.go v1.11.0
 	github.com/plutov/paypal/v4 v4.6.2
 	github.com/stretchr/testify v1.8.0
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.46.2
+	google.golang.org/protobuf v1.28.0
 	gorm.io/driver/postgres v1.3.7
 	gorm.io/gorm v1.23.5
 )
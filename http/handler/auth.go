@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Authorization is the metadata key of the bearer token of a request.
+const Authorization = "authorization"
+
+var (
+	ErrMissingToken = apperr.New(apperr.ErrUnauthenticated, "missing bearer token")
+	ErrForbidden    = apperr.New(apperr.ErrPermissionDenied, "role not allowed")
+)
+
+type Authenticator interface {
+	Authenticate(token string) (model.Actor, error)
+}
+
+// Scoper returns who owns the orders and products addressed by a request.
+type Scoper interface {
+	Owners(c context.Context, ids []uint64) ([]model.Order, error)
+	PaymentOwners(c context.Context, pIDs []string) ([]model.Order, error)
+	ProductOwners(c context.Context, ids []uint64) ([]model.KitchenProduct, error)
+}
+
+// policy are the roles allowed to call an RPC and the check of the IDs sent
+// in its request, scope fills the IDs of the actor left empty and fails when
+// the request addresses data out of the scope of the actor.
+type policy struct {
+	roles []string
+	scope func(c context.Context, sc Scoper, a model.Actor, req interface{}) error
+}
+
+var (
+	customers = []string{model.RoleCustomer, model.RoleAdmin}
+	waiters   = []string{model.RoleWaiter, model.RoleAdmin}
+	managers  = []string{model.RoleManager, model.RoleAdmin}
+	kitchen   = []string{model.RoleCook, model.RoleManager, model.RoleAdmin}
+	floor     = []string{model.RoleWaiter, model.RoleManager, model.RoleAdmin}
+	staff     = []string{model.RoleWaiter, model.RoleCook, model.RoleManager, model.RoleAdmin}
+	everyone  = []string{model.RoleCustomer, model.RoleWaiter, model.RoleCook, model.RoleManager, model.RoleAdmin}
+)
+
+func fullMethod(sd grpc.ServiceDesc, m string) string {
+	return "/" + sd.ServiceName + "/" + m
+}
+
+// policies are the policies of every RPC served, the RPCs of the service
+// without a policy are denied.
+var policies = map[string]policy{
+	fullMethod(pf.OrderService_ServiceDesc, "CreateLocalOrder"): {roles: floor, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		o := req.(*pf.Order)
+		if err := scopeEstablishment(a, "establishment_id", &o.EstablishmentId); err != nil {
+			return err
+		}
+		if lo := o.GetLocalOrder(); lo != nil && a.Role == model.RoleWaiter {
+			return scopeID("local_order.employee_id", &lo.EmployeeId, a.EmployeeID)
+		}
+		return nil
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "CreateDeliveryOrder"): {roles: customers, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		if ro := req.(*pf.Order).GetRemoteOrder(); ro != nil {
+			return scopeUser(a, "remote_order.user_id", &ro.UserId)
+		}
+		return nil
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrdersByUser"): {roles: customers, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		r := req.(*pf.OrdersByUserRequest)
+		if a.IsAdmin() {
+			return nil
+		}
+		if r.Search == nil {
+			r.Search = &pf.SearchOrders{}
+		}
+		return scopeIDs("search.users", &r.Search.Users, a.UserID)
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrdersByKitchen"): {roles: kitchen, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeEstablishment(a, "id", &req.(*pf.RequestKitchen).Id)
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrders"): {roles: managers, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeSearch(a, &req.(*pf.OrdersRequest).Search)
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrdersByEstablishment"): {roles: staff, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeSearch(a, &req.(*pf.OrdersRequest).Search)
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrderByWaiter"): {roles: waiters, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeEmployee(a, "id", &req.(*pf.ID).Id)
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrderPendingByWaiter"): {roles: waiters, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeEmployee(a, "id", &req.(*pf.ID).Id)
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrderByID"): {roles: everyone, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		r := req.(*pf.GetOrderByIDRequest)
+		if err := scopeUser(a, "user_id", &r.UserId); err != nil {
+			return err
+		}
+		return scopeOrders(c, sc, a, r.OrderId)
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "AddProductsToOrder"): {roles: floor, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeOrders(c, sc, a, req.(*pf.AddProductsToOrderRequest).Id)
+	}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetTips"): {roles: waiters, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeEmployee(a, "employee_id", &req.(*pf.GetTipsRequest).EmployeeId)
+	}},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "PayLocal"): {roles: floor, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		r := req.(*pf.PayLocalRequest)
+		// the payment is collected by the employee that calls.
+		if err := scopeEmployee(a, "employee_id", &r.EmployeeId); err != nil {
+			return err
+		}
+		return scopeOrders(c, sc, a, r.OrdeId)
+	}},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "PayDelivery"): {roles: customers, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		r := req.(*pf.PayDeliveryRequest)
+		if err := scopeUser(a, "user_id", &r.UserId); err != nil {
+			return err
+		}
+		return scopeOrders(c, sc, a, r.OrdeId)
+	}},
+	// the payment is approved by the payer in the gateway before its capture.
+	fullMethod(pf.OrderStatusService_ServiceDesc, "CapturePayment"): {roles: customers, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopePayments(c, sc, a, req.(*pf.CapturePaymentRequest).Id)
+	}},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "CompleteProduct"): {roles: kitchen, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeProducts(c, sc, a, req.(*pf.CompleteProductRequest).Id)
+	}},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "DeliverProducts"): {roles: floor, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeProducts(c, sc, a, req.(*pf.DeliverProductRequest).Id...)
+	}},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "CancelOrders"): {roles: customers, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		r := req.(*pf.CancelOrdersRequest)
+		if err := scopeUser(a, "user_id", &r.UserId); err != nil {
+			return err
+		}
+		return scopeOrders(c, sc, a, r.Ids...)
+	}},
+	fullMethod(CancellationService_ServiceDesc, "Cancel"): {roles: staff, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		r := modelCancelRequest(req.(*dynamicpb.Message))
+		if err := scopeOrders(c, sc, a, r.OrderIDs...); err != nil {
+			return err
+		}
+		return scopeProducts(c, sc, a, r.ProductIDs...)
+	}},
+	fullMethod(OrderHistoryService_ServiceDesc, "GetOrderHistory"): {roles: managers, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeOrders(c, sc, a, req.(*pf.GetOrderByIDRequest).OrderId)
+	}},
+	fullMethod(RefundService_ServiceDesc, "Refund"): {roles: managers, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		m := req.(*dynamicpb.Message)
+		return scopeOrders(c, sc, a, m.Get(m.Descriptor().Fields().ByName("order_id")).Uint())
+	}},
+	fullMethod(KitchenService_ServiceDesc, "WatchKitchen"): {roles: kitchen, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeEstablishment(a, "id", &req.(*pf.RequestKitchen).Id)
+	}},
+	fullMethod(WaiterService_ServiceDesc, "WatchWaiter"): {roles: waiters, scope: func(c context.Context, sc Scoper, a model.Actor, req interface{}) error {
+		return scopeEmployee(a, "id", &req.(*pf.RequestKitchen).Id)
+	}},
+}
+
+func outOfScope(field string) error {
+	return apperr.New(apperr.ErrPermissionDenied, fmt.Sprintf("%s: out of the scope of the caller", field))
+}
+
+// scopeID sets id to want when it is empty and fails when it is another.
+func scopeID(field string, id *uint64, want uint64) error {
+	if *id == 0 {
+		*id = want
+	}
+	if *id != want {
+		return outOfScope(field)
+	}
+	return nil
+}
+
+// scopeIDs sets ids to want when they are empty and fails when one is another.
+func scopeIDs(field string, ids *[]uint64, want uint64) error {
+	for _, id := range *ids {
+		if id != want {
+			return outOfScope(field)
+		}
+	}
+	*ids = []uint64{want}
+	return nil
+}
+
+func scopeUser(a model.Actor, field string, id *uint64) error {
+	if a.IsAdmin() {
+		return nil
+	}
+	return scopeID(field, id, a.UserID)
+}
+
+func scopeEmployee(a model.Actor, field string, id *uint64) error {
+	if a.IsAdmin() {
+		return nil
+	}
+	return scopeID(field, id, a.EmployeeID)
+}
+
+func scopeEstablishment(a model.Actor, field string, id *uint64) error {
+	if a.IsAdmin() {
+		return nil
+	}
+	return scopeID(field, id, a.EstablishmentID)
+}
+
+func scopeSearch(a model.Actor, s **pf.SearchOrders) error {
+	if a.IsAdmin() {
+		return nil
+	}
+	if *s == nil {
+		*s = &pf.SearchOrders{}
+	}
+	return scopeIDs("search.establishments", &(*s).Establishments, a.EstablishmentID)
+}
+
+// scopeOrders fails when an order of ids is out of the scope of the actor,
+// the missing orders are left to the handlers.
+func scopeOrders(c context.Context, sc Scoper, a model.Actor, ids ...uint64) error {
+	if a.IsAdmin() || len(ids) == 0 {
+		return nil
+	}
+	os, err := sc.Owners(c, ids)
+	if err != nil {
+		return fmt.Errorf("sc.Owners: %w", err)
+	}
+	for _, o := range os {
+		if !a.InScope(o.UserID, o.EstablishmentID) {
+			return apperr.New(apperr.ErrPermissionDenied, fmt.Sprintf("order %d: out of the scope of the caller", o.ID))
+		}
+	}
+	return nil
+}
+
+// scopePayments is like scopeOrders but finds the orders by their payment IDs.
+func scopePayments(c context.Context, sc Scoper, a model.Actor, pIDs ...string) error {
+	if a.IsAdmin() || len(pIDs) == 0 {
+		return nil
+	}
+	os, err := sc.PaymentOwners(c, pIDs)
+	if err != nil {
+		return fmt.Errorf("sc.PaymentOwners: %w", err)
+	}
+	for _, o := range os {
+		if !a.InScope(o.UserID, o.EstablishmentID) {
+			return apperr.New(apperr.ErrPermissionDenied, fmt.Sprintf("order %d: out of the scope of the caller", o.ID))
+		}
+	}
+	return nil
+}
+
+// scopeProducts fails when a product of ids belongs to an order out of the
+// establishment of the actor.
+func scopeProducts(c context.Context, sc Scoper, a model.Actor, ids ...uint64) error {
+	if a.IsAdmin() || len(ids) == 0 {
+		return nil
+	}
+	kps, err := sc.ProductOwners(c, ids)
+	if err != nil {
+		return fmt.Errorf("sc.ProductOwners: %w", err)
+	}
+	for _, kp := range kps {
+		if a.EstablishmentID == 0 || kp.EstablishmentID != a.EstablishmentID {
+			return apperr.New(apperr.ErrPermissionDenied, fmt.Sprintf("order product %d: out of the scope of the caller", kp.ID))
+		}
+	}
+	return nil
+}
+
+// authorize checks that the actor of c can call method with req, the methods
+// of other services like health and reflection are public.
+func authorize(c context.Context, au Authenticator, sc Scoper, method string, req interface{}) (model.Actor, bool, error) {
+	p, ok := policies[method]
+	if !ok {
+		if strings.HasPrefix(method, "/"+protoPackage+".") {
+			return model.Actor{}, false, apperr.New(apperr.ErrPermissionDenied, "method without policy")
+		}
+		return model.Actor{}, false, nil
+	}
+	token, err := bearerToken(c)
+	if err != nil {
+		return model.Actor{}, false, err
+	}
+	a, err := au.Authenticate(token)
+	if err != nil {
+		return model.Actor{}, false, fmt.Errorf("au.Authenticate: %w", err)
+	}
+	if !hasRole(p.roles, a.Role) {
+		return model.Actor{}, false, ErrForbidden
+	}
+	if p.scope != nil && req != nil {
+		if err := p.scope(c, sc, a, req); err != nil {
+			return model.Actor{}, false, err
+		}
+	}
+	return a, true, nil
+}
+
+func bearerToken(c context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(c)
+	vs := md.Get(Authorization)
+	if len(vs) == 0 {
+		return "", ErrMissingToken
+	}
+	const prefix = "bearer "
+	if len(vs[0]) <= len(prefix) || !strings.EqualFold(vs[0][:len(prefix)], prefix) {
+		return "", ErrMissingToken
+	}
+	return vs[0][len(prefix):], nil
+}
+
+func hasRole(roles []string, r string) bool {
+	for i := range roles {
+		if roles[i] == r {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthUnaryInterceptor authenticates the bearer token of the requests, checks
+// the policy of the RPC and stores the actor in the context.
+func AuthUnaryInterceptor(au Authenticator, sc Scoper) grpc.UnaryServerInterceptor {
+	return func(c context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+		a, ok, err := authorize(c, au, sc, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c = model.ContextWithActor(c, a)
+		}
+		return h(c, req)
+	}
+}
+
+// authStream checks the scope of the first message received, the stream
+// RPCs only receive their request.
+type authStream struct {
+	grpc.ServerStream
+	c      context.Context
+	sc     Scoper
+	a      model.Actor
+	p      policy
+	scoped bool
+}
+
+func (s *authStream) Context() context.Context {
+	return s.c
+}
+
+func (s *authStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.scoped || s.p.scope == nil {
+		return nil
+	}
+	s.scoped = true
+	return s.p.scope(s.c, s.sc, s.a, m)
+}
+
+// AuthStreamInterceptor is AuthUnaryInterceptor for the stream RPCs, the
+// scope is checked when the request is received.
+func AuthStreamInterceptor(au Authenticator, sc Scoper) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+		a, ok, err := authorize(ss.Context(), au, sc, info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return h(srv, ss)
+		}
+		return h(srv, &authStream{
+			ServerStream: ss, c: model.ContextWithActor(ss.Context(), a), sc: sc, a: a, p: policies[info.FullMethod],
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	pf "github.com/modular-project/protobuffers/order/order"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeAuthenticator map[string]model.Actor
+
+func (f fakeAuthenticator) Authenticate(token string) (model.Actor, error) {
+	a, ok := f[token]
+	if !ok {
+		return model.Actor{}, apperr.New(apperr.ErrUnauthenticated, "invalid token")
+	}
+	return a, nil
+}
+
+// fakeScoper has the orders 1 of the user 7 paid with PAY-1, 2 of the
+// establishment 3 paid with PAY-2 and 3 a delivery order of the user 8 not
+// assigned to an establishment, the product 10 is of the order 2.
+type fakeScoper struct{}
+
+func (fakeScoper) Owners(c context.Context, ids []uint64) ([]model.Order, error) {
+	var os []model.Order
+	for _, id := range ids {
+		switch id {
+		case 1:
+			os = append(os, model.Order{Model: model.Model{ID: 1}, UserID: 7})
+		case 2:
+			os = append(os, model.Order{Model: model.Model{ID: 2}, EmployeeID: 2, EstablishmentID: 3})
+		case 3:
+			os = append(os, model.Order{Model: model.Model{ID: 3}, UserID: 8})
+		}
+	}
+	return os, nil
+}
+
+func (fakeScoper) PaymentOwners(c context.Context, pIDs []string) ([]model.Order, error) {
+	var os []model.Order
+	for _, pID := range pIDs {
+		switch pID {
+		case "PAY-1":
+			os = append(os, model.Order{Model: model.Model{ID: 1}, UserID: 7})
+		case "PAY-2":
+			os = append(os, model.Order{Model: model.Model{ID: 2}, EmployeeID: 2, EstablishmentID: 3})
+		}
+	}
+	return os, nil
+}
+
+func (fakeScoper) ProductOwners(c context.Context, ids []uint64) ([]model.KitchenProduct, error) {
+	var kps []model.KitchenProduct
+	for _, id := range ids {
+		if id == 10 {
+			kps = append(kps, model.KitchenProduct{OrderProduct: model.OrderProduct{ID: 10, OrderID: 2}, EstablishmentID: 3})
+		}
+	}
+	return kps, nil
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	au := fakeAuthenticator{
+		"customer": {UserID: 7, Role: model.RoleCustomer},
+		"waiter":   {EmployeeID: 2, Role: model.RoleWaiter, EstablishmentID: 3},
+		"cook":     {EmployeeID: 4, Role: model.RoleCook, EstablishmentID: 3},
+		"manager":  {EmployeeID: 5, Role: model.RoleManager, EstablishmentID: 4},
+		"admin":    {EmployeeID: 1, Role: model.RoleAdmin},
+	}
+	order := func(m string) string { return fullMethod(pf.OrderService_ServiceDesc, m) }
+	status := func(m string) string { return fullMethod(pf.OrderStatusService_ServiceDesc, m) }
+	tests := []struct {
+		name    string
+		token   string
+		method  string
+		req     proto.Message
+		want    proto.Message
+		wantErr error
+	}{
+		{name: "without token", method: order("GetOrders"), req: &pf.OrdersRequest{}, wantErr: apperr.ErrUnauthenticated},
+		{name: "invalid token", token: "other", method: order("GetOrders"), req: &pf.OrdersRequest{}, wantErr: apperr.ErrUnauthenticated},
+		{name: "role not allowed", token: "customer", method: order("GetOrders"), req: &pf.OrdersRequest{}, wantErr: ErrForbidden},
+		{name: "method without policy", token: "admin", method: order("DeleteOrders"), req: &pf.OrdersRequest{}, wantErr: apperr.ErrPermissionDenied},
+		{name: "public method", method: "/grpc.health.v1.Health/Check", req: &pf.ID{}, want: &pf.ID{}},
+		{
+			name: "own orders", token: "customer", method: order("GetOrdersByUser"),
+			req:  &pf.OrdersByUserRequest{},
+			want: &pf.OrdersByUserRequest{Search: &pf.SearchOrders{Users: []uint64{7}}},
+		},
+		{
+			name: "orders of another user", token: "customer", method: order("GetOrdersByUser"),
+			req: &pf.OrdersByUserRequest{Search: &pf.SearchOrders{Users: []uint64{8}}}, wantErr: apperr.ErrPermissionDenied,
+		},
+		{
+			name: "orders of any user", token: "admin", method: order("GetOrdersByUser"),
+			req:  &pf.OrdersByUserRequest{Search: &pf.SearchOrders{Users: []uint64{8}}},
+			want: &pf.OrdersByUserRequest{Search: &pf.SearchOrders{Users: []uint64{8}}},
+		},
+		{name: "own kitchen", token: "cook", method: order("GetOrdersByKitchen"), req: &pf.RequestKitchen{}, want: &pf.RequestKitchen{Id: 3}},
+		{name: "another kitchen", token: "cook", method: order("GetOrdersByKitchen"), req: &pf.RequestKitchen{Id: 4}, wantErr: apperr.ErrPermissionDenied},
+		{
+			name: "orders of the establishment", token: "manager", method: order("GetOrders"),
+			req:  &pf.OrdersRequest{Search: &pf.SearchOrders{Users: []uint64{8}}},
+			want: &pf.OrdersRequest{Search: &pf.SearchOrders{Users: []uint64{8}, Establishments: []uint64{4}}},
+		},
+		{
+			name: "orders of every establishment", token: "manager", method: order("GetOrders"),
+			req: &pf.OrdersRequest{Search: &pf.SearchOrders{Establishments: []uint64{4, 5}}}, wantErr: apperr.ErrPermissionDenied,
+		},
+		{
+			name: "unassigned delivery order", token: "manager", method: fullMethod(OrderHistoryService_ServiceDesc, "GetOrderHistory"),
+			req: &pf.GetOrderByIDRequest{OrderId: 3}, want: &pf.GetOrderByIDRequest{OrderId: 3},
+		},
+		{name: "unassigned delivery order of staff", token: "waiter", method: order("GetOrderByID"), req: &pf.GetOrderByIDRequest{OrderId: 3}, wantErr: apperr.ErrPermissionDenied},
+		{name: "unassigned delivery order of another user", token: "customer", method: order("GetOrderByID"), req: &pf.GetOrderByIDRequest{OrderId: 3}, wantErr: apperr.ErrPermissionDenied},
+		{
+			name: "pay own order", token: "waiter", method: status("PayLocal"),
+			req: &pf.PayLocalRequest{OrdeId: 2}, want: &pf.PayLocalRequest{OrdeId: 2, EmployeeId: 2},
+		},
+		{name: "pay for another employee", token: "waiter", method: status("PayLocal"), req: &pf.PayLocalRequest{OrdeId: 2, EmployeeId: 9}, wantErr: apperr.ErrPermissionDenied},
+		{name: "pay order of another establishment", token: "manager", method: status("PayLocal"), req: &pf.PayLocalRequest{OrdeId: 2}, wantErr: apperr.ErrPermissionDenied},
+		{name: "cancel own orders", token: "customer", method: status("CancelOrders"), req: &pf.CancelOrdersRequest{Ids: []uint64{1}}, want: &pf.CancelOrdersRequest{Ids: []uint64{1}, UserId: 7}},
+		{name: "cancel for another user", token: "customer", method: status("CancelOrders"), req: &pf.CancelOrdersRequest{Ids: []uint64{1}, UserId: 8}, wantErr: apperr.ErrPermissionDenied},
+		{name: "cancel order of another user", token: "customer", method: status("CancelOrders"), req: &pf.CancelOrdersRequest{Ids: []uint64{2}}, wantErr: apperr.ErrPermissionDenied},
+		{name: "capture own payment", token: "customer", method: status("CapturePayment"), req: &pf.CapturePaymentRequest{Id: "PAY-1"}, want: &pf.CapturePaymentRequest{Id: "PAY-1"}},
+		{name: "capture payment of another user", token: "customer", method: status("CapturePayment"), req: &pf.CapturePaymentRequest{Id: "PAY-2"}, wantErr: apperr.ErrPermissionDenied},
+		{name: "capture any payment", token: "admin", method: status("CapturePayment"), req: &pf.CapturePaymentRequest{Id: "PAY-2"}, want: &pf.CapturePaymentRequest{Id: "PAY-2"}},
+		{name: "complete product", token: "cook", method: status("CompleteProduct"), req: &pf.CompleteProductRequest{Id: 10}, want: &pf.CompleteProductRequest{Id: 10}},
+		{name: "complete product of another establishment", token: "manager", method: status("CompleteProduct"), req: &pf.CompleteProductRequest{Id: 10}, wantErr: apperr.ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := context.Background()
+			if tt.token != "" {
+				c = metadata.NewIncomingContext(c, metadata.Pairs(Authorization, "Bearer "+tt.token))
+			}
+			var got interface{}
+			var actor model.Actor
+			_, err := AuthUnaryInterceptor(au, fakeScoper{})(c, tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(c context.Context, req interface{}) (interface{}, error) {
+				got, actor = req, model.ActorFromContext(c)
+				return nil, nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthUnaryInterceptor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				assert.Nil(t, got, "handler called")
+				return
+			}
+			assert.True(t, proto.Equal(tt.want, got.(proto.Message)), "request = %v, want %v", got, tt.want)
+			assert.Equal(t, au[tt.token], actor)
+		})
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	c   context.Context
+	req proto.Message
+}
+
+func (f fakeServerStream) Context() context.Context {
+	return f.c
+}
+
+func (f fakeServerStream) RecvMsg(m interface{}) error {
+	proto.Merge(m.(proto.Message), f.req)
+	return nil
+}
+
+func TestAuthStreamInterceptor(t *testing.T) {
+	au := fakeAuthenticator{"cook": {EmployeeID: 4, Role: model.RoleCook, EstablishmentID: 3}}
+	info := &grpc.StreamServerInfo{FullMethod: fullMethod(KitchenService_ServiceDesc, "WatchKitchen")}
+	tests := []struct {
+		name    string
+		give    *pf.RequestKitchen
+		want    *pf.RequestKitchen
+		wantErr error
+	}{
+		{name: "own kitchen", give: &pf.RequestKitchen{Last: 5}, want: &pf.RequestKitchen{Id: 3, Last: 5}},
+		{name: "another kitchen", give: &pf.RequestKitchen{Id: 4}, wantErr: apperr.ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := metadata.NewIncomingContext(context.Background(), metadata.Pairs(Authorization, "Bearer cook"))
+			ss := fakeServerStream{c: c, req: tt.give}
+			got := &pf.RequestKitchen{}
+			err := AuthStreamInterceptor(au, fakeScoper{})(nil, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+				if err := ss.RecvMsg(got); err != nil {
+					return err
+				}
+				assert.Equal(t, au["cook"], model.ActorFromContext(ss.Context()))
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthStreamInterceptor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				assert.True(t, proto.Equal(tt.want, got), "request = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// cancelFile is proto/order/cancel.proto, built by RegisterFiles.
+var cancelFile protoreflect.FileDescriptor
+
+func init() {
+	addFile(&descriptorpb.FileDescriptorProto{
+		Name: proto.String("order/cancel.proto"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name:  proto.String("CancelReason"),
+			Value: cancelReasonValues(),
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("CancelRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoRepeated(protoField("order_ids", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT64, "")),
+				protoRepeated(protoField("order_product_ids", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT64, "")),
+				protoField("reason", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, protoType("CancelReason")),
+				protoField("note", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("override", 5, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""),
+			},
+		}, {
+			Name: proto.String("CancelResponse"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoRepeated(protoField("order_ids", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT64, "")),
+				protoRepeated(protoField("order_product_ids", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT64, "")),
+			},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			protoService("CancellationService", "Cancel", "CancelRequest", "CancelResponse", false),
+		},
+	}, func(fd protoreflect.FileDescriptor) { cancelFile = fd })
+}
+
+func cancelReasonValues() []*descriptorpb.EnumValueDescriptorProto {
+	vs := []*descriptorpb.EnumValueDescriptorProto{protoEnumValue("CANCEL_REASON_UNSPECIFIED", 0)}
+	for r := model.CustomerRequest; r.IsValid(); r++ {
+		vs = append(vs, protoEnumValue(r.String(), int32(r)))
+	}
+	return vs
+}
+
+func uint64s(l protoreflect.List) []uint64 {
+	ids := make([]uint64, l.Len())
+	for i := range ids {
+		ids[i] = l.Get(i).Uint()
+	}
+	return ids
+}
+
+func setUint64s(m *dynamicpb.Message, name protoreflect.Name, ids []uint64) {
+	l := m.Mutable(m.Descriptor().Fields().ByName(name)).List()
+	for _, id := range ids {
+		l.Append(protoreflect.ValueOfUint64(id))
+	}
+}
+
+func modelCancelRequest(m *dynamicpb.Message) model.CancelRequest {
+	fs := m.Descriptor().Fields()
+	return model.CancelRequest{
+		OrderIDs:   uint64s(m.Get(fs.ByName("order_ids")).List()),
+		ProductIDs: uint64s(m.Get(fs.ByName("order_product_ids")).List()),
+		Cancellation: model.Cancellation{
+			Reason: model.CancelReason(m.Get(fs.ByName("reason")).Enum()),
+			Note:   m.Get(fs.ByName("note")).String(),
+		},
+		Override: m.Get(fs.ByName("override")).Bool(),
+	}
+}
+
+func protoCancelResult(r model.CancelResult) *dynamicpb.Message {
+	m := dynamicpb.NewMessage(cancelFile.Messages().ByName("CancelResponse"))
+	setUint64s(m, "order_ids", r.OrderIDs)
+	setUint64s(m, "order_product_ids", r.ProductIDs)
+	return m
+}
+
+type CancellationServicer interface {
+	Cancel(c context.Context, r model.CancelRequest) (model.CancelResult, error)
+}
+
+type CancellationServiceServer interface {
+	Cancel(context.Context, *dynamicpb.Message) (*dynamicpb.Message, error)
+}
+
+var CancellationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: protoPackage + ".CancellationService",
+	HandlerType: (*CancellationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Cancel",
+		Handler: func(srv interface{}, c context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := dynamicpb.NewMessage(cancelFile.Messages().ByName("CancelRequest"))
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return srv.(CancellationServiceServer).Cancel(c, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + protoPackage + ".CancellationService/Cancel",
+			}
+			return interceptor(c, in, info, func(c context.Context, req interface{}) (interface{}, error) {
+				return srv.(CancellationServiceServer).Cancel(c, req.(*dynamicpb.Message))
+			})
+		},
+	}},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "order/cancel.proto",
+}
+
+type CancellationUC struct {
+	cs CancellationServicer
+}
+
+func NewCancellationUC(cs CancellationServicer) CancellationUC {
+	return CancellationUC{cs: cs}
+}
+
+func (cuc CancellationUC) Cancel(c context.Context, r *dynamicpb.Message) (*dynamicpb.Message, error) {
+	if r == nil {
+		return nil, apperr.New(apperr.ErrInvalidArgument, "nil request")
+	}
+	res, err := cuc.cs.Cancel(c, modelCancelRequest(r))
+	if err != nil {
+		return nil, fmt.Errorf("cs.Cancel: %w", err)
+	}
+	return protoCancelResult(res), nil
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+
+	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The RPCs missing in the protobuffers module are described at runtime with
+// these helpers from the files in proto/order, every file belongs to the
+// package of order/order.proto.
+var protoPackage = string(pf.File_order_order_proto.Package())
+
+// protoFile is a file described at runtime and the function that keeps its
+// descriptor once it is built.
+type protoFile struct {
+	fdp *descriptorpb.FileDescriptorProto
+	set func(protoreflect.FileDescriptor)
+}
+
+var (
+	files        []protoFile
+	registerOnce sync.Once
+	registerErr  error
+)
+
+// addFile adds the file fdp to the ones built by RegisterFiles, set receives
+// its descriptor.
+func addFile(fdp *descriptorpb.FileDescriptorProto, set func(protoreflect.FileDescriptor)) {
+	files = append(files, protoFile{fdp: fdp, set: set})
+}
+
+// RegisterFiles builds the files described at runtime and registers them, so
+// they are served by the reflection service. It must be called before the
+// RPCs of the files are served, the next calls return the first result.
+func RegisterFiles() error {
+	registerOnce.Do(func() {
+		for _, f := range files {
+			fd, err := registerFile(f.fdp)
+			if err != nil {
+				registerErr = err
+				return
+			}
+			f.set(fd)
+		}
+	})
+	return registerErr
+}
+
+func registerFile(fdp *descriptorpb.FileDescriptorProto) (protoreflect.FileDescriptor, error) {
+	// the registry panics on the conflicts instead of returning them.
+	if _, err := protoregistry.GlobalFiles.FindFileByPath(fdp.GetName()); err == nil {
+		return nil, fmt.Errorf("%s is already registered", fdp.GetName())
+	}
+	fdp.Package = proto.String(protoPackage)
+	fdp.Syntax = proto.String("proto3")
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fdp.GetName(), err)
+	}
+	for _, n := range declarations(fd) {
+		if _, err := protoregistry.GlobalFiles.FindDescriptorByName(n); err == nil {
+			return nil, fmt.Errorf("%s: %s is already registered", fdp.GetName(), n)
+		}
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		return nil, fmt.Errorf("register %s: %w", fdp.GetName(), err)
+	}
+	return fd, nil
+}
+
+// declarations returns the names of the top level declarations of fd.
+func declarations(fd protoreflect.FileDescriptor) []protoreflect.FullName {
+	var ns []protoreflect.FullName
+	for i := 0; i < fd.Messages().Len(); i++ {
+		ns = append(ns, fd.Messages().Get(i).FullName())
+	}
+	for i := 0; i < fd.Enums().Len(); i++ {
+		ns = append(ns, fd.Enums().Get(i).FullName())
+	}
+	for i := 0; i < fd.Services().Len(); i++ {
+		ns = append(ns, fd.Services().Get(i).FullName())
+	}
+	return ns
+}
+
+// protoType returns the full name of a type of the package.
+func protoType(name string) string {
+	return "." + protoPackage + "." + name
+}
+
+func protoField(name string, n int32, t descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(n),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     t.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+func protoRepeated(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return f
+}
+
+func protoEnumValue(name string, n int32) *descriptorpb.EnumValueDescriptorProto {
+	return &descriptorpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(n)}
+}
+
+func protoService(name, method, input, output string, stream bool) *descriptorpb.ServiceDescriptorProto {
+	return &descriptorpb.ServiceDescriptorProto{
+		Name: proto.String(name),
+		Method: []*descriptorpb.MethodDescriptorProto{{
+			Name:            proto.String(method),
+			InputType:       proto.String(protoType(input)),
+			OutputType:      proto.String(protoType(output)),
+			ServerStreaming: proto.Bool(stream),
+		}},
+	}
+}
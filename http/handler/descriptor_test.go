@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestMain(m *testing.M) {
+	if err := RegisterFiles(); err != nil {
+		log.Fatalf("RegisterFiles() error = %v", err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestRegisterFile(t *testing.T) {
+	tests := []struct {
+		name string
+		give *descriptorpb.FileDescriptorProto
+	}{
+		{
+			name: "unknown type",
+			give: &descriptorpb.FileDescriptorProto{
+				Name: proto.String("order/test_unknown.proto"),
+				MessageType: []*descriptorpb.DescriptorProto{{
+					Name:  proto.String("Unknown"),
+					Field: []*descriptorpb.FieldDescriptorProto{protoField("other", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, protoType("Other"))},
+				}},
+			},
+		},
+		{
+			name: "registered message",
+			give: &descriptorpb.FileDescriptorProto{
+				Name:        proto.String("order/test_conflict.proto"),
+				MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("KitchenEvent")}},
+			},
+		},
+		{name: "registered file", give: &descriptorpb.FileDescriptorProto{Name: proto.String("order/cancel.proto")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := registerFile(tt.give); err == nil {
+				t.Error("registerFile() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modular-project/orders-service/model"
+	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// historyFile is proto/order/history.proto, built by RegisterFiles.
+var historyFile protoreflect.FileDescriptor
+
+func init() {
+	addFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("order/history.proto"),
+		Dependency: []string{pf.File_order_order_proto.Path(), timestamppb.File_google_protobuf_timestamp_proto.Path()},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("AuditEntry"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("order_id", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("order_product_id", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("user_id", 4, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("employee_id", 5, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("action", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("old_value", 7, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("new_value", 8, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("created_at", 9, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+			},
+		}, {
+			Name: proto.String("OrderHistoryResponse"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoRepeated(protoField("entries", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, protoType("AuditEntry"))),
+			},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			protoService("OrderHistoryService", "GetOrderHistory", "GetOrderByIDRequest", "OrderHistoryResponse", false),
+		},
+	}, func(fd protoreflect.FileDescriptor) { historyFile = fd })
+}
+
+func protoHistory(as []model.OrderAudit) *dynamicpb.Message {
+	ed := historyFile.Messages().ByName("AuditEntry")
+	rd := historyFile.Messages().ByName("OrderHistoryResponse")
+	r := dynamicpb.NewMessage(rd)
+	l := r.Mutable(rd.Fields().ByName("entries")).List()
+	fs := ed.Fields()
+	for _, a := range as {
+		e := dynamicpb.NewMessage(ed)
+		e.Set(fs.ByName("id"), protoreflect.ValueOfUint64(a.ID))
+		e.Set(fs.ByName("order_id"), protoreflect.ValueOfUint64(a.OrderID))
+		e.Set(fs.ByName("order_product_id"), protoreflect.ValueOfUint64(a.OrderProductID))
+		e.Set(fs.ByName("user_id"), protoreflect.ValueOfUint64(a.UserID))
+		e.Set(fs.ByName("employee_id"), protoreflect.ValueOfUint64(a.EmployeeID))
+		e.Set(fs.ByName("action"), protoreflect.ValueOfString(a.Action))
+		e.Set(fs.ByName("old_value"), protoreflect.ValueOfString(a.OldValue))
+		e.Set(fs.ByName("new_value"), protoreflect.ValueOfString(a.NewValue))
+		e.Set(fs.ByName("created_at"), protoreflect.ValueOfMessage(timestamppb.New(a.CreatedAt).ProtoReflect()))
+		l.Append(protoreflect.ValueOfMessage(e))
+	}
+	return r
+}
+
+type HistoryServicer interface {
+	History(c context.Context, oID uint64) ([]model.OrderAudit, error)
+}
+
+type OrderHistoryServiceServer interface {
+	GetOrderHistory(context.Context, *pf.GetOrderByIDRequest) (*dynamicpb.Message, error)
+}
+
+var OrderHistoryService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: protoPackage + ".OrderHistoryService",
+	HandlerType: (*OrderHistoryServiceServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "GetOrderHistory",
+		Handler: func(srv interface{}, c context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &pf.GetOrderByIDRequest{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return srv.(OrderHistoryServiceServer).GetOrderHistory(c, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + protoPackage + ".OrderHistoryService/GetOrderHistory",
+			}
+			return interceptor(c, in, info, func(c context.Context, req interface{}) (interface{}, error) {
+				return srv.(OrderHistoryServiceServer).GetOrderHistory(c, req.(*pf.GetOrderByIDRequest))
+			})
+		},
+	}},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "order/history.proto",
+}
+
+type HistoryUC struct {
+	hs HistoryServicer
+}
+
+func NewHistoryUC(hs HistoryServicer) HistoryUC {
+	return HistoryUC{hs: hs}
+}
+
+func (huc HistoryUC) GetOrderHistory(c context.Context, r *pf.GetOrderByIDRequest) (*dynamicpb.Message, error) {
+	as, err := huc.hs.History(c, r.OrderId)
+	if err != nil {
+		return nil, fmt.Errorf("hs.History: %w", err)
+	}
+	return protoHistory(as), nil
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// IdempotencyKey is the metadata key of the idempotency key of a request.
+const IdempotencyKey = "idempotency-key"
+
+type Idempotenter interface {
+	Do(c context.Context, scope, key string, req []byte, fn func() ([]byte, error)) ([]byte, error)
+}
+
+// idempotentResponses are the RPCs that accept an idempotency key, with the
+// type of their response to decode the stored ones.
+var idempotentResponses = map[string]func() proto.Message{
+	"/" + pf.OrderService_ServiceDesc.ServiceName + "/CreateDeliveryOrder":  func() proto.Message { return &pf.CreateResponse{} },
+	"/" + pf.OrderStatusService_ServiceDesc.ServiceName + "/PayDelivery":    func() proto.Message { return &pf.PayDeliveryResponse{} },
+	"/" + pf.OrderStatusService_ServiceDesc.ServiceName + "/CapturePayment": func() proto.Message { return &pf.CapturePaymentResponse{} },
+}
+
+// IdempotencyUnaryInterceptor runs once the requests of the idempotent RPCs
+// sent with the same idempotency key and returns the first response to the
+// repeated ones, the requests without a key always run.
+func IdempotencyUnaryInterceptor(is Idempotenter) grpc.UnaryServerInterceptor {
+	return func(c context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+		newResp, ok := idempotentResponses[info.FullMethod]
+		if !ok {
+			return h(c, req)
+		}
+		md, _ := metadata.FromIncomingContext(c)
+		keys := md.Get(IdempotencyKey)
+		if len(keys) == 0 {
+			return h(c, req)
+		}
+		rm, ok := req.(proto.Message)
+		if !ok {
+			return h(c, req)
+		}
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(rm)
+		if err != nil {
+			return nil, fmt.Errorf("marshal request: %w", err)
+		}
+		var resp interface{}
+		rb, err := is.Do(c, info.FullMethod, keys[0], b, func() ([]byte, error) {
+			r, err := h(c, req)
+			if err != nil {
+				return nil, err
+			}
+			resp = r
+			return proto.Marshal(r.(proto.Message))
+		})
+		// the errors of the handler are returned as they are to keep their status.
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil {
+			return resp, nil
+		}
+		m := newResp()
+		if err := proto.Unmarshal(rb, m); err != nil {
+			return nil, fmt.Errorf("unmarshal response: %w", err)
+		}
+		return m, nil
+	}
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// kitchenEventDesc is the KitchenEvent message of proto/order/kitchen.proto.
+var kitchenEventDesc protoreflect.MessageDescriptor
+
+func init() {
+	addFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("order/kitchen.proto"),
+		Dependency: []string{pf.File_order_order_proto.Path()},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("KitchenEvent"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoField("cursor", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("kind", 2, descriptorpb.FieldDescriptorProto_TYPE_ENUM, protoType("KitchenEvent.Kind")),
+				protoField("order_id", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("product", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, protoType("OrderProduct")),
+				protoField("table_id", 5, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("name", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("unit_price", 7, descriptorpb.FieldDescriptorProto_TYPE_FLOAT, ""),
+				protoField("subtotal", 8, descriptorpb.FieldDescriptorProto_TYPE_FLOAT, ""),
+			},
+			EnumType: []*descriptorpb.EnumDescriptorProto{{
+				Name: proto.String("Kind"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					protoEnumValue("KIND_UNSPECIFIED", 0),
+					protoEnumValue("ADDED", int32(model.ProductAdded)),
+					protoEnumValue("UPDATED", int32(model.ProductUpdated)),
+					protoEnumValue("CANCELLED", int32(model.ProductCancelled)),
+				},
+			}},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			protoService("KitchenService", "WatchKitchen", "RequestKitchen", "KitchenEvent", true),
+			protoService("WaiterService", "WatchWaiter", "RequestKitchen", "KitchenEvent", true),
+		},
+	}, func(fd protoreflect.FileDescriptor) { kitchenEventDesc = fd.Messages().ByName("KitchenEvent") })
+}
+
+func protoKitchenEvent(e model.KitchenEvent) *dynamicpb.Message {
+	m := dynamicpb.NewMessage(kitchenEventDesc)
+	fs := kitchenEventDesc.Fields()
+	m.Set(fs.ByName("cursor"), protoreflect.ValueOfUint64(e.Cursor))
+	m.Set(fs.ByName("kind"), protoreflect.ValueOfEnum(protoreflect.EnumNumber(e.Kind)))
+	m.Set(fs.ByName("order_id"), protoreflect.ValueOfUint64(e.Product.OrderID))
+	m.Set(fs.ByName("table_id"), protoreflect.ValueOfUint64(e.TableID))
+	m.Set(fs.ByName("name"), protoreflect.ValueOfString(e.Product.Name))
+	m.Set(fs.ByName("unit_price"), protoreflect.ValueOfFloat32(protoMoney(e.Product.UnitPrice)))
+	m.Set(fs.ByName("subtotal"), protoreflect.ValueOfFloat32(protoMoney(e.Product.Subtotal)))
+	m.Set(fs.ByName("product"), protoreflect.ValueOfMessage((&pf.OrderProduct{
+		Id:          e.Product.ID,
+		ProductId:   e.Product.ProductID,
+		Quantity:    e.Product.Quantity,
+		IsReady:     e.Product.IsReady,
+		IsDelivered: e.Product.IsDelivered,
+	}).ProtoReflect()))
+	return m
+}
+
+type KitchenServicer interface {
+	Watch(c context.Context, eID, after uint64, send func(model.KitchenEvent) error) error
+	WatchWaiter(c context.Context, wID, after uint64, send func(model.KitchenEvent) error) error
+}
+
+type KitchenServiceServer interface {
+	WatchKitchen(*pf.RequestKitchen, grpc.ServerStream) error
+}
+
+type WaiterServiceServer interface {
+	WatchWaiter(*pf.RequestKitchen, grpc.ServerStream) error
+}
+
+var KitchenService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: protoPackage + ".KitchenService",
+	HandlerType: (*KitchenServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "WatchKitchen",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			r := &pf.RequestKitchen{}
+			if err := stream.RecvMsg(r); err != nil {
+				return err
+			}
+			return srv.(KitchenServiceServer).WatchKitchen(r, stream)
+		},
+	}},
+	Metadata: "order/kitchen.proto",
+}
+
+var WaiterService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: protoPackage + ".WaiterService",
+	HandlerType: (*WaiterServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "WatchWaiter",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			r := &pf.RequestKitchen{}
+			if err := stream.RecvMsg(r); err != nil {
+				return err
+			}
+			return srv.(WaiterServiceServer).WatchWaiter(r, stream)
+		},
+	}},
+	Metadata: "order/kitchen.proto",
+}
+
+type KitchenUC struct {
+	ks KitchenServicer
+}
+
+func NewKitchenUC(ks KitchenServicer) KitchenUC {
+	return KitchenUC{ks: ks}
+}
+
+func (kuc KitchenUC) WatchKitchen(r *pf.RequestKitchen, stream grpc.ServerStream) error {
+	if r.Id == 0 {
+		return apperr.InvalidArgument(apperr.FieldViolation{Field: "id", Description: "must not be empty"})
+	}
+	err := kuc.ks.Watch(stream.Context(), r.Id, r.Last, func(e model.KitchenEvent) error {
+		return stream.SendMsg(protoKitchenEvent(e))
+	})
+	if err != nil {
+		return fmt.Errorf("ks.Watch: %w", err)
+	}
+	return nil
+}
+
+func (kuc KitchenUC) WatchWaiter(r *pf.RequestKitchen, stream grpc.ServerStream) error {
+	if r.Id == 0 {
+		return apperr.InvalidArgument(apperr.FieldViolation{Field: "id", Description: "must not be empty"})
+	}
+	err := kuc.ks.WatchWaiter(stream.Context(), r.Id, r.Last, func(e model.KitchenEvent) error {
+		return stream.SendMsg(protoKitchenEvent(e))
+	})
+	if err != nil {
+		return fmt.Errorf("ks.WatchWaiter: %w", err)
+	}
+	return nil
+}
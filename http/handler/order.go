@@ -3,22 +3,27 @@ package handler
 import (
 	"context"
 	"fmt"
+	"strconv"
 
+	"github.com/modular-project/orders-service/apperr"
 	"github.com/modular-project/orders-service/model"
 	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 type OrderServicer interface {
-	Products(oID uint64) ([]model.OrderProduct, error)
-	Create(*model.Order) ([]uint64, error)
-	AddProducts(oID uint64, total float64, ps []model.OrderProduct) ([]uint64, error)
-	Kitchen(kID, last uint64) ([]model.OrderProduct, error)
-	Waiter(wID uint64) ([]model.Order, error)
-	WaiterPending(wID uint64) ([]model.Order, error)
-	Search(s *model.SearchOrder) ([]model.Order, error)
-	User(uID uint64, s model.SearchOrder) ([]model.Order, error)
-	Establishment(uID uint64, s model.SearchOrder) ([]model.Order, error)
-	GetTipsFromEmployee(eID uint64, start, end string) (float32, error)
+	Products(c context.Context, oID uint64) ([]model.OrderProduct, error)
+	Create(c context.Context, o *model.Order) ([]uint64, error)
+	AddProducts(c context.Context, oID uint64, total model.Money, ps []model.OrderProduct) ([]uint64, error)
+	Kitchen(c context.Context, kID, last uint64) ([]model.OrderProduct, error)
+	Waiter(c context.Context, wID uint64) ([]model.Order, error)
+	WaiterPending(c context.Context, wID uint64) ([]model.Order, error)
+	Search(c context.Context, s *model.SearchOrder, token string) (model.OrderPage, error)
+	User(c context.Context, uID uint64, s model.SearchOrder, token string) (model.OrderPage, error)
+	Establishment(c context.Context, eID uint64, s model.SearchOrder, token string) (model.OrderPage, error)
+	GetTipsFromEmployee(c context.Context, eID uint64, start, end string) (model.Money, error)
 }
 
 type OrderUC struct {
@@ -33,16 +38,16 @@ func NewOrderUC(os OrderServicer) OrderUC {
 func (ouc OrderUC) CreateLocalOrder(c context.Context, o *pf.Order) (*pf.CreateResponse, error) {
 	lo := o.GetLocalOrder()
 	if lo == nil {
-		return &pf.CreateResponse{}, fmt.Errorf("local order is nil")
+		return &pf.CreateResponse{}, apperr.InvalidArgument(apperr.FieldViolation{Field: "local_order", Description: "must not be empty"})
 	}
 	mo := model.Order{
 		TypeID:          model.Local,
 		EmployeeID:      lo.EmployeeId,
 		EstablishmentID: o.EstablishmentId,
 		TableID:         lo.TableId,
-		StatusID:        model.Pending,
-		Total:           float64(o.Total),
-		Tip:             lo.Tip,
+		StatusID:        model.InPreparation,
+		Total:           newMoney(o.Total),
+		Tip:             model.NewTipRate(lo.Tip),
 	}
 	if o.OrderProducts != nil {
 		mo.OrderProducts = make([]model.OrderProduct, len(o.OrderProducts))
@@ -53,7 +58,7 @@ func (ouc OrderUC) CreateLocalOrder(c context.Context, o *pf.Order) (*pf.CreateR
 			Quantity:  o.OrderProducts[i].Quantity,
 		}
 	}
-	ids, err := ouc.os.Create(&mo)
+	ids, err := ouc.os.Create(c, &mo)
 	if err != nil {
 		return &pf.CreateResponse{}, fmt.Errorf("os.create: %w", err)
 	}
@@ -61,28 +66,28 @@ func (ouc OrderUC) CreateLocalOrder(c context.Context, o *pf.Order) (*pf.CreateR
 }
 
 func (ouc OrderUC) GetTips(c context.Context, r *pf.GetTipsRequest) (*pf.GetTipsResponse, error) {
-	tips, err := ouc.os.GetTipsFromEmployee(r.EmployeeId, r.Start, r.End)
+	tips, err := ouc.os.GetTipsFromEmployee(c, r.EmployeeId, r.Start, r.End)
 	if err != nil {
 		return &pf.GetTipsResponse{}, fmt.Errorf("handler GetTips: %w", err)
 	}
-	return &pf.GetTipsResponse{Tips: tips}, nil
+	return &pf.GetTipsResponse{Tips: protoMoney(tips)}, nil
 }
 
 func (ouc OrderUC) CreateDeliveryOrder(c context.Context, o *pf.Order) (*pf.CreateResponse, error) {
 	do := o.GetRemoteOrder()
 	if do == nil {
-		return &pf.CreateResponse{}, fmt.Errorf("delivery order is nil")
+		return &pf.CreateResponse{}, apperr.InvalidArgument(apperr.FieldViolation{Field: "remote_order", Description: "must not be empty"})
 	}
 	mo := model.Order{
 		UserID:        do.UserId,
 		TypeID:        model.Delivery,
-		StatusID:      model.WithoutPay,
+		StatusID:      model.AwaitingPayment,
 		OrderProducts: make([]model.OrderProduct, len(o.OrderProducts)),
 		AddressID:     &do.AddressId,
-		Total:         float64(o.Total),
+		Total:         newMoney(o.Total),
 	}
 	if o.OrderProducts == nil {
-		return &pf.CreateResponse{}, fmt.Errorf("without products")
+		return &pf.CreateResponse{}, apperr.InvalidArgument(apperr.FieldViolation{Field: "order_products", Description: "must not be empty"})
 	}
 	for i := range o.OrderProducts {
 		mo.OrderProducts[i] = model.OrderProduct{
@@ -90,7 +95,7 @@ func (ouc OrderUC) CreateDeliveryOrder(c context.Context, o *pf.Order) (*pf.Crea
 			Quantity:  o.OrderProducts[i].Quantity,
 		}
 	}
-	ids, err := ouc.os.Create(&mo)
+	ids, err := ouc.os.Create(c, &mo)
 	if err != nil {
 		return &pf.CreateResponse{}, fmt.Errorf("os.create: %w", err)
 	}
@@ -99,42 +104,54 @@ func (ouc OrderUC) CreateDeliveryOrder(c context.Context, o *pf.Order) (*pf.Crea
 
 func (ouc OrderUC) GetOrdersByUser(c context.Context, r *pf.OrdersByUserRequest) (*pf.OrdersResponse, error) {
 	if r == nil {
-		return &pf.OrdersResponse{}, fmt.Errorf("nil request")
+		return &pf.OrdersResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
-	if r.Search.Users == nil {
-		return &pf.OrdersResponse{}, fmt.Errorf("nil user")
+	if r.Search == nil || len(r.Search.Users) == 0 {
+		return &pf.OrdersResponse{}, apperr.InvalidArgument(apperr.FieldViolation{Field: "search.users", Description: "must not be empty"})
 	}
-	o, err := ouc.os.User(r.Search.Users[0], newSearch(r.Search))
+	s, token, err := newPageSearch(c, r.Search)
 	if err != nil {
-		return &pf.OrdersResponse{}, fmt.Errorf("os.User: %w", err)
+		return &pf.OrdersResponse{}, err
 	}
-	if o == nil {
-		return &pf.OrdersResponse{}, nil
+	p, err := ouc.os.User(c, r.Search.Users[0], s, token)
+	if err != nil {
+		return &pf.OrdersResponse{}, fmt.Errorf("os.User: %w", err)
 	}
-	return &pf.OrdersResponse{Orders: protoOrder(o)}, nil
+	return protoPage(c, p, s.Total)
 }
 
 func (ouc OrderUC) GetOrderByID(c context.Context, r *pf.GetOrderByIDRequest) (*pf.OrderResponse, error) {
 	if r == nil {
-		return &pf.OrderResponse{}, fmt.Errorf("nil request")
+		return &pf.OrderResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
-	ps, err := ouc.os.Products(r.OrderId)
+	ps, err := ouc.os.Products(c, r.OrderId)
 	if err != nil {
 		return &pf.OrderResponse{}, fmt.Errorf("os.User: %w", err)
 	}
 	if ps == nil {
 		return &pf.OrderResponse{}, nil
 	}
+	t, err := model.ProductsTotal(ps)
+	if err != nil {
+		return &pf.OrderResponse{}, fmt.Errorf("model.ProductsTotal: %w", err)
+	}
+	active := make([]model.OrderProduct, 0, len(ps))
+	for _, p := range ps {
+		if !p.IsCancelled {
+			active = append(active, p)
+		}
+	}
 	o := []model.Order{
 		{
-			OrderProducts: ps,
+			Total:         t,
+			OrderProducts: active,
 		},
 	}
 	return &pf.OrderResponse{Order: protoOrder(o)[0]}, nil
 }
 
 func (ouc OrderUC) GetOrdersByKitchen(c context.Context, r *pf.RequestKitchen) (*pf.OrderProductsResponse, error) {
-	ops, err := ouc.os.Kitchen(r.Id, r.Last)
+	ops, err := ouc.os.Kitchen(c, r.Id, r.Last)
 	if err != nil {
 		return &pf.OrderProductsResponse{}, fmt.Errorf("os.Kitchen: %w", err)
 	}
@@ -156,38 +173,39 @@ func (ouc OrderUC) GetOrdersByKitchen(c context.Context, r *pf.RequestKitchen) (
 
 func (ouc OrderUC) GetOrders(c context.Context, r *pf.OrdersRequest) (*pf.OrdersResponse, error) {
 	if r == nil {
-		return &pf.OrdersResponse{}, fmt.Errorf("nil request")
+		return &pf.OrdersResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
-	s := newSearch(r.Search)
-	os, err := ouc.os.Search(&s)
+	s, token, err := newPageSearch(c, r.Search)
 	if err != nil {
-		return &pf.OrdersResponse{}, fmt.Errorf("os.Search(): %w", err)
+		return &pf.OrdersResponse{}, err
 	}
-	if os == nil {
-		return &pf.OrdersResponse{}, nil
+	p, err := ouc.os.Search(c, &s, token)
+	if err != nil {
+		return &pf.OrdersResponse{}, fmt.Errorf("os.Search(): %w", err)
 	}
-	return &pf.OrdersResponse{Orders: protoOrder(os)}, nil
+	return protoPage(c, p, s.Total)
 }
 
 func (ouc OrderUC) GetOrdersByEstablishment(c context.Context, r *pf.OrdersRequest) (*pf.OrdersResponse, error) {
 	if r == nil {
-		return &pf.OrdersResponse{}, fmt.Errorf("nil request")
+		return &pf.OrdersResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
-	if r.Search.Establishments == nil {
-		return &pf.OrdersResponse{}, fmt.Errorf("nil establishment")
+	if r.Search == nil || len(r.Search.Establishments) == 0 {
+		return &pf.OrdersResponse{}, apperr.InvalidArgument(apperr.FieldViolation{Field: "search.establishments", Description: "must not be empty"})
 	}
-	os, err := ouc.os.Establishment(r.Search.Establishments[0], newSearch(r.Search))
+	s, token, err := newPageSearch(c, r.Search)
 	if err != nil {
-		return &pf.OrdersResponse{}, fmt.Errorf("os.Establishment: %w", err)
+		return &pf.OrdersResponse{}, err
 	}
-	if os == nil {
-		return &pf.OrdersResponse{}, nil
+	p, err := ouc.os.Establishment(c, r.Search.Establishments[0], s, token)
+	if err != nil {
+		return &pf.OrdersResponse{}, fmt.Errorf("os.Establishment: %w", err)
 	}
-	return &pf.OrdersResponse{Orders: protoOrder(os)}, nil
+	return protoPage(c, p, s.Total)
 }
 
 func (ouc OrderUC) GetOrderByWaiter(c context.Context, id *pf.ID) (*pf.OrdersResponse, error) {
-	os, err := ouc.os.Waiter(id.Id)
+	os, err := ouc.os.Waiter(c, id.Id)
 	if err != nil {
 		return &pf.OrdersResponse{}, fmt.Errorf("os.Waiter: %w", err)
 	}
@@ -198,7 +216,7 @@ func (ouc OrderUC) GetOrderByWaiter(c context.Context, id *pf.ID) (*pf.OrdersRes
 }
 
 func (ouc OrderUC) GetOrderPendingByWaiter(c context.Context, id *pf.ID) (*pf.OrdersResponse, error) {
-	os, err := ouc.os.WaiterPending(id.Id)
+	os, err := ouc.os.WaiterPending(c, id.Id)
 	if err != nil {
 		return &pf.OrdersResponse{}, fmt.Errorf("os.WaiterPending: %w", err)
 	}
@@ -210,15 +228,121 @@ func (ouc OrderUC) GetOrderPendingByWaiter(c context.Context, id *pf.ID) (*pf.Or
 
 func (ouc OrderUC) AddProductsToOrder(c context.Context, r *pf.AddProductsToOrderRequest) (*pf.AddProductsToOrderResponse, error) {
 	if r == nil {
-		return &pf.AddProductsToOrderResponse{}, fmt.Errorf("nil request")
+		return &pf.AddProductsToOrderResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
-	ids, err := ouc.os.AddProducts(r.Id, float64(r.Total), orderProducts(r.Products))
+	ids, err := ouc.os.AddProducts(c, r.Id, newMoney(r.Total), orderProducts(r.Products))
 	if err != nil {
 		return &pf.AddProductsToOrderResponse{}, fmt.Errorf("os.AddProducts: %w", err)
 	}
 	return &pf.AddProductsToOrderResponse{Ids: ids}, nil
 }
 
+// The page fields of SearchOrders and OrdersResponse are in proto/README.md,
+// they are read and written as unknown fields of the messages of the
+// protobuffers module until they are added there.
+const (
+	pageTokenField     protowire.Number = 10 // SearchOrders.page_token
+	includeTotalField  protowire.Number = 11 // SearchOrders.include_total
+	nextPageTokenField protowire.Number = 2  // OrdersResponse.next_page_token
+	totalField         protowire.Number = 3  // OrdersResponse.total
+)
+
+// The clients built with the messages of the protobuffers module send the
+// page options in the metadata and get them in the header.
+const (
+	// PageToken is the metadata key of the token of the page requested, the
+	// first page is returned without one.
+	PageToken = "page-token"
+	// IncludeTotal is the metadata key that asks with "true" to count every
+	// order found.
+	IncludeTotal = "include-total"
+	// NextPageToken is the header key of the token of the next page, it is
+	// only sent when more orders follow.
+	NextPageToken = "next-page-token"
+	// TotalCount is the header key of the number of orders found.
+	TotalCount = "total-count"
+)
+
+// pageFields returns the page_token and include_total fields of ps.
+func pageFields(ps *pf.SearchOrders) (string, bool, error) {
+	var token string
+	var total bool
+	invalid := apperr.InvalidArgument(apperr.FieldViolation{Field: "search", Description: "must be a SearchOrders message"})
+	b := ps.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", false, invalid
+		}
+		b = b[n:]
+		switch {
+		case num == pageTokenField && typ == protowire.BytesType:
+			token, n = protowire.ConsumeString(b)
+		case num == includeTotalField && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			total = protowire.DecodeBool(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return "", false, invalid
+		}
+		b = b[n:]
+	}
+	return token, total, nil
+}
+
+// newPageSearch returns the search with the filters and the page options
+// of ps, or of the metadata when ps has none, and the page token.
+func newPageSearch(c context.Context, ps *pf.SearchOrders) (model.SearchOrder, string, error) {
+	s := newSearch(ps)
+	f, any, err := newSearchFilters(c)
+	if err != nil {
+		return model.SearchOrder{}, "", err
+	}
+	f.Status, f.Types = s.Status, s.Types
+	s.Filter, s.Any = f, any
+	token, total, err := pageFields(ps)
+	if err != nil {
+		return model.SearchOrder{}, "", err
+	}
+	md, _ := metadata.FromIncomingContext(c)
+	if vs := md.Get(IncludeTotal); !total && len(vs) > 0 {
+		total = vs[0] == "true"
+	}
+	if vs := md.Get(PageToken); token == "" && len(vs) > 0 {
+		token = vs[0]
+	}
+	s.Total = total
+	return s, token, nil
+}
+
+// protoPage returns the orders of the page with its token and total, which
+// are also sent in the header.
+func protoPage(c context.Context, p model.OrderPage, total bool) (*pf.OrdersResponse, error) {
+	r := &pf.OrdersResponse{Orders: protoOrder(p.Orders)}
+	var b []byte
+	md := metadata.MD{}
+	if p.NextToken != "" {
+		b = protowire.AppendTag(b, nextPageTokenField, protowire.BytesType)
+		b = protowire.AppendString(b, p.NextToken)
+		md.Set(NextPageToken, p.NextToken)
+	}
+	if total {
+		b = protowire.AppendTag(b, totalField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p.Total))
+		md.Set(TotalCount, strconv.FormatInt(p.Total, 10))
+	}
+	r.ProtoReflect().SetUnknown(b)
+	if md.Len() > 0 {
+		if err := grpc.SetHeader(c, md); err != nil {
+			return &pf.OrdersResponse{}, fmt.Errorf("grpc.SetHeader: %w", err)
+		}
+	}
+	return r, nil
+}
+
 func newOrderBy(s []*pf.SearchBy) []model.OrderBy {
 	if s == nil {
 		return nil
@@ -256,19 +380,57 @@ func newSearch(ps *pf.SearchOrders) model.SearchOrder {
 		s.Types = t
 	}
 	if ps.Status != nil {
-		st := make([]model.Status, len(ps.Status))
+		var st []model.Status
 		for i := range ps.Status {
-			st[i] = model.Status(ps.Status[i])
+			st = append(st, modelStatus(ps.Status[i])...)
 		}
 		s.Status = st
 	}
-	if ps.Range != nil {
-		s.Lower = float64(ps.Range[0])
-		s.Higher = float64(ps.Range[1])
+	if len(ps.Range) == 2 {
+		s.Lower = newMoney(ps.Range[0])
+		s.Higher = newMoney(ps.Range[1])
 	}
 	return s
 }
 
+// newMoney converts the amounts sent by the clients, they are rounded to cents.
+func newMoney(f float32) model.Money {
+	return model.MoneyFromFloat(float64(f), model.MXN)
+}
+
+// protoMoney converts an amount to the float used by the proto messages.
+func protoMoney(m model.Money) float32 {
+	return float32(m.Float64())
+}
+
+// protoStatus maps the order lifecycle onto the statuses known by the clients.
+func protoStatus(s model.Status) pf.Status {
+	switch s {
+	case model.Draft, model.AwaitingPayment:
+		return pf.Status_WITHOUTPAY
+	case model.InPreparation, model.Ready, model.OutForDelivery, model.Delivered:
+		return pf.Status_PENDING
+	case model.Paid, model.Closed:
+		return pf.Status_COMPLETED
+	default:
+		return pf.Status_NONE
+	}
+}
+
+// modelStatus returns every status of the lifecycle represented by s.
+func modelStatus(s pf.Status) []model.Status {
+	switch s {
+	case pf.Status_WITHOUTPAY:
+		return []model.Status{model.Draft, model.AwaitingPayment}
+	case pf.Status_PENDING:
+		return []model.Status{model.InPreparation, model.Ready, model.OutForDelivery, model.Delivered}
+	case pf.Status_COMPLETED:
+		return []model.Status{model.Paid, model.Closed}
+	default:
+		return []model.Status{model.Cancelled, model.Refunded, model.PartiallyRefunded, model.Expired}
+	}
+}
+
 func orderProducts(pop []*pf.OrderProduct) []model.OrderProduct {
 	if pop == nil {
 		return nil
@@ -293,8 +455,8 @@ func protoOrder(os []model.Order) []*pf.Order {
 		po[i] = &pf.Order{
 			Id:              t.ID,
 			EstablishmentId: t.EstablishmentID,
-			Total:           float32(t.Total),
-			Status:          pf.Status(t.StatusID),
+			Total:           protoMoney(t.Total),
+			Status:          protoStatus(t.StatusID),
 			OrderProducts:   make([]*pf.OrderProduct, len(t.OrderProducts)),
 			CreateAt:        uint64(t.CreatedAt.Unix()),
 		}
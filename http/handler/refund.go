@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// refundFile is proto/order/refund.proto, built by RegisterFiles.
+var refundFile protoreflect.FileDescriptor
+
+func init() {
+	addFile(&descriptorpb.FileDescriptorProto{
+		Name: proto.String("order/refund.proto"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("RefundRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoField("order_id", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("amount", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("reason", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			},
+		}, {
+			Name: proto.String("RefundResponse"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("order_id", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				protoField("amount", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("currency", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("status", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("provider_id", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			protoService("RefundService", "Refund", "RefundRequest", "RefundResponse", false),
+		},
+	}, func(fd protoreflect.FileDescriptor) { refundFile = fd })
+}
+
+func modelRefundRequest(m *dynamicpb.Message) (model.RefundRequest, error) {
+	fs := m.Descriptor().Fields()
+	r := model.RefundRequest{
+		OrderID: m.Get(fs.ByName("order_id")).Uint(),
+		Reason:  m.Get(fs.ByName("reason")).String(),
+	}
+	if a := m.Get(fs.ByName("amount")).String(); a != "" {
+		var err error
+		if r.Amount, err = model.ParseMoney(a, model.MXN); err != nil {
+			return model.RefundRequest{}, apperr.InvalidArgument(apperr.FieldViolation{Field: "amount", Description: "must be a decimal amount"})
+		}
+	}
+	return r, nil
+}
+
+func protoRefund(rf model.Refund) *dynamicpb.Message {
+	m := dynamicpb.NewMessage(refundFile.Messages().ByName("RefundResponse"))
+	fs := m.Descriptor().Fields()
+	m.Set(fs.ByName("id"), protoreflect.ValueOfUint64(rf.ID))
+	m.Set(fs.ByName("order_id"), protoreflect.ValueOfUint64(rf.OrderID))
+	m.Set(fs.ByName("amount"), protoreflect.ValueOfString(rf.Amount.String()))
+	m.Set(fs.ByName("currency"), protoreflect.ValueOfString(rf.Amount.Currency))
+	m.Set(fs.ByName("status"), protoreflect.ValueOfString(rf.Status))
+	if rf.ProviderID != nil {
+		m.Set(fs.ByName("provider_id"), protoreflect.ValueOfString(*rf.ProviderID))
+	}
+	return m
+}
+
+type RefundServicer interface {
+	Refund(c context.Context, r model.RefundRequest) (model.Refund, error)
+}
+
+type RefundServiceServer interface {
+	Refund(context.Context, *dynamicpb.Message) (*dynamicpb.Message, error)
+}
+
+var RefundService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: protoPackage + ".RefundService",
+	HandlerType: (*RefundServiceServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Refund",
+		Handler: func(srv interface{}, c context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := dynamicpb.NewMessage(refundFile.Messages().ByName("RefundRequest"))
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return srv.(RefundServiceServer).Refund(c, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + protoPackage + ".RefundService/Refund",
+			}
+			return interceptor(c, in, info, func(c context.Context, req interface{}) (interface{}, error) {
+				return srv.(RefundServiceServer).Refund(c, req.(*dynamicpb.Message))
+			})
+		},
+	}},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "order/refund.proto",
+}
+
+type RefundUC struct {
+	rs RefundServicer
+}
+
+func NewRefundUC(rs RefundServicer) RefundUC {
+	return RefundUC{rs: rs}
+}
+
+func (ruc RefundUC) Refund(c context.Context, r *dynamicpb.Message) (*dynamicpb.Message, error) {
+	if r == nil {
+		return nil, apperr.New(apperr.ErrInvalidArgument, "nil request")
+	}
+	req, err := modelRefundRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := ruc.rs.Refund(c, req)
+	if err != nil {
+		return nil, fmt.Errorf("rs.Refund: %w", err)
+	}
+	return protoRefund(rf), nil
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// searchFiltersDesc is the SearchFilters message of proto/order/search.proto.
+var searchFiltersDesc protoreflect.MessageDescriptor
+
+func init() {
+	addFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("order/search.proto"),
+		Dependency: []string{pf.File_order_order_proto.Path()},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("TimeRange"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoField("from", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				protoField("to", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			},
+		}, {
+			Name: proto.String("SearchFilter"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoRepeated(protoField("status", 1, descriptorpb.FieldDescriptorProto_TYPE_ENUM, protoType("Status"))),
+				protoRepeated(protoField("types", 2, descriptorpb.FieldDescriptorProto_TYPE_ENUM, protoType("OrderType"))),
+				protoRepeated(protoField("employees", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, "")),
+				protoRepeated(protoField("tables", 4, descriptorpb.FieldDescriptorProto_TYPE_UINT64, "")),
+				protoRepeated(protoField("payments", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, protoType("PaymentMethod"))),
+				protoRepeated(protoField("pay_ids", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")),
+				protoRepeated(protoField("products", 7, descriptorpb.FieldDescriptorProto_TYPE_UINT64, "")),
+				protoField("tip", 8, descriptorpb.FieldDescriptorProto_TYPE_ENUM, protoType("SearchFilter.Tip")),
+				protoRepeated(protoField("addresses", 9, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")),
+				protoField("created", 10, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, protoType("TimeRange")),
+				protoField("text", 11, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			},
+			EnumType: []*descriptorpb.EnumDescriptorProto{{
+				Name: proto.String("Tip"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					protoEnumValue("ANY_TIP", int32(model.AnyTip)),
+					protoEnumValue("WITH_TIP", int32(model.WithTip)),
+					protoEnumValue("WITHOUT_TIP", int32(model.WithoutTip)),
+				},
+			}},
+		}, {
+			Name: proto.String("SearchFilters"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoField("filter", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, protoType("SearchFilter")),
+				protoRepeated(protoField("any", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, protoType("SearchFilter"))),
+			},
+		}},
+	}, func(fd protoreflect.FileDescriptor) { searchFiltersDesc = fd.Messages().ByName("SearchFilters") })
+}
+
+// SearchFilter is the metadata key of the SearchFilters of a search, the
+// binary keys are sent in base64 by gRPC.
+const SearchFilter = "search-filter-bin"
+
+// maxFilterGroups is the most groups of a search.
+const maxFilterGroups = 10
+
+func filterRules(prefix string) []rule {
+	return []rule{
+		{prefix + ".status", definedEnum},
+		{prefix + ".types", definedEnum},
+		{prefix + ".employees", positive},
+		{prefix + ".tables", positive},
+		{prefix + ".payments", definedEnum},
+		{prefix + ".pay_ids", maxLen(255)},
+		{prefix + ".products", positive},
+		{prefix + ".tip", definedEnum},
+		{prefix + ".addresses", maxLen(255)},
+		{prefix + ".created", timeRange},
+		{prefix + ".created.from", timestamp},
+		{prefix + ".created.to", timestamp},
+		{prefix + ".text", maxLen(200)},
+	}
+}
+
+// searchFiltersRules are the rules of the SearchFilters message.
+var searchFiltersRules = join([]rule{
+	{"filter.status", unset},
+	{"filter.types", unset},
+	{"any", maxItems(maxFilterGroups)},
+}, filterRules("filter"), filterRules("any"))
+
+func modelPaymentMethod(pm pf.PaymentMethod) model.PaymentMethod {
+	if pm == pf.PaymentMethod_CASH {
+		return model.CASH
+	}
+	return model.PAYPAL
+}
+
+func uints(l protoreflect.List) []uint64 {
+	if l.Len() == 0 {
+		return nil
+	}
+	ids := make([]uint64, l.Len())
+	for i := range ids {
+		ids[i] = l.Get(i).Uint()
+	}
+	return ids
+}
+
+func strs(l protoreflect.List) []string {
+	if l.Len() == 0 {
+		return nil
+	}
+	ss := make([]string, l.Len())
+	for i := range ss {
+		ss[i] = l.Get(i).String()
+	}
+	return ss
+}
+
+// parseTime returns s in UTC or nil for an empty s, the times are validated
+// before.
+func parseTime(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// newFilter converts a SearchFilter.
+func newFilter(m protoreflect.Message) model.Filter {
+	fs := m.Descriptor().Fields()
+	f := model.Filter{
+		Employees: uints(m.Get(fs.ByName("employees")).List()),
+		Tables:    uints(m.Get(fs.ByName("tables")).List()),
+		PayIDs:    strs(m.Get(fs.ByName("pay_ids")).List()),
+		Products:  uints(m.Get(fs.ByName("products")).List()),
+		Tip:       model.TipFilter(m.Get(fs.ByName("tip")).Enum()),
+		Addresses: strs(m.Get(fs.ByName("addresses")).List()),
+		Text:      m.Get(fs.ByName("text")).String(),
+	}
+	l := m.Get(fs.ByName("status")).List()
+	for i := 0; i < l.Len(); i++ {
+		f.Status = append(f.Status, modelStatus(pf.Status(l.Get(i).Enum()))...)
+	}
+	l = m.Get(fs.ByName("types")).List()
+	for i := 0; i < l.Len(); i++ {
+		f.Types = append(f.Types, model.Type(l.Get(i).Enum()))
+	}
+	l = m.Get(fs.ByName("payments")).List()
+	for i := 0; i < l.Len(); i++ {
+		f.Payments = append(f.Payments, modelPaymentMethod(pf.PaymentMethod(l.Get(i).Enum())))
+	}
+	if m.Has(fs.ByName("created")) {
+		r := m.Get(fs.ByName("created")).Message()
+		rfs := r.Descriptor().Fields()
+		f.From = parseTime(r.Get(rfs.ByName("from")).String())
+		f.To = parseTime(r.Get(rfs.ByName("to")).String())
+	}
+	return f
+}
+
+// newSearchFilters returns the filter and the groups sent in the metadata.
+func newSearchFilters(c context.Context) (model.Filter, []model.Filter, error) {
+	md, _ := metadata.FromIncomingContext(c)
+	vs := md.Get(SearchFilter)
+	if len(vs) == 0 {
+		return model.Filter{}, nil, nil
+	}
+	m := dynamicpb.NewMessage(searchFiltersDesc)
+	if err := proto.Unmarshal([]byte(vs[0]), m); err != nil {
+		return model.Filter{}, nil, apperr.InvalidArgument(apperr.FieldViolation{Field: SearchFilter, Description: "must be a SearchFilters message"})
+	}
+	if vs := violations(m, SearchFilter, searchFiltersRules); len(vs) != 0 {
+		return model.Filter{}, nil, apperr.InvalidArgument(vs...)
+	}
+	fs := searchFiltersDesc.Fields()
+	f := newFilter(m.Get(fs.ByName("filter")).Message())
+	var any []model.Filter
+	l := m.Get(fs.ByName("any")).List()
+	for i := 0; i < l.Len(); i++ {
+		any = append(any, newFilter(l.Get(i).Message()))
+	}
+	return f, any, nil
+}
@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package handler
+
+import (
+	"testing"
+
+	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/protobuf/proto"
+)
+
+// FuzzNewSearch decodes arbitrary searches, newSearch must never panic and
+// the valid ones must keep their range ordered.
+func FuzzNewSearch(f *testing.F) {
+	seeds := []*pf.SearchOrders{
+		{},
+		{Range: []float32{10}},
+		{Range: []float32{10, 20}, Start: "2022-01-01", End: "2022-01-31"},
+		{Status: []pf.Status{pf.Status_PENDING, 42}, Types: []pf.OrderType{7}},
+		{Default: &pf.Default{Limit: 10, Offset: 5, SearchBy: []*pf.SearchBy{{By: pf.OrderBy_PRICE, Sort: pf.Sort_DESCENDING}}}},
+	}
+	for _, s := range seeds {
+		b, err := proto.Marshal(s)
+		if err != nil {
+			f.Fatalf("proto.Marshal() error = %v", err)
+		}
+		f.Add(b)
+	}
+	method := fullMethod(pf.OrderService_ServiceDesc, "GetOrders")
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ps := &pf.SearchOrders{}
+		if err := proto.Unmarshal(b, ps); err != nil {
+			return
+		}
+		s := newSearch(ps)
+		if Validate(method, &pf.OrdersRequest{Search: ps}) != nil {
+			return
+		}
+		if s.Higher.Amount < s.Lower.Amount {
+			t.Errorf("newSearch() range = [%s, %s], want ordered", s.Lower, s.Higher)
+		}
+		if s.Limit < 0 || s.Limit > maxSearchLimit {
+			t.Errorf("newSearch() limit = %d, want between 0 and %d", s.Limit, maxSearchLimit)
+		}
+	})
+}
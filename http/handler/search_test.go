@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	pf "github.com/modular-project/protobuffers/order/order"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// searchFilters returns the metadata of the SearchFilters in text format.
+func searchFilters(t *testing.T, text string) context.Context {
+	m := dynamicpb.NewMessage(searchFiltersDesc)
+	if err := prototext.Unmarshal([]byte(text), m); err != nil {
+		t.Fatalf("prototext.Unmarshal() error = %v", err)
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(SearchFilter, string(b)))
+}
+
+func TestNewPageSearch(t *testing.T) {
+	from := time.Date(2022, 10, 1, 6, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 10, 2, 5, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		c         context.Context
+		give      *pf.SearchOrders
+		want      model.SearchOrder
+		wantToken string
+		wantErr   []apperr.FieldViolation
+	}{
+		{name: "without filters", c: context.Background(), give: &pf.SearchOrders{Users: []uint64{7}}, want: model.SearchOrder{Users: []uint64{7}}},
+		{
+			name: "filters",
+			c: searchFilters(t, `
+				filter { employees: 1 tables: [2, 3] payments: CASH pay_ids: "PAY-1" products: 4 tip: WITH_TIP addresses: "home" text: "taco"
+				         created { from: "2022-10-01T01:00:00-05:00" to: "2022-10-02T00:00:00-05:00" } }
+				any { status: COMPLETED types: DELIVERY }
+				any { payments: PAYPAL tip: WITHOUT_TIP }`),
+			give: &pf.SearchOrders{Status: []pf.Status{pf.Status_PENDING}},
+			want: model.SearchOrder{
+				Filter: model.Filter{
+					Status:    []model.Status{model.InPreparation, model.Ready, model.OutForDelivery, model.Delivered},
+					Employees: []uint64{1}, Tables: []uint64{2, 3}, Payments: []model.PaymentMethod{model.CASH},
+					PayIDs: []string{"PAY-1"}, Products: []uint64{4}, Tip: model.WithTip, Addresses: []string{"home"},
+					From: &from, To: &to, Text: "taco",
+				},
+				Any: []model.Filter{
+					{Status: []model.Status{model.Paid, model.Closed}, Types: []model.Type{model.Delivery}},
+					{Payments: []model.PaymentMethod{model.PAYPAL}, Tip: model.WithoutTip},
+				},
+			},
+		},
+		{
+			name: "invalid filters",
+			c: searchFilters(t, `
+				filter { status: PENDING tables: 0 created { from: "2022-10-02T00:00:00Z" to: "2022-10-01T00:00:00Z" } }
+				any { created { from: "2022-10-01" } tip: 7 }`),
+			wantErr: []apperr.FieldViolation{
+				{Field: SearchFilter + ".filter.status", Description: "must not be set"},
+				{Field: SearchFilter + ".filter.tables", Description: "must be greater than 0"},
+				{Field: SearchFilter + ".filter.created", Description: "must end after its start"},
+				{Field: SearchFilter + ".any[0].tip", Description: "must be a valid value"},
+				{Field: SearchFilter + ".any[0].created.from", Description: "must be an RFC 3339 timestamp"},
+			},
+		},
+		{
+			name:    "malformed",
+			c:       metadata.NewIncomingContext(context.Background(), metadata.Pairs(SearchFilter, "\xff")),
+			wantErr: []apperr.FieldViolation{{Field: SearchFilter, Description: "must be a SearchFilters message"}},
+		},
+		{
+			name:      "page fields",
+			c:         metadata.NewIncomingContext(context.Background(), metadata.Pairs(PageToken, "header", IncludeTotal, "false")),
+			give:      pageSearch(&pf.SearchOrders{Users: []uint64{7}}, "token", true),
+			want:      model.SearchOrder{Search: model.Search{Total: true}, Users: []uint64{7}},
+			wantToken: "token",
+		},
+		{
+			name:      "page metadata",
+			c:         metadata.NewIncomingContext(context.Background(), metadata.Pairs(PageToken, "header", IncludeTotal, "true")),
+			give:      &pf.SearchOrders{},
+			want:      model.SearchOrder{Search: model.Search{Total: true}},
+			wantToken: "header",
+		},
+		{
+			name: "malformed page fields",
+			c:    context.Background(),
+			give: func() *pf.SearchOrders {
+				ps := &pf.SearchOrders{}
+				ps.ProtoReflect().SetUnknown(protowire.AppendTag(nil, pageTokenField, protowire.BytesType))
+				return ps
+			}(),
+			wantErr: []apperr.FieldViolation{{Field: "search", Description: "must be a SearchOrders message"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, token, err := newPageSearch(tt.c, tt.give)
+			if tt.wantErr != nil {
+				if !errors.Is(err, apperr.ErrInvalidArgument) {
+					t.Fatalf("newPageSearch() error = %v, want %v", err, apperr.ErrInvalidArgument)
+				}
+				assert.Equal(t, tt.wantErr, apperr.Violations(err))
+				return
+			}
+			if err != nil {
+				t.Fatalf("newPageSearch() error = %v", err)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantToken, token)
+		})
+	}
+}
+
+// pageSearch returns ps with the page_token and include_total fields.
+func pageSearch(ps *pf.SearchOrders, token string, total bool) *pf.SearchOrders {
+	b := protowire.AppendTag(nil, pageTokenField, protowire.BytesType)
+	b = protowire.AppendString(b, token)
+	b = protowire.AppendTag(b, includeTotalField, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(total))
+	ps.ProtoReflect().SetUnknown(b)
+	return ps
+}
+
+// headerStream records the header set by a handler.
+type headerStream struct {
+	grpc.ServerTransportStream
+	md metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.md = metadata.Join(s.md, md)
+	return nil
+}
+
+func TestProtoPage(t *testing.T) {
+	tests := []struct {
+		name       string
+		give       model.OrderPage
+		total      bool
+		wantToken  string
+		wantTotal  uint64
+		wantHeader metadata.MD
+	}{
+		{name: "last page", give: model.OrderPage{Orders: []model.Order{{Model: model.Model{ID: 1}}}, Total: 1}},
+		{
+			name:       "next page with total",
+			give:       model.OrderPage{Orders: []model.Order{{Model: model.Model{ID: 1}}, {Model: model.Model{ID: 2}}}, NextToken: "token", Total: 3},
+			total:      true,
+			wantToken:  "token",
+			wantTotal:  3,
+			wantHeader: metadata.Pairs(NextPageToken, "token", TotalCount, "3"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &headerStream{}
+			got, err := protoPage(grpc.NewContextWithServerTransportStream(context.Background(), s), tt.give, tt.total)
+			if err != nil {
+				t.Fatalf("protoPage() error = %v", err)
+			}
+			assert.Len(t, got.Orders, len(tt.give.Orders))
+			var token string
+			var total uint64
+			b := got.ProtoReflect().GetUnknown()
+			for len(b) > 0 {
+				num, _, n := protowire.ConsumeTag(b)
+				b = b[n:]
+				switch num {
+				case nextPageTokenField:
+					token, n = protowire.ConsumeString(b)
+				case totalField:
+					total, n = protowire.ConsumeVarint(b)
+				}
+				if n < 0 {
+					t.Fatalf("protoPage() malformed field %d", num)
+				}
+				b = b[n:]
+			}
+			assert.Equal(t, tt.wantToken, token)
+			assert.Equal(t, tt.wantTotal, total)
+			assert.Equal(t, tt.wantHeader, s.md)
+		})
+	}
+}
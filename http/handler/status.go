@@ -4,17 +4,18 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/modular-project/orders-service/apperr"
 	"github.com/modular-project/orders-service/model"
 	pf "github.com/modular-project/protobuffers/order/order"
 )
 
 type OrderStatusServicer interface {
 	PayDelivery(c context.Context, oID, uID, eID uint64, aID string, pm model.PaymentMethod) (string, error)
-	PayLocal(oID, eID uint64, pm model.PaymentMethod, tip float32) error
-	CompleteProduct(uint64) error
-	DeliverProduct([]uint64) error
+	PayLocal(c context.Context, oID, eID uint64, pm model.PaymentMethod, tip model.TipRate) error
+	CompleteProduct(context.Context, uint64) error
+	DeliverProduct(context.Context, []uint64) error
 	CapturePayment(context.Context, string) (string, error)
-	CancelOrders([]uint64, uint64) error
+	CancelOrders(context.Context, []uint64, uint64) error
 }
 
 type OrderStatusUC struct {
@@ -28,18 +29,18 @@ func NewOrderStatusUC(oss OrderStatusServicer) OrderStatusUC {
 
 func (ouc OrderStatusUC) CancelOrders(c context.Context, r *pf.CancelOrdersRequest) (*pf.CancelOrdersResponse, error) {
 	if r == nil {
-		return &pf.CancelOrdersResponse{}, fmt.Errorf("nil request")
+		return &pf.CancelOrdersResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
-	err := ouc.oss.CancelOrders(r.Ids, r.UserId)
+	err := ouc.oss.CancelOrders(c, r.Ids, r.UserId)
 	if err != nil {
-		return &pf.CancelOrdersResponse{}, fmt.Errorf("oss.PayDelivery: %w", err)
+		return &pf.CancelOrdersResponse{}, fmt.Errorf("oss.CancelOrders: %w", err)
 	}
 	return &pf.CancelOrdersResponse{}, nil
 }
 
 func (ouc OrderStatusUC) PayDelivery(c context.Context, r *pf.PayDeliveryRequest) (*pf.PayDeliveryResponse, error) {
 	if r == nil {
-		return &pf.PayDeliveryResponse{}, fmt.Errorf("nil request")
+		return &pf.PayDeliveryResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
 	id, err := ouc.oss.PayDelivery(c, r.OrdeId, r.UserId, r.EstablishmentId, r.Address, model.PaymentMethod(r.Payment))
 	if err != nil {
@@ -50,9 +51,9 @@ func (ouc OrderStatusUC) PayDelivery(c context.Context, r *pf.PayDeliveryRequest
 
 func (ouc OrderStatusUC) PayLocal(c context.Context, r *pf.PayLocalRequest) (*pf.PayLocalResponse, error) {
 	if r == nil {
-		return &pf.PayLocalResponse{}, fmt.Errorf("nil request")
+		return &pf.PayLocalResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
-	if err := ouc.oss.PayLocal(r.OrdeId, r.EmployeeId, model.PaymentMethod(r.Payment), r.Tip); err != nil {
+	if err := ouc.oss.PayLocal(c, r.OrdeId, r.EmployeeId, model.PaymentMethod(r.Payment), model.NewTipRate(r.Tip)); err != nil {
 		return &pf.PayLocalResponse{}, fmt.Errorf("oss.PayDelivery: %w", err)
 	}
 	return &pf.PayLocalResponse{}, nil
@@ -60,9 +61,9 @@ func (ouc OrderStatusUC) PayLocal(c context.Context, r *pf.PayLocalRequest) (*pf
 
 func (ouc OrderStatusUC) CompleteProduct(c context.Context, r *pf.CompleteProductRequest) (*pf.CompleteProductResponse, error) {
 	if r == nil {
-		return &pf.CompleteProductResponse{}, fmt.Errorf("nil request")
+		return &pf.CompleteProductResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
-	if err := ouc.oss.CompleteProduct(r.Id); err != nil {
+	if err := ouc.oss.CompleteProduct(c, r.Id); err != nil {
 		return &pf.CompleteProductResponse{}, fmt.Errorf("ouc.CompleteProduct: %w", err)
 	}
 	return &pf.CompleteProductResponse{}, nil
@@ -70,7 +71,7 @@ func (ouc OrderStatusUC) CompleteProduct(c context.Context, r *pf.CompleteProduc
 
 func (ouc OrderStatusUC) CapturePayment(c context.Context, r *pf.CapturePaymentRequest) (*pf.CapturePaymentResponse, error) {
 	if r == nil {
-		return &pf.CapturePaymentResponse{}, fmt.Errorf("nil request")
+		return &pf.CapturePaymentResponse{}, apperr.New(apperr.ErrInvalidArgument, "nil request")
 	}
 	st, err := ouc.oss.CapturePayment(c, r.Id)
 	if err != nil {
@@ -81,9 +82,9 @@ func (ouc OrderStatusUC) CapturePayment(c context.Context, r *pf.CapturePaymentR
 
 func (ouc OrderStatusUC) DeliverProducts(c context.Context, r *pf.DeliverProductRequest) (*pf.DeliverProductResponse, error) {
 	if r.Id == nil {
-		return &pf.DeliverProductResponse{}, fmt.Errorf("empty array of ids")
+		return &pf.DeliverProductResponse{}, apperr.InvalidArgument(apperr.FieldViolation{Field: "id", Description: "must not be empty"})
 	}
-	if err := ouc.oss.DeliverProduct(r.Id); err != nil {
+	if err := ouc.oss.DeliverProduct(c, r.Id); err != nil {
 		return &pf.DeliverProductResponse{}, fmt.Errorf("oss.DeliverProduct: %w", err)
 	}
 	return &pf.DeliverProductResponse{}, nil
@@ -0,0 +1,400 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	pf "github.com/modular-project/protobuffers/order/order"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	// maxSearchLimit is the most orders returned by a search.
+	maxSearchLimit = 100
+	// maxTip is the highest tip, a fraction of the total.
+	maxTip = 1
+)
+
+// check returns why the field fd of m is invalid or an empty string.
+type check func(m protoreflect.Message, fd protoreflect.FieldDescriptor) string
+
+// rule applies check to the field in path, the names of the fields from the
+// request joined by dots. The unset messages in the path are skipped and the
+// repeated ones are checked one by one.
+type rule struct {
+	path  string
+	check check
+}
+
+func required(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	if !m.Has(fd) {
+		return "must not be empty"
+	}
+	return ""
+}
+
+func items(n int) check {
+	return func(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+		if l := m.Get(fd).List().Len(); l != 0 && l != n {
+			return fmt.Sprintf("must have %d values", n)
+		}
+		return ""
+	}
+}
+
+// values returns the values of fd, one for the singular fields.
+func values(m protoreflect.Message, fd protoreflect.FieldDescriptor) []protoreflect.Value {
+	if !fd.IsList() {
+		return []protoreflect.Value{m.Get(fd)}
+	}
+	l := m.Get(fd).List()
+	vs := make([]protoreflect.Value, l.Len())
+	for i := range vs {
+		vs[i] = l.Get(i)
+	}
+	return vs
+}
+
+func number(fd protoreflect.FieldDescriptor, v protoreflect.Value) float64 {
+	switch fd.Kind() {
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+		return float64(v.Uint())
+	case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Sint32Kind, protoreflect.Sint64Kind,
+		protoreflect.Sfixed32Kind, protoreflect.Sfixed64Kind:
+		return float64(v.Int())
+	}
+	panic(fmt.Sprintf("field %s is not a number", fd.FullName()))
+}
+
+// between checks that every value of a numeric field is in [min, max], the
+// NaN values are invalid.
+func between(min, max float64) check {
+	return func(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+		for _, v := range values(m, fd) {
+			if n := number(fd, v); !(n >= min && n <= max) {
+				return fmt.Sprintf("must be between %g and %g", min, max)
+			}
+		}
+		return ""
+	}
+}
+
+// amount checks that every value of an amount is not negative and fits in
+// the cents stored.
+func amount(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	for _, v := range values(m, fd) {
+		switch n := number(fd, v); {
+		case !(n >= 0):
+			return "must not be negative"
+		case n > model.MaxAmount.Float64():
+			return fmt.Sprintf("must not exceed %s", model.MaxAmount)
+		}
+	}
+	return ""
+}
+
+func positive(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	for _, v := range values(m, fd) {
+		if !(number(fd, v) > 0) {
+			return "must be greater than 0"
+		}
+	}
+	return ""
+}
+
+func definedEnum(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	for _, v := range values(m, fd) {
+		if fd.Enum().Values().ByNumber(v.Enum()) == nil {
+			return "must be a valid value"
+		}
+	}
+	return ""
+}
+
+func date(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	if s := m.Get(fd).String(); s != "" {
+		if _, _, err := model.ParseDate(s); err != nil {
+			return "must be a date like " + model.DateLayout + " or an RFC 3339 timestamp"
+		}
+	}
+	return ""
+}
+
+func maxLen(n int) check {
+	return func(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+		for _, v := range values(m, fd) {
+			if len(v.String()) > n {
+				return fmt.Sprintf("must have at most %d characters", n)
+			}
+		}
+		return ""
+	}
+}
+
+func maxItems(n int) check {
+	return func(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+		if m.Get(fd).List().Len() > n {
+			return fmt.Sprintf("must have at most %d values", n)
+		}
+		return ""
+	}
+}
+
+// unset checks that a field is not sent because its value is taken from
+// another one.
+func unset(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	if m.Has(fd) {
+		return "must not be set"
+	}
+	return ""
+}
+
+func timestamp(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	if s := m.Get(fd).String(); s != "" {
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return "must be an RFC 3339 timestamp"
+		}
+	}
+	return ""
+}
+
+// ascending checks that the values of a numeric list do not decrease.
+func ascending(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	vs := values(m, fd)
+	for i := 1; i < len(vs); i++ {
+		if number(fd, vs[i]) < number(fd, vs[i-1]) {
+			return "must be in ascending order"
+		}
+	}
+	return ""
+}
+
+// dateRange checks the start and end dates of a search, both are sent or
+// none and the end is not before the start.
+func dateRange(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	if !m.Has(fd) {
+		return ""
+	}
+	s := m.Get(fd).Message()
+	fs := s.Descriptor().Fields()
+	start, end := s.Get(fs.ByName("start")).String(), s.Get(fs.ByName("end")).String()
+	if (start == "") != (end == "") {
+		return "must have both start and end"
+	}
+	// the dates are compared at midnight in UTC, they are only compared to
+	// each other.
+	st, _, serr := model.ParseDate(start)
+	en, _, eerr := model.ParseDate(end)
+	if serr == nil && eerr == nil && en.Before(st) {
+		return "must not end before its start"
+	}
+	return ""
+}
+
+// timeRange checks that the end of a TimeRange is after its start when both
+// are sent.
+func timeRange(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	if !m.Has(fd) {
+		return ""
+	}
+	r := m.Get(fd).Message()
+	fs := r.Descriptor().Fields()
+	from, ferr := time.Parse(time.RFC3339, r.Get(fs.ByName("from")).String())
+	to, terr := time.Parse(time.RFC3339, r.Get(fs.ByName("to")).String())
+	if ferr == nil && terr == nil && !to.After(from) {
+		return "must end after its start"
+	}
+	return ""
+}
+
+func searchRules(prefix string) []rule {
+	return []rule{
+		{prefix, dateRange},
+		{prefix + ".start", date},
+		{prefix + ".end", date},
+		{prefix + ".range", items(2)},
+		{prefix + ".range", amount},
+		{prefix + ".range", ascending},
+		{prefix + ".status", definedEnum},
+		{prefix + ".types", definedEnum},
+		{prefix + ".default.limit", between(0, maxSearchLimit)},
+		{prefix + ".default.search_by.by", definedEnum},
+		{prefix + ".default.search_by.sort", definedEnum},
+	}
+}
+
+func productRules(prefix string) []rule {
+	return []rule{
+		{prefix, required},
+		{prefix + ".product_id", required},
+		{prefix + ".quantity", positive},
+	}
+}
+
+func join(rs ...[]rule) []rule {
+	var all []rule
+	for _, r := range rs {
+		all = append(all, r...)
+	}
+	return all
+}
+
+// rules are the rules of the requests of every RPC, they run after the
+// policies so the IDs of the caller are already set.
+var rules = map[string][]rule{
+	fullMethod(pf.OrderService_ServiceDesc, "CreateLocalOrder"): join([]rule{
+		{"establishment_id", required},
+		{"local_order", required},
+		{"local_order.table_id", required},
+		{"local_order.employee_id", required},
+		{"local_order.tip", between(0, maxTip)},
+		{"total", amount},
+	}, productRules("order_products")),
+	fullMethod(pf.OrderService_ServiceDesc, "CreateDeliveryOrder"): join([]rule{
+		{"remote_order", required},
+		{"remote_order.user_id", required},
+		{"remote_order.address_id", required},
+		{"remote_order.address_id", maxLen(255)},
+		{"total", amount},
+	}, productRules("order_products")),
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrdersByUser"): join([]rule{
+		{"search", required},
+		{"search.users", required},
+	}, searchRules("search")),
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrdersByKitchen"): {{"id", required}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrders"):          searchRules("search"),
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrdersByEstablishment"): join([]rule{
+		{"search", required},
+		{"search.establishments", required},
+	}, searchRules("search")),
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrderByWaiter"):        {{"id", required}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrderPendingByWaiter"): {{"id", required}},
+	fullMethod(pf.OrderService_ServiceDesc, "GetOrderByID"):            {{"order_id", required}},
+	fullMethod(pf.OrderService_ServiceDesc, "AddProductsToOrder"): join([]rule{
+		{"id", required},
+		{"total", amount},
+	}, productRules("products")),
+	fullMethod(pf.OrderService_ServiceDesc, "GetTips"): {
+		{"employee_id", required},
+		{"start", required},
+		{"start", date},
+		{"end", required},
+		{"end", date},
+	},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "PayLocal"): {
+		{"orde_id", required},
+		{"employee_id", required},
+		{"payment", definedEnum},
+		{"tip", between(0, maxTip)},
+	},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "PayDelivery"): {
+		{"orde_id", required},
+		{"user_id", required},
+		{"payment", definedEnum},
+		{"address", maxLen(255)},
+	},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "CapturePayment"):  {{"id", required}},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "CompleteProduct"): {{"id", required}},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "DeliverProducts"): {{"id", required}, {"id", positive}},
+	fullMethod(pf.OrderStatusService_ServiceDesc, "CancelOrders"):    {{"ids", required}, {"ids", positive}, {"user_id", required}},
+	fullMethod(CancellationService_ServiceDesc, "Cancel"): {
+		{"order_ids", positive},
+		{"order_product_ids", positive},
+		{"reason", definedEnum},
+		{"note", maxLen(500)},
+	},
+	fullMethod(OrderHistoryService_ServiceDesc, "GetOrderHistory"): {{"order_id", required}},
+	fullMethod(RefundService_ServiceDesc, "Refund"):                {{"order_id", required}, {"reason", required}, {"reason", maxLen(500)}},
+	fullMethod(KitchenService_ServiceDesc, "WatchKitchen"):         {{"id", required}},
+	fullMethod(WaiterService_ServiceDesc, "WatchWaiter"):           {{"id", required}},
+}
+
+func (r rule) apply(m protoreflect.Message, prefix string, path []string) []apperr.FieldViolation {
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(path[0]))
+	if fd == nil {
+		panic(fmt.Sprintf("rule %s: %s has no field %s", r.path, m.Descriptor().FullName(), path[0]))
+	}
+	name := path[0]
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+	if len(path) == 1 {
+		if d := r.check(m, fd); d != "" {
+			return []apperr.FieldViolation{{Field: name, Description: d}}
+		}
+		return nil
+	}
+	switch {
+	case fd.IsList() && fd.Message() != nil:
+		var vs []apperr.FieldViolation
+		l := m.Get(fd).List()
+		for i := 0; i < l.Len(); i++ {
+			vs = append(vs, r.apply(l.Get(i).Message(), fmt.Sprintf("%s[%d]", name, i), path[1:])...)
+		}
+		return vs
+	case fd.Message() != nil && m.Has(fd):
+		return r.apply(m.Get(fd).Message(), name, path[1:])
+	}
+	return nil
+}
+
+// Validate returns an InvalidArgument error with every invalid field of the
+// request of method.
+func Validate(method string, req interface{}) error {
+	pm, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	m := pm.ProtoReflect()
+	if !m.IsValid() {
+		return apperr.New(apperr.ErrInvalidArgument, "nil request")
+	}
+	if vs := violations(m, "", rules[method]); len(vs) != 0 {
+		return apperr.InvalidArgument(vs...)
+	}
+	return nil
+}
+
+// violations returns the fields of m that break rs, named after prefix.
+func violations(m protoreflect.Message, prefix string, rs []rule) []apperr.FieldViolation {
+	var vs []apperr.FieldViolation
+	for _, r := range rs {
+		vs = append(vs, r.apply(m, prefix, strings.Split(r.path, "."))...)
+	}
+	return vs
+}
+
+// ValidationUnaryInterceptor rejects the requests that break the rules of
+// their RPC before they reach the handler.
+func ValidationUnaryInterceptor(c context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+	if err := Validate(info.FullMethod, req); err != nil {
+		return nil, err
+	}
+	return h(c, req)
+}
+
+// validStream validates the messages received by a stream RPC.
+type validStream struct {
+	grpc.ServerStream
+	method string
+}
+
+func (s validStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return Validate(s.method, m)
+}
+
+// ValidationStreamInterceptor is ValidationUnaryInterceptor for the stream RPCs.
+func ValidationStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+	return h(srv, validStream{ServerStream: ss, method: info.FullMethod})
+}
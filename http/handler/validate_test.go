@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/modular-project/orders-service/apperr"
+	pf "github.com/modular-project/protobuffers/order/order"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// TestRules checks that every RPC with a policy has rules and that the path
+// of every rule names the fields of its message.
+func TestRules(t *testing.T) {
+	for method := range policies {
+		assert.Contains(t, rules, method)
+	}
+	for method, rs := range rules {
+		parts := strings.Split(strings.TrimPrefix(method, "/"), "/")
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(parts[0]))
+		if err != nil {
+			t.Fatalf("FindDescriptorByName(%s) error = %v", parts[0], err)
+		}
+		md := d.(protoreflect.ServiceDescriptor).Methods().ByName(protoreflect.Name(parts[1]))
+		if md == nil {
+			t.Fatalf("%s not found", method)
+		}
+		checkPaths(t, md.Input(), rs)
+	}
+	checkPaths(t, searchFiltersDesc, searchFiltersRules)
+}
+
+func checkPaths(t *testing.T, md protoreflect.MessageDescriptor, rs []rule) {
+	for _, r := range rs {
+		msg := md
+		for i, name := range strings.Split(r.path, ".") {
+			fd := msg.Fields().ByName(protoreflect.Name(name))
+			if fd == nil {
+				t.Fatalf("%s: rule %s: %s has no field %s", md.FullName(), r.path, msg.FullName(), name)
+			}
+			if i < len(strings.Split(r.path, "."))-1 {
+				msg = fd.Message()
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	order := func(m string) string { return fullMethod(pf.OrderService_ServiceDesc, m) }
+	status := func(m string) string { return fullMethod(pf.OrderStatusService_ServiceDesc, m) }
+	local := func(tip float32, ps ...*pf.OrderProduct) *pf.Order {
+		return &pf.Order{
+			EstablishmentId: 1, Total: 10, OrderProducts: ps,
+			Type: &pf.Order_LocalOrder{LocalOrder: &pf.LocalOrder{TableId: 1, EmployeeId: 2, Tip: tip}},
+		}
+	}
+	search := func(s *pf.SearchOrders) *pf.OrdersRequest { return &pf.OrdersRequest{Search: s} }
+	tests := []struct {
+		name   string
+		method string
+		req    interface{}
+		want   []apperr.FieldViolation
+	}{
+		{name: "local order", method: order("CreateLocalOrder"), req: local(0.1, &pf.OrderProduct{ProductId: 1, Quantity: 2})},
+		{
+			name: "local order without products", method: order("CreateLocalOrder"), req: local(0.1),
+			want: []apperr.FieldViolation{{Field: "order_products", Description: "must not be empty"}},
+		},
+		{
+			name: "invalid local order", method: order("CreateLocalOrder"),
+			req: local(-0.1, &pf.OrderProduct{ProductId: 1}, &pf.OrderProduct{Quantity: 1}),
+			want: []apperr.FieldViolation{
+				{Field: "local_order.tip", Description: "must be between 0 and 1"},
+				{Field: "order_products[1].product_id", Description: "must not be empty"},
+				{Field: "order_products[0].quantity", Description: "must be greater than 0"},
+			},
+		},
+		{
+			name: "local order without type", method: order("CreateLocalOrder"), req: &pf.Order{EstablishmentId: 1, Total: -1, OrderProducts: []*pf.OrderProduct{{ProductId: 1, Quantity: 1}}},
+			want: []apperr.FieldViolation{
+				{Field: "local_order", Description: "must not be empty"},
+				{Field: "total", Description: "must not be negative"},
+			},
+		},
+		{
+			name: "nan total", method: order("AddProductsToOrder"), req: &pf.AddProductsToOrderRequest{Id: 1, Total: float32(math.NaN()), Products: []*pf.OrderProduct{{ProductId: 1, Quantity: 1}}},
+			want: []apperr.FieldViolation{{Field: "total", Description: "must not be negative"}},
+		},
+		{
+			name: "total larger than stored", method: order("AddProductsToOrder"), req: &pf.AddProductsToOrderRequest{Id: 1, Total: 1e10, Products: []*pf.OrderProduct{{ProductId: 1, Quantity: 1}}},
+			want: []apperr.FieldViolation{{Field: "total", Description: "must not exceed 9999999999.99"}},
+		},
+		{
+			name: "orders by user without search", method: order("GetOrdersByUser"), req: &pf.OrdersByUserRequest{},
+			want: []apperr.FieldViolation{{Field: "search", Description: "must not be empty"}},
+		},
+		{name: "search", method: order("GetOrders"), req: search(&pf.SearchOrders{Range: []float32{10, 20}, Start: "2022-01-01", End: "2022-01-31"})},
+		{name: "empty search", method: order("GetOrders"), req: &pf.OrdersRequest{}},
+		{
+			name: "range with one value", method: order("GetOrders"), req: search(&pf.SearchOrders{Range: []float32{10}}),
+			want: []apperr.FieldViolation{{Field: "search.range", Description: "must have 2 values"}},
+		},
+		{
+			name: "invalid range", method: order("GetOrders"), req: search(&pf.SearchOrders{Range: []float32{-10, -20}}),
+			want: []apperr.FieldViolation{
+				{Field: "search.range", Description: "must not be negative"},
+				{Field: "search.range", Description: "must be in ascending order"},
+			},
+		},
+		{
+			name: "invalid dates", method: order("GetOrders"), req: search(&pf.SearchOrders{Start: "2022-02-01", End: "2022-01-32"}),
+			want: []apperr.FieldViolation{{Field: "search.end", Description: "must be a date like 2006-01-02 or an RFC 3339 timestamp"}},
+		},
+		{name: "timestamps", method: order("GetOrders"), req: search(&pf.SearchOrders{Start: "2022-02-01", End: "2022-02-01T12:00:00-06:00"})},
+		{name: "tips between timestamps", method: order("GetTips"), req: &pf.GetTipsRequest{EmployeeId: 1, Start: "2022-02-01T06:00:00Z", End: "2022-02-02"}},
+		{
+			name: "dates reversed", method: order("GetOrders"), req: search(&pf.SearchOrders{Start: "2022-02-01", End: "2022-01-01"}),
+			want: []apperr.FieldViolation{{Field: "search", Description: "must not end before its start"}},
+		},
+		{
+			name: "search without end", method: order("GetOrders"), req: search(&pf.SearchOrders{Start: "2022-02-01"}),
+			want: []apperr.FieldViolation{{Field: "search", Description: "must have both start and end"}},
+		},
+		{
+			name: "invalid enums and limit", method: order("GetOrders"),
+			req: search(&pf.SearchOrders{Status: []pf.Status{42}, Default: &pf.Default{Limit: 1000, SearchBy: []*pf.SearchBy{{By: 9}}}}),
+			want: []apperr.FieldViolation{
+				{Field: "search.status", Description: "must be a valid value"},
+				{Field: "search.default.limit", Description: "must be between 0 and 100"},
+				{Field: "search.default.search_by[0].by", Description: "must be a valid value"},
+			},
+		},
+		{
+			name: "pay local", method: status("PayLocal"), req: &pf.PayLocalRequest{OrdeId: 1, Payment: 7, Tip: 2},
+			want: []apperr.FieldViolation{
+				{Field: "employee_id", Description: "must not be empty"},
+				{Field: "payment", Description: "must be a valid value"},
+				{Field: "tip", Description: "must be between 0 and 1"},
+			},
+		},
+		{
+			name: "deliver products", method: status("DeliverProducts"), req: &pf.DeliverProductRequest{Id: []uint64{1, 0}},
+			want: []apperr.FieldViolation{{Field: "id", Description: "must be greater than 0"}},
+		},
+		{name: "method without rules", method: "/grpc.health.v1.Health/Check", req: &pf.ID{}},
+		{name: "nil request", method: order("GetOrders"), req: (*pf.OrdersRequest)(nil), want: []apperr.FieldViolation{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.method, tt.req)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			if !errors.Is(err, apperr.ErrInvalidArgument) {
+				t.Fatalf("Validate() error = %v, want %v", err, apperr.ErrInvalidArgument)
+			}
+			if len(tt.want) != 0 {
+				assert.Equal(t, tt.want, apperr.Violations(err))
+			}
+		})
+	}
+}
+
+func TestNewSearch(t *testing.T) {
+	tests := []struct {
+		name string
+		give *pf.SearchOrders
+		want [2]float64
+	}{
+		{name: "nil"},
+		{name: "range", give: &pf.SearchOrders{Range: []float32{10.5, 20}}, want: [2]float64{10.5, 20}},
+		{name: "range with one value", give: &pf.SearchOrders{Range: []float32{10}}},
+		{name: "range with three values", give: &pf.SearchOrders{Range: []float32{1, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSearch(tt.give)
+			assert.Equal(t, tt.want, [2]float64{s.Lower.Float64(), s.Higher.Float64()})
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+)
+
+// maxWebhookBody is the largest body accepted from a payment gateway.
+const maxWebhookBody = 1 << 20
+
+type PaymentEventer interface {
+	PaymentEvent(context.Context, model.PaymentEvent) error
+}
+
+// WebhookVerifier returns the event of a webhook after it checks that it was
+// sent by the payment gateway.
+type WebhookVerifier interface {
+	Verify(r *http.Request) (model.PaymentEvent, error)
+}
+
+// WebhookUC receives the webhooks of a payment gateway, any status other
+// than 2xx makes the gateway send the webhook again later.
+type WebhookUC struct {
+	oss PaymentEventer
+	wv  WebhookVerifier
+}
+
+func NewWebhookUC(oss PaymentEventer, wv WebhookVerifier) WebhookUC {
+	return WebhookUC{oss: oss, wv: wv}
+}
+
+var httpCodes = map[error]int{
+	apperr.ErrNotFound:           http.StatusNotFound,
+	apperr.ErrInvalidArgument:    http.StatusBadRequest,
+	apperr.ErrFailedPrecondition: http.StatusConflict,
+	apperr.ErrConflict:           http.StatusConflict,
+	apperr.ErrPaymentDeclined:    http.StatusConflict,
+	apperr.ErrUnavailable:        http.StatusServiceUnavailable,
+	apperr.ErrPermissionDenied:   http.StatusUnauthorized,
+	apperr.ErrUnauthenticated:    http.StatusUnauthorized,
+}
+
+// httpError writes the status of err, the errors that are not defined in
+// apperr are internal.
+func httpError(w http.ResponseWriter, err error) {
+	c, ok := httpCodes[apperr.Kind(err)]
+	if !ok {
+		c = http.StatusInternalServerError
+	}
+	http.Error(w, http.StatusText(c), c)
+}
+
+func (wuc WebhookUC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBody)
+	e, err := wuc.wv.Verify(r)
+	if err != nil {
+		log.Printf("webhook: wv.Verify: %s", err)
+		httpError(w, err)
+		return
+	}
+	if err := wuc.oss.PaymentEvent(r.Context(), e); err != nil {
+		log.Printf("webhook: oss.PaymentEvent %s %s: %s", e.ID, e.Type, err)
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actions recorded in the audit trail.
+const (
+	AuditCreate          = "order.create"
+	AuditAddProducts     = "order.add_products"
+	AuditSetPayment      = "order.set_payment"
+	AuditDenyPayment     = "order.deny_payment"
+	AuditPay             = "order.pay"
+	AuditCancel          = "order.cancel"
+	AuditExpire          = "order.expire"
+	AuditStatus          = "order.status"
+	AuditRefund          = "order.refund"
+	AuditCancelProduct   = "product.cancel"
+	AuditCompleteProduct = "product.complete"
+	AuditDeliverProduct  = "product.deliver"
+)
+
+// Roles of the actors, the staff roles are bound to an establishment.
+const (
+	RoleCustomer = "customer"
+	RoleWaiter   = "waiter"
+	RoleCook     = "cook"
+	// RoleManager can override the rules applied to the staff of its
+	// establishment.
+	RoleManager = "manager"
+	// RoleAdmin is not bound to an establishment.
+	RoleAdmin = "admin"
+)
+
+// Actor is who made a request, the IDs are zero when unknown.
+type Actor struct {
+	UserID          uint64
+	EmployeeID      uint64
+	Role            string
+	EstablishmentID uint64
+}
+
+// IsManager reports whether the actor can override the rules applied to the staff.
+func (a Actor) IsManager() bool {
+	return a.EmployeeID != 0 && (a.Role == RoleManager || a.Role == RoleAdmin)
+}
+
+func (a Actor) IsAdmin() bool {
+	return a.Role == RoleAdmin
+}
+
+// InScope reports whether the actor can access an order of the user uID in
+// the establishment eID, the customers only access their own orders and the
+// staff the orders of their establishment. The delivery orders are assigned
+// to an establishment when they are paid, until then eID is zero and only the
+// managers access them.
+func (a Actor) InScope(uID, eID uint64) bool {
+	switch a.Role {
+	case RoleAdmin:
+		return true
+	case RoleCustomer:
+		return a.UserID != 0 && a.UserID == uID
+	case RoleManager:
+		return eID == 0 || a.EstablishmentID != 0 && a.EstablishmentID == eID
+	case RoleWaiter, RoleCook:
+		return a.EstablishmentID != 0 && a.EstablishmentID == eID
+	}
+	return false
+}
+
+type actorKey struct{}
+
+func ContextWithActor(c context.Context, a Actor) context.Context {
+	return context.WithValue(c, actorKey{}, a)
+}
+
+func ActorFromContext(c context.Context) Actor {
+	a, _ := c.Value(actorKey{}).(Actor)
+	return a
+}
+
+// AuditValues are the fields of an order or product changed by an action.
+type AuditValues map[string]interface{}
+
+// OrderAudit is a row of the append only audit trail, OrderProductID is zero
+// when the action changed the whole order.
+type OrderAudit struct {
+	ID             uint64 `gorm:"primarykey"`
+	OrderID        uint64 `gorm:"index"`
+	OrderProductID uint64
+	UserID         uint64
+	EmployeeID     uint64
+	Action         string
+	OldValue       string `gorm:"type:jsonb"`
+	NewValue       string `gorm:"type:jsonb"`
+	CreatedAt      time.Time
+}
+
+func (OrderAudit) TableName() string {
+	return "order_audit"
+}
+
+// NewOrderAudit returns the audit of an action made by the actor of c.
+func NewOrderAudit(c context.Context, action string, oID, opID uint64, old, new AuditValues) (OrderAudit, error) {
+	ov, err := marshalValues(old)
+	if err != nil {
+		return OrderAudit{}, fmt.Errorf("old value: %w", err)
+	}
+	nv, err := marshalValues(new)
+	if err != nil {
+		return OrderAudit{}, fmt.Errorf("new value: %w", err)
+	}
+	a := ActorFromContext(c)
+	return OrderAudit{
+		OrderID:        oID,
+		OrderProductID: opID,
+		UserID:         a.UserID,
+		EmployeeID:     a.EmployeeID,
+		Action:         action,
+		OldValue:       ov,
+		NewValue:       nv,
+	}, nil
+}
+
+func marshalValues(v AuditValues) (string, error) {
+	if v == nil {
+		v = AuditValues{}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,58 @@
+package model
+
+import "fmt"
+
+const (
+	CustomerRequest CancelReason = iota + 1
+	OutOfStock
+	KitchenError
+	DuplicateOrder
+	PaymentIssue
+	OtherReason
+)
+
+// CancelReason is the code of why an order or product was cancelled.
+type CancelReason uint32
+
+var cancelReasonNames = map[CancelReason]string{
+	CustomerRequest: "CUSTOMER_REQUEST",
+	OutOfStock:      "OUT_OF_STOCK",
+	KitchenError:    "KITCHEN_ERROR",
+	DuplicateOrder:  "DUPLICATE_ORDER",
+	PaymentIssue:    "PAYMENT_ISSUE",
+	OtherReason:     "OTHER",
+}
+
+func (r CancelReason) String() string {
+	if n, ok := cancelReasonNames[r]; ok {
+		return n
+	}
+	return fmt.Sprintf("REASON(%d)", uint32(r))
+}
+
+func (r CancelReason) IsValid() bool {
+	_, ok := cancelReasonNames[r]
+	return ok
+}
+
+// Cancellation is why an order or product is cancelled, Note is free text.
+type Cancellation struct {
+	Reason CancelReason
+	Note   string
+}
+
+// CancelRequest cancels whole orders and single products of other orders.
+// Override is only allowed to managers, it also cancels products that are
+// ready or delivered and orders that are ready or on their way.
+type CancelRequest struct {
+	OrderIDs   []uint64
+	ProductIDs []uint64
+	Cancellation
+	Override bool
+}
+
+// CancelResult are the orders and products cancelled.
+type CancelResult struct {
+	OrderIDs   []uint64
+	ProductIDs []uint64
+}
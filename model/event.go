@@ -0,0 +1,103 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Types of the events published for the other services.
+const (
+	EventOrderCreated   = "order.created"
+	EventProductsAdded  = "order.products_added"
+	EventOrderPaid      = "order.paid"
+	EventOrderCompleted = "order.completed"
+	EventOrderCancelled = "order.cancelled"
+	// EventProductsCancelled is published when some products of an order are voided.
+	EventProductsCancelled = "order.products_cancelled"
+	EventOrderRefunded     = "order.refunded"
+	// EventOrderPartiallyRefunded is published for every refund that does not
+	// return all the money captured.
+	EventOrderPartiallyRefunded = "order.partially_refunded"
+	EventOrderExpired           = "order.expired"
+	EventStatusChanged          = "order.status_changed"
+)
+
+// StatusEvent returns the type of the event of an order that moved to status s.
+func StatusEvent(s Status) string {
+	switch s {
+	case Paid:
+		return EventOrderPaid
+	case Closed:
+		return EventOrderCompleted
+	case Cancelled:
+		return EventOrderCancelled
+	case Refunded:
+		return EventOrderRefunded
+	case PartiallyRefunded:
+		return EventOrderPartiallyRefunded
+	case Expired:
+		return EventOrderExpired
+	}
+	return EventStatusChanged
+}
+
+// OrderEvent is a row of the outbox, it is stored in the same transaction as
+// the change it describes and published later at least once. EventID does not
+// change between attempts so the consumers can discard duplicates.
+type OrderEvent struct {
+	ID          uint64 `gorm:"primarykey"`
+	EventID     string `gorm:"size:36;uniqueIndex"`
+	OrderID     uint64 `gorm:"index"`
+	Type        string
+	Payload     string `gorm:"type:jsonb"`
+	CreatedAt   time.Time
+	PublishedAt *time.Time `gorm:"index"`
+	Attempts    uint32
+}
+
+// OrderEventPayload is the state of the order after the change.
+type OrderEventPayload struct {
+	OrderID         uint64 `json:"order_id"`
+	Type            Type   `json:"type"`
+	UserID          uint64 `json:"user_id,omitempty"`
+	EmployeeID      uint64 `json:"employee_id,omitempty"`
+	EstablishmentID uint64 `json:"establishment_id,omitempty"`
+	Status          string `json:"status"`
+	Total           string `json:"total"`
+	Currency        string `json:"currency"`
+}
+
+// NewOrderEvent returns an event of type t with a new EventID.
+func NewOrderEvent(t string, o Order) (OrderEvent, error) {
+	p, err := json.Marshal(OrderEventPayload{
+		OrderID:         o.ID,
+		Type:            o.TypeID,
+		UserID:          o.UserID,
+		EmployeeID:      o.EmployeeID,
+		EstablishmentID: o.EstablishmentID,
+		Status:          o.StatusID.String(),
+		Total:           o.Total.String(),
+		Currency:        o.Total.currency(),
+	})
+	if err != nil {
+		return OrderEvent{}, fmt.Errorf("marshal payload: %w", err)
+	}
+	id, err := NewUUID()
+	if err != nil {
+		return OrderEvent{}, err
+	}
+	return OrderEvent{EventID: id, OrderID: o.ID, Type: t, Payload: string(p)}, nil
+}
+
+// NewUUID returns a random UUID.
+func NewUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("read random: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
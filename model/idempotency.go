@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// IdempotencyKey is a request that the client identified with Key, the
+// response of the first request is returned to the requests repeated with the
+// same Key in the same Scope until ExpiresAt. Hash is the digest of the request
+// and CompletedAt is nil while the first request runs.
+type IdempotencyKey struct {
+	Key         string `gorm:"primarykey;size:255"`
+	Scope       string `gorm:"primarykey;size:255"`
+	Hash        string `gorm:"size:64;not null"`
+	Response    []byte
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	ExpiresAt   time.Time `gorm:"index;not null"`
+}
@@ -0,0 +1,43 @@
+package model
+
+import "fmt"
+
+const (
+	ProductAdded KitchenEventKind = iota + 1
+	ProductUpdated
+	ProductCancelled
+)
+
+type KitchenEventKind uint32
+
+func (k KitchenEventKind) String() string {
+	switch k {
+	case ProductAdded:
+		return "ADDED"
+	case ProductUpdated:
+		return "UPDATED"
+	case ProductCancelled:
+		return "CANCELLED"
+	}
+	return fmt.Sprintf("KIND(%d)", uint32(k))
+}
+
+// KitchenProduct is an order product with the data of its order needed to
+// route it to a kitchen and to the waiter of the table.
+type KitchenProduct struct {
+	OrderProduct    `gorm:"embedded"`
+	EstablishmentID uint64
+	EmployeeID      uint64
+	TableID         uint64
+	StatusID        Status
+}
+
+// KitchenEvent is a change of a product shown in the kitchen of an
+// establishment or to a waiter. Cursor increases with every event and is
+// used to resume a feed.
+type KitchenEvent struct {
+	Cursor  uint64
+	Kind    KitchenEventKind
+	TableID uint64
+	Product OrderProduct
+}
@@ -6,12 +6,6 @@ import (
 	"gorm.io/gorm"
 )
 
-const (
-	WithoutPay Status = iota + 1
-	Pending
-	Completed
-)
-
 const (
 	Local Type = iota + 1
 	Delivery
@@ -24,8 +18,6 @@ const (
 
 type PaymentMethod uint32
 
-type Status uint32
-
 type Type uint32
 
 type Model struct {
@@ -36,30 +28,95 @@ type Model struct {
 }
 
 type Product struct {
-	Price float64
+	ID    uint64
+	Price Money
 	Name  string
 }
 
+// Order is an order of a table or a delivery. PayRequestID is the key of the
+// online payment being created, it is kept until PayID is stored so a retry
+// gets the same payment. Currency is the currency of every amount of the
+// order, Tip is the rate of the total given as tip.
 type Order struct {
 	Model
 	TypeID          Type
-	UserID          uint64
-	EmployeeID      uint64
-	EstablishmentID uint64
-	TableID         uint64
-	AddressID       *string
+	UserID          uint64  `gorm:"index"`
+	EmployeeID      uint64  `gorm:"index"`
+	EstablishmentID uint64  `gorm:"index:idx_orders_establishment_table,priority:1"`
+	TableID         uint64  `gorm:"index:idx_orders_establishment_table,priority:2"`
+	AddressID       *string `gorm:"index"`
 	StatusID        Status
-	Total           float64
-	PayID           *string
-	Tip             float32 `gorm:"not null;default:0;"`
+	Total           Money
+	PayID           *string `gorm:"index"`
+	PayRequestID    *string
+	PaymentMethod   PaymentMethod
+	CaptureID       *string
+	Currency        string  `gorm:"size:3;not null;default:MXN"`
+	Captured        Money   `gorm:"not null;default:0;"`
+	Refunded        Money   `gorm:"not null;default:0;"`
+	Tip             TipRate `gorm:"not null;default:0;"`
+	CancelReason    CancelReason
+	CancelNote      string
 	OrderProducts   []OrderProduct
 }
 
 type OrderProduct struct {
-	ID          uint64 `gorm:"primarykey" json:"id"`
-	OrderID     uint64
-	ProductID   uint64
-	Quantity    uint32
-	IsReady     bool
-	IsDelivered bool
+	ID           uint64 `gorm:"primarykey" json:"id"`
+	OrderID      uint64 `gorm:"index"`
+	ProductID    uint64 `gorm:"index"`
+	Name         string
+	UnitPrice    Money
+	Quantity     uint32
+	Subtotal     Money
+	Currency     string `gorm:"size:3;not null;default:MXN"`
+	IsReady      bool
+	IsDelivered  bool
+	IsCancelled  bool
+	CancelReason CancelReason
+	CancelNote   string
+}
+
+// BeforeCreate stores the currency of the total.
+func (o *Order) BeforeCreate(tx *gorm.DB) error {
+	o.Currency = o.Total.currency()
+	return nil
+}
+
+// AfterFind sets the stored currency to the amounts, the rows read without it
+// keep MXN.
+func (o *Order) AfterFind(tx *gorm.DB) error {
+	o.Total = o.Total.WithCurrency(o.Currency)
+	o.Captured = o.Captured.WithCurrency(o.Currency)
+	o.Refunded = o.Refunded.WithCurrency(o.Currency)
+	return nil
+}
+
+// BeforeCreate stores the currency of the price.
+func (p *OrderProduct) BeforeCreate(tx *gorm.DB) error {
+	p.Currency = p.UnitPrice.currency()
+	return nil
+}
+
+// AfterFind sets the stored currency to the amounts, the rows read without it
+// keep MXN.
+func (p *OrderProduct) AfterFind(tx *gorm.DB) error {
+	p.UnitPrice = p.UnitPrice.WithCurrency(p.Currency)
+	p.Subtotal = p.Subtotal.WithCurrency(p.Currency)
+	return nil
+}
+
+// ProductsTotal returns the sum of the price snapshot of every product that
+// is not cancelled.
+func ProductsTotal(ps []OrderProduct) (Money, error) {
+	t := Money{Currency: MXN}
+	var err error
+	for i := range ps {
+		if ps[i].IsCancelled {
+			continue
+		}
+		if t, err = t.Add(ps[i].Subtotal); err != nil {
+			return Money{}, err
+		}
+	}
+	return t, nil
 }
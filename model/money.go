@@ -0,0 +1,277 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/modular-project/orders-service/apperr"
+)
+
+// MXN is the currency used when none is given.
+const MXN = "MXN"
+
+// minorUnits is the number of minor units of a major unit, every supported
+// currency uses cents.
+const minorUnits = 100
+
+// amountDigits is the precision of the stored amounts, the last two digits
+// are the minor units.
+const amountDigits = 12
+
+// MaxAmount is the highest amount stored, 9999999999.99 in any currency.
+var MaxAmount = Money{Amount: int64(math.Pow10(amountDigits)) - 1}
+
+var ErrCurrencyMismatch = apperr.New(apperr.ErrInvalidArgument, "currency mismatch")
+
+// Money is an exact amount expressed in minor units (cents) of a currency.
+// It is stored as NUMERIC(12,2) so the database keeps the decimal value.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney returns an amount of cents in the given currency.
+func NewMoney(cents int64, currency string) Money {
+	return Money{Amount: cents, Currency: currency}
+}
+
+// MoneyFromFloat rounds v to the nearest cent, it must only be used at the
+// boundaries where amounts arrive as floats.
+func MoneyFromFloat(v float64, currency string) Money {
+	return Money{Amount: int64(math.Round(v * minorUnits)), Currency: currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return MXN
+	}
+	return m.Currency
+}
+
+// SameCurrency reports whether both amounts use the same currency.
+func (m Money) SameCurrency(o Money) bool {
+	return m.currency() == o.currency()
+}
+
+// Add returns m + o, o must be in the same currency as m.
+func (m Money) Add(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency(), o.currency())
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currency()}, nil
+}
+
+// Mul returns m multiplied by q.
+func (m Money) Mul(q int64) Money {
+	return Money{Amount: m.Amount * q, Currency: m.currency()}
+}
+
+// Float64 returns the amount in major units, it is only meant for clients
+// that do not support exact amounts.
+func (m Money) Float64() float64 {
+	return float64(m.Amount) / minorUnits
+}
+
+// String returns the amount in major units with two decimals, e.g. "155055.34".
+func (m Money) String() string {
+	a := m.Amount
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, a/minorUnits, a%minorUnits)
+}
+
+// ParseMoney parses a decimal amount with an optional sign and at most two
+// decimals, like "-12.5" or "155055.34".
+func ParseMoney(s, currency string) (Money, error) {
+	invalid := apperr.New(apperr.ErrInvalidArgument, fmt.Sprintf("amount %q must be a number with at most two decimals", s))
+	d := strings.TrimSpace(s)
+	neg := false
+	if d != "" && (d[0] == '-' || d[0] == '+') {
+		neg, d = d[0] == '-', d[1:]
+	}
+	ip, fp := d, ""
+	if i := strings.IndexByte(d, '.'); i >= 0 {
+		ip, fp = d[:i], d[i+1:]
+		if fp == "" {
+			return Money{}, invalid
+		}
+	}
+	if !isDigits(ip) || len(fp) > 2 || (fp != "" && !isDigits(fp)) {
+		return Money{}, invalid
+	}
+	tooLarge := apperr.New(apperr.ErrInvalidArgument, fmt.Sprintf("amount %q must not exceed %s", s, MaxAmount))
+	units, err := strconv.ParseInt(ip, 10, 64)
+	if err != nil || units > MaxAmount.Amount/minorUnits {
+		return Money{}, tooLarge
+	}
+	for len(fp) < 2 {
+		fp += "0"
+	}
+	cents, err := strconv.ParseInt(fp, 10, 64)
+	if err != nil {
+		return Money{}, invalid
+	}
+	a := units*minorUnits + cents
+	if a > MaxAmount.Amount {
+		return Money{}, tooLarge
+	}
+	if neg {
+		a = -a
+	}
+	return Money{Amount: a, Currency: currency}, nil
+}
+
+// isDigits reports whether s is a non empty sequence of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (Money) GormDataType() string {
+	return fmt.Sprintf("numeric(%d,2)", amountDigits)
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads an amount in MXN, the rows that store their currency set it
+// once they are found.
+func (m *Money) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*m = Money{Currency: MXN}
+	case []byte:
+		*m, err = ParseMoney(string(v), MXN)
+	case string:
+		*m, err = ParseMoney(v, MXN)
+	case int64:
+		*m = Money{Amount: v * minorUnits, Currency: MXN}
+	case float64:
+		*m = MoneyFromFloat(v, MXN)
+	default:
+		return fmt.Errorf("can not scan %T into Money", src)
+	}
+	return err
+}
+
+// WithCurrency returns m in currency, an empty currency keeps the one of m.
+func (m Money) WithCurrency(currency string) Money {
+	if currency != "" {
+		m.Currency = currency
+	}
+	return m
+}
+
+// basisPoints is the number of basis points of a whole.
+const basisPoints = 10000
+
+// TipRate is a tip as a fraction of the total kept in basis points, 1500 is a
+// tip of 15%. It is stored as NUMERIC(5,4) so the database keeps it exact.
+type TipRate int64
+
+// NewTipRate rounds the fraction f to the nearest basis point, it must only
+// be used at the boundaries where rates arrive as floats.
+func NewTipRate(f float32) TipRate {
+	return TipRate(math.Round(float64(f) * basisPoints))
+}
+
+// Of returns the tip of the amount m rounded half away from zero to the cent,
+// as the database rounds it.
+func (r TipRate) Of(m Money) Money {
+	a := m.Amount * int64(r)
+	q, rem := a/basisPoints, a%basisPoints
+	switch {
+	case rem*2 >= basisPoints:
+		q++
+	case rem*2 <= -basisPoints:
+		q--
+	}
+	return Money{Amount: q, Currency: m.currency()}
+}
+
+// String returns the rate with four decimals, e.g. "0.1500".
+func (r TipRate) String() string {
+	sign := ""
+	if r < 0 {
+		sign = "-"
+		r = -r
+	}
+	return fmt.Sprintf("%s%d.%04d", sign, r/basisPoints, r%basisPoints)
+}
+
+func (TipRate) GormDataType() string {
+	return "numeric(5,4)"
+}
+
+func (r TipRate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+func (r *TipRate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*r = 0
+	case []byte:
+		return r.parse(string(v))
+	case string:
+		return r.parse(v)
+	case int64:
+		*r = TipRate(v * basisPoints)
+	case float64:
+		*r = TipRate(math.Round(v * basisPoints))
+	default:
+		return fmt.Errorf("can not scan %T into TipRate", src)
+	}
+	return nil
+}
+
+// parse reads a rate like "0.15" or "-0.15" with at most four decimals.
+func (r *TipRate) parse(s string) error {
+	d := s
+	neg := false
+	if d != "" && d[0] == '-' {
+		neg, d = true, d[1:]
+	}
+	ip, fp := d, ""
+	if i := strings.IndexByte(d, '.'); i >= 0 {
+		ip, fp = d[:i], d[i+1:]
+	}
+	if !isDigits(ip) || len(fp) > 4 || (fp != "" && !isDigits(fp)) {
+		return fmt.Errorf("tip rate %q must be a number with at most four decimals", s)
+	}
+	for len(fp) < 4 {
+		fp += "0"
+	}
+	u, err := strconv.ParseInt(ip, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse tip rate %q: %w", s, err)
+	}
+	f, err := strconv.ParseInt(fp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse tip rate %q: %w", s, err)
+	}
+	*r = TipRate(u*basisPoints + f)
+	if neg {
+		*r = -*r
+	}
+	return nil
+}
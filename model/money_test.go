@@ -0,0 +1,119 @@
+package model
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		give    string
+		want    int64
+		wantStr string
+		wantErr bool
+	}{
+		{give: "155055.34", want: 15505534, wantStr: "155055.34"},
+		{give: "12", want: 1200, wantStr: "12.00"},
+		{give: "12.5", want: 1250, wantStr: "12.50"},
+		{give: "-0.5", want: -50, wantStr: "-0.50"},
+		{give: "+7.05", want: 705, wantStr: "7.05"},
+		{give: "9999999999.99", want: 999999999999, wantStr: "9999999999.99"},
+		{give: "-9999999999.99", want: -999999999999, wantStr: "-9999999999.99"},
+		{give: "10000000000", wantErr: true},
+		{give: "99999999999999999999", wantErr: true},
+		{give: ".99", wantErr: true},
+		{give: "10.125", wantErr: true},
+		{give: "10.12a", wantErr: true},
+		{give: "1.-5", wantErr: true},
+		{give: "1.", wantErr: true},
+		{give: "--1", wantErr: true},
+		{give: "", wantErr: true},
+		{give: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.give, func(t *testing.T) {
+			got, err := ParseMoney(tt.give, MXN)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMoney() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Amount != tt.want {
+				t.Errorf("ParseMoney() = %d, want %d", got.Amount, tt.want)
+			}
+			if got.String() != tt.wantStr {
+				t.Errorf("Money.String() = %s, want %s", got, tt.wantStr)
+			}
+		})
+	}
+}
+
+func TestMoney_Add(t *testing.T) {
+	a := NewMoney(15505534, MXN)
+	got, err := a.Add(NewMoney(66, MXN))
+	if err != nil {
+		t.Fatalf("Money.Add() error = %v", err)
+	}
+	if got.String() != "155056.00" {
+		t.Errorf("Money.Add() = %s, want 155056.00", got)
+	}
+	if _, err := a.Add(NewMoney(1, "USD")); err == nil {
+		t.Errorf("Money.Add() with different currency, want error")
+	}
+}
+
+func TestTipRate_Of(t *testing.T) {
+	tests := []struct {
+		name string
+		rate TipRate
+		give Money
+		want int64
+	}{
+		{name: "exact", rate: NewTipRate(0.15), give: NewMoney(10000, MXN), want: 1500},
+		{name: "rounded down", rate: NewTipRate(0.1), give: NewMoney(15505534, MXN), want: 1550553},
+		{name: "half rounded up", rate: 1250, give: NewMoney(1004, MXN), want: 126},
+		{name: "half rounded down when negative", rate: 1250, give: NewMoney(-1004, MXN), want: -126},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rate.Of(tt.give); got.Amount != tt.want {
+				t.Errorf("TipRate.Of() = %d, want %d", got.Amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestTipRate_Scan(t *testing.T) {
+	var r TipRate
+	if err := r.Scan([]byte("0.1500")); err != nil {
+		t.Fatalf("TipRate.Scan() error = %v", err)
+	}
+	if r != 1500 || r.String() != "0.1500" {
+		t.Errorf("TipRate.Scan() = %s, want 0.1500", r)
+	}
+	if err := r.Scan("0.15a"); err == nil {
+		t.Errorf("TipRate.Scan() of 0.15a, want error")
+	}
+}
+
+func TestTipRate_String(t *testing.T) {
+	tests := []struct {
+		give TipRate
+		want string
+	}{
+		{give: 0, want: "0.0000"},
+		{give: 1500, want: "0.1500"},
+		{give: 12500, want: "1.2500"},
+		{give: -1500, want: "-0.1500"},
+		{give: -12500, want: "-1.2500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.give.String(); got != tt.want {
+				t.Errorf("TipRate.String() = %s, want %s", got, tt.want)
+			}
+			var r TipRate
+			if err := r.Scan(tt.want); err != nil || r != tt.give {
+				t.Errorf("TipRate.Scan(%s) = %d, %v, want %d", tt.want, r, err, tt.give)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"gorm.io/gorm"
+)
+
+// PaymentStatus is the status of a payment in its gateway, every gateway maps
+// its own statuses to these.
+type PaymentStatus string
+
+const (
+	// PaymentCreated is waiting for the payer to approve it.
+	PaymentCreated PaymentStatus = "CREATED"
+	// PaymentApproved was approved by the payer and can be captured.
+	PaymentApproved PaymentStatus = "APPROVED"
+	// PaymentCompleted was captured.
+	PaymentCompleted PaymentStatus = "COMPLETED"
+	// PaymentVoided can no longer be captured.
+	PaymentVoided PaymentStatus = "VOIDED"
+)
+
+// Payment is a payment as it is known by its gateway, CaptureID is empty
+// until it is captured.
+type Payment struct {
+	ID        string
+	Status    PaymentStatus
+	Amount    Money
+	CaptureID string
+}
+
+// PaymentEventType is the type of the notifications of the payment gateway.
+type PaymentEventType string
+
+const (
+	// PaymentEventApproved means the payer approved the payment PayID.
+	PaymentEventApproved PaymentEventType = "CHECKOUT.ORDER.APPROVED"
+	// PaymentEventCaptured means the payment PayID was captured as CaptureID.
+	PaymentEventCaptured PaymentEventType = "PAYMENT.CAPTURE.COMPLETED"
+	// PaymentEventDenied means the capture of the payment PayID was denied.
+	PaymentEventDenied PaymentEventType = "PAYMENT.CAPTURE.DENIED"
+	// PaymentEventRefunded means Amount of the capture CaptureID was returned
+	// as the refund RefundID.
+	PaymentEventRefunded PaymentEventType = "PAYMENT.CAPTURE.REFUNDED"
+)
+
+// PaymentEvent is a notification sent by the payment gateway, ID is the ID
+// given by the gateway and it is stored to process every notification once.
+type PaymentEvent struct {
+	ID        string `gorm:"primarykey"`
+	Type      PaymentEventType
+	PayID     string `gorm:"index"`
+	CaptureID string
+	RefundID  string
+	Amount    Money
+	Currency  string `gorm:"size:3;not null;default:MXN"`
+	CreatedAt time.Time
+}
+
+// BeforeCreate stores the currency of the amount.
+func (e *PaymentEvent) BeforeCreate(tx *gorm.DB) error {
+	e.Currency = e.Amount.currency()
+	return nil
+}
+
+// AfterFind sets the stored currency to the amount.
+func (e *PaymentEvent) AfterFind(tx *gorm.DB) error {
+	e.Amount = e.Amount.WithCurrency(e.Currency)
+	return nil
+}
+
+// ErrPaymentEventProcessed is returned when a payment event is stored twice.
+var ErrPaymentEventProcessed = apperr.New(apperr.ErrConflict, "payment event already processed")
+
+var paymentMethodNames = map[PaymentMethod]string{
+	CASH:   "CASH",
+	PAYPAL: "PAYPAL",
+}
+
+func (pm PaymentMethod) String() string {
+	if n, ok := paymentMethodNames[pm]; ok {
+		return n
+	}
+	return fmt.Sprintf("PAYMENT_METHOD(%d)", uint32(pm))
+}
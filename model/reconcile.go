@@ -0,0 +1,41 @@
+package model
+
+// DiscrepancyKind is how an order differs from its payment in the gateway.
+type DiscrepancyKind string
+
+const (
+	// DiscrepancyCaptured is a payment captured that was not recorded, the
+	// order is moved to Paid.
+	DiscrepancyCaptured DiscrepancyKind = "CAPTURED_NOT_RECORDED"
+	// DiscrepancyApproved is a payment approved that was not captured, it is
+	// captured.
+	DiscrepancyApproved DiscrepancyKind = "APPROVED_NOT_CAPTURED"
+	// DiscrepancyVoided is a payment that can no longer be captured, the order
+	// expires.
+	DiscrepancyVoided DiscrepancyKind = "PAYMENT_VOIDED"
+	// DiscrepancyMissing is a payment unknown to the gateway, usually because
+	// it expired, the order expires.
+	DiscrepancyMissing DiscrepancyKind = "PAYMENT_NOT_FOUND"
+	// DiscrepancyAmount is a payment of another amount than the order, the
+	// order is left to be checked by hand.
+	DiscrepancyAmount DiscrepancyKind = "AMOUNT_MISMATCH"
+	// DiscrepancyFailed is an order that could not be reconciled.
+	DiscrepancyFailed DiscrepancyKind = "FAILED"
+)
+
+// Discrepancy is an order waiting for a payment that its gateway reports in
+// another status.
+type Discrepancy struct {
+	OrderID uint64          `json:"order_id"`
+	PayID   string          `json:"pay_id"`
+	Kind    DiscrepancyKind `json:"kind"`
+	Payment PaymentStatus   `json:"payment_status,omitempty"`
+	Detail  string          `json:"detail,omitempty"`
+}
+
+// ReconcileReport is the result of checking Checked orders against their
+// payment gateway.
+type ReconcileReport struct {
+	Checked       int           `json:"checked"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Capture is a payment collected by the payment provider.
+type Capture struct {
+	ID     string
+	Status string
+	Amount Money
+}
+
+const (
+	// RefundPending is a refund sent to the payment provider that is not
+	// stored as completed yet.
+	RefundPending = "PENDING"
+	// RefundFailed is a refund the payment provider declined.
+	RefundFailed = "FAILED"
+)
+
+// Refund is money returned of a paid order, ProviderID is nil when the order
+// was paid in cash. The sum of the completed refunds of an order is kept in
+// Order.Refunded and can not exceed Order.Captured, the money collected.
+// RequestID is the key the refund is sent to the payment provider with, so a
+// pending refund that is sent again is not made twice.
+type Refund struct {
+	ID         uint64 `gorm:"primarykey"`
+	OrderID    uint64 `gorm:"index;not null"`
+	Amount     Money
+	Currency   string `gorm:"size:3;not null;default:MXN"`
+	Reason     string
+	ProviderID *string `gorm:"index"`
+	RequestID  *string `gorm:"uniqueIndex"`
+	Status     string
+	EmployeeID uint64
+	CreatedAt  time.Time
+}
+
+// BeforeCreate stores the currency of the amount.
+func (rf *Refund) BeforeCreate(tx *gorm.DB) error {
+	rf.Currency = rf.Amount.currency()
+	return nil
+}
+
+// AfterFind sets the stored currency to the amount.
+func (rf *Refund) AfterFind(tx *gorm.DB) error {
+	rf.Amount = rf.Amount.WithCurrency(rf.Currency)
+	return nil
+}
+
+// RefundRequest returns Amount of the order OrderID, a zero Amount refunds
+// everything that was captured and not refunded yet.
+type RefundRequest struct {
+	OrderID uint64
+	Amount  Money
+	Reason  string
+}
@@ -1,6 +1,29 @@
 package model
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+)
+
+// DateLayout is the layout of the local dates of the searches.
+const DateLayout = "2006-01-02"
+
+// ParseDate parses s as an RFC 3339 timestamp or a local date like
+// DateLayout, date reports whether s is a local date, which is returned at
+// midnight in UTC.
+func ParseDate(s string) (t time.Time, date bool, err error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse(DateLayout, s)
+	if err != nil {
+		return time.Time{}, false, apperr.New(apperr.ErrInvalidArgument, fmt.Sprintf("%q must be a date like %s or an RFC 3339 timestamp", s, DateLayout))
+	}
+	return t, true, nil
+}
 
 const (
 	ASC Sort = iota
@@ -28,40 +51,123 @@ type Search struct {
 
 	Limit  int `json:"limit,omitempty"`
 	Offset int `json:"offset,omitempty"`
+	// After is the cursor of the last order of the previous page, Offset is
+	// ignored when it is set.
+	After *Cursor `json:"-"`
+	// Total asks to count every order found besides the page.
+	Total bool `json:"-"`
+}
+
+// Cursor is the position of an order in the results of a search, the value
+// of its sort keys in the order of Sorts and its ID.
+type Cursor struct {
+	Keys []int64 `json:"k"`
+	ID   uint64  `json:"id"`
+}
+
+// OrderPage is a page of the orders found by a search.
+type OrderPage struct {
+	Orders []Order
+	// Next is the cursor of the last order when more orders follow.
+	Next *Cursor
+	// NextToken is Next signed for the clients.
+	NextToken string
+	// Total is the number of orders found, only counted when requested.
+	Total int64
+}
+
+const (
+	AnyTip TipFilter = iota
+	WithTip
+	WithoutTip
+)
+
+type TipFilter uint32
+
+// Filter is a group of conditions of a search, an order matches it when it
+// meets all of them.
+type Filter struct {
+	Status    []Status `json:"status"`
+	Types     []Type
+	Employees []uint64        `json:"employees,omitempty"`
+	Tables    []uint64        `json:"tables,omitempty"`
+	Payments  []PaymentMethod `json:"payments,omitempty"`
+	PayIDs    []string        `json:"pay_ids,omitempty"`
+	// Products are the products of which the order has at least one that is
+	// not cancelled.
+	Products  []uint64  `json:"products,omitempty"`
+	Tip       TipFilter `json:"tip,omitempty"`
+	Addresses []string  `json:"addresses,omitempty"`
+	// From and To bound the creation time of the orders, To is excluded.
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+	// Text are the words all found in the cancel note of the order or in the
+	// name of one of its products.
+	Text string `json:"text,omitempty"`
+}
+
+// IsZero reports whether f has no conditions, it matches every order.
+func (f Filter) IsZero() bool {
+	return len(f.Status) == 0 && len(f.Types) == 0 && len(f.Employees) == 0 && len(f.Tables) == 0 &&
+		len(f.Payments) == 0 && len(f.PayIDs) == 0 && len(f.Products) == 0 && f.Tip == AnyTip &&
+		len(f.Addresses) == 0 && f.From == nil && f.To == nil && f.Text == ""
+}
+
+// DayRange bounds the creation time of the orders of the establishments in
+// Ests, or of every establishment but the ones in Except when Ests is nil. To
+// is excluded.
+type DayRange struct {
+	Ests   []uint64  `json:"ests,omitempty"`
+	Except []uint64  `json:"except,omitempty"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
 }
 
 type SearchOrder struct {
 	Search
-	Status []Status `json:"status"`
+	Filter
 	Ests   []uint64 `json:"ests,omitempty"`
 	Users  []uint64
-	Types  []Type
-	Lower  float64
-	Higher float64
-	Start  string
-	End    string
+	Lower  Money
+	Higher Money
+	// Start and End are local dates or RFC 3339 timestamps, they are
+	// resolved to Days with the time zone of the establishments. An End date
+	// is included and an End timestamp is excluded.
+	Start string
+	End   string
+	// Days are the ranges of which the orders match one.
+	Days []DayRange `json:"days,omitempty"`
+	// Any are groups of conditions of which the orders match at least one,
+	// besides the rest of the search.
+	Any []Filter `json:"any,omitempty"`
 }
 
-func (o OrderBy) get() string {
-	var sort string
-	var order string
-	var b strings.Builder
-
-	switch o.By {
+// Column returns the column of the orders sorted by b, an empty string for
+// an unknown b.
+func (b By) Column() string {
+	switch b {
 	case DATE:
-		order = "created_at"
+		return "created_at"
 	case TYPE:
-		order = "type_id"
+		return "type_id"
 	case PRICE:
-		order = "total"
+		return "total"
 	case EST:
-		order = "establishment_id"
+		return "establishment_id"
 	case STATUS:
-		order = "status_id"
-	default:
-		return ""
+		return "status_id"
 	}
+	return ""
+}
+
+func (o OrderBy) get() string {
+	var sort string
+	var b strings.Builder
 
+	order := o.By.Column()
+	if order == "" {
+		return ""
+	}
 	if o.Sort == DES {
 		sort = " DESC"
 	}
@@ -81,3 +187,67 @@ func (s Search) Query() string {
 	}
 	return q.String()
 }
+
+// Sorts returns the known sorts of the search.
+func (s Search) Sorts() []OrderBy {
+	var obs []OrderBy
+	for _, o := range s.OrderBys {
+		if o.By.Column() != "" {
+			obs = append(obs, o)
+		}
+	}
+	return obs
+}
+
+// Key returns the value of the sort key of o by b, the dates are kept in
+// microseconds like in the database.
+func (o Order) Key(b By) int64 {
+	switch b {
+	case DATE:
+		return o.CreatedAt.UnixNano() / int64(time.Microsecond)
+	case PRICE:
+		return o.Total.Amount
+	case EST:
+		return int64(o.EstablishmentID)
+	case STATUS:
+		return int64(o.StatusID)
+	case TYPE:
+		return int64(o.TypeID)
+	}
+	return 0
+}
+
+// Cursor returns the position of o in the results of the search.
+func (s Search) Cursor(o Order) Cursor {
+	obs := s.Sorts()
+	c := Cursor{Keys: make([]int64, len(obs)), ID: o.ID}
+	for i, ob := range obs {
+		c.Keys[i] = o.Key(ob.By)
+	}
+	return c
+}
+
+// Compare returns -1, 0 or 1 when a goes before, with or after b in the
+// results of the search, the ties are sorted by ID in the order id.
+func (s Search) Compare(a, b Cursor, id Sort) int {
+	for i, ob := range s.Sorts() {
+		if c := compareKeys(a.Keys[i], b.Keys[i], ob.Sort); c != 0 {
+			return c
+		}
+	}
+	return compareKeys(int64(a.ID), int64(b.ID), id)
+}
+
+func compareKeys(a, b int64, s Sort) int {
+	c := 0
+	switch {
+	case a < b:
+		c = -1
+	case a > b:
+		c = 1
+	}
+	if s == DES {
+		return -c
+	}
+	return c
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/modular-project/orders-service/apperr"
+)
+
+// The numeric values are persisted in orders.status_id, the first three keep
+// the values of the former WithoutPay, Pending and Completed statuses.
+const (
+	AwaitingPayment Status = iota + 1
+	InPreparation
+	Paid
+	Draft
+	Ready
+	OutForDelivery
+	Delivered
+	Closed
+	Cancelled
+	Refunded
+	PartiallyRefunded
+	// Expired is an order that was not paid in time.
+	Expired
+)
+
+// ErrStatusChanged is returned by the storage when an order is no longer in
+// the status the caller expected.
+var ErrStatusChanged = apperr.New(apperr.ErrConflict, "order status changed")
+
+type Status uint32
+
+var statusNames = map[Status]string{
+	AwaitingPayment:   "AWAITING_PAYMENT",
+	InPreparation:     "IN_PREPARATION",
+	Paid:              "PAID",
+	Draft:             "DRAFT",
+	Ready:             "READY",
+	OutForDelivery:    "OUT_FOR_DELIVERY",
+	Delivered:         "DELIVERED",
+	Closed:            "CLOSED",
+	Cancelled:         "CANCELLED",
+	Refunded:          "REFUNDED",
+	PartiallyRefunded: "PARTIALLY_REFUNDED",
+	Expired:           "EXPIRED",
+}
+
+func (s Status) String() string {
+	if n, ok := statusNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("STATUS(%d)", uint32(s))
+}
+
+// IsValid reports whether s is a known status.
+func (s Status) IsValid() bool {
+	_, ok := statusNames[s]
+	return ok
+}
+
+// NotInKitchen are the statuses of orders whose products must not be prepared.
+var NotInKitchen = []Status{Draft, AwaitingPayment, Cancelled, Refunded, PartiallyRefunded, Expired}
+
+// InKitchen reports whether the products of an order in status s are shown
+// in the kitchen.
+func (s Status) InKitchen() bool {
+	for _, n := range NotInKitchen {
+		if s == n {
+			return false
+		}
+	}
+	return true
+}
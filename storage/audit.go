@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modular-project/orders-service/model"
+	"gorm.io/gorm"
+)
+
+// audit adds an action of the actor of ctx to the audit trail, it must be
+// called with the transaction of the change.
+func audit(ctx context.Context, tx *gorm.DB, action string, oID, opID uint64, old, new model.AuditValues) error {
+	a, err := model.NewOrderAudit(ctx, action, oID, opID, old, new)
+	if err != nil {
+		return fmt.Errorf("new audit: %w", err)
+	}
+	if err := tx.Create(&a).Error; err != nil {
+		return fmt.Errorf("create audit: %w", dbError(err))
+	}
+	return nil
+}
+
+// History returns the audit trail of the order from the oldest action.
+func (os OrderStorage) History(ctx context.Context, oID uint64) ([]model.OrderAudit, error) {
+	var as []model.OrderAudit
+	if err := os.db.WithContext(ctx).Where("order_id = ?", oID).Order("id").Find(&as).Error; err != nil {
+		return nil, fmt.Errorf("find audit: %w", dbError(err))
+	}
+	return as, nil
+}
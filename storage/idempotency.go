@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/modular-project/orders-service/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IdempotencyStorage struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyStorage(db *DB) IdempotencyStorage {
+	return IdempotencyStorage{db: db.db}
+}
+
+// Reserve stores k unless its key is stored and not expired, in which case
+// the stored key is returned and created is false.
+func (is IdempotencyStorage) Reserve(ctx context.Context, k *model.IdempotencyKey) (model.IdempotencyKey, bool, error) {
+	var got model.IdempotencyKey
+	created := false
+	err := is.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("key = ? AND scope = ? AND expires_at <= ?", k.Key, k.Scope, time.Now()).Delete(&model.IdempotencyKey{}).Error
+		if err != nil {
+			return fmt.Errorf("delete expired key: %w", dbError(err))
+		}
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(k)
+		if res.Error != nil {
+			return fmt.Errorf("create key: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 1 {
+			got, created = *k, true
+			return nil
+		}
+		if err := tx.Where("key = ? AND scope = ?", k.Key, k.Scope).First(&got).Error; err != nil {
+			return fmt.Errorf("first key: %w", dbError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return model.IdempotencyKey{}, false, err
+	}
+	return got, created, nil
+}
+
+// Complete stores the response of the request of the key.
+func (is IdempotencyStorage) Complete(ctx context.Context, key, scope string, resp []byte) error {
+	err := is.db.WithContext(ctx).Model(&model.IdempotencyKey{}).Where("key = ? AND scope = ?", key, scope).
+		Updates(map[string]interface{}{"response": resp, "completed_at": time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("update key: %w", dbError(err))
+	}
+	return nil
+}
+
+// Release deletes the key while its request is not completed so it can be
+// sent again.
+func (is IdempotencyStorage) Release(ctx context.Context, key, scope string) error {
+	err := is.db.WithContext(ctx).Where("key = ? AND scope = ? AND completed_at IS NULL", key, scope).
+		Delete(&model.IdempotencyKey{}).Error
+	if err != nil {
+		return fmt.Errorf("delete key: %w", dbError(err))
+	}
+	return nil
+}
+
+// Purge deletes the keys that expired before before and returns how many.
+func (is IdempotencyStorage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res := is.db.WithContext(ctx).Where("expires_at <= ?", before).Delete(&model.IdempotencyKey{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("delete keys: %w", dbError(res.Error))
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,936 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/model"
+	"gorm.io/gorm"
+)
+
+type memoryData struct {
+	orders      map[uint64]model.Order
+	products    map[uint64]model.OrderProduct
+	events      []model.OrderEvent
+	audits      []model.OrderAudit
+	refunds     []model.Refund
+	payEvents   map[string]model.PaymentEvent
+	keys        map[[2]string]model.IdempotencyKey
+	lastOrder   uint64
+	lastProduct uint64
+}
+
+func (d *memoryData) clone() *memoryData {
+	c := &memoryData{
+		orders:      make(map[uint64]model.Order, len(d.orders)),
+		products:    make(map[uint64]model.OrderProduct, len(d.products)),
+		events:      append([]model.OrderEvent(nil), d.events...),
+		audits:      append([]model.OrderAudit(nil), d.audits...),
+		refunds:     append([]model.Refund(nil), d.refunds...),
+		payEvents:   make(map[string]model.PaymentEvent, len(d.payEvents)),
+		keys:        make(map[[2]string]model.IdempotencyKey, len(d.keys)),
+		lastOrder:   d.lastOrder,
+		lastProduct: d.lastProduct,
+	}
+	for k, v := range d.payEvents {
+		c.payEvents[k] = v
+	}
+	for k, v := range d.keys {
+		c.keys[k] = v
+	}
+	for k, v := range d.orders {
+		c.orders[k] = v
+	}
+	for k, v := range d.products {
+		c.products[k] = v
+	}
+	return c
+}
+
+// MemoryStorage keeps the orders in memory with the same behavior as the
+// database storages, it implements both order storages and is meant for
+// tests and local development.
+type MemoryStorage struct {
+	mu   *sync.Mutex
+	data *memoryData
+	inTx bool
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		mu: &sync.Mutex{},
+		data: &memoryData{
+			orders:    make(map[uint64]model.Order),
+			products:  make(map[uint64]model.OrderProduct),
+			payEvents: make(map[string]model.PaymentEvent),
+			keys:      make(map[[2]string]model.IdempotencyKey),
+		},
+	}
+}
+
+func (ms *MemoryStorage) lock() func() {
+	if ms.inTx {
+		return func() {}
+	}
+	ms.mu.Lock()
+	return ms.mu.Unlock
+}
+
+// WithTx runs fn with exclusive access to the storage, every change made by
+// fn is discarded when it returns an error.
+func (ms *MemoryStorage) WithTx(ctx context.Context, fn func(tx *MemoryStorage) error) error {
+	defer ms.lock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	backup := ms.data.clone()
+	if err := fn(&MemoryStorage{mu: ms.mu, data: ms.data, inTx: true}); err != nil {
+		*ms.data = *backup
+		return err
+	}
+	return nil
+}
+
+// order returns an order that is not deleted.
+func (ms *MemoryStorage) order(oID uint64) (model.Order, bool) {
+	o, ok := ms.data.orders[oID]
+	if !ok || o.DeletedAt.Valid {
+		return model.Order{}, false
+	}
+	return o, true
+}
+
+// sortedOrders returns the orders that are not deleted and match f ordered by ID.
+func (ms *MemoryStorage) sortedOrders(f func(model.Order) bool) []model.Order {
+	var os []model.Order
+	for _, o := range ms.data.orders {
+		if !o.DeletedAt.Valid && f(o) {
+			os = append(os, o)
+		}
+	}
+	sort.Slice(os, func(i, j int) bool { return os[i].ID < os[j].ID })
+	return os
+}
+
+// sortedProducts returns the products that match f ordered by ID.
+func (ms *MemoryStorage) sortedProducts(f func(model.OrderProduct) bool) []model.OrderProduct {
+	var ps []model.OrderProduct
+	for _, p := range ms.data.products {
+		if f(p) {
+			ps = append(ps, p)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].ID < ps[j].ID })
+	return ps
+}
+
+// stored returns m as it is read from the database, in MXN when it has no
+// currency.
+func stored(m model.Money) model.Money {
+	return model.NewMoney(m.Amount, model.MXN).WithCurrency(m.Currency)
+}
+
+func hasStatus(s model.Status, ss []model.Status) bool {
+	for i := range ss {
+		if ss[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasID(id uint64, ids []uint64) bool {
+	for i := range ids {
+		if ids[i] == id {
+			return true
+		}
+	}
+	return false
+}
+
+// inDays reports whether o was created in one of the ranges, every order is
+// without ranges.
+func inDays(o model.Order, rs []model.DayRange) bool {
+	for _, r := range rs {
+		switch {
+		case o.CreatedAt.Before(r.From) || !o.CreatedAt.Before(r.To),
+			r.Ests != nil && !hasID(o.EstablishmentID, r.Ests),
+			r.Ests == nil && hasID(o.EstablishmentID, r.Except):
+			continue
+		}
+		return true
+	}
+	return len(rs) == 0
+}
+
+func (ms *MemoryStorage) Kitchen(ctx context.Context, eID, last uint64) ([]model.OrderProduct, error) {
+	defer ms.lock()()
+	ps := ms.sortedProducts(func(p model.OrderProduct) bool {
+		o, ok := ms.data.orders[p.OrderID]
+		return ok && o.EstablishmentID == eID && !p.IsReady && !p.IsCancelled && !hasStatus(o.StatusID, notInKitchen) && p.ID > last
+	})
+	return ps, nil
+}
+
+func (ms *MemoryStorage) KitchenProducts(ctx context.Context, ids []uint64) ([]model.KitchenProduct, error) {
+	defer ms.lock()()
+	var kps []model.KitchenProduct
+	for _, p := range ms.sortedProducts(func(p model.OrderProduct) bool { return hasID(p.ID, ids) }) {
+		o, ok := ms.data.orders[p.OrderID]
+		if !ok {
+			continue
+		}
+		kps = append(kps, model.KitchenProduct{
+			OrderProduct: p, EstablishmentID: o.EstablishmentID, EmployeeID: o.EmployeeID, TableID: o.TableID, StatusID: o.StatusID,
+		})
+	}
+	return kps, nil
+}
+
+func (ms *MemoryStorage) Owners(ctx context.Context, ids []uint64) ([]model.Order, error) {
+	defer ms.lock()()
+	var res []model.Order
+	for _, o := range ms.sortedOrders(func(o model.Order) bool { return hasID(o.ID, ids) }) {
+		res = append(res, model.Order{
+			Model: model.Model{ID: o.ID}, UserID: o.UserID, EmployeeID: o.EmployeeID, EstablishmentID: o.EstablishmentID,
+		})
+	}
+	return res, nil
+}
+
+func (ms *MemoryStorage) PaymentOwners(ctx context.Context, pIDs []string) ([]model.Order, error) {
+	defer ms.lock()()
+	var res []model.Order
+	for _, o := range ms.sortedOrders(func(o model.Order) bool { return o.PayID != nil && hasString(*o.PayID, pIDs) }) {
+		pID := *o.PayID
+		res = append(res, model.Order{
+			Model: model.Model{ID: o.ID}, UserID: o.UserID, EmployeeID: o.EmployeeID, EstablishmentID: o.EstablishmentID, PayID: &pID,
+		})
+	}
+	return res, nil
+}
+
+// matches reports whether o meets every condition of f like the database
+// storage.
+func (ms *MemoryStorage) matches(o model.Order, f model.Filter) bool {
+	switch {
+	case len(f.Status) != 0 && !hasStatus(o.StatusID, f.Status),
+		len(f.Types) != 0 && !hasType(o.TypeID, f.Types),
+		len(f.Employees) != 0 && !hasID(o.EmployeeID, f.Employees),
+		len(f.Tables) != 0 && !hasID(o.TableID, f.Tables),
+		len(f.Payments) != 0 && !hasPayment(o.PaymentMethod, f.Payments),
+		len(f.PayIDs) != 0 && (o.PayID == nil || !hasString(*o.PayID, f.PayIDs)),
+		len(f.Addresses) != 0 && (o.AddressID == nil || !hasString(*o.AddressID, f.Addresses)),
+		f.Tip == model.WithTip && o.Tip <= 0,
+		f.Tip == model.WithoutTip && o.Tip != 0,
+		f.From != nil && o.CreatedAt.Before(*f.From),
+		f.To != nil && !o.CreatedAt.Before(*f.To):
+		return false
+	}
+	if len(f.Products) != 0 && len(ms.sortedProducts(func(p model.OrderProduct) bool {
+		return p.OrderID == o.ID && !p.IsCancelled && hasID(p.ProductID, f.Products)
+	})) == 0 {
+		return false
+	}
+	if f.Text != "" && !hasWords(o.CancelNote, f.Text) && len(ms.sortedProducts(func(p model.OrderProduct) bool {
+		return p.OrderID == o.ID && hasWords(p.Name, f.Text)
+	})) == 0 {
+		return false
+	}
+	return true
+}
+
+func hasType(t model.Type, ts []model.Type) bool {
+	for i := range ts {
+		if ts[i] == t {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPayment(pm model.PaymentMethod, pms []model.PaymentMethod) bool {
+	for i := range pms {
+		if pms[i] == pm {
+			return true
+		}
+	}
+	return false
+}
+
+func hasString(s string, ss []string) bool {
+	for i := range ss {
+		if ss[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+// words splits s in lower case words like the simple text search
+// configuration of the database.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// hasWords reports whether every word of text is in doc.
+func hasWords(doc, text string) bool {
+	ws := words(doc)
+	for _, w := range words(text) {
+		if !hasString(w, ws) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ms *MemoryStorage) Search(ctx context.Context, s *model.SearchOrder) (model.OrderPage, error) {
+	defer ms.lock()()
+	os := ms.sortedOrders(func(o model.Order) bool {
+		switch {
+		case s.Users != nil && !hasID(o.UserID, s.Users),
+			s.Ests != nil && !hasID(o.EstablishmentID, s.Ests),
+			s.Lower.Amount > 0 && o.Total.Amount < s.Lower.Amount,
+			s.Higher.Amount > 0 && o.Total.Amount > s.Higher.Amount,
+			!inDays(o, s.Days),
+			!ms.matches(o, s.Filter):
+			return false
+		}
+		for _, f := range s.Any {
+			if ms.matches(o, f) {
+				return true
+			}
+		}
+		return len(s.Any) == 0
+	})
+	p := keysetPage(os, s.Search, model.ASC)
+	os = p.Orders
+	res := make([]model.Order, len(os))
+	for i, o := range os {
+		res[i] = model.Order{
+			Model:           model.Model{ID: o.ID, CreatedAt: o.CreatedAt},
+			TypeID:          o.TypeID,
+			EstablishmentID: o.EstablishmentID,
+			AddressID:       o.AddressID,
+			StatusID:        o.StatusID,
+			Total:           o.Total,
+			UserID:          o.UserID,
+		}
+	}
+	p.Orders = res
+	return p, nil
+}
+
+// keysetPage sorts os like keyset and returns the page after s.After.
+func keysetPage(os []model.Order, s model.Search, id model.Sort) model.OrderPage {
+	sort.SliceStable(os, func(i, j int) bool {
+		return s.Compare(s.Cursor(os[i]), s.Cursor(os[j]), id) < 0
+	})
+	total := int64(0)
+	if s.Total {
+		total = int64(len(os))
+	}
+	switch {
+	case s.After != nil:
+		i := sort.Search(len(os), func(i int) bool { return s.Compare(*s.After, s.Cursor(os[i]), id) < 0 })
+		os = os[i:]
+	case s.Offset >= len(os):
+		os = nil
+	case s.Offset > 0:
+		os = os[s.Offset:]
+	}
+	if s.Limit > 0 && s.Limit+1 < len(os) {
+		os = os[:s.Limit+1]
+	}
+	return newPage(os, s, total)
+}
+
+func (ms *MemoryStorage) GetTipsFromEmployee(ctx context.Context, eID uint64, days []model.DayRange) (model.Money, error) {
+	defer ms.lock()()
+	sum := model.NewMoney(0, model.MXN)
+	for _, o := range ms.data.orders {
+		if o.EmployeeID != eID || !inDays(o, days) {
+			continue
+		}
+		sum.Amount += o.Tip.Of(o.Total).Amount
+	}
+	return sum, nil
+}
+
+func (ms *MemoryStorage) User(ctx context.Context, uID uint64, s model.Search) (model.OrderPage, error) {
+	defer ms.lock()()
+	p := keysetPage(ms.sortedOrders(func(o model.Order) bool { return o.UserID == uID }), s, model.DES)
+	os := p.Orders
+	res := make([]model.Order, len(os))
+	for i, o := range os {
+		res[i] = model.Order{
+			Model:           model.Model{ID: o.ID, CreatedAt: o.CreatedAt},
+			AddressID:       o.AddressID,
+			Total:           o.Total,
+			StatusID:        o.StatusID,
+			UserID:          o.UserID,
+			PayID:           o.PayID,
+			EstablishmentID: o.EstablishmentID,
+			TypeID:          o.TypeID,
+		}
+		for _, p := range ms.sortedProducts(func(p model.OrderProduct) bool { return p.OrderID == o.ID }) {
+			res[i].OrderProducts = append(res[i].OrderProducts, model.OrderProduct{
+				ID: p.ID, ProductID: p.ProductID, Name: p.Name, UnitPrice: p.UnitPrice,
+				Quantity: p.Quantity, Subtotal: p.Subtotal, OrderID: p.OrderID,
+			})
+		}
+	}
+	p.Orders = res
+	return p, nil
+}
+
+func (ms *MemoryStorage) waiter(wID uint64, pending bool) []model.Order {
+	os := ms.sortedOrders(func(o model.Order) bool {
+		return o.EmployeeID == wID && hasStatus(o.StatusID, servingTables)
+	})
+	res := make([]model.Order, len(os))
+	for i, o := range os {
+		res[i] = model.Order{Model: model.Model{ID: o.ID}, TableID: o.TableID}
+		if !pending {
+			res[i].Total = o.Total
+		}
+		for _, p := range ms.sortedProducts(func(p model.OrderProduct) bool { return p.OrderID == o.ID }) {
+			if p.IsCancelled || pending && (!p.IsReady || p.IsDelivered) {
+				continue
+			}
+			op := model.OrderProduct{
+				ID: p.ID, ProductID: p.ProductID, Name: p.Name, Quantity: p.Quantity,
+				OrderID: p.OrderID, IsReady: p.IsReady, IsDelivered: p.IsDelivered,
+			}
+			if !pending {
+				op.UnitPrice, op.Subtotal = p.UnitPrice, p.Subtotal
+			}
+			res[i].OrderProducts = append(res[i].OrderProducts, op)
+		}
+	}
+	return res
+}
+
+func (ms *MemoryStorage) Waiter(ctx context.Context, wID uint64) ([]model.Order, error) {
+	defer ms.lock()()
+	return ms.waiter(wID, false), nil
+}
+
+func (ms *MemoryStorage) WaiterPending(ctx context.Context, wID uint64) ([]model.Order, error) {
+	defer ms.lock()()
+	return ms.waiter(wID, true), nil
+}
+
+func (ms *MemoryStorage) addProducts(oID uint64, ps []model.OrderProduct) {
+	for i := range ps {
+		ms.data.lastProduct++
+		ps[i].ID = ms.data.lastProduct
+		ps[i].OrderID = oID
+		ps[i].UnitPrice = stored(ps[i].UnitPrice)
+		ps[i].Subtotal = stored(ps[i].Subtotal)
+		ps[i].Currency = ps[i].UnitPrice.Currency
+		ms.data.products[ps[i].ID] = ps[i]
+	}
+}
+
+// addEvent adds to the outbox an event of type t for the order oID.
+func (ms *MemoryStorage) addEvent(t string, oID uint64) error {
+	e, err := model.NewOrderEvent(t, ms.data.orders[oID])
+	if err != nil {
+		return fmt.Errorf("new event: %w", err)
+	}
+	e.ID = uint64(len(ms.data.events) + 1)
+	e.CreatedAt = time.Now()
+	ms.data.events = append(ms.data.events, e)
+	return nil
+}
+
+func (ms *MemoryStorage) audit(ctx context.Context, action string, oID, opID uint64, old, new model.AuditValues) error {
+	a, err := model.NewOrderAudit(ctx, action, oID, opID, old, new)
+	if err != nil {
+		return fmt.Errorf("new audit: %w", err)
+	}
+	a.ID = uint64(len(ms.data.audits) + 1)
+	a.CreatedAt = time.Now()
+	ms.data.audits = append(ms.data.audits, a)
+	return nil
+}
+
+func (ms *MemoryStorage) History(ctx context.Context, oID uint64) ([]model.OrderAudit, error) {
+	defer ms.lock()()
+	var as []model.OrderAudit
+	for _, a := range ms.data.audits {
+		if a.OrderID == oID {
+			as = append(as, a)
+		}
+	}
+	return as, nil
+}
+
+func (ms *MemoryStorage) Dispatch(ctx context.Context, limit int, publish func(model.OrderEvent) error) (int, error) {
+	defer ms.lock()()
+	n := 0
+	for i := range ms.data.events {
+		if n == limit {
+			break
+		}
+		e := &ms.data.events[i]
+		if e.PublishedAt != nil {
+			continue
+		}
+		if err := publish(*e); err != nil {
+			e.Attempts++
+			return n, fmt.Errorf("publish: %w", err)
+		}
+		now := time.Now()
+		e.PublishedAt = &now
+		n++
+	}
+	return n, nil
+}
+
+func (ms *MemoryStorage) Reserve(ctx context.Context, k *model.IdempotencyKey) (model.IdempotencyKey, bool, error) {
+	defer ms.lock()()
+	id := [2]string{k.Key, k.Scope}
+	if got, ok := ms.data.keys[id]; ok && got.ExpiresAt.After(time.Now()) {
+		return got, false, nil
+	}
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now()
+	}
+	ms.data.keys[id] = *k
+	return *k, true, nil
+}
+
+func (ms *MemoryStorage) Complete(ctx context.Context, key, scope string, resp []byte) error {
+	defer ms.lock()()
+	id := [2]string{key, scope}
+	if k, ok := ms.data.keys[id]; ok {
+		now := time.Now()
+		k.Response, k.CompletedAt = resp, &now
+		ms.data.keys[id] = k
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) Release(ctx context.Context, key, scope string) error {
+	defer ms.lock()()
+	id := [2]string{key, scope}
+	if k, ok := ms.data.keys[id]; ok && k.CompletedAt == nil {
+		delete(ms.data.keys, id)
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	defer ms.lock()()
+	var n int64
+	for id, k := range ms.data.keys {
+		if !k.ExpiresAt.After(before) {
+			delete(ms.data.keys, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (ms *MemoryStorage) Create(ctx context.Context, o *model.Order) error {
+	if o == nil {
+		return apperr.New(apperr.ErrInvalidArgument, "nil order")
+	}
+	defer ms.lock()()
+	ms.data.lastOrder++
+	o.ID = ms.data.lastOrder
+	now := time.Now()
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = now
+	}
+	o.UpdatedAt = now
+	o.Total = stored(o.Total)
+	o.Currency = o.Total.Currency
+	o.Captured, o.Refunded = o.Captured.WithCurrency(o.Currency), o.Refunded.WithCurrency(o.Currency)
+	ms.addProducts(o.ID, o.OrderProducts)
+	c := *o
+	c.OrderProducts = nil
+	ms.data.orders[o.ID] = c
+	nv := model.AuditValues{"status": o.StatusID.String(), "total": o.Total.String(), "products": len(o.OrderProducts)}
+	if err := ms.audit(ctx, model.AuditCreate, o.ID, 0, nil, nv); err != nil {
+		return err
+	}
+	return ms.addEvent(model.EventOrderCreated, o.ID)
+}
+
+func (ms *MemoryStorage) Products(ctx context.Context, oID uint64) ([]model.OrderProduct, error) {
+	defer ms.lock()()
+	return ms.sortedProducts(func(p model.OrderProduct) bool { return p.OrderID == oID }), nil
+}
+
+func (ms *MemoryStorage) AddProducts(ctx context.Context, oID uint64, total model.Money, ps []model.OrderProduct) error {
+	if ps == nil {
+		return apperr.New(apperr.ErrInvalidArgument, "nil products")
+	}
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok {
+		return fmt.Errorf("updateTotal: update total: %w", dbError(gorm.ErrRecordNotFound))
+	}
+	old := model.AuditValues{"total": o.Total.String()}
+	o.Total.Amount += total.Amount
+	ms.data.orders[oID] = o
+	ms.addProducts(oID, ps)
+	ids := make([]uint64, len(ps))
+	for i := range ps {
+		ids[i] = ps[i].ID
+	}
+	if err := ms.audit(ctx, model.AuditAddProducts, oID, 0, old, model.AuditValues{"total": o.Total.String(), "products": ids}); err != nil {
+		return err
+	}
+	return ms.addEvent(model.EventProductsAdded, oID)
+}
+
+// summary returns the columns of o read by Order, PaymentOrder and CaptureOrder.
+func summary(o model.Order) model.Order {
+	return model.Order{
+		Model: model.Model{ID: o.ID}, TypeID: o.TypeID, UserID: o.UserID, EmployeeID: o.EmployeeID, EstablishmentID: o.EstablishmentID,
+		StatusID: o.StatusID, Total: o.Total, CancelReason: o.CancelReason, CancelNote: o.CancelNote,
+		PayID: o.PayID, PayRequestID: o.PayRequestID, PaymentMethod: o.PaymentMethod, CaptureID: o.CaptureID,
+		Captured: o.Captured, Refunded: o.Refunded,
+	}
+}
+
+func (ms *MemoryStorage) Order(ctx context.Context, oID uint64) (model.Order, error) {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok {
+		return model.Order{}, fmt.Errorf("first order: %w", dbError(gorm.ErrRecordNotFound))
+	}
+	return summary(o), nil
+}
+
+func (ms *MemoryStorage) CancelOrder(ctx context.Context, oID uint64, from model.Status, cn model.Cancellation) error {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok || o.StatusID != from {
+		return fmt.Errorf("order %d: %w", oID, model.ErrStatusChanged)
+	}
+	o.StatusID, o.CancelReason, o.CancelNote = model.Cancelled, cn.Reason, cn.Note
+	ms.data.orders[oID] = o
+	nv := cancelValues(cn)
+	nv["status"] = model.Cancelled.String()
+	if err := ms.audit(ctx, model.AuditCancel, oID, 0, model.AuditValues{"status": from.String()}, nv); err != nil {
+		return err
+	}
+	return ms.addEvent(model.EventOrderCancelled, oID)
+}
+
+func (ms *MemoryStorage) ExpireOrder(ctx context.Context, oID uint64, from model.Status) error {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok || o.StatusID != from {
+		return fmt.Errorf("order %d: %w", oID, model.ErrStatusChanged)
+	}
+	o.StatusID = model.Expired
+	ms.data.orders[oID] = o
+	if err := ms.audit(ctx, model.AuditExpire, oID, 0, model.AuditValues{"status": from.String()}, model.AuditValues{"status": model.Expired.String()}); err != nil {
+		return err
+	}
+	return ms.addEvent(model.EventOrderExpired, oID)
+}
+
+func (ms *MemoryStorage) SetStatus(ctx context.Context, oID uint64, from, to model.Status) error {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok || o.StatusID != from {
+		return fmt.Errorf("order %d: %w", oID, model.ErrStatusChanged)
+	}
+	o.StatusID = to
+	ms.data.orders[oID] = o
+	if err := ms.audit(ctx, model.AuditStatus, oID, 0, model.AuditValues{"status": from.String()}, model.AuditValues{"status": to.String()}); err != nil {
+		return err
+	}
+	return ms.addEvent(model.StatusEvent(to), oID)
+}
+
+func (ms *MemoryStorage) CancelProducts(ctx context.Context, oID uint64, ids []uint64, cn model.Cancellation) error {
+	defer ms.lock()()
+	ps := ms.sortedProducts(func(p model.OrderProduct) bool {
+		return p.OrderID == oID && !p.IsCancelled && hasID(p.ID, ids)
+	})
+	o, ok := ms.order(oID)
+	if !ok || len(ps) != len(ids) {
+		return fmt.Errorf("products of order %d: %w", oID, model.ErrStatusChanged)
+	}
+	for _, p := range ps {
+		p.IsCancelled, p.CancelReason, p.CancelNote = true, cn.Reason, cn.Note
+		ms.data.products[p.ID] = p
+		o.Total.Amount -= p.Subtotal.Amount
+		nv := cancelValues(cn)
+		nv["is_cancelled"] = true
+		if err := ms.audit(ctx, model.AuditCancelProduct, oID, p.ID, model.AuditValues{"is_cancelled": false}, nv); err != nil {
+			return err
+		}
+	}
+	ms.data.orders[oID] = o
+	return ms.addEvent(model.EventProductsCancelled, oID)
+}
+
+func (ms *MemoryStorage) Status(ctx context.Context, oID uint64) (model.Status, error) {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok {
+		return 0, fmt.Errorf("first order: %w", dbError(gorm.ErrRecordNotFound))
+	}
+	return o.StatusID, nil
+}
+
+// byPayment returns the first order with the payment pID.
+func (ms *MemoryStorage) byPayment(pID string) (model.Order, bool) {
+	os := ms.sortedOrders(func(o model.Order) bool { return o.PayID != nil && *o.PayID == pID })
+	if len(os) == 0 {
+		return model.Order{}, false
+	}
+	return os[0], true
+}
+
+func (ms *MemoryStorage) PaymentOrder(ctx context.Context, pID string) (model.Order, error) {
+	defer ms.lock()()
+	o, ok := ms.byPayment(pID)
+	if !ok {
+		return model.Order{}, fmt.Errorf("first order: %w", dbError(gorm.ErrRecordNotFound))
+	}
+	return summary(o), nil
+}
+
+func (ms *MemoryStorage) CaptureOrder(ctx context.Context, cID string) (model.Order, error) {
+	defer ms.lock()()
+	os := ms.sortedOrders(func(o model.Order) bool { return o.CaptureID != nil && *o.CaptureID == cID })
+	if len(os) == 0 {
+		return model.Order{}, fmt.Errorf("first order: %w", dbError(gorm.ErrRecordNotFound))
+	}
+	return summary(os[0]), nil
+}
+
+func (ms *MemoryStorage) UnpaidOrders(ctx context.Context, before time.Time, after uint64, limit int) ([]model.Order, error) {
+	defer ms.lock()()
+	var orders []model.Order
+	for _, o := range ms.sortedOrders(func(o model.Order) bool {
+		return o.StatusID == model.AwaitingPayment && o.CreatedAt.Before(before) && o.ID > after
+	}) {
+		if len(orders) == limit {
+			break
+		}
+		so := summary(o)
+		so.CreatedAt = o.CreatedAt
+		orders = append(orders, so)
+	}
+	return orders, nil
+}
+
+func (ms *MemoryStorage) AddPaymentEvent(ctx context.Context, e *model.PaymentEvent) error {
+	defer ms.lock()()
+	if _, ok := ms.data.payEvents[e.ID]; ok {
+		return fmt.Errorf("payment event %s: %w", e.ID, model.ErrPaymentEventProcessed)
+	}
+	e.Amount = stored(e.Amount)
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	ms.data.payEvents[e.ID] = *e
+	return nil
+}
+
+func (ms *MemoryStorage) HasRefund(ctx context.Context, prID string) (bool, error) {
+	defer ms.lock()()
+	for _, rf := range ms.data.refunds {
+		if rf.ProviderID != nil && *rf.ProviderID == prID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ms *MemoryStorage) SetPaymentRequest(ctx context.Context, oID uint64, key string) error {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok {
+		return fmt.Errorf("order %d: %w", oID, dbError(gorm.ErrRecordNotFound))
+	}
+	o.PayRequestID = &key
+	ms.data.orders[oID] = o
+	return nil
+}
+
+func (ms *MemoryStorage) SetPaymentDelivery(ctx context.Context, oID uint64, eID uint64, pID string, aID string, pm model.PaymentMethod) error {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok {
+		return nil
+	}
+	old := paymentValues(o)
+	if eID != 0 {
+		o.EstablishmentID = eID
+	}
+	o.PayID, o.AddressID, o.PaymentMethod, o.PayRequestID = &pID, &aID, pm, nil
+	ms.data.orders[oID] = o
+	return ms.audit(ctx, model.AuditSetPayment, oID, 0, old, paymentValues(o))
+}
+
+func (ms *MemoryStorage) DenyPayment(ctx context.Context, oID uint64, pID string) error {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok || o.PayID == nil || *o.PayID != pID {
+		return fmt.Errorf("payment %s of order %d: %w", pID, oID, model.ErrStatusChanged)
+	}
+	o.PayID, o.PayRequestID = nil, nil
+	ms.data.orders[oID] = o
+	return ms.audit(ctx, model.AuditDenyPayment, oID, 0, model.AuditValues{"pay_id": pID}, model.AuditValues{"pay_id": nil})
+}
+
+func (ms *MemoryStorage) PayLocal(ctx context.Context, oID uint64, eID uint64, tip model.TipRate, from, to model.Status) error {
+	defer ms.lock()()
+	o, ok := ms.order(oID)
+	if !ok || o.EmployeeID != eID || o.StatusID != from {
+		return fmt.Errorf("order %d of employee %d: %w", oID, eID, model.ErrStatusChanged)
+	}
+	o.StatusID, o.Tip, o.Captured, o.PaymentMethod = to, tip, o.Total, model.CASH
+	ms.data.orders[oID] = o
+	if err := ms.audit(ctx, model.AuditPay, oID, 0, model.AuditValues{"status": from.String()}, model.AuditValues{"status": to.String(), "tip": tip.String()}); err != nil {
+		return err
+	}
+	return ms.addEvent(model.StatusEvent(to), oID)
+}
+
+func (ms *MemoryStorage) PayDelivey(ctx context.Context, pID string, cp model.Capture, from, to model.Status) error {
+	defer ms.lock()()
+	n := 0
+	for _, o := range ms.sortedOrders(func(o model.Order) bool {
+		return o.PayID != nil && *o.PayID == pID && o.StatusID == from
+	}) {
+		cID := cp.ID
+		o.StatusID, o.CaptureID, o.Captured = to, &cID, stored(cp.Amount)
+		ms.data.orders[o.ID] = o
+		nv := model.AuditValues{"status": to.String(), "pay_id": pID, "capture_id": cp.ID, "captured": cp.Amount.String()}
+		if err := ms.audit(ctx, model.AuditPay, o.ID, 0, model.AuditValues{"status": from.String()}, nv); err != nil {
+			return err
+		}
+		if err := ms.addEvent(model.StatusEvent(to), o.ID); err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return fmt.Errorf("order with payment %s: %w", pID, model.ErrStatusChanged)
+	}
+	return nil
+}
+
+// addRefund stores rf as a new refund.
+func (ms *MemoryStorage) addRefund(rf *model.Refund) error {
+	for _, r := range ms.data.refunds {
+		if rf.RequestID != nil && r.RequestID != nil && *r.RequestID == *rf.RequestID {
+			return fmt.Errorf("create refund: %w", apperr.New(apperr.ErrConflict, "duplicated key"))
+		}
+	}
+	rf.ID = uint64(len(ms.data.refunds) + 1)
+	rf.Amount = stored(rf.Amount)
+	rf.Currency = rf.Amount.Currency
+	if rf.CreatedAt.IsZero() {
+		rf.CreatedAt = time.Now()
+	}
+	ms.data.refunds = append(ms.data.refunds, *rf)
+	return nil
+}
+
+func (ms *MemoryStorage) AddRefund(ctx context.Context, rf *model.Refund) error {
+	defer ms.lock()()
+	rf.Status = model.RefundPending
+	return ms.addRefund(rf)
+}
+
+func (ms *MemoryStorage) PendingRefund(ctx context.Context, oID uint64) (model.Refund, error) {
+	defer ms.lock()()
+	for _, rf := range ms.data.refunds {
+		if rf.OrderID == oID && rf.Status == model.RefundPending {
+			return rf, nil
+		}
+	}
+	return model.Refund{}, fmt.Errorf("first refund: %w", dbError(gorm.ErrRecordNotFound))
+}
+
+// pendingRefund returns the index of the pending refund rID.
+func (ms *MemoryStorage) pendingRefund(rID uint64) (int, bool) {
+	for i, rf := range ms.data.refunds {
+		if rf.ID == rID && rf.Status == model.RefundPending {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (ms *MemoryStorage) FailRefund(ctx context.Context, rID uint64) error {
+	defer ms.lock()()
+	i, ok := ms.pendingRefund(rID)
+	if !ok {
+		return fmt.Errorf("refund %d: %w", rID, model.ErrStatusChanged)
+	}
+	ms.data.refunds[i].Status = model.RefundFailed
+	return nil
+}
+
+func (ms *MemoryStorage) Refund(ctx context.Context, rf *model.Refund, from, to model.Status) error {
+	defer ms.lock()()
+	o, ok := ms.order(rf.OrderID)
+	if !ok || o.StatusID != from || o.Refunded.Amount+rf.Amount.Amount > o.Captured.Amount {
+		return fmt.Errorf("order %d: %w", rf.OrderID, model.ErrStatusChanged)
+	}
+	if rf.ID == 0 {
+		if err := ms.addRefund(rf); err != nil {
+			return err
+		}
+	} else {
+		i, ok := ms.pendingRefund(rf.ID)
+		if !ok {
+			return fmt.Errorf("refund %d: %w", rf.ID, model.ErrStatusChanged)
+		}
+		ms.data.refunds[i].Status, ms.data.refunds[i].ProviderID = rf.Status, rf.ProviderID
+	}
+	o.StatusID = to
+	o.Refunded.Amount += rf.Amount.Amount
+	ms.data.orders[o.ID] = o
+	if err := ms.audit(ctx, model.AuditRefund, o.ID, 0, model.AuditValues{"status": from.String()}, refundValues(to, rf)); err != nil {
+		return err
+	}
+	return ms.addEvent(model.StatusEvent(to), o.ID)
+}
+
+func (ms *MemoryStorage) CompleteProduct(ctx context.Context, pID uint64) error {
+	defer ms.lock()()
+	if p, ok := ms.data.products[pID]; ok {
+		old := model.AuditValues{"is_ready": p.IsReady}
+		p.IsReady = true
+		ms.data.products[pID] = p
+		return ms.audit(ctx, model.AuditCompleteProduct, p.OrderID, p.ID, old, model.AuditValues{"is_ready": true})
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) DeliverProduct(ctx context.Context, ids []uint64) error {
+	defer ms.lock()()
+	for _, p := range ms.sortedProducts(func(p model.OrderProduct) bool { return hasID(p.ID, ids) }) {
+		old := model.AuditValues{"is_delivered": p.IsDelivered}
+		p.IsDelivered = true
+		ms.data.products[p.ID] = p
+		if err := ms.audit(ctx, model.AuditDeliverProduct, p.OrderID, p.ID, old, model.AuditValues{"is_delivered": true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
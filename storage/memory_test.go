@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/modular-project/orders-service/controller"
+	"github.com/modular-project/orders-service/storage/storagetest"
+)
+
+type memoryTransactor struct {
+	ms *MemoryStorage
+}
+
+func (mt memoryTransactor) WithTx(c context.Context, fn func(controller.OrderStorager, controller.OrderStatusStorager) error) error {
+	return mt.ms.WithTx(c, func(tx *MemoryStorage) error {
+		return fn(tx, tx)
+	})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		ms := NewMemoryStorage()
+		return storagetest.Backend{Orders: ms, Status: ms, Tx: memoryTransactor{ms: ms}, Outbox: ms, Keys: ms}
+	})
+}
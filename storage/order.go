@@ -1,195 +1,407 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/modular-project/orders-service/apperr"
 	"github.com/modular-project/orders-service/model"
 	"gorm.io/gorm"
 )
 
+var (
+	// notInKitchen are the statuses of orders that must not be prepared.
+	notInKitchen = model.NotInKitchen
+	// servingTables are the statuses of local orders still attended by a waiter.
+	servingTables = []model.Status{model.InPreparation, model.Ready, model.Delivered}
+)
+
 type OrderStorage struct {
 	db *gorm.DB
 }
 
-func NewOrderStorage() OrderStorage {
-	return OrderStorage{db: _db}
-}
-
-func (os OrderStorage) Complete(oID uint64) error {
-	err := os.db.Model(&model.Order{Model: model.Model{ID: oID}}).Update("status_id", model.Completed).Error
-	if err != nil {
-		return fmt.Errorf("update status_id: %w", err)
-	}
-	return nil
+func NewOrderStorage(db *DB) OrderStorage {
+	return OrderStorage{db: db.db}
 }
 
-func (os OrderStorage) Kitchen(eID, last uint64) ([]model.OrderProduct, error) {
+func (os OrderStorage) Kitchen(ctx context.Context, eID, last uint64) ([]model.OrderProduct, error) {
 	var ps []model.OrderProduct
 	log.Println(last)
-	tx := os.db.Model(&model.OrderProduct{}).Joins("LEFT JOIN orders as o ON o.id = order_products.order_id").
-		Where("o.establishment_id = ? AND order_products.is_ready = false AND o.status_id <> ?", eID, model.WithoutPay)
+	tx := os.db.WithContext(ctx).Model(&model.OrderProduct{}).Joins("LEFT JOIN orders as o ON o.id = order_products.order_id").
+		Where("o.establishment_id = ? AND order_products.is_ready = false AND order_products.is_cancelled = false AND o.status_id NOT IN ?", eID, notInKitchen)
 	if last > 0 {
 		tx.Where("order_products.id > ?", last)
 	}
 	err := tx.Order("order_products.id").Find(&ps).Error
 	if err != nil {
-		return nil, fmt.Errorf("find order products: %w", err)
+		return nil, fmt.Errorf("find order products: %w", dbError(err))
 	}
 	return ps, nil
 }
 
-func (os OrderStorage) Search(s *model.SearchOrder) ([]model.Order, error) {
+// KitchenProducts returns the products ids with the establishment, waiter,
+// table and status of their order.
+func (os OrderStorage) KitchenProducts(ctx context.Context, ids []uint64) ([]model.KitchenProduct, error) {
+	var kps []model.KitchenProduct
+	err := os.db.WithContext(ctx).Table("order_products").Select("order_products.*, o.establishment_id, o.employee_id, o.table_id, o.status_id").
+		Joins("JOIN orders as o ON o.id = order_products.order_id").Where("order_products.id IN ?", ids).
+		Order("order_products.id").Scan(&kps).Error
+	if err != nil {
+		return nil, fmt.Errorf("find kitchen products: %w", dbError(err))
+	}
+	return kps, nil
+}
+
+// Owners returns the orders ids with the user, employee and establishment
+// that own them.
+func (os OrderStorage) Owners(ctx context.Context, ids []uint64) ([]model.Order, error) {
 	var o []model.Order
-	tx := os.db.Model(&o).Select("id, type_id, establishment_id, address_id, status_id, total, created_at, user_id")
-	if s.Users != nil {
-		tx.Where("user_id IN ?", s.Users)
+	err := os.db.WithContext(ctx).Select("id", "user_id", "employee_id", "establishment_id").Where("id IN ?", ids).Order("id").Find(&o).Error
+	if err != nil {
+		return nil, fmt.Errorf("find owners: %w", dbError(err))
 	}
-	if s.Status != nil {
-		tx.Where("status_id IN ?", s.Status)
+	return o, nil
+}
+
+// PaymentOwners is like Owners but finds the orders by their payment IDs.
+func (os OrderStorage) PaymentOwners(ctx context.Context, pIDs []string) ([]model.Order, error) {
+	var o []model.Order
+	err := os.db.WithContext(ctx).Select("id", "user_id", "employee_id", "establishment_id", "pay_id").Where("pay_id IN ?", pIDs).Order("id").Find(&o).Error
+	if err != nil {
+		return nil, fmt.Errorf("find payment owners: %w", dbError(err))
 	}
-	if s.Ests != nil {
-		tx.Where("establishment_id IN ?", s.Ests)
+	return o, nil
+}
+
+// keyValue returns the value of a cursor key as it is compared with its column.
+func keyValue(b model.By, k int64) interface{} {
+	switch b {
+	case model.DATE:
+		return time.Unix(0, k*int64(time.Microsecond)).UTC()
+	case model.PRICE:
+		return model.NewMoney(k, model.MXN)
+	}
+	return k
+}
+
+// keyset sorts tx by the search and then by id, reads the orders after
+// s.After and one more than the limit to know if a next page follows.
+func keyset(tx *gorm.DB, s model.Search, id model.Sort) *gorm.DB {
+	obs := append(s.Sorts(), model.OrderBy{Sort: id})
+	cols := make([]string, len(obs))
+	for i, ob := range obs[:len(obs)-1] {
+		cols[i] = ob.By.Column()
 	}
-	if s.Types != nil {
-		tx.Where("type_id IN ?", s.Types)
+	cols[len(cols)-1] = "id"
+	order := make([]string, len(obs))
+	for i, ob := range obs {
+		order[i] = cols[i]
+		if ob.Sort == model.DES {
+			order[i] += " DESC"
+		}
 	}
-	if s.Lower > 0 {
-		tx.Where("total >= ?", s.Lower)
+	tx = tx.Order(strings.Join(order, ", "))
+	if s.After != nil {
+		// (k0 > v0) OR (k0 = v0 AND k1 > v1) OR ... with < for the descending keys.
+		var ors []string
+		var args []interface{}
+		for i := range obs {
+			var and []string
+			for j := 0; j <= i; j++ {
+				op := " = ?"
+				if j == i {
+					op = " > ?"
+					if obs[j].Sort == model.DES {
+						op = " < ?"
+					}
+				}
+				and = append(and, cols[j]+op)
+				if j == len(obs)-1 {
+					args = append(args, s.After.ID)
+				} else {
+					args = append(args, keyValue(obs[j].By, s.After.Keys[j]))
+				}
+			}
+			ors = append(ors, "("+strings.Join(and, " AND ")+")")
+		}
+		tx = tx.Where(strings.Join(ors, " OR "), args...)
+	} else if s.Offset != 0 {
+		tx = tx.Offset(s.Offset)
 	}
-	if s.Higher > 0 {
-		tx.Where("total <= ?", s.Higher)
+	if s.Limit != 0 {
+		tx = tx.Limit(s.Limit + 1)
 	}
-	if s.Start != "" && s.End != "" {
-		tx.Where("(created_at, created_at) OVERLAPS (?, ?)", fmt.Sprintf("%s 05:00:00", s.Start), fmt.Sprintf("%s 05:00:00", s.End))
+	return tx
+}
+
+// newPage returns the page of the orders read by keyset.
+func newPage(os []model.Order, s model.Search, total int64) model.OrderPage {
+	p := model.OrderPage{Orders: os, Total: total}
+	if s.Limit > 0 && len(os) > s.Limit {
+		p.Orders = os[:s.Limit]
+		c := s.Cursor(p.Orders[s.Limit-1])
+		p.Next = &c
 	}
-	q := s.Query()
-	if q != "" {
-		tx = tx.Order(s.Query())
+	return p
+}
+
+// count returns the number of rows of tx when the search asks for it.
+func count(tx *gorm.DB, s model.Search) (int64, error) {
+	if !s.Total {
+		return 0, nil
 	}
-	if s.Limit != 0 {
-		tx = tx.Limit(s.Limit)
+	var n int64
+	if err := tx.Count(&n).Error; err != nil {
+		return 0, fmt.Errorf("count orders: %w", dbError(err))
 	}
-	if s.Offset != 0 {
-		tx = tx.Offset(s.Offset)
+	return n, nil
+}
+
+// textQuery matches the orders with every word of the text in their cancel
+// note or in the name of one of their products, both have a GIN index.
+const textQuery = `(to_tsvector('simple', orders.cancel_note) @@ plainto_tsquery('simple', @text) OR EXISTS (
+	SELECT 1 FROM order_products WHERE order_products.order_id = orders.id
+	AND to_tsvector('simple', order_products.name) @@ plainto_tsquery('simple', @text)))`
+
+// filter adds the conditions of f to tx.
+func filter(tx *gorm.DB, f model.Filter) *gorm.DB {
+	if len(f.Status) != 0 {
+		tx = tx.Where("orders.status_id IN ?", f.Status)
+	}
+	if len(f.Types) != 0 {
+		tx = tx.Where("orders.type_id IN ?", f.Types)
+	}
+	if len(f.Employees) != 0 {
+		tx = tx.Where("orders.employee_id IN ?", f.Employees)
+	}
+	if len(f.Tables) != 0 {
+		tx = tx.Where("orders.table_id IN ?", f.Tables)
+	}
+	if len(f.Payments) != 0 {
+		tx = tx.Where("orders.payment_method IN ?", f.Payments)
+	}
+	if len(f.PayIDs) != 0 {
+		tx = tx.Where("orders.pay_id IN ?", f.PayIDs)
+	}
+	if len(f.Products) != 0 {
+		tx = tx.Where("EXISTS (SELECT 1 FROM order_products WHERE order_products.order_id = orders.id AND order_products.product_id IN ? AND NOT order_products.is_cancelled)", f.Products)
+	}
+	switch f.Tip {
+	case model.WithTip:
+		tx = tx.Where("orders.tip > 0")
+	case model.WithoutTip:
+		tx = tx.Where("orders.tip = 0")
+	}
+	if len(f.Addresses) != 0 {
+		tx = tx.Where("orders.address_id IN ?", f.Addresses)
+	}
+	if f.From != nil {
+		tx = tx.Where("orders.created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		tx = tx.Where("orders.created_at < ?", *f.To)
 	}
-	err := tx.Find(&o).Error
+	if f.Text != "" {
+		tx = tx.Where(textQuery, sql.Named("text", f.Text))
+	}
+	return tx
+}
+
+// anyFilter returns the condition that matches the orders of any of the
+// groups, nil when one of them matches every order.
+func (os OrderStorage) anyFilter(fs []model.Filter) *gorm.DB {
+	if len(fs) == 0 {
+		return nil
+	}
+	db := os.db.Session(&gorm.Session{NewDB: true})
+	var any *gorm.DB
+	for _, f := range fs {
+		if f.IsZero() {
+			return nil
+		}
+		if any == nil {
+			any = db.Where(filter(db, f))
+		} else {
+			any = any.Or(filter(db, f))
+		}
+	}
+	return any
+}
+
+func (os OrderStorage) Search(ctx context.Context, s *model.SearchOrder) (model.OrderPage, error) {
+	tx := os.db.WithContext(ctx).Model(&model.Order{})
+	if s.Users != nil {
+		tx = tx.Where("user_id IN ?", s.Users)
+	}
+	if s.Ests != nil {
+		tx = tx.Where("establishment_id IN ?", s.Ests)
+	}
+	tx = filter(tx, s.Filter)
+	if s.Lower.Amount > 0 {
+		tx = tx.Where("total >= ?", s.Lower)
+	}
+	if s.Higher.Amount > 0 {
+		tx = tx.Where("total <= ?", s.Higher)
+	}
+	if days := os.dayRanges(s.Days); days != nil {
+		tx = tx.Where(days)
+	}
+	if any := os.anyFilter(s.Any); any != nil {
+		tx = tx.Where(any)
+	}
+	tx = tx.Session(&gorm.Session{})
+	total, err := count(tx, s.Search)
 	if err != nil {
-		return nil, fmt.Errorf("find orders: %w", err)
+		return model.OrderPage{}, err
 	}
-	return o, nil
+	var o []model.Order
+	err = keyset(tx, s.Search, model.ASC).Select("id, type_id, establishment_id, address_id, status_id, total, currency, created_at, user_id").Find(&o).Error
+	if err != nil {
+		return model.OrderPage{}, fmt.Errorf("find orders: %w", dbError(err))
+	}
+	return newPage(o, s.Search, total), nil
+}
+
+// dayRanges returns the condition that matches the orders created in any of
+// the ranges, nil without ranges.
+func (os OrderStorage) dayRanges(rs []model.DayRange) *gorm.DB {
+	if len(rs) == 0 {
+		return nil
+	}
+	db := os.db.Session(&gorm.Session{NewDB: true})
+	var days *gorm.DB
+	for _, r := range rs {
+		tx := db.Where("orders.created_at >= ? AND orders.created_at < ?", r.From, r.To)
+		if r.Ests != nil {
+			tx = tx.Where("orders.establishment_id IN ?", r.Ests)
+		} else if len(r.Except) != 0 {
+			tx = tx.Where("orders.establishment_id NOT IN ?", r.Except)
+		}
+		if days == nil {
+			days = db.Where(tx)
+		} else {
+			days = days.Or(tx)
+		}
+	}
+	return days
 }
 
-func (os OrderStorage) GetTipsFromEmployee(eID uint64, start, end string) (float32, error) {
-	var sum float32
-	err := os.db.Table("orders").Where(`created_at BETWEEN ? AND ?`, fmt.Sprintf("%s 05:00:00", start), fmt.Sprintf("%s 05:00:00", end)).
-		Where("employee_id = ?", eID).Select("sum(total * tip)").Row().Scan(&sum)
-	// TODO CHECK TIMEZONE, CURRENLY IN CDT
+func (os OrderStorage) GetTipsFromEmployee(ctx context.Context, eID uint64, days []model.DayRange) (model.Money, error) {
+	var sum model.Money
+	tx := os.db.WithContext(ctx).Table("orders").Where("employee_id = ?", eID)
+	if d := os.dayRanges(days); d != nil {
+		tx = tx.Where(d)
+	}
+	err := tx.Select("sum(round(total * tip, 2))").Row().Scan(&sum)
 	if err != nil {
-		return 0, fmt.Errorf("failed tu get tips between (%s, %s): %w", start, end, err)
+		return model.Money{}, fmt.Errorf("failed tu get tips of %d: %w", eID, dbError(err))
 	}
 	return sum, nil
 }
 
-func (os OrderStorage) User(uID uint64, limit, offset int) ([]model.Order, error) {
-	tx := os.db.Preload("OrderProducts", func(db *gorm.DB) *gorm.DB {
-		return db.Select("id", "product_id", "quantity", "order_id")
-	}).Select("id", "address_id", "total", "status_id", "user_id", "pay_id", "created_at").Where("user_id = ?", uID)
-	if limit != 0 {
-		tx = tx.Limit(limit)
-	}
-	if offset != 0 {
-		tx = tx.Offset(offset)
+// User returns a page of the orders of the user with their products, the
+// newest first unless the search is sorted.
+func (os OrderStorage) User(ctx context.Context, uID uint64, s model.Search) (model.OrderPage, error) {
+	tx := os.db.WithContext(ctx).Model(&model.Order{}).Where("user_id = ?", uID).Session(&gorm.Session{})
+	total, err := count(tx, s)
+	if err != nil {
+		return model.OrderPage{}, err
 	}
 	var orders []model.Order
-	res := tx.Order("id DESC").Find(&orders)
-	if res.Error != nil {
-		return nil, fmt.Errorf("find: %w", res.Error)
+	err = keyset(tx, s, model.DES).Preload("OrderProducts", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "product_id", "name", "unit_price", "quantity", "subtotal", "currency", "order_id")
+	}).Select("id", "address_id", "total", "currency", "status_id", "user_id", "pay_id", "created_at", "establishment_id", "type_id").Find(&orders).Error
+	if err != nil {
+		return model.OrderPage{}, fmt.Errorf("find: %w", dbError(err))
 	}
-	return orders, nil
+	return newPage(orders, s, total), nil
 }
 
-func (os OrderStorage) Waiter(wID uint64) ([]model.Order, error) {
+func (os OrderStorage) Waiter(ctx context.Context, wID uint64) ([]model.Order, error) {
 	var o []model.Order
-	err := os.db.Preload("OrderProducts", func(db *gorm.DB) *gorm.DB {
-		return db.Select("id", "product_id", "quantity", "order_id", "is_ready", "is_delivered")
-	}).Select("id", "table_id", "total").Where("employee_id = ? AND status_id = ?", wID, model.Pending).Find(&o).Error
+	err := os.db.WithContext(ctx).Preload("OrderProducts", func(db *gorm.DB) *gorm.DB {
+		return db.Where("is_cancelled = false").Select("id", "product_id", "name", "unit_price", "quantity", "subtotal", "currency", "order_id", "is_ready", "is_delivered")
+	}).Select("id", "table_id", "total", "currency").Where("employee_id = ? AND status_id IN ?", wID, servingTables).Find(&o).Error
 	if err != nil {
-		return nil, fmt.Errorf("find order products: %w", err)
+		return nil, fmt.Errorf("find order products: %w", dbError(err))
 	}
 	return o, nil
 }
 
-func (os OrderStorage) WaiterPending(wID uint64) ([]model.Order, error) {
+func (os OrderStorage) WaiterPending(ctx context.Context, wID uint64) ([]model.Order, error) {
 	var o []model.Order
-	err := os.db.Preload("OrderProducts", func(db *gorm.DB) *gorm.DB {
-		return db.Where("is_ready = true AND is_delivered = false").Select("id", "product_id", "quantity", "order_id", "is_ready", "is_delivered")
-	}).Select("id", "table_id").Where("employee_id = ? AND status_id = ?", wID, model.Pending).Find(&o).Error
+	err := os.db.WithContext(ctx).Preload("OrderProducts", func(db *gorm.DB) *gorm.DB {
+		return db.Where("is_ready = true AND is_delivered = false AND is_cancelled = false").Select("id", "product_id", "name", "quantity", "order_id", "is_ready", "is_delivered")
+	}).Select("id", "table_id").Where("employee_id = ? AND status_id IN ?", wID, servingTables).Find(&o).Error
 	if err != nil {
-		return nil, fmt.Errorf("find order products: %w", err)
+		return nil, fmt.Errorf("find order products: %w", dbError(err))
 	}
 	return o, nil
 }
 
-func (os OrderStorage) Create(o *model.Order) error {
+func (os OrderStorage) Create(ctx context.Context, o *model.Order) error {
 	if o == nil {
-		return fmt.Errorf("nil order")
-	}
-	if err := os.db.Create(o).Error; err != nil {
-		return fmt.Errorf("create order: %w", err)
+		return apperr.New(apperr.ErrInvalidArgument, "nil order")
 	}
-	return nil
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(o).Error; err != nil {
+			return fmt.Errorf("create order: %w", dbError(err))
+		}
+		nv := model.AuditValues{"status": o.StatusID.String(), "total": o.Total.String(), "products": len(o.OrderProducts)}
+		if err := audit(ctx, tx, model.AuditCreate, o.ID, 0, nil, nv); err != nil {
+			return err
+		}
+		return saveEvents(tx, model.EventOrderCreated, []model.Order{*o})
+	})
 }
 
-func (os OrderStorage) Products(oID uint64) ([]model.OrderProduct, error) {
+func (os OrderStorage) Products(ctx context.Context, oID uint64) ([]model.OrderProduct, error) {
 	var ps []model.OrderProduct
-	if err := os.db.Where("order_id = ?", oID).Find(&ps).Error; err != nil {
-		return nil, fmt.Errorf("find all products by order: %w", err)
+	if err := os.db.WithContext(ctx).Where("order_id = ?", oID).Find(&ps).Error; err != nil {
+		return nil, fmt.Errorf("find all products by order: %w", dbError(err))
 	}
 	return ps, nil
 }
 
-func (os OrderStorage) updateTotal(oID uint64, total float64) error {
-	o := model.Order{}
-	if err := os.db.Where("id = ?", oID).Select("total").First(&o).Error; err != nil {
-		return fmt.Errorf("first order: %w", err)
+func updateTotal(tx *gorm.DB, oID uint64, total model.Money) error {
+	res := tx.Model(&model.Order{}).Where("id = ?", oID).Update("total", gorm.Expr("total + ?", total))
+	if res.Error != nil {
+		return fmt.Errorf("update total: %w", dbError(res.Error))
 	}
-	if err := os.db.Model(&model.Order{Model: model.Model{ID: oID}}).Update("total", o.Total+total).Error; err != nil {
-		return fmt.Errorf("update total: %w", err)
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("update total: %w", dbError(gorm.ErrRecordNotFound))
 	}
 	return nil
 }
 
-func (os OrderStorage) AddProducts(oID uint64, total float64, ps []model.OrderProduct) error {
+func (os OrderStorage) AddProducts(ctx context.Context, oID uint64, total model.Money, ps []model.OrderProduct) error {
 	if ps == nil {
-		return fmt.Errorf("nil products")
-	}
-	if err := os.db.Model(&model.Order{Model: model.Model{ID: oID}}).Association("OrderProducts").Append(&ps); err != nil {
-		return fmt.Errorf("append products to order: %w", err)
+		return apperr.New(apperr.ErrInvalidArgument, "nil products")
 	}
-	if err := os.updateTotal(oID, total); err != nil {
-		return fmt.Errorf("os.updateTotal: %w", err)
-	}
-	return nil
-}
-
-func (os OrderStorage) Pay(oID uint64, total float64) error {
-	if err := os.db.Model(&model.Order{Model: model.Model{ID: oID}}).Updates(&model.Order{Total: total, StatusID: model.Completed}).Error; err != nil {
-		return fmt.Errorf("pay order in db: %w", err)
-	}
-	return nil
-}
-
-func (os OrderStorage) SetPayment(oID uint64, total float64, pID string) error {
-	if err := os.db.Model(&model.Order{Model: model.Model{ID: oID}}).Updates(&model.Order{Total: total, StatusID: model.Completed, PayID: &pID}).Error; err != nil {
-		return fmt.Errorf("set payment in db: %w", err)
-	}
-	return nil
-}
-
-func (os OrderStorage) PayPaypal(pID string) error {
-	if err := os.db.Model(&model.Order{}).Where("pay_id = ?", pID).Update("status_id", model.Completed).Error; err != nil {
-		return fmt.Errorf("pay order with paypal in db: %w", err)
-	}
-	return nil
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := updateTotal(tx, oID, total); err != nil {
+			return fmt.Errorf("updateTotal: %w", err)
+		}
+		if err := tx.Model(&model.Order{Model: model.Model{ID: oID}}).Association("OrderProducts").Append(&ps); err != nil {
+			return fmt.Errorf("append products to order: %w", dbError(err))
+		}
+		o := model.Order{}
+		if err := tx.Select("total, currency").Where("id = ?", oID).First(&o).Error; err != nil {
+			return fmt.Errorf("first order: %w", dbError(err))
+		}
+		ids := make([]uint64, len(ps))
+		for i := range ps {
+			ids[i] = ps[i].ID
+		}
+		old := model.AuditValues{"total": (model.Money{Amount: o.Total.Amount - total.Amount, Currency: o.Total.Currency}).String()}
+		nv := model.AuditValues{"total": o.Total.String(), "products": ids}
+		if err := audit(ctx, tx, model.AuditAddProducts, oID, 0, old, nv); err != nil {
+			return err
+		}
+		return addEvents(tx, model.EventProductsAdded, "id = ?", oID)
+	})
 }
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"reflect"
 	"testing"
 
@@ -11,34 +13,58 @@ import (
 
 var TestConfigDB DBConnection = DBConnection{
 	TypeDB:   POSTGRESQL,
-	User:     "admin_restaurant",
-	Password: "RestAuraNt_pgsql.561965697",
-	Host:     "localhost",
-	Port:     "5433",
-	NameDB:   "testing",
+	User:     testEnv("ORDER_TEST_DB_USER", "admin_restaurant"),
+	Password: os.Getenv("ORDER_TEST_DB_PWD"),
+	Host:     testEnv("ORDER_TEST_DB_HOST", "localhost"),
+	Port:     testEnv("ORDER_TEST_DB_PORT", "5433"),
+	NameDB:   testEnv("ORDER_TEST_DB_NAME", "testing"),
 }
 
-func TestCleanup(t *testing.T) {
-	err := NewDB(TestConfigDB)
+func testEnv(env, def string) string {
+	if v, f := os.LookupEnv(env); f {
+		return v
+	}
+	return def
+}
+
+// requirePostgres opens a connection to the testing database, closed at the
+// end of the test, and skips the test when its password is not set in
+// ORDER_TEST_DB_PWD or the database is not running.
+func requirePostgres(t *testing.T) *DB {
+	if TestConfigDB.Password == "" {
+		t.Skip("ORDER_TEST_DB_PWD is not set")
+	}
+	db, err := NewDB(context.Background(), TestConfigDB)
 	if err != nil {
-		t.Fatalf("NewGormDB: %s", err)
+		t.Skipf("postgres is not available: %s", err)
 	}
-	models := []interface{}{&model.Order{}, &model.OrderProduct{}}
-	err = Drop(models...)
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("failed to close db: %s", err)
+		}
+	})
+	return db
+}
+
+func TestCleanup(t *testing.T) {
+	db := requirePostgres(t)
+	var err error
+	models := []interface{}{&model.Order{}, &model.OrderProduct{}, &model.OrderEvent{}, &model.OrderAudit{}, &model.Refund{}, &model.PaymentEvent{}, &model.IdempotencyKey{}}
+	err = db.Drop(models...)
 	if err != nil {
 		t.Fatalf("Failed to Create tables: %s", err)
 	}
 }
 
-func generateData(t *testing.T) {
+func generateData(t *testing.T, db *DB) {
 	orders := []model.Order{
 		{
 			TypeID:          model.Local,
 			EmployeeID:      1,
 			EstablishmentID: 1,
 			TableID:         1,
-			StatusID:        model.Pending,
-			Total:           155055.34,
+			StatusID:        model.InPreparation,
+			Total:           model.NewMoney(15505534, model.MXN),
 			OrderProducts: []model.OrderProduct{
 				{ProductID: 1, Quantity: 3},
 				{ProductID: 1, Quantity: 2},
@@ -49,8 +75,8 @@ func generateData(t *testing.T) {
 			EmployeeID:      1,
 			EstablishmentID: 1,
 			TableID:         1,
-			StatusID:        model.Completed,
-			Total:           100,
+			StatusID:        model.Paid,
+			Total:           model.NewMoney(10000, model.MXN),
 			OrderProducts: []model.OrderProduct{
 				{ProductID: 3, Quantity: 3, IsReady: true},
 				{ProductID: 4, Quantity: 2, IsReady: true},
@@ -61,8 +87,8 @@ func generateData(t *testing.T) {
 			EmployeeID:      1,
 			EstablishmentID: 1,
 			TableID:         2,
-			StatusID:        model.Pending,
-			Total:           200,
+			StatusID:        model.InPreparation,
+			Total:           model.NewMoney(20000, model.MXN),
 			OrderProducts: []model.OrderProduct{
 				{ProductID: 3, Quantity: 3},
 				{ProductID: 6, Quantity: 2},
@@ -73,8 +99,8 @@ func generateData(t *testing.T) {
 			EmployeeID:      2,
 			EstablishmentID: 2,
 			TableID:         3,
-			StatusID:        model.Pending,
-			Total:           200,
+			StatusID:        model.InPreparation,
+			Total:           model.NewMoney(20000, model.MXN),
 			OrderProducts: []model.OrderProduct{
 				{ProductID: 3, Quantity: 3},
 				{ProductID: 6, Quantity: 2},
@@ -82,29 +108,30 @@ func generateData(t *testing.T) {
 			},
 		},
 	}
-	if err := _db.CreateInBatches(&orders, len(orders)).Error; err != nil {
+	if err := db.db.CreateInBatches(&orders, len(orders)).Error; err != nil {
 		t.Fatalf("failed to generate data: %s", err)
 	}
 }
 
 func TestOrderStorage_AddProducts(t *testing.T) {
-	if err := NewDB(TestConfigDB); err != nil {
-		t.Fatalf("failed to start connection with db: %s", err)
-	}
+	db := requirePostgres(t)
 	models := []interface{}{
 		model.Order{},
 		model.OrderProduct{},
+		model.OrderEvent{},
+		model.OrderAudit{},
+		model.Refund{},
 	}
-	_db.AutoMigrate(models...)
+	db.db.AutoMigrate(models...)
 	t.Cleanup(func() {
-		err := _db.Migrator().DropTable(models...)
+		err := db.db.Migrator().DropTable(models...)
 		if err != nil {
 			t.Fatalf("Failed to Create tables: %s", err)
 		}
 	})
-	os := NewOrderStorage()
+	os := NewOrderStorage(db)
 
-	generateData(t)
+	generateData(t, db)
 	type args struct {
 		oID uint64
 		ps  []model.OrderProduct
@@ -129,7 +156,7 @@ func TestOrderStorage_AddProducts(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.os.AddProducts(tt.args.oID, 100, tt.args.ps); (err != nil) != tt.wantErr {
+			if err := tt.os.AddProducts(context.Background(), tt.args.oID, model.NewMoney(10000, model.MXN), tt.args.ps); (err != nil) != tt.wantErr {
 				t.Errorf("OrderStorage.AddProducts() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -137,23 +164,24 @@ func TestOrderStorage_AddProducts(t *testing.T) {
 }
 
 func TestOrderStorage_Waiter(t *testing.T) {
-	if err := NewDB(TestConfigDB); err != nil {
-		t.Fatalf("failed to start connection with db: %s", err)
-	}
+	db := requirePostgres(t)
 	models := []interface{}{
 		model.Order{},
 		model.OrderProduct{},
+		model.OrderEvent{},
+		model.OrderAudit{},
+		model.Refund{},
 	}
-	_db.AutoMigrate(models...)
+	db.db.AutoMigrate(models...)
 	t.Cleanup(func() {
-		err := _db.Migrator().DropTable(models...)
+		err := db.db.Migrator().DropTable(models...)
 		if err != nil {
 			t.Fatalf("Failed to Create tables: %s", err)
 		}
 	})
-	os := NewOrderStorage()
+	os := NewOrderStorage(db)
 
-	generateData(t)
+	generateData(t, db)
 	tests := []struct {
 		name    string
 		os      OrderStorage
@@ -188,7 +216,7 @@ func TestOrderStorage_Waiter(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.os.Waiter(tt.giveID)
+			got, err := tt.os.Waiter(context.Background(), tt.giveID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("OrderStorage.Waiter() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -209,23 +237,24 @@ func TestOrderStorage_Waiter(t *testing.T) {
 }
 
 func TestOrderStorage_Kitchen(t *testing.T) {
-	if err := NewDB(TestConfigDB); err != nil {
-		t.Fatalf("failed to start connection with db: %s", err)
-	}
+	db := requirePostgres(t)
 	models := []interface{}{
 		model.Order{},
 		model.OrderProduct{},
+		model.OrderEvent{},
+		model.OrderAudit{},
+		model.Refund{},
 	}
-	_db.AutoMigrate(models...)
+	db.db.AutoMigrate(models...)
 	t.Cleanup(func() {
-		err := _db.Migrator().DropTable(models...)
+		err := db.db.Migrator().DropTable(models...)
 		if err != nil {
 			t.Fatalf("Failed to Create tables: %s", err)
 		}
 	})
-	os := NewOrderStorage()
+	os := NewOrderStorage(db)
 
-	generateData(t)
+	generateData(t, db)
 	tests := []struct {
 		name    string
 		os      OrderStorage
@@ -249,7 +278,7 @@ func TestOrderStorage_Kitchen(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.os.Kitchen(uint64(tt.giveID), 0)
+			got, err := tt.os.Kitchen(context.Background(), uint64(tt.giveID), 0)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("OrderStorage.Kitchen() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/modular-project/orders-service/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// saveEvents adds to the outbox an event of type t for every order, it must
+// be called with the transaction of the change.
+func saveEvents(tx *gorm.DB, t string, os []model.Order) error {
+	if len(os) == 0 {
+		return nil
+	}
+	es := make([]model.OrderEvent, len(os))
+	for i := range os {
+		e, err := model.NewOrderEvent(t, os[i])
+		if err != nil {
+			return fmt.Errorf("new event: %w", err)
+		}
+		es[i] = e
+	}
+	if err := tx.Create(&es).Error; err != nil {
+		return fmt.Errorf("create events: %w", dbError(err))
+	}
+	return nil
+}
+
+// addEvents adds to the outbox an event of type t for the orders that match
+// the query, including the deleted ones.
+func addEvents(tx *gorm.DB, t string, query interface{}, args ...interface{}) error {
+	var os []model.Order
+	err := tx.Unscoped().Select("id, type_id, user_id, employee_id, establishment_id, status_id, total, currency").
+		Where(query, args...).Order("id").Find(&os).Error
+	if err != nil {
+		return fmt.Errorf("find orders: %w", dbError(err))
+	}
+	return saveEvents(tx, t, os)
+}
+
+type OutboxStorage struct {
+	db *gorm.DB
+}
+
+func NewOutboxStorage(db *DB) OutboxStorage {
+	return OutboxStorage{db: db.db}
+}
+
+// Dispatch calls publish with the oldest unpublished events, up to limit, and
+// marks them as published. It stops at the first failure, the event keeps
+// pending and its attempts are increased. The events are locked while they are
+// published so several relays can run at the same time.
+func (os OutboxStorage) Dispatch(ctx context.Context, limit int, publish func(model.OrderEvent) error) (int, error) {
+	n := 0
+	var perr error
+	err := os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var es []model.OrderEvent
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("published_at IS NULL").Order("id").Limit(limit).Find(&es).Error
+		if err != nil {
+			return fmt.Errorf("find events: %w", dbError(err))
+		}
+		for _, e := range es {
+			if perr = publish(e); perr != nil {
+				if err := tx.Model(&e).Update("attempts", gorm.Expr("attempts + 1")).Error; err != nil {
+					return fmt.Errorf("update attempts: %w", dbError(err))
+				}
+				return nil
+			}
+			if err := tx.Model(&e).Update("published_at", time.Now()).Error; err != nil {
+				return fmt.Errorf("update published at: %w", dbError(err))
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if perr != nil {
+		return n, fmt.Errorf("publish: %w", perr)
+	}
+	return n, nil
+}
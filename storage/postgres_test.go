@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/modular-project/orders-service/controller"
+	"github.com/modular-project/orders-service/model"
+	"github.com/modular-project/orders-service/storage/storagetest"
+)
+
+type postgresTransactor struct {
+	uow UnitOfWork
+}
+
+func (pt postgresTransactor) WithTx(c context.Context, fn func(controller.OrderStorager, controller.OrderStatusStorager) error) error {
+	return pt.uow.WithTx(c, func(tx Tx) error {
+		return fn(tx.Orders(), tx.OrderStatus())
+	})
+}
+
+func TestPostgresStorage(t *testing.T) {
+	db := requirePostgres(t)
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		models := []interface{}{&model.Order{}, &model.OrderProduct{}, &model.OrderEvent{}, &model.OrderAudit{}, &model.Refund{}, &model.PaymentEvent{}, &model.IdempotencyKey{}}
+		if err := db.Migrate(models...); err != nil {
+			t.Fatalf("failed to migrate: %s", err)
+		}
+		t.Cleanup(func() {
+			if err := db.Drop(models...); err != nil {
+				t.Fatalf("failed to drop tables: %s", err)
+			}
+		})
+		return storagetest.Backend{
+			Orders: NewOrderStorage(db),
+			Status: NewOrderStatusStorage(db),
+			Tx:     postgresTransactor{uow: NewUnitOfWork(db)},
+			Outbox: NewOutboxStorage(db),
+			Keys:   NewIdempotencyStorage(db),
+		}
+	})
+}
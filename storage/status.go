@@ -1,75 +1,407 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/modular-project/orders-service/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type orderStatusStorage struct {
 	db *gorm.DB
 }
 
-func NewOrderStatusStorage() orderStatusStorage {
-	return orderStatusStorage{db: _db}
+func NewOrderStatusStorage(db *DB) orderStatusStorage {
+	return orderStatusStorage{db: db.db}
 }
 
-func (os orderStatusStorage) CancelOrders(ids []uint64, uID uint64) error {
-	if err := os.db.Delete(&model.Order{}, "user_id = ? AND status_id = ?", uID, model.WithoutPay).Error; err != nil {
-		return fmt.Errorf("storage CancelOrders: %w", err)
+// orderColumns are the columns read by Order, PaymentOrder and CaptureOrder.
+const orderColumns = "id, type_id, user_id, employee_id, establishment_id, status_id, total, currency, cancel_reason, cancel_note, " +
+	"pay_id, pay_request_id, payment_method, capture_id, captured, refunded"
+
+// Order returns the order without its products, inside a transaction the
+// order stays locked until it ends.
+func (os orderStatusStorage) Order(ctx context.Context, oID uint64) (model.Order, error) {
+	o := model.Order{}
+	err := os.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", oID).Select(orderColumns).First(&o).Error
+	if err != nil {
+		return model.Order{}, fmt.Errorf("first order: %w", dbError(err))
 	}
-	return nil
+	return o, nil
+}
+
+func cancelValues(cn model.Cancellation) model.AuditValues {
+	return model.AuditValues{"reason": cn.Reason.String(), "note": cn.Note}
+}
+
+// CancelOrder moves the order from status from to Cancelled.
+func (os orderStatusStorage) CancelOrder(ctx context.Context, oID uint64, from model.Status, cn model.Cancellation) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.Order{}).Where("id = ? AND status_id = ?", oID, from).
+			Updates(map[string]interface{}{"status_id": model.Cancelled, "cancel_reason": cn.Reason, "cancel_note": cn.Note})
+		if res.Error != nil {
+			return fmt.Errorf("update order status: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("order %d: %w", oID, model.ErrStatusChanged)
+		}
+		nv := cancelValues(cn)
+		nv["status"] = model.Cancelled.String()
+		if err := audit(ctx, tx, model.AuditCancel, oID, 0, model.AuditValues{"status": from.String()}, nv); err != nil {
+			return err
+		}
+		return addEvents(tx, model.EventOrderCancelled, "id = ?", oID)
+	})
+}
+
+// ExpireOrder moves the order from status from to Expired.
+func (os orderStatusStorage) ExpireOrder(ctx context.Context, oID uint64, from model.Status) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.Order{}).Where("id = ? AND status_id = ?", oID, from).Update("status_id", model.Expired)
+		if res.Error != nil {
+			return fmt.Errorf("update order status: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("order %d: %w", oID, model.ErrStatusChanged)
+		}
+		old := model.AuditValues{"status": from.String()}
+		if err := audit(ctx, tx, model.AuditExpire, oID, 0, old, model.AuditValues{"status": model.Expired.String()}); err != nil {
+			return err
+		}
+		return addEvents(tx, model.EventOrderExpired, "id = ?", oID)
+	})
+}
+
+// SetStatus moves the order from status from to status to.
+func (os orderStatusStorage) SetStatus(ctx context.Context, oID uint64, from, to model.Status) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.Order{}).Where("id = ? AND status_id = ?", oID, from).Update("status_id", to)
+		if res.Error != nil {
+			return fmt.Errorf("update order status: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("order %d: %w", oID, model.ErrStatusChanged)
+		}
+		old := model.AuditValues{"status": from.String()}
+		if err := audit(ctx, tx, model.AuditStatus, oID, 0, old, model.AuditValues{"status": to.String()}); err != nil {
+			return err
+		}
+		return addEvents(tx, model.StatusEvent(to), "id = ?", oID)
+	})
+}
+
+// CancelProducts voids the products ids of the order and subtracts them from its total.
+func (os orderStatusStorage) CancelProducts(ctx context.Context, oID uint64, ids []uint64, cn model.Cancellation) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ps []model.OrderProduct
+		err := tx.Select("id, subtotal, currency").Where("order_id = ? AND id IN ? AND is_cancelled = false", oID, ids).Order("id").Find(&ps).Error
+		if err != nil {
+			return fmt.Errorf("find order products: %w", dbError(err))
+		}
+		if len(ps) != len(ids) {
+			return fmt.Errorf("products of order %d: %w", oID, model.ErrStatusChanged)
+		}
+		err = tx.Model(&model.OrderProduct{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"is_cancelled": true, "cancel_reason": cn.Reason, "cancel_note": cn.Note}).Error
+		if err != nil {
+			return fmt.Errorf("update order products: %w", dbError(err))
+		}
+		voided := model.Money{}
+		for _, p := range ps {
+			voided.Amount += p.Subtotal.Amount
+			nv := cancelValues(cn)
+			nv["is_cancelled"] = true
+			if err := audit(ctx, tx, model.AuditCancelProduct, oID, p.ID, model.AuditValues{"is_cancelled": false}, nv); err != nil {
+				return err
+			}
+		}
+		if err := updateTotal(tx, oID, voided.Mul(-1)); err != nil {
+			return fmt.Errorf("updateTotal: %w", err)
+		}
+		return addEvents(tx, model.EventProductsCancelled, "id = ?", oID)
+	})
 }
 
-func (os orderStatusStorage) TotalPrice(oID uint64, uID uint64) (float64, error) {
+// Status returns the status of the order, inside a transaction the order stays
+// locked until it ends.
+func (os orderStatusStorage) Status(ctx context.Context, oID uint64) (model.Status, error) {
 	o := model.Order{}
-	if err := os.db.Where("id = ? AND user_id = ?", oID, uID).Select("total").First(&o).Error; err != nil {
-		return 0, fmt.Errorf("first order: %w", err)
+	if err := os.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", oID).Select("status_id").First(&o).Error; err != nil {
+		return 0, fmt.Errorf("first order: %w", dbError(err))
 	}
-	return o.Total, nil
+	return o.StatusID, nil
 }
 
-func (os orderStatusStorage) SetPaymentDelivery(oID uint64, eID uint64, pID string, aID string) error {
-	o := model.Order{
-		EstablishmentID: eID,
-		PayID:           &pID,
-		AddressID:       &aID,
+// PaymentOrder is like Order but finds the order by its payment ID.
+func (os orderStatusStorage) PaymentOrder(ctx context.Context, pID string) (model.Order, error) {
+	o := model.Order{}
+	err := os.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("pay_id = ?", pID).Select(orderColumns).First(&o).Error
+	if err != nil {
+		return model.Order{}, fmt.Errorf("first order: %w", dbError(err))
 	}
-	if err := os.db.Model(&model.Order{Model: model.Model{ID: oID}}).Updates(&o).Error; err != nil {
-		return fmt.Errorf("update order: %w", err)
+	return o, nil
+}
+
+// CaptureOrder is like Order but finds the order by its capture ID.
+func (os orderStatusStorage) CaptureOrder(ctx context.Context, cID string) (model.Order, error) {
+	o := model.Order{}
+	err := os.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("capture_id = ?", cID).Select(orderColumns).First(&o).Error
+	if err != nil {
+		return model.Order{}, fmt.Errorf("first order: %w", dbError(err))
 	}
-	return nil
+	return o, nil
 }
 
-func (os orderStatusStorage) PayLocal(oID uint64, eID uint64, tip float32) error {
-	err := os.db.Model(&model.Order{}).Where("id = ? AND employee_id = ?", oID, eID).Updates(map[string]interface{}{"status_id": model.Completed, "tip": tip}).Error
+// UnpaidOrders returns up to limit orders after the ID after that are in
+// AwaitingPayment and were created before before, ordered by ID.
+func (os orderStatusStorage) UnpaidOrders(ctx context.Context, before time.Time, after uint64, limit int) ([]model.Order, error) {
+	var orders []model.Order
+	err := os.db.WithContext(ctx).Select(orderColumns+", created_at").
+		Where("status_id = ? AND created_at < ? AND id > ?", model.AwaitingPayment, before, after).
+		Order("id").Limit(limit).Find(&orders).Error
 	if err != nil {
-		return fmt.Errorf("update order status: %w", err)
+		return nil, fmt.Errorf("find orders: %w", dbError(err))
+	}
+	return orders, nil
+}
+
+// AddPaymentEvent stores e, it returns ErrPaymentEventProcessed when it was
+// already stored.
+func (os orderStatusStorage) AddPaymentEvent(ctx context.Context, e *model.PaymentEvent) error {
+	res := os.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(e)
+	if res.Error != nil {
+		return fmt.Errorf("create payment event: %w", dbError(res.Error))
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("payment event %s: %w", e.ID, model.ErrPaymentEventProcessed)
 	}
 	return nil
 }
 
-func (os orderStatusStorage) PayDelivey(pID string) error {
-	err := os.db.Model(&model.Order{}).Where("pay_id = ?", pID).Update("status_id", model.Completed).Error
-	if err != nil {
-		return fmt.Errorf("update order status: %w", err)
+// HasRefund reports if the refund prID of the payment provider is stored.
+func (os orderStatusStorage) HasRefund(ctx context.Context, prID string) (bool, error) {
+	var n int64
+	if err := os.db.WithContext(ctx).Model(&model.Refund{}).Where("provider_id = ?", prID).Count(&n).Error; err != nil {
+		return false, fmt.Errorf("count refunds: %w", dbError(err))
+	}
+	return n > 0, nil
+}
+
+func paymentValues(o model.Order) model.AuditValues {
+	return model.AuditValues{"establishment_id": o.EstablishmentID, "pay_id": o.PayID, "address_id": o.AddressID, "payment_method": o.PaymentMethod.String()}
+}
+
+// SetPaymentRequest stores the key of the payment being created for the order.
+func (os orderStatusStorage) SetPaymentRequest(ctx context.Context, oID uint64, key string) error {
+	res := os.db.WithContext(ctx).Model(&model.Order{}).Where("id = ?", oID).Update("pay_request_id", key)
+	if res.Error != nil {
+		return fmt.Errorf("update order: %w", dbError(res.Error))
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("order %d: %w", oID, dbError(gorm.ErrRecordNotFound))
 	}
 	return nil
 }
 
-func (os orderStatusStorage) CompleteProduct(pID uint64) error {
-	err := os.db.Model(&model.OrderProduct{}).Where("id = ?", pID).Update("is_ready", true).Error
-	if err != nil {
-		return fmt.Errorf("update order product status: %w", err)
+// SetPaymentDelivery stores the payment pID of the order and clears the key
+// of the payment being created.
+func (os orderStatusStorage) SetPaymentDelivery(ctx context.Context, oID uint64, eID uint64, pID string, aID string, pm model.PaymentMethod) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		old := model.Order{}
+		if err := tx.Select("establishment_id, pay_id, address_id, payment_method").Where("id = ?", oID).Find(&old).Error; err != nil {
+			return fmt.Errorf("find order: %w", dbError(err))
+		}
+		o := model.Order{
+			EstablishmentID: old.EstablishmentID,
+			PayID:           &pID,
+			AddressID:       &aID,
+			PaymentMethod:   pm,
+		}
+		if eID != 0 {
+			o.EstablishmentID = eID
+		}
+		res := tx.Model(&model.Order{Model: model.Model{ID: oID}}).Updates(map[string]interface{}{
+			"establishment_id": o.EstablishmentID, "pay_id": pID, "address_id": aID, "payment_method": pm, "pay_request_id": nil,
+		})
+		if res.Error != nil {
+			return fmt.Errorf("update order: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		return audit(ctx, tx, model.AuditSetPayment, oID, 0, paymentValues(old), paymentValues(o))
+	})
+}
+
+// DenyPayment removes the payment pID whose capture was denied from the order,
+// the order is paid again with a new payment.
+func (os orderStatusStorage) DenyPayment(ctx context.Context, oID uint64, pID string) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.Order{}).Where("id = ? AND pay_id = ?", oID, pID).
+			Updates(map[string]interface{}{"pay_id": nil, "pay_request_id": nil})
+		if res.Error != nil {
+			return fmt.Errorf("update order: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("payment %s of order %d: %w", pID, oID, model.ErrStatusChanged)
+		}
+		return audit(ctx, tx, model.AuditDenyPayment, oID, 0, model.AuditValues{"pay_id": pID}, model.AuditValues{"pay_id": nil})
+	})
+}
+
+func (os orderStatusStorage) PayLocal(ctx context.Context, oID uint64, eID uint64, tip model.TipRate, from, to model.Status) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.Order{}).Where("id = ? AND employee_id = ? AND status_id = ?", oID, eID, from).
+			Updates(map[string]interface{}{"status_id": to, "tip": tip, "captured": gorm.Expr("total"), "payment_method": model.CASH})
+		if res.Error != nil {
+			return fmt.Errorf("update order status: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("order %d of employee %d: %w", oID, eID, model.ErrStatusChanged)
+		}
+		old := model.AuditValues{"status": from.String()}
+		if err := audit(ctx, tx, model.AuditPay, oID, 0, old, model.AuditValues{"status": to.String(), "tip": tip.String()}); err != nil {
+			return err
+		}
+		return addEvents(tx, model.StatusEvent(to), "id = ?", oID)
+	})
+}
+
+func (os orderStatusStorage) PayDelivey(ctx context.Context, pID string, cp model.Capture, from, to model.Status) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var oIDs []uint64
+		if err := tx.Model(&model.Order{}).Where("pay_id = ? AND status_id = ?", pID, from).Pluck("id", &oIDs).Error; err != nil {
+			return fmt.Errorf("find orders: %w", dbError(err))
+		}
+		res := tx.Model(&model.Order{}).Where("id IN ? AND status_id = ?", oIDs, from).
+			Updates(map[string]interface{}{"status_id": to, "capture_id": cp.ID, "captured": cp.Amount})
+		if res.Error != nil {
+			return fmt.Errorf("update order status: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("order with payment %s: %w", pID, model.ErrStatusChanged)
+		}
+		for _, id := range oIDs {
+			old := model.AuditValues{"status": from.String()}
+			nv := model.AuditValues{"status": to.String(), "pay_id": pID, "capture_id": cp.ID, "captured": cp.Amount.String()}
+			if err := audit(ctx, tx, model.AuditPay, id, 0, old, nv); err != nil {
+				return err
+			}
+		}
+		return addEvents(tx, model.StatusEvent(to), "pay_id = ? AND status_id = ?", pID, to)
+	})
+}
+
+func refundValues(s model.Status, rf *model.Refund) model.AuditValues {
+	return model.AuditValues{"status": s.String(), "refund_id": rf.ID, "amount": rf.Amount.String(), "reason": rf.Reason}
+}
+
+// AddRefund stores rf as a pending refund, the order does not change until
+// the refund is completed with Refund.
+func (os orderStatusStorage) AddRefund(ctx context.Context, rf *model.Refund) error {
+	rf.Status = model.RefundPending
+	if err := os.db.WithContext(ctx).Create(rf).Error; err != nil {
+		return fmt.Errorf("create refund: %w", dbError(err))
 	}
 	return nil
 }
 
-func (os orderStatusStorage) DeliverProduct(ids []uint64) error {
-	err := os.db.Table("order_products").Where("id IN ?", ids).Updates(&model.OrderProduct{IsDelivered: true}).Error
+// PendingRefund returns the pending refund of the order.
+func (os orderStatusStorage) PendingRefund(ctx context.Context, oID uint64) (model.Refund, error) {
+	rf := model.Refund{}
+	err := os.db.WithContext(ctx).Where("order_id = ? AND status = ?", oID, model.RefundPending).Order("id").First(&rf).Error
 	if err != nil {
-		return fmt.Errorf("update: %w", err)
+		return model.Refund{}, fmt.Errorf("first refund: %w", dbError(err))
+	}
+	return rf, nil
+}
+
+// FailRefund marks the pending refund rID as failed.
+func (os orderStatusStorage) FailRefund(ctx context.Context, rID uint64) error {
+	res := os.db.WithContext(ctx).Model(&model.Refund{}).Where("id = ? AND status = ?", rID, model.RefundPending).
+		Update("status", model.RefundFailed)
+	if res.Error != nil {
+		return fmt.Errorf("update refund: %w", dbError(res.Error))
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("refund %d: %w", rID, model.ErrStatusChanged)
 	}
 	return nil
 }
+
+// Refund stores rf, or completes it when it is pending, and adds its amount
+// to the money refunded of the order. The order must be in status from and
+// can not refund more than it captured.
+func (os orderStatusStorage) Refund(ctx context.Context, rf *model.Refund, from, to model.Status) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.Order{}).Where("id = ? AND status_id = ? AND refunded + ? <= captured", rf.OrderID, from, rf.Amount).
+			Updates(map[string]interface{}{"status_id": to, "refunded": gorm.Expr("refunded + ?", rf.Amount)})
+		if res.Error != nil {
+			return fmt.Errorf("update order refunded: %w", dbError(res.Error))
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("order %d: %w", rf.OrderID, model.ErrStatusChanged)
+		}
+		if rf.ID == 0 {
+			if err := tx.Create(rf).Error; err != nil {
+				return fmt.Errorf("create refund: %w", dbError(err))
+			}
+		} else {
+			res := tx.Model(&model.Refund{}).Where("id = ? AND status = ?", rf.ID, model.RefundPending).
+				Updates(map[string]interface{}{"status": rf.Status, "provider_id": rf.ProviderID})
+			if res.Error != nil {
+				return fmt.Errorf("update refund: %w", dbError(res.Error))
+			}
+			if res.RowsAffected == 0 {
+				return fmt.Errorf("refund %d: %w", rf.ID, model.ErrStatusChanged)
+			}
+		}
+		if err := audit(ctx, tx, model.AuditRefund, rf.OrderID, 0, model.AuditValues{"status": from.String()}, refundValues(to, rf)); err != nil {
+			return err
+		}
+		return addEvents(tx, model.StatusEvent(to), "id = ?", rf.OrderID)
+	})
+}
+
+func (os orderStatusStorage) CompleteProduct(ctx context.Context, pID uint64) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ps []model.OrderProduct
+		if err := tx.Select("id, order_id, is_ready").Where("id = ?", pID).Find(&ps).Error; err != nil {
+			return fmt.Errorf("find order product: %w", dbError(err))
+		}
+		err := tx.Model(&model.OrderProduct{}).Where("id = ?", pID).Update("is_ready", true).Error
+		if err != nil {
+			return fmt.Errorf("update order product status: %w", dbError(err))
+		}
+		for _, p := range ps {
+			old := model.AuditValues{"is_ready": p.IsReady}
+			if err := audit(ctx, tx, model.AuditCompleteProduct, p.OrderID, p.ID, old, model.AuditValues{"is_ready": true}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (os orderStatusStorage) DeliverProduct(ctx context.Context, ids []uint64) error {
+	return os.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ps []model.OrderProduct
+		if err := tx.Select("id, order_id, is_delivered").Where("id IN ?", ids).Order("id").Find(&ps).Error; err != nil {
+			return fmt.Errorf("find order products: %w", dbError(err))
+		}
+		err := tx.Table("order_products").Where("id IN ?", ids).Updates(&model.OrderProduct{IsDelivered: true}).Error
+		if err != nil {
+			return fmt.Errorf("update: %w", dbError(err))
+		}
+		for _, p := range ps {
+			old := model.AuditValues{"is_delivered": p.IsDelivered}
+			if err := audit(ctx, tx, model.AuditDeliverProduct, p.OrderID, p.ID, old, model.AuditValues{"is_delivered": true}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"log"
-	"sync"
+	"net"
+	"time"
 
+	"github.com/modular-project/orders-service/apperr"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -13,12 +18,8 @@ type DRIVER string
 
 const (
 	POSTGRESQL DRIVER = "POSTGRES"
-	TESTING    DRIVER = "TESTING"
-)
-
-var (
-	_db  *gorm.DB
-	once sync.Once
+	// MEMORY is served by MemoryStorage, it does not need a connection.
+	MEMORY DRIVER = "MEMORY"
 )
 
 type DBConnection struct {
@@ -28,122 +29,124 @@ type DBConnection struct {
 	Port     string
 	NameDB   string
 	Host     string
+	// Pool settings, the driver defaults are kept when they are zero.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
 }
 
-func NewDB(conn DBConnection) error {
-	var err error
-	once.Do(func() {
-		switch conn.TypeDB {
-		case POSTGRESQL:
-			err = newPostgresDB(&conn)
-		default:
-			err = fmt.Errorf("invalid database type")
-		}
-	})
-	return err
-}
-func Drop(tables ...interface{}) error {
-	return _db.Migrator().DropTable(tables...)
+// DB is a connection pool to the database, it is shared by the storages
+// created with it and must be closed when it is no longer used.
+type DB struct {
+	db *gorm.DB
 }
 
-func Migrate(tables ...interface{}) error {
-	// err := _db.SetupJoinTable(&model.User{}, "Roles", &model.UserRole{})
-	// if err != nil {
-	// 	return fmt.Errorf("fail at setup join table :%w", err)
-	// }
-	err := _db.AutoMigrate(tables...)
+// NewDB opens a connection pool and checks that the database is reachable.
+func NewDB(ctx context.Context, conn DBConnection) (*DB, error) {
+	var (
+		db  *DB
+		err error
+	)
+	switch conn.TypeDB {
+	case POSTGRESQL:
+		db, err = newPostgresDB(&conn)
+	default:
+		return nil, fmt.Errorf("invalid database type")
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
-}
-func newPostgresDB(u *DBConnection) error {
-	var err error
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
-		u.Host, u.User, u.Password, u.NameDB, u.Port)
-	_db, err = gorm.Open(postgres.Open(dsn))
-	if _db == nil {
-		log.Fatalf("nil db at open db")
+	sqlDB, err := db.db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("get sql db: %w", err)
+	}
+	if conn.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(conn.MaxOpenConns)
+	}
+	if conn.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(conn.MaxIdleConns)
 	}
+	if conn.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(conn.ConnMaxLifetime)
+	}
+	if err := db.Ping(ctx); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Ping checks that the database is reachable.
+func (d *DB) Ping(ctx context.Context) error {
+	sqlDB, err := d.db.DB()
 	if err != nil {
-		return fmt.Errorf("open postgres: %w", err)
+		return fmt.Errorf("get sql db: %w", err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping: %w", apperr.Wrap(apperr.ErrUnavailable, err))
 	}
-	log.Println("connected to postgres")
 	return nil
 }
 
-// func New(driver DRIVER) {
-// 	once.Do(func() {
-// 		u := loadData()
-// 		switch driver {
-// 		case POSTGRESQL:
-// 			newPostgresDB(&u)
-// 		case TESTING:
-// 			newTestingDB(&u)
-// 		}
-// 	})
-// }
-
-// func newTestingDB(u *dbUser) {
-// 	var err error
-// 	fmt.Print(u)
-// 	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", u.User, u.Password, u.Host, u.Port, "testing")
-// 	_db, err = gorm.Open(postgres.Open(dsn))
-// 	if err != nil {
-// 		log.Fatalf("no se pudo abrir la base de datos: %v", err)
-// 	}
+// Close closes the connection pool.
+func (d *DB) Close() error {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return fmt.Errorf("get sql db: %w", err)
+	}
+	return sqlDB.Close()
+}
 
-// 	fmt.Println("conectado a Testing")
-// }
+func (d *DB) Drop(tables ...interface{}) error {
+	return d.db.Migrator().DropTable(tables...)
+}
 
-// func newPostgresDB(u *dbUser) {
-// 	var err error
-// 	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", u.User, u.Password, u.Host, u.Port, u.NameDB)
-// 	_db, err = gorm.Open(postgres.Open(dsn))
-// 	if err != nil {
-// 		log.Fatalf("no se pudo abrir la base de datos: %v", err)
-// 	}
+func (d *DB) Migrate(tables ...interface{}) error {
+	err := d.db.AutoMigrate(tables...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
 
-// 	fmt.Println("conectado a postgres")
-// }
+// searchIndexes are the indexes of the searches that can't be declared in
+// the models.
+var searchIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders (created_at)",
+	"CREATE INDEX IF NOT EXISTS idx_orders_cancel_note_text ON orders USING GIN (to_tsvector('simple', cancel_note))",
+	"CREATE INDEX IF NOT EXISTS idx_order_products_name_text ON order_products USING GIN (to_tsvector('simple', name))",
+}
 
-// // DB return a unique instance of db
-// func DB() *gorm.DB {
-// 	return _db
-// }
+// MigrateSearch creates the indexes of the order searches, the orders and
+// their products must be migrated first.
+func (d *DB) MigrateSearch() error {
+	for _, q := range searchIndexes {
+		if err := d.db.Exec(q).Error; err != nil {
+			return fmt.Errorf("create index: %w", err)
+		}
+	}
+	return nil
+}
 
-// func getEnv(env string) (string, error) {
-// 	s, f := os.LookupEnv(env)
-// 	if !f {
-// 		return "", fmt.Errorf("environment variable (%s) not found", env)
-// 	}
-// 	return s, nil
-// }
+// dbError classifies the errors returned by the database.
+func dbError(err error) error {
+	var ne net.Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return apperr.Wrap(apperr.ErrNotFound, err)
+	case errors.Is(err, driver.ErrBadConn), errors.As(err, &ne):
+		return apperr.Wrap(apperr.ErrUnavailable, err)
+	}
+	return err
+}
 
-// func loadData() dbUser {
-// 	typeDb, err := getEnv("RGE_TYPE")
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
-// 	user, err := getEnv("RGE_USER")
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
-// 	password, err := getEnv("RGE_PASSWORD")
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
-// 	port, err := getEnv("RGE_PORT")
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
-// 	name, err := getEnv("RGE_NAME_DB")
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
-// 	host, err := getEnv("RGE_HOST")
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
-// 	return dbUser{DRIVER(typeDb), user, password, port, name, host}
-// }
+func newPostgresDB(u *DBConnection) (*DB, error) {
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
+		u.Host, u.User, u.Password, u.NameDB, u.Port)
+	db, err := gorm.Open(postgres.Open(dsn))
+	if err != nil {
+		return nil, fmt.Errorf("open postgres: %w", err)
+	}
+	log.Println("connected to postgres")
+	return &DB{db: db}, nil
+}
@@ -0,0 +1,1217 @@
+// Package storagetest contains the behavior shared by every storage backend,
+// each backend runs it from its own tests.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modular-project/orders-service/apperr"
+	"github.com/modular-project/orders-service/controller"
+	"github.com/modular-project/orders-service/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// Backend are the storages under test, they must start without orders.
+type Backend struct {
+	Orders controller.OrderStorager
+	Status controller.OrderStatusStorager
+	Tx     controller.Transactor
+	Outbox controller.OutboxStorager
+	Keys   controller.IdempotencyStorager
+}
+
+func mxn(cents int64) model.Money {
+	return model.NewMoney(cents, model.MXN)
+}
+
+func str(s string) *string {
+	return &s
+}
+
+// generateData creates the orders used by every test, their IDs are 1 to 5.
+func generateData(t *testing.T, b Backend) []model.Order {
+	orders := []model.Order{
+		{
+			TypeID: model.Local, EmployeeID: 1, EstablishmentID: 1, TableID: 1,
+			StatusID: model.InPreparation, Total: mxn(15505534), Tip: 1000,
+			OrderProducts: []model.OrderProduct{
+				{ProductID: 1, Quantity: 3, UnitPrice: mxn(100), Subtotal: mxn(300), Name: "Taco"},
+				{ProductID: 1, Quantity: 2},
+				{ProductID: 2, Quantity: 7},
+			},
+		}, {
+			TypeID: model.Local, EmployeeID: 1, EstablishmentID: 1, TableID: 1,
+			StatusID: model.Closed, Total: mxn(10000), Tip: 1500,
+			OrderProducts: []model.OrderProduct{
+				{ProductID: 3, Quantity: 3, IsReady: true},
+				{ProductID: 4, Quantity: 2, IsReady: true},
+				{ProductID: 2, Quantity: 7},
+			},
+		}, {
+			TypeID: model.Local, EmployeeID: 1, EstablishmentID: 1, TableID: 2,
+			StatusID: model.InPreparation, Total: mxn(20000),
+			OrderProducts: []model.OrderProduct{
+				{ProductID: 3, Quantity: 3, IsReady: true},
+				{ProductID: 6, Quantity: 2},
+			},
+		}, {
+			TypeID: model.Local, EmployeeID: 2, EstablishmentID: 2, TableID: 3,
+			StatusID: model.InPreparation, Total: mxn(20000),
+			OrderProducts: []model.OrderProduct{
+				{ProductID: 3, Quantity: 3},
+			},
+		}, {
+			TypeID: model.Delivery, UserID: 7, AddressID: str("home"), PayID: str("PAY-1"),
+			StatusID: model.AwaitingPayment, Total: mxn(5000),
+			OrderProducts: []model.OrderProduct{
+				{ProductID: 1, Quantity: 5},
+			},
+		},
+	}
+	c := context.Background()
+	for i := range orders {
+		if err := b.Orders.Create(c, &orders[i]); err != nil {
+			t.Fatalf("failed to generate data: %s", err)
+		}
+	}
+	return orders
+}
+
+func productIDs(ps []model.OrderProduct) []uint64 {
+	ids := make([]uint64, len(ps))
+	for i := range ps {
+		ids[i] = ps[i].ID
+	}
+	return ids
+}
+
+func orderIDs(os []model.Order) []uint64 {
+	ids := make([]uint64, len(os))
+	for i := range os {
+		ids[i] = os[i].ID
+	}
+	return ids
+}
+
+// Run runs every test against the backends returned by open.
+func Run(t *testing.T, open func(t *testing.T) Backend) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, b Backend)
+	}{
+		{"Create", testCreate},
+		{"AddProducts", testAddProducts},
+		{"Currency", testCurrency},
+		{"Kitchen", testKitchen},
+		{"Owners", testOwners},
+		{"PaymentOwners", testPaymentOwners},
+		{"Waiter", testWaiter},
+		{"Search", testSearch},
+		{"SearchFilters", testSearchFilters},
+		{"SearchPages", testSearchPages},
+		{"User", testUser},
+		{"Tips", testTips},
+		{"Status", testStatus},
+		{"Pay", testPay},
+		{"DenyPayment", testDenyPayment},
+		{"Refund", testRefund},
+		{"PendingRefund", testPendingRefund},
+		{"PaymentEvent", testPaymentEvent},
+		{"UnpaidOrders", testUnpaidOrders},
+		{"ExpireOrder", testExpireOrder},
+		{"SetStatus", testSetStatus},
+		{"Idempotency", testIdempotency},
+		{"Products", testProducts},
+		{"CancelOrder", testCancelOrder},
+		{"CancelProducts", testCancelProducts},
+		{"Transaction", testTransaction},
+		{"Outbox", testOutbox},
+		{"Audit", testAudit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+func testCreate(t *testing.T, b Backend) {
+	os := generateData(t, b)
+	assert := assert.New(t)
+	for i, o := range os {
+		assert.Equal(uint64(i+1), o.ID, "order ID")
+		assert.False(o.CreatedAt.IsZero(), "created at")
+		for _, p := range o.OrderProducts {
+			assert.NotZero(p.ID, "product ID")
+			assert.Equal(o.ID, p.OrderID, "product order")
+		}
+	}
+	ps, err := b.Orders.Products(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Products() error = %v", err)
+	}
+	assert.Equal(productIDs(os[0].OrderProducts), productIDs(ps))
+	assert.Equal("Taco", ps[0].Name)
+	assert.Equal(mxn(100), ps[0].UnitPrice)
+	assert.Equal(mxn(300), ps[0].Subtotal)
+}
+
+func testCurrency(t *testing.T, b Backend) {
+	c := context.Background()
+	usd := func(cents int64) model.Money { return model.NewMoney(cents, "USD") }
+	o := &model.Order{
+		TypeID: model.Local, EmployeeID: 1, EstablishmentID: 1, TableID: 1, StatusID: model.InPreparation, Total: usd(300),
+		OrderProducts: []model.OrderProduct{{ProductID: 1, Name: "Taco", UnitPrice: usd(100), Quantity: 3, Subtotal: usd(300)}},
+	}
+	if err := b.Orders.Create(c, o); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	got, err := b.Status.Order(c, o.ID)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Equal(t, usd(300), got.Total)
+	assert.Equal(t, "USD", got.Captured.Currency)
+	ps, err := b.Orders.Products(c, o.ID)
+	if err != nil {
+		t.Fatalf("Products() error = %v", err)
+	}
+	if assert.Len(t, ps, 1) {
+		assert.Equal(t, usd(100), ps[0].UnitPrice)
+		assert.Equal(t, usd(300), ps[0].Subtotal)
+	}
+}
+
+func testAddProducts(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	ps := []model.OrderProduct{{ProductID: 1, Quantity: 3}, {ProductID: 3, Quantity: 1}}
+	if err := b.Orders.AddProducts(c, 1, mxn(66), ps); err != nil {
+		t.Fatalf("AddProducts() error = %v", err)
+	}
+	for _, p := range ps {
+		assert.NotZero(t, p.ID, "product ID")
+	}
+	got, err := b.Orders.Products(c, 1)
+	if err != nil {
+		t.Fatalf("Products() error = %v", err)
+	}
+	assert.Len(t, got, 5)
+	os, err := b.Orders.Search(c, &model.SearchOrder{
+		Ests:   []uint64{1},
+		Search: model.Search{OrderBys: []model.OrderBy{{By: model.PRICE, Sort: model.DES}}, Limit: 1},
+	})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	assert.Equal(t, mxn(15505600), os.Orders[0].Total)
+	err = b.Orders.AddProducts(c, 100, mxn(1), []model.OrderProduct{{ProductID: 1, Quantity: 1}})
+	if !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("AddProducts() to unknown order error = %v, want ErrNotFound", err)
+	}
+}
+
+func testKitchen(t *testing.T, b Backend) {
+	os := generateData(t, b)
+	c := context.Background()
+	got, err := b.Orders.Kitchen(c, 1, 0)
+	if err != nil {
+		t.Fatalf("Kitchen() error = %v", err)
+	}
+	want := append(productIDs(os[0].OrderProducts), os[1].OrderProducts[2].ID, os[2].OrderProducts[1].ID)
+	assert.Equal(t, want, productIDs(got))
+	got, err = b.Orders.Kitchen(c, 1, os[1].OrderProducts[2].ID)
+	if err != nil {
+		t.Fatalf("Kitchen() error = %v", err)
+	}
+	assert.Equal(t, []uint64{os[2].OrderProducts[1].ID}, productIDs(got))
+
+	kps, err := b.Orders.KitchenProducts(c, []uint64{os[4].OrderProducts[0].ID, os[0].OrderProducts[1].ID, 1000})
+	if err != nil {
+		t.Fatalf("KitchenProducts() error = %v", err)
+	}
+	if assert.Len(t, kps, 2) {
+		assert.Equal(t, os[0].OrderProducts[1].ID, kps[0].ID)
+		assert.Equal(t, uint64(1), kps[0].EstablishmentID)
+		assert.Equal(t, uint64(1), kps[0].EmployeeID)
+		assert.Equal(t, uint64(1), kps[0].TableID)
+		assert.Equal(t, model.InPreparation, kps[0].StatusID)
+		assert.Equal(t, uint32(2), kps[0].Quantity)
+		assert.Equal(t, model.AwaitingPayment, kps[1].StatusID)
+	}
+}
+
+func testOwners(t *testing.T, b Backend) {
+	generateData(t, b)
+	got, err := b.Orders.Owners(context.Background(), []uint64{5, 1, 1000})
+	if err != nil {
+		t.Fatalf("Owners() error = %v", err)
+	}
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, uint64(1), got[0].ID)
+		assert.Equal(t, uint64(1), got[0].EmployeeID)
+		assert.Equal(t, uint64(1), got[0].EstablishmentID)
+		assert.Equal(t, uint64(5), got[1].ID)
+		assert.Equal(t, uint64(7), got[1].UserID)
+	}
+}
+
+func testPaymentOwners(t *testing.T, b Backend) {
+	generateData(t, b)
+	got, err := b.Orders.PaymentOwners(context.Background(), []string{"PAY-1", "PAY-9"})
+	if err != nil {
+		t.Fatalf("PaymentOwners() error = %v", err)
+	}
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, uint64(5), got[0].ID)
+		assert.Equal(t, uint64(7), got[0].UserID)
+		assert.Equal(t, "PAY-1", *got[0].PayID)
+	}
+}
+
+func testWaiter(t *testing.T, b Backend) {
+	os := generateData(t, b)
+	c := context.Background()
+	got, err := b.Orders.Waiter(c, 1)
+	if err != nil {
+		t.Fatalf("Waiter() error = %v", err)
+	}
+	assert.Equal(t, []uint64{1, 3}, orderIDs(got))
+	assert.Equal(t, uint64(2), got[1].TableID)
+	assert.Equal(t, mxn(20000), got[1].Total)
+	assert.Equal(t, productIDs(os[2].OrderProducts), productIDs(got[1].OrderProducts))
+	got, err = b.Orders.WaiterPending(c, 1)
+	if err != nil {
+		t.Fatalf("WaiterPending() error = %v", err)
+	}
+	assert.Equal(t, []uint64{1, 3}, orderIDs(got))
+	assert.Empty(t, got[0].OrderProducts)
+	assert.Equal(t, []uint64{os[2].OrderProducts[0].ID}, productIDs(got[1].OrderProducts))
+	if err := b.Status.DeliverProduct(c, []uint64{os[2].OrderProducts[0].ID}); err != nil {
+		t.Fatalf("DeliverProduct() error = %v", err)
+	}
+	got, err = b.Orders.WaiterPending(c, 1)
+	if err != nil {
+		t.Fatalf("WaiterPending() error = %v", err)
+	}
+	assert.Empty(t, got[1].OrderProducts)
+}
+
+func testSearch(t *testing.T, b Backend) {
+	generateData(t, b)
+	day := 24 * time.Hour
+	yesterday := time.Now().UTC().Add(-day)
+	tomorrow := time.Now().UTC().Add(day)
+	tests := []struct {
+		name string
+		give model.SearchOrder
+		want []uint64
+	}{
+		{
+			name: "by establishment ordered by price",
+			give: model.SearchOrder{
+				Ests:   []uint64{1},
+				Search: model.Search{OrderBys: []model.OrderBy{{By: model.PRICE, Sort: model.DES}}},
+			},
+			want: []uint64{1, 3, 2},
+		}, {
+			name: "by status and type",
+			give: model.SearchOrder{
+				Filter: model.Filter{
+					Status: []model.Status{model.InPreparation, model.AwaitingPayment},
+					Types:  []model.Type{model.Local},
+				},
+				Search: model.Search{OrderBys: []model.OrderBy{{By: model.EST}, {By: model.PRICE}}},
+			},
+			want: []uint64{3, 1, 4},
+		}, {
+			name: "by user",
+			give: model.SearchOrder{Users: []uint64{7}},
+			want: []uint64{5},
+		}, {
+			name: "by total",
+			give: model.SearchOrder{
+				Lower: mxn(10000), Higher: mxn(20000),
+				Search: model.Search{OrderBys: []model.OrderBy{{By: model.PRICE}, {By: model.EST, Sort: model.DES}}},
+			},
+			want: []uint64{2, 4, 3},
+		}, {
+			name: "limit and offset",
+			give: model.SearchOrder{
+				Search: model.Search{OrderBys: []model.OrderBy{{By: model.PRICE}, {By: model.EST}}, Limit: 2, Offset: 1},
+			},
+			want: []uint64{2, 3},
+		}, {
+			name: "by date",
+			give: model.SearchOrder{Days: []model.DayRange{{From: yesterday, To: tomorrow}}, Users: []uint64{7}},
+			want: []uint64{5},
+		}, {
+			name: "out of date range",
+			give: model.SearchOrder{Days: []model.DayRange{{From: tomorrow, To: tomorrow.Add(day)}}},
+			want: []uint64{},
+		}, {
+			name: "by date of each establishment",
+			give: model.SearchOrder{Days: []model.DayRange{
+				{Ests: []uint64{1}, From: yesterday, To: tomorrow},
+				{Except: []uint64{1}, From: tomorrow, To: tomorrow.Add(day)},
+			}},
+			want: []uint64{1, 2, 3},
+		}, {
+			name: "by date of other establishments",
+			give: model.SearchOrder{Days: []model.DayRange{
+				{Ests: []uint64{1}, From: tomorrow, To: tomorrow.Add(day)},
+				{Except: []uint64{1}, From: yesterday, To: tomorrow},
+			}},
+			want: []uint64{4, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.Orders.Search(context.Background(), &tt.give)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			assert.Equal(t, tt.want, orderIDs(got.Orders))
+		})
+	}
+}
+
+func testUser(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	o := model.Order{TypeID: model.Delivery, UserID: 7, AddressID: str("work"), StatusID: model.AwaitingPayment, Total: mxn(100)}
+	if err := b.Orders.Create(c, &o); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	got, err := b.Orders.User(c, 7, model.Search{})
+	if err != nil {
+		t.Fatalf("User() error = %v", err)
+	}
+	assert.Equal(t, []uint64{6, 5}, orderIDs(got.Orders))
+	assert.Len(t, got.Orders[1].OrderProducts, 1)
+	assert.Equal(t, "PAY-1", *got.Orders[1].PayID)
+	got, err = b.Orders.User(c, 7, model.Search{Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatalf("User() error = %v", err)
+	}
+	assert.Equal(t, []uint64{5}, orderIDs(got.Orders))
+	assert.Nil(t, got.Next)
+	got, err = b.Orders.User(c, 7, model.Search{Limit: 1, Total: true})
+	if err != nil {
+		t.Fatalf("User() error = %v", err)
+	}
+	assert.Equal(t, []uint64{6}, orderIDs(got.Orders))
+	assert.Equal(t, int64(2), got.Total)
+	if assert.NotNil(t, got.Next) {
+		got, err = b.Orders.User(c, 7, model.Search{Limit: 1, After: got.Next})
+		if err != nil {
+			t.Fatalf("User() error = %v", err)
+		}
+		assert.Equal(t, []uint64{5}, orderIDs(got.Orders))
+		assert.Nil(t, got.Next)
+	}
+}
+
+func testSearchFilters(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	cancelled := model.Order{
+		TypeID: model.Local, EmployeeID: 3, EstablishmentID: 2, TableID: 4,
+		StatusID: model.Cancelled, Total: mxn(3000), PaymentMethod: model.CASH, CancelNote: "Cliente sin cambio",
+		OrderProducts: []model.OrderProduct{{ProductID: 8, Quantity: 1, Name: "Agua de horchata", IsCancelled: true}},
+	}
+	if err := b.Orders.Create(c, &cancelled); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	all, err := b.Orders.Search(c, &model.SearchOrder{})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	third := all.Orders[2].CreatedAt
+	tests := []struct {
+		name string
+		give model.SearchOrder
+		want []uint64
+	}{
+		{name: "by employee", give: model.SearchOrder{Filter: model.Filter{Employees: []uint64{1}}}, want: []uint64{1, 2, 3}},
+		{name: "by table", give: model.SearchOrder{Ests: []uint64{1}, Filter: model.Filter{Tables: []uint64{1}}}, want: []uint64{1, 2}},
+		{name: "by payment", give: model.SearchOrder{Filter: model.Filter{Payments: []model.PaymentMethod{model.CASH}}}, want: []uint64{6}},
+		{name: "by pay id", give: model.SearchOrder{Filter: model.Filter{PayIDs: []string{"PAY-1", "PAY-2"}}}, want: []uint64{5}},
+		{name: "by product", give: model.SearchOrder{Filter: model.Filter{Products: []uint64{2}}}, want: []uint64{1, 2}},
+		{name: "by cancelled product", give: model.SearchOrder{Filter: model.Filter{Products: []uint64{8}}}, want: []uint64{}},
+		{name: "with tip", give: model.SearchOrder{Filter: model.Filter{Tip: model.WithTip}}, want: []uint64{1, 2}},
+		{name: "without tip", give: model.SearchOrder{Filter: model.Filter{Tip: model.WithoutTip}}, want: []uint64{3, 4, 5, 6}},
+		{name: "by address", give: model.SearchOrder{Filter: model.Filter{Addresses: []string{"home"}}}, want: []uint64{5}},
+		{name: "from", give: model.SearchOrder{Filter: model.Filter{From: &third}}, want: []uint64{3, 4, 5, 6}},
+		{name: "to", give: model.SearchOrder{Filter: model.Filter{To: &third}}, want: []uint64{1, 2}},
+		{name: "product name", give: model.SearchOrder{Filter: model.Filter{Text: "taco"}}, want: []uint64{1}},
+		{name: "cancel note", give: model.SearchOrder{Filter: model.Filter{Text: "SIN cambio"}}, want: []uint64{6}},
+		{name: "words of different texts", give: model.SearchOrder{Filter: model.Filter{Text: "taco cambio"}}, want: []uint64{}},
+		{
+			name: "any group",
+			give: model.SearchOrder{Any: []model.Filter{{Employees: []uint64{2}}, {Tip: model.WithTip, Tables: []uint64{1}}}},
+			want: []uint64{1, 2, 4},
+		}, {
+			name: "any group and status",
+			give: model.SearchOrder{
+				Filter: model.Filter{Status: []model.Status{model.InPreparation}},
+				Any:    []model.Filter{{Employees: []uint64{2}}, {Tip: model.WithTip, Tables: []uint64{1}}},
+			},
+			want: []uint64{1, 4},
+		}, {
+			name: "empty group",
+			give: model.SearchOrder{Any: []model.Filter{{Employees: []uint64{2}}, {}}},
+			want: []uint64{1, 2, 3, 4, 5, 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.Orders.Search(c, &tt.give)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			assert.Equal(t, tt.want, orderIDs(got.Orders))
+		})
+	}
+}
+
+// testSearchPages reads every search one order at a time following the
+// cursors, the pages must match the whole search for every sort.
+func testSearchPages(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	var sorts [][]model.OrderBy
+	for by := model.DATE; by <= model.TYPE; by++ {
+		sorts = append(sorts, []model.OrderBy{{By: by}}, []model.OrderBy{{By: by, Sort: model.DES}})
+	}
+	sorts = append(sorts, nil, []model.OrderBy{{By: model.EST, Sort: model.DES}, {By: model.PRICE}})
+	for _, obs := range sorts {
+		all, err := b.Orders.Search(c, &model.SearchOrder{Search: model.Search{OrderBys: obs}})
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		var got []uint64
+		s := model.SearchOrder{Search: model.Search{OrderBys: obs, Limit: 2, Total: true}}
+		for i := 0; i < len(all.Orders); i++ {
+			p, err := b.Orders.Search(c, &s)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			assert.Equal(t, int64(len(all.Orders)), p.Total, "total of %v", obs)
+			got = append(got, orderIDs(p.Orders)...)
+			if p.Next == nil {
+				break
+			}
+			s.After = p.Next
+		}
+		assert.Equal(t, orderIDs(all.Orders), got, "pages of %v", obs)
+	}
+	// the orders created between pages don't move the next ones.
+	s := model.SearchOrder{Search: model.Search{OrderBys: []model.OrderBy{{By: model.PRICE}}, Limit: 2}}
+	p, err := b.Orders.Search(c, &s)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	assert.Equal(t, []uint64{5, 2}, orderIDs(p.Orders))
+	o := model.Order{TypeID: model.Delivery, UserID: 8, AddressID: str("home"), StatusID: model.AwaitingPayment, Total: mxn(1)}
+	if err := b.Orders.Create(c, &o); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	s.After = p.Next
+	p, err = b.Orders.Search(c, &s)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	assert.Equal(t, []uint64{3, 4}, orderIDs(p.Orders))
+}
+
+func testTips(t *testing.T, b Backend) {
+	generateData(t, b)
+	day := 24 * time.Hour
+	yesterday := time.Now().UTC().Add(-day)
+	tomorrow := time.Now().UTC().Add(day)
+	got, err := b.Orders.GetTipsFromEmployee(context.Background(), 1, []model.DayRange{{From: yesterday, To: tomorrow}})
+	if err != nil {
+		t.Fatalf("GetTipsFromEmployee() error = %v", err)
+	}
+	assert.Equal(t, mxn(1550553+1500), got)
+	got, err = b.Orders.GetTipsFromEmployee(context.Background(), 1, []model.DayRange{{Ests: []uint64{2}, From: yesterday, To: tomorrow}})
+	if err != nil {
+		t.Fatalf("GetTipsFromEmployee() error = %v", err)
+	}
+	assert.Equal(t, mxn(0), got)
+}
+
+func testStatus(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	st, err := b.Status.Status(c, 2)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	assert.Equal(t, model.Closed, st)
+	o, err := b.Status.PaymentOrder(c, "PAY-1")
+	if err != nil {
+		t.Fatalf("PaymentOrder() error = %v", err)
+	}
+	assert.Equal(t, uint64(5), o.ID)
+	assert.Equal(t, model.AwaitingPayment, o.StatusID)
+	if _, err := b.Status.Status(c, 100); !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("Status() of unknown order error = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Status.PaymentOrder(c, "PAY-2"); !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("PaymentOrder() of unknown payment error = %v, want ErrNotFound", err)
+	}
+	if err := b.Status.SetPaymentRequest(c, 100, "KEY-1"); !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("SetPaymentRequest() of unknown order error = %v, want ErrNotFound", err)
+	}
+}
+
+func testPay(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	if err := b.Status.PayLocal(c, 1, 1, 2000, model.InPreparation, model.Closed); err != nil {
+		t.Fatalf("PayLocal() error = %v", err)
+	}
+	err := b.Status.PayLocal(c, 1, 1, 2000, model.InPreparation, model.Closed)
+	if !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("PayLocal() twice error = %v, want ErrStatusChanged", err)
+	}
+	err = b.Status.PayLocal(c, 3, 2, 2000, model.InPreparation, model.Closed)
+	if !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("PayLocal() of another employee error = %v, want ErrStatusChanged", err)
+	}
+	if err := b.Status.SetPaymentRequest(c, 5, "KEY-1"); err != nil {
+		t.Fatalf("SetPaymentRequest() error = %v", err)
+	}
+	o, err := b.Status.Order(c, 5)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	if assert.NotNil(t, o.PayRequestID) {
+		assert.Equal(t, "KEY-1", *o.PayRequestID)
+	}
+	if err := b.Status.SetPaymentDelivery(c, 5, 3, "PAY-3", "office", model.PAYPAL); err != nil {
+		t.Fatalf("SetPaymentDelivery() error = %v", err)
+	}
+	cp := model.Capture{ID: "CAP-3", Status: "COMPLETED", Amount: mxn(5000)}
+	if err := b.Status.PayDelivey(c, "PAY-3", cp, model.AwaitingPayment, model.Paid); err != nil {
+		t.Fatalf("PayDelivey() error = %v", err)
+	}
+	err = b.Status.PayDelivey(c, "PAY-3", cp, model.AwaitingPayment, model.Paid)
+	if !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("PayDelivey() twice error = %v, want ErrStatusChanged", err)
+	}
+	p, err := b.Orders.Search(c, &model.SearchOrder{Users: []uint64{7}})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	os := p.Orders
+	assert.Equal(t, model.Paid, os[0].StatusID)
+	assert.Equal(t, uint64(3), os[0].EstablishmentID)
+	assert.Equal(t, "office", *os[0].AddressID)
+	o, err = b.Status.Order(c, 5)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Nil(t, o.PayRequestID)
+	if assert.NotNil(t, o.CaptureID) {
+		assert.Equal(t, "CAP-3", *o.CaptureID)
+	}
+	assert.Equal(t, model.PAYPAL, o.PaymentMethod)
+	assert.Equal(t, mxn(5000), o.Captured)
+	o, err = b.Status.Order(c, 1)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Nil(t, o.CaptureID)
+	assert.Equal(t, mxn(15505534), o.Captured)
+	assert.Equal(t, model.CASH, o.PaymentMethod)
+}
+
+func testDenyPayment(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	if err := b.Status.SetPaymentRequest(c, 5, "KEY-1"); err != nil {
+		t.Fatalf("SetPaymentRequest() error = %v", err)
+	}
+	if err := b.Status.DenyPayment(c, 5, "PAY-1"); err != nil {
+		t.Fatalf("DenyPayment() error = %v", err)
+	}
+	if err := b.Status.DenyPayment(c, 5, "PAY-1"); !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("DenyPayment() twice error = %v, want ErrStatusChanged", err)
+	}
+	o, err := b.Status.Order(c, 5)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Nil(t, o.PayID)
+	assert.Nil(t, o.PayRequestID)
+	assert.Equal(t, model.AwaitingPayment, o.StatusID)
+	as, err := b.Orders.History(c, 5)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if assert.NotEmpty(t, as) {
+		a := as[len(as)-1]
+		assert.Equal(t, model.AuditDenyPayment, a.Action)
+		assert.JSONEq(t, `{"pay_id":"PAY-1"}`, a.OldValue)
+	}
+}
+
+func testRefund(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	if err := b.Status.PayLocal(c, 3, 1, 0, model.InPreparation, model.Closed); err != nil {
+		t.Fatalf("PayLocal() error = %v", err)
+	}
+	rf := &model.Refund{OrderID: 3, Amount: mxn(5000), Reason: "cold food", Status: "COMPLETED", EmployeeID: 2}
+	if err := b.Status.Refund(c, rf, model.Closed, model.PartiallyRefunded); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	assert.NotZero(t, rf.ID)
+	err := b.Status.Refund(c, &model.Refund{OrderID: 3, Amount: mxn(15001)}, model.PartiallyRefunded, model.Refunded)
+	if !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("Refund() of more than captured error = %v, want ErrStatusChanged", err)
+	}
+	err = b.Status.Refund(c, &model.Refund{OrderID: 3, Amount: mxn(100)}, model.Closed, model.PartiallyRefunded)
+	if !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("Refund() from another status error = %v, want ErrStatusChanged", err)
+	}
+	if err := b.Status.Refund(c, &model.Refund{OrderID: 3, Amount: mxn(15000)}, model.PartiallyRefunded, model.Refunded); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	o, err := b.Status.Order(c, 3)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Equal(t, model.Refunded, o.StatusID)
+	assert.Equal(t, mxn(20000), o.Captured)
+	assert.Equal(t, mxn(20000), o.Refunded)
+	as, err := b.Orders.History(c, 3)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if assert.Len(t, as, 4) {
+		assert.Equal(t, model.AuditRefund, as[2].Action)
+		assert.JSONEq(t, `{"status":"PARTIALLY_REFUNDED","refund_id":1,"amount":"50.00","reason":"cold food"}`, as[2].NewValue)
+	}
+}
+
+func testPendingRefund(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	if err := b.Status.SetPaymentDelivery(c, 5, 3, "PAY-3", "office", model.PAYPAL); err != nil {
+		t.Fatalf("SetPaymentDelivery() error = %v", err)
+	}
+	cp := model.Capture{ID: "CAP-3", Status: "COMPLETED", Amount: mxn(5000)}
+	if err := b.Status.PayDelivey(c, "PAY-3", cp, model.AwaitingPayment, model.Paid); err != nil {
+		t.Fatalf("PayDelivey() error = %v", err)
+	}
+	rf := &model.Refund{OrderID: 5, Amount: mxn(1000), Reason: "late delivery", RequestID: str("KEY-1"), EmployeeID: 2}
+	if err := b.Status.AddRefund(c, rf); err != nil {
+		t.Fatalf("AddRefund() error = %v", err)
+	}
+	if err := b.Status.AddRefund(c, &model.Refund{OrderID: 5, Amount: mxn(1000), RequestID: str("KEY-1")}); err == nil {
+		t.Errorf("AddRefund() with the same key error = nil, want an error")
+	}
+	got, err := b.Status.PendingRefund(c, 5)
+	if err != nil {
+		t.Fatalf("PendingRefund() error = %v", err)
+	}
+	assert.Equal(t, rf.ID, got.ID)
+	assert.Equal(t, model.RefundPending, got.Status)
+	assert.Equal(t, mxn(1000), got.Amount)
+	o, err := b.Status.Order(c, 5)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Equal(t, mxn(0), o.Refunded)
+	rf.ProviderID, rf.Status = str("REF-1"), "COMPLETED"
+	if err := b.Status.Refund(c, rf, model.Paid, model.PartiallyRefunded); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	if err := b.Status.Refund(c, rf, model.PartiallyRefunded, model.PartiallyRefunded); !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("Refund() of a completed refund error = %v, want ErrStatusChanged", err)
+	}
+	if _, err := b.Status.PendingRefund(c, 5); !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("PendingRefund() after Refund() error = %v, want ErrNotFound", err)
+	}
+	ok, err := b.Status.HasRefund(c, "REF-1")
+	if err != nil {
+		t.Fatalf("HasRefund() error = %v", err)
+	}
+	assert.True(t, ok)
+	o, err = b.Status.Order(c, 5)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Equal(t, model.PartiallyRefunded, o.StatusID)
+	assert.Equal(t, mxn(1000), o.Refunded)
+	rf = &model.Refund{OrderID: 5, Amount: mxn(4000), RequestID: str("KEY-2")}
+	if err := b.Status.AddRefund(c, rf); err != nil {
+		t.Fatalf("AddRefund() error = %v", err)
+	}
+	if err := b.Status.FailRefund(c, rf.ID); err != nil {
+		t.Fatalf("FailRefund() error = %v", err)
+	}
+	if err := b.Status.FailRefund(c, rf.ID); !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("FailRefund() twice error = %v, want ErrStatusChanged", err)
+	}
+	if _, err := b.Status.PendingRefund(c, 5); !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("PendingRefund() after FailRefund() error = %v, want ErrNotFound", err)
+	}
+}
+
+func testPaymentEvent(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	e := &model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventCaptured, PayID: "PAY-3", CaptureID: "CAP-3", Amount: mxn(5000)}
+	if err := b.Status.AddPaymentEvent(c, e); err != nil {
+		t.Fatalf("AddPaymentEvent() error = %v", err)
+	}
+	err := b.Status.AddPaymentEvent(c, &model.PaymentEvent{ID: "WH-1", Type: model.PaymentEventDenied})
+	if !errors.Is(err, model.ErrPaymentEventProcessed) {
+		t.Errorf("AddPaymentEvent() twice error = %v, want ErrPaymentEventProcessed", err)
+	}
+	if err := b.Status.SetPaymentDelivery(c, 5, 3, "PAY-3", "office", model.PAYPAL); err != nil {
+		t.Fatalf("SetPaymentDelivery() error = %v", err)
+	}
+	cp := model.Capture{ID: "CAP-3", Status: "COMPLETED", Amount: mxn(5000)}
+	if err := b.Status.PayDelivey(c, "PAY-3", cp, model.AwaitingPayment, model.Paid); err != nil {
+		t.Fatalf("PayDelivey() error = %v", err)
+	}
+	o, err := b.Status.CaptureOrder(c, "CAP-3")
+	if err != nil {
+		t.Fatalf("CaptureOrder() error = %v", err)
+	}
+	assert.Equal(t, uint64(5), o.ID)
+	assert.Equal(t, model.Paid, o.StatusID)
+	if _, err := b.Status.CaptureOrder(c, "CAP-4"); !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("CaptureOrder() of unknown capture error = %v, want ErrNotFound", err)
+	}
+	ok, err := b.Status.HasRefund(c, "REF-1")
+	if err != nil {
+		t.Fatalf("HasRefund() error = %v", err)
+	}
+	assert.False(t, ok)
+	rf := &model.Refund{OrderID: 5, Amount: mxn(1000), ProviderID: str("REF-1"), Status: "COMPLETED"}
+	if err := b.Status.Refund(c, rf, model.Paid, model.PartiallyRefunded); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	ok, err = b.Status.HasRefund(c, "REF-1")
+	if err != nil {
+		t.Fatalf("HasRefund() error = %v", err)
+	}
+	assert.True(t, ok)
+}
+
+func testUnpaidOrders(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	tests := []struct {
+		name   string
+		before time.Time
+		after  uint64
+		want   []uint64
+	}{
+		{name: "waiting", before: time.Now().Add(time.Minute), want: []uint64{5}},
+		{name: "too recent", before: time.Now().Add(-time.Hour), want: []uint64{}},
+		{name: "after the last", before: time.Now().Add(time.Minute), after: 5, want: []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.Status.UnpaidOrders(c, tt.before, tt.after, 10)
+			if err != nil {
+				t.Fatalf("UnpaidOrders() error = %v", err)
+			}
+			assert.Equal(t, tt.want, orderIDs(got))
+			for _, o := range got {
+				assert.Equal(t, "PAY-1", *o.PayID)
+				assert.Equal(t, model.Delivery, o.TypeID)
+				assert.False(t, o.CreatedAt.IsZero())
+			}
+		})
+	}
+}
+
+func testExpireOrder(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	if err := b.Status.ExpireOrder(c, 5, model.AwaitingPayment); err != nil {
+		t.Fatalf("ExpireOrder() error = %v", err)
+	}
+	if err := b.Status.ExpireOrder(c, 5, model.AwaitingPayment); !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("ExpireOrder() twice error = %v, want ErrStatusChanged", err)
+	}
+	o, err := b.Status.Order(c, 5)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Equal(t, model.Expired, o.StatusID)
+	as, err := b.Orders.History(c, 5)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if assert.Len(t, as, 2) {
+		assert.Equal(t, model.AuditExpire, as[1].Action)
+		assert.JSONEq(t, `{"status":"EXPIRED"}`, as[1].NewValue)
+	}
+	var types []string
+	_, err = b.Outbox.Dispatch(c, 10, func(e model.OrderEvent) error {
+		types = append(types, e.Type)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	assert.Contains(t, types, model.EventOrderExpired)
+}
+
+func testSetStatus(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	if err := b.Status.SetStatus(c, 1, model.InPreparation, model.Ready); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	if err := b.Status.SetStatus(c, 1, model.InPreparation, model.Ready); !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("SetStatus() twice error = %v, want ErrStatusChanged", err)
+	}
+	st, err := b.Status.Status(c, 1)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	assert.Equal(t, model.Ready, st)
+	as, err := b.Orders.History(c, 1)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if assert.Len(t, as, 2) {
+		assert.Equal(t, model.AuditStatus, as[1].Action)
+		assert.JSONEq(t, `{"status":"READY"}`, as[1].NewValue)
+	}
+}
+
+func testIdempotency(t *testing.T, b Backend) {
+	c := context.Background()
+	k := &model.IdempotencyKey{Key: "K-1", Scope: "create", Hash: "h1", ExpiresAt: time.Now().Add(time.Hour)}
+	got, created, err := b.Keys.Reserve(c, k)
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	assert.True(t, created)
+	assert.Equal(t, "h1", got.Hash)
+	got, created, err = b.Keys.Reserve(c, &model.IdempotencyKey{Key: "K-1", Scope: "create", Hash: "h2", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Reserve() twice error = %v", err)
+	}
+	assert.False(t, created)
+	assert.Equal(t, "h1", got.Hash)
+	assert.Nil(t, got.CompletedAt)
+	if err := b.Keys.Complete(c, "K-1", "create", []byte("response")); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if err := b.Keys.Release(c, "K-1", "create"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	got, created, err = b.Keys.Reserve(c, &model.IdempotencyKey{Key: "K-1", Scope: "create", Hash: "h1", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Reserve() completed error = %v", err)
+	}
+	assert.False(t, created)
+	assert.Equal(t, []byte("response"), got.Response)
+	assert.NotNil(t, got.CompletedAt)
+
+	k = &model.IdempotencyKey{Key: "K-1", Scope: "pay", Hash: "h1", ExpiresAt: time.Now().Add(time.Hour)}
+	if _, created, err = b.Keys.Reserve(c, k); err != nil || !created {
+		t.Fatalf("Reserve() in another scope = %v, %v, want created", created, err)
+	}
+	if err := b.Keys.Release(c, "K-1", "pay"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, created, err = b.Keys.Reserve(c, k); err != nil || !created {
+		t.Fatalf("Reserve() released = %v, %v, want created", created, err)
+	}
+
+	expired := &model.IdempotencyKey{Key: "K-2", Scope: "create", Hash: "h1", ExpiresAt: time.Now().Add(-time.Minute)}
+	if _, _, err := b.Keys.Reserve(c, expired); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if _, created, err = b.Keys.Reserve(c, &model.IdempotencyKey{Key: "K-2", Scope: "create", Hash: "h2", ExpiresAt: time.Now().Add(time.Hour)}); err != nil || !created {
+		t.Errorf("Reserve() expired = %v, %v, want created", created, err)
+	}
+	if _, _, err := b.Keys.Reserve(c, &model.IdempotencyKey{Key: "K-3", Scope: "create", Hash: "h1", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	n, err := b.Keys.Purge(c, time.Now())
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	assert.Equal(t, int64(1), n)
+}
+
+func testProducts(t *testing.T, b Backend) {
+	os := generateData(t, b)
+	c := context.Background()
+	id := os[0].OrderProducts[1].ID
+	if err := b.Status.CompleteProduct(c, id); err != nil {
+		t.Fatalf("CompleteProduct() error = %v", err)
+	}
+	got, err := b.Orders.WaiterPending(c, 1)
+	if err != nil {
+		t.Fatalf("WaiterPending() error = %v", err)
+	}
+	assert.Equal(t, []uint64{id}, productIDs(got[0].OrderProducts))
+}
+
+func testCancelOrder(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	cn := model.Cancellation{Reason: model.CustomerRequest, Note: "changed my mind"}
+	if err := b.Status.CancelOrder(c, 5, model.AwaitingPayment, cn); err != nil {
+		t.Fatalf("CancelOrder() error = %v", err)
+	}
+	if err := b.Status.CancelOrder(c, 5, model.AwaitingPayment, cn); !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("CancelOrder() twice error = %v, want ErrStatusChanged", err)
+	}
+	o, err := b.Status.Order(c, 5)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Equal(t, model.Cancelled, o.StatusID)
+	assert.Equal(t, model.Delivery, o.TypeID)
+	assert.Equal(t, uint64(7), o.UserID)
+	assert.Equal(t, cn.Reason, o.CancelReason)
+	assert.Equal(t, cn.Note, o.CancelNote)
+	if st, err := b.Status.Status(c, 1); assert.NoError(t, err) {
+		assert.Equal(t, model.InPreparation, st)
+	}
+	if _, err := b.Status.Order(c, 1000); !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("Order() of unknown order error = %v, want ErrNotFound", err)
+	}
+}
+
+func testCancelProducts(t *testing.T, b Backend) {
+	os := generateData(t, b)
+	c := context.Background()
+	id := os[0].OrderProducts[0].ID
+	cn := model.Cancellation{Reason: model.OutOfStock, Note: "no tortillas"}
+	if err := b.Status.CancelProducts(c, 1, []uint64{id}, cn); err != nil {
+		t.Fatalf("CancelProducts() error = %v", err)
+	}
+	if err := b.Status.CancelProducts(c, 1, []uint64{id}, cn); !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("CancelProducts() twice error = %v, want ErrStatusChanged", err)
+	}
+	if err := b.Status.CancelProducts(c, 2, []uint64{os[0].OrderProducts[1].ID}, cn); !errors.Is(err, model.ErrStatusChanged) {
+		t.Errorf("CancelProducts() of another order error = %v, want ErrStatusChanged", err)
+	}
+	o, err := b.Status.Order(c, 1)
+	if err != nil {
+		t.Fatalf("Order() error = %v", err)
+	}
+	assert.Equal(t, mxn(15505234), o.Total)
+	assert.Equal(t, model.InPreparation, o.StatusID)
+	ps, err := b.Orders.Products(c, 1)
+	if err != nil {
+		t.Fatalf("Products() error = %v", err)
+	}
+	for _, p := range ps {
+		assert.Equal(t, p.ID == id, p.IsCancelled, "product %d", p.ID)
+		if p.ID == id {
+			assert.Equal(t, cn.Reason, p.CancelReason)
+			assert.Equal(t, cn.Note, p.CancelNote)
+		}
+	}
+	got, err := b.Orders.Kitchen(c, 1, 0)
+	if err != nil {
+		t.Fatalf("Kitchen() error = %v", err)
+	}
+	assert.NotContains(t, productIDs(got), id)
+	ws, err := b.Orders.Waiter(c, 1)
+	if err != nil {
+		t.Fatalf("Waiter() error = %v", err)
+	}
+	if assert.NotEmpty(t, ws) {
+		assert.Equal(t, productIDs(os[0].OrderProducts[1:]), productIDs(ws[0].OrderProducts))
+	}
+}
+
+func testTransaction(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	errRollback := errors.New("rollback")
+	err := b.Tx.WithTx(c, func(ost controller.OrderStorager, sst controller.OrderStatusStorager) error {
+		if err := ost.AddProducts(c, 3, mxn(500), []model.OrderProduct{{ProductID: 9, Quantity: 1}}); err != nil {
+			return err
+		}
+		if err := sst.PayLocal(c, 3, 1, 0, model.InPreparation, model.Closed); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx() error = %v, want %v", err, errRollback)
+	}
+	st, err := b.Status.Status(c, 3)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	assert.Equal(t, model.InPreparation, st)
+	ps, err := b.Orders.Products(c, 3)
+	if err != nil {
+		t.Fatalf("Products() error = %v", err)
+	}
+	assert.Len(t, ps, 2)
+	err = b.Tx.WithTx(c, func(_ controller.OrderStorager, sst controller.OrderStatusStorager) error {
+		return sst.PayLocal(c, 3, 1, 0, model.InPreparation, model.Closed)
+	})
+	if err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+	st, err = b.Status.Status(c, 3)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	assert.Equal(t, model.Closed, st)
+}
+
+func testOutbox(t *testing.T, b Backend) {
+	generateData(t, b)
+	c := context.Background()
+	if err := b.Orders.AddProducts(c, 3, mxn(500), []model.OrderProduct{{ProductID: 9, Quantity: 1}}); err != nil {
+		t.Fatalf("AddProducts() error = %v", err)
+	}
+	if err := b.Status.PayLocal(c, 1, 1, 2000, model.InPreparation, model.Closed); err != nil {
+		t.Fatalf("PayLocal() error = %v", err)
+	}
+	errRollback := errors.New("rollback")
+	err := b.Tx.WithTx(c, func(_ controller.OrderStorager, sst controller.OrderStatusStorager) error {
+		if err := sst.PayLocal(c, 3, 1, 0, model.InPreparation, model.Closed); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx() error = %v, want %v", err, errRollback)
+	}
+	if err := b.Status.CancelOrder(c, 5, model.AwaitingPayment, model.Cancellation{Reason: model.CustomerRequest}); err != nil {
+		t.Fatalf("CancelOrder() error = %v", err)
+	}
+
+	var got []model.OrderEvent
+	errDown := errors.New("bus is down")
+	n, err := b.Outbox.Dispatch(c, 10, func(e model.OrderEvent) error {
+		if len(got) == 2 {
+			return errDown
+		}
+		got = append(got, e)
+		return nil
+	})
+	if !errors.Is(err, errDown) {
+		t.Fatalf("Dispatch() error = %v, want %v", err, errDown)
+	}
+	assert.Equal(t, 2, n)
+	for {
+		n, err = b.Outbox.Dispatch(c, 3, func(e model.OrderEvent) error {
+			got = append(got, e)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Dispatch() error = %v", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	var types []string
+	var oIDs []uint64
+	ids := make(map[string]bool)
+	for _, e := range got {
+		types = append(types, e.Type)
+		oIDs = append(oIDs, e.OrderID)
+		ids[e.EventID] = true
+	}
+	assert.Equal(t, []string{
+		model.EventOrderCreated, model.EventOrderCreated, model.EventOrderCreated, model.EventOrderCreated, model.EventOrderCreated,
+		model.EventProductsAdded, model.EventOrderCompleted, model.EventOrderCancelled,
+	}, types)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 3, 1, 5}, oIDs)
+	assert.Len(t, ids, len(got), "event IDs must be unique")
+	assert.Equal(t, uint32(1), got[2].Attempts)
+	assert.JSONEq(t, `{"order_id":1,"type":1,"employee_id":1,"establishment_id":1,"status":"CLOSED","total":"155055.34","currency":"MXN"}`, got[6].Payload)
+}
+
+func testAudit(t *testing.T, b Backend) {
+	c := model.ContextWithActor(context.Background(), model.Actor{EmployeeID: 1})
+	for _, o := range []model.Order{
+		{TypeID: model.Local, EmployeeID: 1, EstablishmentID: 1, TableID: 1, StatusID: model.InPreparation, Total: mxn(300),
+			OrderProducts: []model.OrderProduct{{ProductID: 1, Quantity: 3, Subtotal: mxn(300)}}},
+		{TypeID: model.Delivery, UserID: 7, StatusID: model.AwaitingPayment, Total: mxn(5000),
+			OrderProducts: []model.OrderProduct{{ProductID: 1, Quantity: 5}}},
+	} {
+		if err := b.Orders.Create(c, &o); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	if err := b.Orders.AddProducts(c, 1, mxn(500), []model.OrderProduct{{ProductID: 9, Quantity: 1}}); err != nil {
+		t.Fatalf("AddProducts() error = %v", err)
+	}
+	if err := b.Status.CompleteProduct(c, 1); err != nil {
+		t.Fatalf("CompleteProduct() error = %v", err)
+	}
+	if err := b.Status.DeliverProduct(c, []uint64{1}); err != nil {
+		t.Fatalf("DeliverProduct() error = %v", err)
+	}
+	errRollback := errors.New("rollback")
+	err := b.Tx.WithTx(c, func(_ controller.OrderStorager, sst controller.OrderStatusStorager) error {
+		if err := sst.PayLocal(c, 1, 1, 0, model.InPreparation, model.Closed); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx() error = %v, want %v", err, errRollback)
+	}
+	if err := b.Status.PayLocal(c, 1, 1, 1000, model.InPreparation, model.Closed); err != nil {
+		t.Fatalf("PayLocal() error = %v", err)
+	}
+	uc := model.ContextWithActor(context.Background(), model.Actor{UserID: 7})
+	if err := b.Status.CancelOrder(uc, 2, model.AwaitingPayment, model.Cancellation{Reason: model.CustomerRequest}); err != nil {
+		t.Fatalf("CancelOrder() error = %v", err)
+	}
+
+	as, err := b.Orders.History(c, 1)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	var actions []string
+	for _, a := range as {
+		actions = append(actions, a.Action)
+		assert.Equal(t, uint64(1), a.EmployeeID)
+		assert.False(t, a.CreatedAt.IsZero())
+	}
+	assert.Equal(t, []string{
+		model.AuditCreate, model.AuditAddProducts, model.AuditCompleteProduct, model.AuditDeliverProduct, model.AuditPay,
+	}, actions)
+	if len(as) == 5 {
+		assert.JSONEq(t, `{"total":"3.00"}`, as[1].OldValue)
+		assert.JSONEq(t, `{"total":"8.00","products":[3]}`, as[1].NewValue)
+		assert.Equal(t, uint64(1), as[2].OrderProductID)
+		assert.JSONEq(t, `{"is_ready":false}`, as[2].OldValue)
+		assert.JSONEq(t, `{"status":"IN_PREPARATION"}`, as[4].OldValue)
+		assert.JSONEq(t, `{"status":"CLOSED","tip":"0.1000"}`, as[4].NewValue)
+	}
+	as, err = b.Orders.History(c, 2)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if assert.Len(t, as, 2) {
+		assert.Equal(t, model.AuditCancel, as[1].Action)
+		assert.JSONEq(t, `{"status":"CANCELLED","reason":"CUSTOMER_REQUEST","note":""}`, as[1].NewValue)
+		assert.Equal(t, uint64(7), as[1].UserID)
+		assert.Zero(t, as[1].EmployeeID)
+	}
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Tx gives access to the storages bound to a single transaction.
+type Tx struct {
+	db *gorm.DB
+}
+
+func (t Tx) Orders() OrderStorage {
+	return OrderStorage{db: t.db}
+}
+
+func (t Tx) OrderStatus() orderStatusStorage {
+	return orderStatusStorage{db: t.db}
+}
+
+type UnitOfWork struct {
+	db *gorm.DB
+}
+
+func NewUnitOfWork(db *DB) UnitOfWork {
+	return UnitOfWork{db: db.db}
+}
+
+// WithTx runs fn inside a transaction, it is committed when fn returns nil
+// and rolled back otherwise.
+func (u UnitOfWork) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(Tx{db: tx})
+	})
+}